@@ -1,60 +1,149 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dyne/pinkmask/internal/config"
 	"github.com/dyne/pinkmask/internal/copy"
 	"github.com/dyne/pinkmask/internal/inspect"
+	"github.com/dyne/pinkmask/internal/lint"
 	"github.com/dyne/pinkmask/internal/log"
 	"github.com/dyne/pinkmask/internal/plan"
+	"github.com/dyne/pinkmask/internal/schema"
 	"github.com/dyne/pinkmask/internal/transform"
+	"github.com/dyne/pinkmask/internal/vault"
 	"github.com/spf13/cobra"
 )
 
 type globalOptions struct {
-	Verbose  bool
-	Salt     string
-	Seed     int64
-	FK       string
-	Triggers string
-	Jobs     int
-	TempDir  string
-	Plugins  []string
+	Verbose bool
+	Salt    string
+	// SaltVersion rotates the salt; see transform.DeriveSalt.
+	SaltVersion int
+	Seed        int64
+	FK          string
+	Triggers    string
+	Jobs        int
+	TempDir     string
+	Plugins     []string
+	// PluginsBestEffort skips plugins that fail to load instead of
+	// aborting; the config must not use their transforms.
+	PluginsBestEffort bool
+	Timeout           time.Duration
+	// AllowTransforms and DenyTransforms restrict the transforms any
+	// config may use; see transform.Policy.
+	AllowTransforms []string
+	DenyTransforms  []string
 }
 
 func main() {
 	rootOpts := &globalOptions{}
+	cancel := func() {}
 	root := &cobra.Command{
 		Use:   "pinkmask",
 		Short: "Deterministic SQLite anonymization and subsetting",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if rootOpts.Timeout < 0 {
+				return fmt.Errorf("invalid timeout: %s", rootOpts.Timeout)
+			}
+			if rootOpts.Timeout > 0 {
+				var ctx context.Context
+				ctx, cancel = context.WithTimeout(cmd.Context(), rootOpts.Timeout)
+				cmd.SetContext(ctx)
+			}
+			return nil
+		},
 	}
 
 	root.PersistentFlags().BoolVar(&rootOpts.Verbose, "verbose", false, "enable debug logging")
 	root.PersistentFlags().StringVar(&rootOpts.Salt, "salt", "", "salt for deterministic hashing")
+	root.PersistentFlags().IntVar(&rootOpts.SaltVersion, "salt-version", 0, "derive the salt from the base salt and this version, rotating every pseudonym (0 = use the salt as is)")
 	root.PersistentFlags().Int64Var(&rootOpts.Seed, "seed", 0, "seed for deterministic generation")
 	root.PersistentFlags().StringVar(&rootOpts.FK, "fk", "on", "foreign key enforcement (on|off)")
 	root.PersistentFlags().StringVar(&rootOpts.Triggers, "triggers", "on", "trigger creation (on|off)")
-	root.PersistentFlags().IntVar(&rootOpts.Jobs, "jobs", 4, "parallelism")
+	root.PersistentFlags().IntVar(&rootOpts.Jobs, "jobs", 0, "transform workers per table and concurrent subset lookups (0 = number of CPUs, capped at the number of CPUs)")
 	root.PersistentFlags().StringVar(&rootOpts.TempDir, "tempdir", "", "temporary directory")
 	root.PersistentFlags().StringSliceVar(&rootOpts.Plugins, "plugin", nil, "plugin .so path (repeatable)")
+	root.PersistentFlags().BoolVar(&rootOpts.PluginsBestEffort, "plugins-best-effort", false, "warn about and skip plugins that fail to load, as long as the config does not use their transforms")
+	root.PersistentFlags().DurationVar(&rootOpts.Timeout, "timeout", 0, "abort the command after this long, e.g. 30m (0 = no limit)")
+	root.PersistentFlags().StringSliceVar(&rootOpts.AllowTransforms, "allow-transforms", nil, "only allow these transform types (@plugins = every plugin transform)")
+	root.PersistentFlags().StringSliceVar(&rootOpts.DenyTransforms, "deny-transforms", nil, "refuse these transform types (@plugins = every plugin transform)")
 
 	root.AddCommand(copyCmd(rootOpts, false))
 	root.AddCommand(copyCmd(rootOpts, true))
+	root.AddCommand(maskCmd(rootOpts))
 	root.AddCommand(inspectCmd(rootOpts))
 	root.AddCommand(planCmd(rootOpts))
+	root.AddCommand(transformersCmd(rootOpts))
+	root.AddCommand(lintCmd(rootOpts))
+	root.AddCommand(vaultCmd())
 
-	if err := root.Execute(); err != nil {
+	err := root.ExecuteContext(context.Background())
+	cancel()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %w", rootOpts.Timeout, err)
+		}
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes by failure class, so CI can tell a broken config from a
+// failed copy.
+const (
+	exitFailure   = 1 // anything else: flags, I/O, timeouts
+	exitConfig    = 2 // the config does not parse or uses unknown or disallowed transforms
+	exitSchema    = 3 // the config does not fit the input schema, or the output schema cannot be created
+	exitIntegrity = 4 // copying the rows failed, or the output failed an integrity check
+)
+
+func exitCode(err error) int {
+	var tableErr *copy.TableError
+	var columnErr *copy.ColumnError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return exitFailure
+	case errors.Is(err, config.ErrInvalid), errors.Is(err, transform.ErrUnknownTransformer), errors.Is(err, transform.ErrDenied):
+		return exitConfig
+	case errors.Is(err, copy.ErrSchema), errors.Is(err, schema.ErrMissingTable), errors.Is(err, schema.ErrMissingColumn):
+		return exitSchema
+	case errors.Is(err, copy.ErrIntegrity), errors.As(err, &tableErr), errors.As(err, &columnErr):
+		return exitIntegrity
 	}
+	return exitFailure
 }
 
 func copyCmd(rootOpts *globalOptions, sample bool) *cobra.Command {
 	var inPath string
 	var outPath string
 	var cfgPath string
+	var skipFailedSchema bool
+	var incremental bool
+	var requireSalt bool
+	var dumpConfigPath string
+	var dropDanglingFKs bool
+	var noIndexes bool
+	var noViews bool
+	var deferUnique bool
+	var noVersionPragmas bool
+	var checkFK bool
+	var chunkSize int
+	var outMode string
+	var failOnEmpty bool
+	var tags []string
+	var shards int
+	var shardKey string
+	var vaultPath string
+	var vaultKeyFile string
+	var noClobber bool
 	cmdName := "copy"
 	cmdShort := "Copy a SQLite database with masking"
 	if sample {
@@ -65,50 +154,226 @@ func copyCmd(rootOpts *globalOptions, sample bool) *cobra.Command {
 		Use:   cmdName,
 		Short: cmdShort,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := transform.LoadPlugins(rootOpts.Plugins); err != nil {
+			if err := loadPlugins(cmd, rootOpts); err != nil {
+				return err
+			}
+			cfg, err := loadConfig(rootOpts, cfgPath)
+			if err != nil {
 				return err
 			}
-			cfg, err := config.Load(cfgPath)
+			salt, seed, err := secrets(cmd, rootOpts, cfg)
 			if err != nil {
 				return err
 			}
+			var mode uint64
+			if outMode != "" {
+				if mode, err = strconv.ParseUint(outMode, 8, 32); err != nil || mode > 0o777 {
+					return fmt.Errorf("invalid --out-mode %q: want octal permissions such as 0600", outMode)
+				}
+			}
+			var vaultKey []byte
+			if vaultKeyFile != "" {
+				if vaultKey, err = vault.LoadKey(vaultKeyFile); err != nil {
+					return err
+				}
+			}
 			level := log.LevelInfo
 			if rootOpts.Verbose {
 				level = log.LevelDebug
 			}
 			logger := log.New(level, cmd.OutOrStdout())
 			opts := copy.Options{
-				InPath:   inPath,
-				OutPath:  outPath,
-				Config:   cfg,
-				Salt:     rootOpts.Salt,
-				Seed:     rootOpts.Seed,
-				FKMode:   rootOpts.FK,
-				Triggers: rootOpts.Triggers,
-				Jobs:     rootOpts.Jobs,
-				TempDir:  rootOpts.TempDir,
-				Subset:   sample,
-				Logger:   logger,
+				InPath:           inPath,
+				OutPath:          outPath,
+				Config:           cfg,
+				Salt:             salt,
+				Seed:             seed,
+				FKMode:           rootOpts.FK,
+				Triggers:         rootOpts.Triggers,
+				NoIndexes:        noIndexes,
+				NoViews:          noViews,
+				DeferUnique:      deferUnique,
+				NoVersionPragmas: noVersionPragmas,
+				CheckFK:          checkFK,
+				ChunkSize:        chunkSize,
+				OutMode:          os.FileMode(mode),
+				FailOnEmpty:      failOnEmpty,
+				Tags:             tags,
+				Jobs:             rootOpts.Jobs,
+				TempDir:          rootOpts.TempDir,
+				Subset:           sample,
+				Logger:           logger,
+				SkipFailedSchema: skipFailedSchema,
+				Incremental:      incremental,
+				RequireSalt:      requireSalt,
+				DumpConfigPath:   dumpConfigPath,
+				DropDanglingFKs:  dropDanglingFKs,
+				Shards:           shards,
+				ShardKey:         shardKey,
+				VaultPath:        vaultPath,
+				VaultKey:         vaultKey,
+				NoClobber:        noClobber,
 			}
 			return copy.Run(cmd.Context(), opts)
 		},
 	}
-	cmd.Flags().StringVar(&inPath, "in", "", "input SQLite file")
-	cmd.Flags().StringVar(&outPath, "out", "", "output SQLite file")
-	cmd.Flags().StringVar(&cfgPath, "config", "", "mask configuration file")
+	cmd.Flags().StringVar(&inPath, "in", "", "input SQLite file or file: URI")
+	cmd.Flags().StringVar(&outPath, "out", "", "output SQLite file, replaced if it exists")
+	cmd.Flags().StringVar(&cfgPath, "config", "", "mask configuration file ('-' for stdin, or an http(s) URL)")
+	cmd.Flags().BoolVar(&noClobber, "no-clobber", false, "fail instead of replacing an existing output or vault file")
+	cmd.Flags().StringVar(&outMode, "out-mode", "", "octal permissions of the output file, e.g. 0600 (default 0644 minus the umask)")
+	cmd.Flags().StringSliceVar(&tags, "tags", nil, "apply the transforms tagged with any of these tags (untagged transforms always apply)")
+	cmd.Flags().StringVar(&dumpConfigPath, "dump-config", "", "write the effective config used by this run to a YAML file")
+	cmd.Flags().BoolVar(&requireSalt, "require-salt", false, "fail instead of warning when hash transforms run without --salt")
+	cmd.Flags().BoolVar(&incremental, "incremental", false, "keep an existing output and only copy rows whose key is not present yet")
+	cmd.Flags().IntVar(&shards, "shards", 0, "split the output into this many files by a hash of --shard-key")
+	cmd.Flags().StringVar(&shardKey, "shard-key", "", "table.column whose hash routes rows to shards; rows referencing it follow it")
+	cmd.Flags().StringVar(&vaultPath, "vault", "", "write the originals of vault_columns to this encrypted file")
+	cmd.Flags().StringVar(&vaultKeyFile, "vault-key-file", "", "file holding the hex-encoded 32-byte vault key")
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", 0, "keys per IN (...) query when subsetting or sharding (0 = 500)")
+	cmd.Flags().BoolVar(&dropDanglingFKs, "drop-dangling-fks", false, "remove foreign keys that reference tables excluded from the copy")
+	cmd.Flags().BoolVar(&noIndexes, "no-indexes", false, "do not recreate the source's indexes (constraint indexes are kept)")
+	cmd.Flags().BoolVar(&noViews, "no-views", false, "do not recreate the source's views or the triggers on them")
+	cmd.Flags().BoolVar(&deferUnique, "defer-unique", false, "create inline UNIQUE constraints as unique indexes after the data is loaded")
+	cmd.Flags().BoolVar(&noVersionPragmas, "no-version-pragmas", false, "do not copy PRAGMA user_version and application_id to the output")
+	cmd.Flags().BoolVar(&failOnEmpty, "fail-on-empty", false, "fail if a table with input rows ends up empty, or the subset selects nothing")
+	cmd.Flags().BoolVar(&checkFK, "check-fk", false, "run PRAGMA foreign_key_check on the output and report every violating row")
+	cmd.Flags().BoolVar(&skipFailedSchema, "skip-failed-schema", false, "continue when a table, view, index or trigger cannot be created")
 	_ = cmd.MarkFlagRequired("in")
 	_ = cmd.MarkFlagRequired("out")
 	return cmd
 }
 
+func maskCmd(rootOpts *globalOptions) *cobra.Command {
+	var dbPath string
+	var cfgPath string
+	var backupPath string
+	var requireSalt bool
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "mask",
+		Short: "Mask a SQLite database in place",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadPlugins(cmd, rootOpts); err != nil {
+				return err
+			}
+			cfg, err := loadConfig(rootOpts, cfgPath)
+			if err != nil {
+				return err
+			}
+			salt, seed, err := secrets(cmd, rootOpts, cfg)
+			if err != nil {
+				return err
+			}
+			level := log.LevelInfo
+			if rootOpts.Verbose {
+				level = log.LevelDebug
+			}
+			logger := log.New(level, cmd.OutOrStdout())
+			return copy.Mask(cmd.Context(), copy.MaskOptions{
+				DBPath:      dbPath,
+				BackupPath:  backupPath,
+				Config:      cfg,
+				Salt:        salt,
+				Seed:        seed,
+				FKMode:      rootOpts.FK,
+				RequireSalt: requireSalt,
+				Tags:        tags,
+				Logger:      logger,
+			})
+		},
+	}
+	cmd.Flags().StringVar(&dbPath, "db", "", "SQLite file to mask in place")
+	cmd.Flags().StringVar(&cfgPath, "config", "", "mask configuration file ('-' for stdin, or an http(s) URL)")
+	cmd.Flags().StringVar(&backupPath, "backup", "", "copy the database to this new file before masking")
+	cmd.Flags().StringSliceVar(&tags, "tags", nil, "apply the transforms tagged with any of these tags (untagged transforms always apply)")
+	cmd.Flags().BoolVar(&requireSalt, "require-salt", false, "fail instead of warning when hash transforms run without --salt")
+	_ = cmd.MarkFlagRequired("db")
+	_ = cmd.MarkFlagRequired("config")
+	return cmd
+}
+
+// secrets returns the salt and seed from the flags or from the config's
+// salt_file and seed_file. Setting both sources of one value is an error.
+func secrets(cmd *cobra.Command, rootOpts *globalOptions, cfg *config.Config) (string, int64, error) {
+	salt, seed := rootOpts.Salt, rootOpts.Seed
+	if cfg.SaltFile != "" {
+		if cmd.Flags().Changed("salt") {
+			return "", 0, fmt.Errorf("--salt and salt_file are both set; use one")
+		}
+		var err error
+		if salt, err = cfg.ReadSalt(); err != nil {
+			return "", 0, err
+		}
+	}
+	if cfg.SeedFile != "" {
+		if cmd.Flags().Changed("seed") {
+			return "", 0, fmt.Errorf("--seed and seed_file are both set; use one")
+		}
+		var err error
+		if seed, err = cfg.ReadSeed(); err != nil {
+			return "", 0, err
+		}
+	}
+	if rootOpts.SaltVersion != 0 {
+		if rootOpts.SaltVersion < 0 {
+			return "", 0, fmt.Errorf("invalid --salt-version: %d", rootOpts.SaltVersion)
+		}
+		if salt == "" {
+			return "", 0, fmt.Errorf("--salt-version requires a salt (--salt or salt_file)")
+		}
+		salt = transform.DeriveSalt(salt, rootOpts.SaltVersion)
+	}
+	return salt, seed, nil
+}
+
+// loadConfig loads the config at path and enforces the transform policy of
+// the flags and of the config's transform_policy.
+func loadConfig(rootOpts *globalOptions, path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	policies := []transform.Policy{{Allow: rootOpts.AllowTransforms, Deny: rootOpts.DenyTransforms}}
+	if p := cfg.TransformPolicy; p != nil {
+		policies = append(policies, transform.Policy{Allow: p.Allow, Deny: p.Deny})
+	}
+	transform.SetPolicy(policies...)
+	if rootOpts.PluginsBestEffort {
+		if err := transform.CheckTypes(cfg); err != nil {
+			return nil, fmt.Errorf("%w (a skipped plugin may provide it)", err)
+		}
+	}
+	return cfg, nil
+}
+
+// loadPlugins loads the --plugin paths; with --plugins-best-effort a
+// plugin that fails to load is logged to stderr and skipped, and
+// loadConfig checks that the config does not need it.
+func loadPlugins(cmd *cobra.Command, rootOpts *globalOptions) error {
+	if !rootOpts.PluginsBestEffort {
+		return transform.LoadPlugins(rootOpts.Plugins)
+	}
+	logger := log.New(log.LevelInfo, cmd.ErrOrStderr())
+	for _, err := range transform.LoadPluginsBestEffort(rootOpts.Plugins) {
+		logger.Infof("skipping plugin: %v", err)
+	}
+	return nil
+}
+
 func inspectCmd(rootOpts *globalOptions) *cobra.Command {
 	var inPath string
 	var draftPath string
+	var graphPath string
+	var fkCheck bool
+	var cfgPath string
+	var columnStats bool
+	var statsSample int
 	cmd := &cobra.Command{
 		Use:   "inspect",
 		Short: "Inspect schema and detect PII candidates",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := transform.LoadPlugins(rootOpts.Plugins); err != nil {
+			if err := loadPlugins(cmd, rootOpts); err != nil {
 				return err
 			}
 			level := log.LevelInfo
@@ -116,11 +381,29 @@ func inspectCmd(rootOpts *globalOptions) *cobra.Command {
 				level = log.LevelDebug
 			}
 			logger := log.New(level, cmd.OutOrStdout())
-			return inspect.Run(cmd.Context(), inPath, draftPath, logger)
+			cfg, err := loadConfig(rootOpts, cfgPath)
+			if err != nil {
+				return err
+			}
+			return inspect.Run(cmd.Context(), inspect.Options{
+				InPath:      inPath,
+				DraftPath:   draftPath,
+				GraphPath:   graphPath,
+				FKCheck:     fkCheck,
+				ColumnStats: columnStats,
+				StatsSample: statsSample,
+				Config:      cfg,
+				Logger:      logger,
+			})
 		},
 	}
-	cmd.Flags().StringVar(&inPath, "in", "", "input SQLite file")
+	cmd.Flags().StringVar(&inPath, "in", "", "input SQLite file or file: URI")
+	cmd.Flags().StringVar(&cfgPath, "config", "", "configuration file with extra pii_keywords ('-' for stdin, or an http(s) URL)")
 	cmd.Flags().StringVar(&draftPath, "draft-config", "", "write a draft mask config to a file ('-' for stdout)")
+	cmd.Flags().StringVar(&graphPath, "graph", "", "write the foreign key graph in Graphviz DOT format to a file ('-' for stdout)")
+	cmd.Flags().BoolVar(&fkCheck, "fk-check", false, "report input rows whose foreign keys reference missing parents")
+	cmd.Flags().BoolVar(&columnStats, "column-stats", false, "report distinct counts and null fractions per column")
+	cmd.Flags().IntVar(&statsSample, "stats-sample", 10000, "rows scanned per table for --column-stats (0 scans every row)")
 	_ = cmd.MarkFlagRequired("in")
 	return cmd
 }
@@ -128,14 +411,16 @@ func inspectCmd(rootOpts *globalOptions) *cobra.Command {
 func planCmd(rootOpts *globalOptions) *cobra.Command {
 	var inPath string
 	var cfgPath string
+	var strictTypes bool
+	var showSubset bool
 	cmd := &cobra.Command{
 		Use:   "plan",
 		Short: "Show transformation plan",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := transform.LoadPlugins(rootOpts.Plugins); err != nil {
+			if err := loadPlugins(cmd, rootOpts); err != nil {
 				return err
 			}
-			cfg, err := config.Load(cfgPath)
+			cfg, err := loadConfig(rootOpts, cfgPath)
 			if err != nil {
 				return err
 			}
@@ -144,11 +429,104 @@ func planCmd(rootOpts *globalOptions) *cobra.Command {
 				level = log.LevelDebug
 			}
 			logger := log.New(level, cmd.OutOrStdout())
-			return plan.Run(cmd.Context(), inPath, cfg, logger)
+			return plan.Run(cmd.Context(), inPath, cfg, strictTypes, showSubset, logger)
+		},
+	}
+	cmd.Flags().StringVar(&inPath, "in", "", "input SQLite file or file: URI")
+	cmd.Flags().StringVar(&cfgPath, "config", "", "mask configuration file ('-' for stdin, or an http(s) URL)")
+	cmd.Flags().BoolVar(&strictTypes, "strict-types", false, "fail when a transform changes a column's type")
+	cmd.Flags().BoolVar(&showSubset, "subset", false, "select the config's subset and show how many keys of each table it selects")
+	_ = cmd.MarkFlagRequired("in")
+	return cmd
+}
+
+func transformersCmd(rootOpts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "transformers",
+		Short: "List available transformers",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadPlugins(cmd, rootOpts); err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "Transformers:")
+			for _, info := range transform.Catalog() {
+				if info.Path != "" {
+					fmt.Fprintf(out, "- %s (%s: %s)", info.Name, info.Source, info.Path)
+				} else {
+					fmt.Fprintf(out, "- %s (%s)", info.Name, info.Source)
+				}
+				if info.Description != "" {
+					fmt.Fprintf(out, ": %s", info.Description)
+				}
+				fmt.Fprintln(out)
+				if len(info.Params) > 0 {
+					fmt.Fprintf(out, "  params: %s\n", strings.Join(info.Params, ", "))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func lintCmd(rootOpts *globalOptions) *cobra.Command {
+	var cfgPath string
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check a mask config without opening a database",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadPlugins(cmd, rootOpts); err != nil {
+				return err
+			}
+			cfg, err := loadConfig(rootOpts, cfgPath)
+			if err != nil {
+				return err
+			}
+			level := log.LevelInfo
+			if rootOpts.Verbose {
+				level = log.LevelDebug
+			}
+			logger := log.New(level, cmd.OutOrStdout())
+			return lint.Run(cfg, logger)
+		},
+	}
+	cmd.Flags().StringVar(&cfgPath, "config", "", "mask configuration file ('-' for stdin, or an http(s) URL)")
+	_ = cmd.MarkFlagRequired("config")
+	return cmd
+}
+
+func vaultCmd() *cobra.Command {
+	var inPath string
+	var keyFile string
+	var table string
+	var column string
+	var masked string
+	cmd := &cobra.Command{
+		Use:   "vault",
+		Short: "Decrypt a vault and print its entries as JSON lines",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := vault.LoadKey(keyFile)
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			return vault.Read(inPath, key, func(e vault.Entry) error {
+				if (table != "" && e.Table != table) || (column != "" && e.Column != column) {
+					return nil
+				}
+				if masked != "" && fmt.Sprint(e.Masked) != masked {
+					return nil
+				}
+				return enc.Encode(e)
+			})
 		},
 	}
-	cmd.Flags().StringVar(&inPath, "in", "", "input SQLite file")
-	cmd.Flags().StringVar(&cfgPath, "config", "", "mask configuration file")
+	cmd.Flags().StringVar(&inPath, "in", "", "vault file")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "file holding the hex-encoded 32-byte vault key")
+	cmd.Flags().StringVar(&table, "table", "", "only print entries of this table")
+	cmd.Flags().StringVar(&column, "column", "", "only print entries of this column")
+	cmd.Flags().StringVar(&masked, "masked", "", "only print entries for this masked value")
 	_ = cmd.MarkFlagRequired("in")
+	_ = cmd.MarkFlagRequired("key-file")
 	return cmd
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/transform"
+	"github.com/spf13/cobra"
+)
+
+func TestSecrets(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"mask.yml": "salt_file: salt\nseed_file: seed\n",
+		"salt":     "from-file\n",
+		"seed":     "7\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	withFiles, err := config.Load(filepath.Join(dir, "mask.yml"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		args     []string
+		cfg      *config.Config
+		wantSalt string
+		wantSeed int64
+		wantErr  string
+	}{
+		{name: "flags", args: []string{"--salt", "from-flag", "--seed", "3"}, cfg: &config.Config{}, wantSalt: "from-flag", wantSeed: 3},
+		{name: "files", cfg: withFiles, wantSalt: "from-file", wantSeed: 7},
+		{name: "salt twice", args: []string{"--salt", "from-flag"}, cfg: withFiles, wantErr: "--salt and salt_file are both set"},
+		{name: "seed twice", args: []string{"--seed", "3"}, cfg: withFiles, wantErr: "--seed and seed_file are both set"},
+		// An explicit empty --salt still counts as set.
+		{name: "empty salt flag", args: []string{"--salt="}, cfg: withFiles, wantErr: "--salt and salt_file are both set"},
+		{name: "version", args: []string{"--salt-version", "2"}, cfg: withFiles, wantSalt: transform.DeriveSalt("from-file", 2), wantSeed: 7},
+		{name: "version without salt", args: []string{"--salt-version", "2"}, cfg: &config.Config{}, wantErr: "--salt-version requires a salt"},
+		{name: "missing file", cfg: &config.Config{SaltFile: filepath.Join(dir, "nope")}, wantErr: "read salt_file"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootOpts := &globalOptions{}
+			cmd := &cobra.Command{Use: "copy"}
+			cmd.Flags().StringVar(&rootOpts.Salt, "salt", "", "")
+			cmd.Flags().IntVar(&rootOpts.SaltVersion, "salt-version", 0, "")
+			cmd.Flags().Int64Var(&rootOpts.Seed, "seed", 0, "")
+			if err := cmd.ParseFlags(tc.args); err != nil {
+				t.Fatalf("parse flags: %v", err)
+			}
+			salt, seed, err := secrets(cmd, rootOpts, tc.cfg)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected %q error, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("secrets: %v", err)
+			}
+			if salt != tc.wantSalt || seed != tc.wantSeed {
+				t.Fatalf("got salt %q seed %d, want %q %d", salt, seed, tc.wantSalt, tc.wantSeed)
+			}
+		})
+	}
+}
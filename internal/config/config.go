@@ -1,60 +1,220 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	IncludeTables []string                `yaml:"include_tables"`
-	ExcludeTables []string                `yaml:"exclude_tables"`
-	Tables        map[string]*TableConfig `yaml:"tables"`
-	Subset        *SubsetConfig           `yaml:"subset"`
+	IncludeTables []string                `yaml:"include_tables,omitempty"`
+	ExcludeTables []string                `yaml:"exclude_tables,omitempty"`
+	Tables        map[string]*TableConfig `yaml:"tables,omitempty"`
+	Subset        *SubsetConfig           `yaml:"subset,omitempty"`
+	PIIKeywords   map[string]string       `yaml:"pii_keywords,omitempty"`
+	AuditTable    string                  `yaml:"audit_table,omitempty"`
+	// ByType is the transform for columns that have none of their own,
+	// keyed by the column's type affinity: TEXT, INTEGER, REAL, NUMERIC,
+	// or BLOB.
+	ByType map[string]*TransformConfig `yaml:"by_type,omitempty"`
+	// SaltFile and SeedFile name files holding the salt and the seed, so
+	// they stay out of the config and the shell history. Relative paths
+	// are resolved against the config file's directory.
+	SaltFile string `yaml:"salt_file,omitempty"`
+	SeedFile string `yaml:"seed_file,omitempty"`
+	// TransformPolicy restricts the transforms the config may use.
+	TransformPolicy *TransformPolicy `yaml:"transform_policy,omitempty"`
+}
+
+// TransformPolicy lists transform types by name. With Allow set, only
+// those may be used; Deny names types that may not, and wins over Allow.
+// The entry "@plugins" stands for every plugin transform.
+type TransformPolicy struct {
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
 }
 
 type TableConfig struct {
-	Columns map[string]*TransformConfig `yaml:"columns"`
-	Limit   int                         `yaml:"limit"`
-	Where   string                      `yaml:"where"`
+	Columns       map[string]*TransformConfig `yaml:"columns,omitempty"`
+	Limit         int                         `yaml:"limit,omitempty"`
+	Where         string                      `yaml:"where,omitempty"`
+	PreserveRowID bool                        `yaml:"preserve_rowid,omitempty"`
+	DropColumns   []string                    `yaml:"drop_columns,omitempty"`
+	AddColumns    []AddColumnConfig           `yaml:"add_columns,omitempty"`
+	ShuffleRows   bool                        `yaml:"shuffle_rows,omitempty"`
+	AuditColumns  []string                    `yaml:"audit_columns,omitempty"`
+	VaultColumns  []string                    `yaml:"vault_columns,omitempty"`
+	// IdentityTable replaces the table name in the per-row seed, so a
+	// renamed table keeps the pseudonyms it had under its old name.
+	IdentityTable string `yaml:"identity_table,omitempty"`
+	// RenameTo is the table's name in the output.
+	RenameTo string `yaml:"rename_to,omitempty"`
+	// Seed is mixed into the global seed for this table's masks, so they
+	// can be rotated alone. 0 leaves the global seed as is.
+	Seed int64 `yaml:"seed,omitempty"`
+}
+
+type AddColumnConfig struct {
+	Name      string           `yaml:"name,omitempty"`
+	Type      string           `yaml:"type,omitempty"`
+	Transform *TransformConfig `yaml:"transform,omitempty"`
 }
 
 type TransformConfig struct {
-	Type        string            `yaml:"type"`
-	Params      map[string]any    `yaml:"params"`
-	Value       any               `yaml:"value"`
-	Pattern     string            `yaml:"pattern"`
-	Replace     string            `yaml:"replace"`
-	Locale      string            `yaml:"locale"`
-	MaxLen      int               `yaml:"maxlen"`
-	Map         map[string]string `yaml:"map"`
-	LookupTable string            `yaml:"lookup_table"`
-	LookupKey   string            `yaml:"lookup_key"`
-	LookupValue string            `yaml:"lookup_value"`
+	Type        string            `yaml:"type,omitempty"`
+	Params      map[string]any    `yaml:"params,omitempty"`
+	Value       any               `yaml:"value,omitempty"`
+	Pattern     string            `yaml:"pattern,omitempty"`
+	Replace     string            `yaml:"replace,omitempty"`
+	Locale      string            `yaml:"locale,omitempty"`
+	MaxLen      int               `yaml:"maxlen,omitempty"`
+	MaxLenUnit  string            `yaml:"maxlen_unit,omitempty"`
+	Map         map[string]string `yaml:"map,omitempty"`
+	LookupTable string            `yaml:"lookup_table,omitempty"`
+	LookupKey   string            `yaml:"lookup_key,omitempty"`
+	LookupValue string            `yaml:"lookup_value,omitempty"`
+	// Groups maps named capture groups of a RegexReplace pattern to the
+	// transform applied to each group's text, in place of Replace.
+	Groups map[string]*TransformConfig `yaml:"groups,omitempty"`
+	// Element is the transform JSONArrayMask applies to every element of a
+	// JSON array.
+	Element *TransformConfig `yaml:"element,omitempty"`
+	// Tags limits the transform to runs selecting one of them with
+	// --tags. An untagged transform always applies.
+	Tags []string `yaml:"tags,omitempty"`
 }
 
 type SubsetConfig struct {
-	Roots []RootConfig `yaml:"roots"`
+	Roots []RootConfig `yaml:"roots,omitempty"`
 }
 
 type RootConfig struct {
-	Table string `yaml:"table"`
-	Where string `yaml:"where"`
-	Limit int    `yaml:"limit"`
+	Table      string `yaml:"table,omitempty"`
+	Where      string `yaml:"where,omitempty"`
+	Limit      int    `yaml:"limit,omitempty"`
+	OrderBy    string `yaml:"order_by,omitempty"`
+	Keys       []any  `yaml:"keys,omitempty"`
+	StratifyBy string `yaml:"stratify_by,omitempty"`
 }
 
+// ErrInvalid matches, with errors.Is, the errors Load returns for a config
+// it read but could not parse or validate.
+var ErrInvalid = errors.New("invalid config")
+
+// invalidError is an error of Load that matches ErrInvalid; its message is
+// the wrapped error's.
+type invalidError struct{ error }
+
+func invalid(err error) error { return invalidError{err} }
+
+func (e invalidError) Unwrap() []error { return []error{e.error, ErrInvalid} }
+
+// Load reads a YAML config from path, from standard input when path is
+// "-", or from an http:// or https:// URL. An empty path yields an empty
+// config.
 func Load(path string) (*Config, error) {
 	if path == "" {
 		return &Config{}, nil
 	}
-	data, err := os.ReadFile(path)
+	var data []byte
+	var err error
+	remote := isRemote(path)
+	switch {
+	case path == "-":
+		data, err = io.ReadAll(os.Stdin)
+	case remote:
+		if data, err = fetch(path); err != nil {
+			return nil, err
+		}
+	default:
+		data, err = os.ReadFile(path)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("read config: %w", err)
 	}
 	cfg := &Config{}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
+		return nil, invalid(fmt.Errorf("parse config: %w", err))
+	}
+	for affinity := range cfg.ByType {
+		switch strings.ToUpper(affinity) {
+		case "TEXT", "INTEGER", "REAL", "NUMERIC", "BLOB":
+		default:
+			return nil, invalid(fmt.Errorf("by_type: unknown affinity %q (expected TEXT, INTEGER, REAL, NUMERIC, or BLOB)", affinity))
+		}
+	}
+	// Relative secret files of a config read from stdin or a URL are
+	// resolved against the working directory.
+	if path != "-" && !remote {
+		dir := filepath.Dir(path)
+		for _, p := range []*string{&cfg.SaltFile, &cfg.SeedFile} {
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
 	}
 	return cfg, nil
 }
+
+// ReadSalt returns the salt stored in SaltFile, without its trailing line
+// break. It returns "" when SaltFile is not set.
+func (c *Config) ReadSalt() (string, error) {
+	if c.SaltFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(c.SaltFile)
+	if err != nil {
+		return "", fmt.Errorf("read salt_file: %w", err)
+	}
+	salt := strings.TrimRight(string(data), "\r\n")
+	if salt == "" {
+		return "", fmt.Errorf("salt_file %s is empty", c.SaltFile)
+	}
+	return salt, nil
+}
+
+// ReadSeed returns the integer seed stored in SeedFile. It returns 0 when
+// SeedFile is not set.
+func (c *Config) ReadSeed() (int64, error) {
+	if c.SeedFile == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(c.SeedFile)
+	if err != nil {
+		return 0, fmt.Errorf("read seed_file: %w", err)
+	}
+	seed, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("seed_file %s: expected an integer", c.SeedFile)
+	}
+	return seed, nil
+}
+
+// Masks reports whether the config sets a transform for column of table.
+func (c *Config) Masks(table, column string) bool {
+	tc := c.Tables[table]
+	return tc != nil && tc.Columns[column] != nil
+}
+
+// ColumnTransform returns the transform for column of table, whose type
+// affinity is given: the column's own, or else the by_type one. A column
+// listed under the table with no transform gets none.
+func (c *Config) ColumnTransform(table, column, affinity string) *TransformConfig {
+	if tc := c.Tables[table]; tc != nil {
+		if t, ok := tc.Columns[column]; ok {
+			return t
+		}
+	}
+	for a, t := range c.ByType {
+		if strings.EqualFold(a, affinity) {
+			return t
+		}
+	}
+	return nil
+}
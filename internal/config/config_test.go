@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("mask.yml", "salt_file: salt\nseed_file: secrets/seed\n")
+	write("salt", "s3cret\r\n")
+	if err := os.Mkdir(filepath.Join(dir, "secrets"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	write("secrets/seed", " 42\n")
+
+	// The files are found next to the config, not in the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	cfg, err := Load(filepath.Join(dir, "mask.yml"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.SaltFile != filepath.Join(dir, "salt") || cfg.SeedFile != filepath.Join(dir, "secrets", "seed") {
+		t.Fatalf("secret files not resolved against the config: %q, %q", cfg.SaltFile, cfg.SeedFile)
+	}
+	if salt, err := cfg.ReadSalt(); err != nil || salt != "s3cret" {
+		t.Fatalf("salt = %q, %v", salt, err)
+	}
+	if seed, err := cfg.ReadSeed(); err != nil || seed != 42 {
+		t.Fatalf("seed = %d, %v", seed, err)
+	}
+
+	write("salt", "\n")
+	if _, err := cfg.ReadSalt(); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("expected an empty salt_file error, got %v", err)
+	}
+	write("secrets/seed", "forty-two\n")
+	if _, err := cfg.ReadSeed(); err == nil || !strings.Contains(err.Error(), "expected an integer") {
+		t.Fatalf("expected a non-integer seed_file error, got %v", err)
+	}
+
+	empty := &Config{}
+	if salt, err := empty.ReadSalt(); err != nil || salt != "" {
+		t.Fatalf("unset salt_file: %q, %v", salt, err)
+	}
+	if seed, err := empty.ReadSeed(); err != nil || seed != 0 {
+		t.Fatalf("unset seed_file: %d, %v", seed, err)
+	}
+}
+
+func TestLoadStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+	go func() {
+		w.WriteString("tables:\n  users:\n    columns:\n      email: {type: HmacSha256}\nsalt_file: secrets/salt\n")
+		w.Close()
+	}()
+
+	cfg, err := Load("-")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !cfg.Masks("users", "email") {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	// There is no config directory, so relative files stay relative to
+	// the working directory.
+	if cfg.SaltFile != "secrets/salt" {
+		t.Fatalf("salt_file = %q, want it unresolved", cfg.SaltFile)
+	}
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuthEnv names the environment variable whose value, when set, is sent as
+// the Authorization header when fetching a config from a URL, e.g.
+// "Bearer <token>".
+const AuthEnv = "PINKMASK_CONFIG_AUTH"
+
+const (
+	remoteTimeout = 30 * time.Second
+	// remoteMaxSize bounds the config a server may send.
+	remoteMaxSize = 16 << 20
+	// remoteMaxRedirects is how many redirects fetch follows, as many as
+	// net/http does by default.
+	remoteMaxRedirects = 10
+)
+
+// remoteTransport is the transport of fetch; nil uses the default one.
+// Tests replace it to trust their own server.
+var remoteTransport http.RoundTripper
+
+// isRemote reports whether path is an http:// or https:// URL.
+func isRemote(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
+
+// fetch downloads the config at url. TLS certificates are verified against
+// the system roots, and the credentials of AuthEnv are only sent over
+// https, so a redirect to any other scheme is refused while they are set.
+func fetch(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetch config: %w", err)
+	}
+	auth := os.Getenv(AuthEnv)
+	if auth != "" {
+		if req.URL.Scheme != "https" {
+			return nil, fmt.Errorf("fetch config %s: %s is set; refusing to send it over %s", url, AuthEnv, req.URL.Scheme)
+		}
+		req.Header.Set("Authorization", auth)
+	}
+	req.Header.Set("Accept", "application/yaml, text/yaml, text/plain, */*")
+	client := &http.Client{
+		Timeout:   remoteTimeout,
+		Transport: remoteTransport,
+		// net/http forwards Authorization to the same host whatever the
+		// scheme, so an https URL could hand it on to plain http.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= remoteMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			if auth != "" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%s is set; refusing to follow a redirect to %s", AuthEnv, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch config %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, remoteMaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch config %s: %w", url, err)
+	}
+	if len(data) > remoteMaxSize {
+		return nil, fmt.Errorf("fetch config %s: larger than %d bytes", url, remoteMaxSize)
+	}
+	return data, nil
+}
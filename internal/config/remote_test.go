@@ -0,0 +1,73 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadRemote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mask.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("tables:\n  users:\n    columns:\n      email: {type: HmacSha256}\nsalt_file: salt\n"))
+	}))
+	defer srv.Close()
+
+	t.Setenv(AuthEnv, "")
+	cfg, err := Load(srv.URL + "/mask.yml")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !cfg.Masks("users", "email") || cfg.SaltFile != "salt" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	if _, err := Load(srv.URL + "/missing.yml"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+
+	t.Setenv(AuthEnv, "Bearer secret")
+	if _, err := Load(srv.URL + "/mask.yml"); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Fatalf("expected credentials over http to be refused, got %v", err)
+	}
+
+	// The test server's certificate is self-signed, so verification fails.
+	tlsSrv := httptest.NewTLSServer(srv.Config.Handler)
+	defer tlsSrv.Close()
+	if _, err := Load(tlsSrv.URL + "/mask.yml"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected a certificate error, got %v", err)
+	}
+}
+
+func TestLoadRemoteRedirectToHTTP(t *testing.T) {
+	var leaked string
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte("tables: {}\n"))
+	}))
+	defer plain.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+r.URL.Path, http.StatusFound)
+	}))
+	defer tlsSrv.Close()
+	remoteTransport = tlsSrv.Client().Transport
+	t.Cleanup(func() { remoteTransport = nil })
+
+	t.Setenv(AuthEnv, "Bearer secret")
+	if _, err := Load(tlsSrv.URL + "/mask.yml"); err == nil || !strings.Contains(err.Error(), "refusing to follow a redirect to http") {
+		t.Fatalf("expected the redirect to http to be refused, got %v", err)
+	}
+	if leaked != "" {
+		t.Fatalf("credentials sent over http: %q", leaked)
+	}
+
+	// Without credentials there is nothing to leak, so the redirect is
+	// followed.
+	t.Setenv(AuthEnv, "")
+	if _, err := Load(tlsSrv.URL + "/mask.yml"); err != nil {
+		t.Fatalf("load without credentials: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package copy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/transform"
+)
+
+const defaultAuditTable = "pinkmask_audit"
+
+func auditTable(cfg *config.Config) string {
+	if cfg.AuditTable != "" {
+		return cfg.AuditTable
+	}
+	return defaultAuditTable
+}
+
+func auditColumns(cfg *config.Config, name string) []string {
+	tbl := cfg.Tables[name]
+	if tbl == nil {
+		return nil
+	}
+	return tbl.AuditColumns
+}
+
+func auditEnabled(cfg *config.Config) bool {
+	for _, tc := range cfg.Tables {
+		if tc != nil && len(tc.AuditColumns) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAuditColumns requires every audited column to be masked (an
+// audit of a pass-through column would only duplicate it) and the audit
+// table not to shadow a copied table.
+func validateAuditColumns(s *schema.Schema, cfg *config.Config) error {
+	if !auditEnabled(cfg) {
+		return nil
+	}
+	if _, ok := s.Tables[auditTable(cfg)]; ok {
+		return fmt.Errorf("audit_table: %s already exists in the input", auditTable(cfg))
+	}
+	names := make([]string, 0, len(cfg.Tables))
+	for name := range cfg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, col := range auditColumns(cfg, name) {
+			if cfg.Tables[name].Columns[col] == nil {
+				return fmt.Errorf("audit_columns: %s.%s has no transform", name, col)
+			}
+		}
+	}
+	return nil
+}
+
+func createAuditTable(ctx context.Context, outDB *sql.DB, cfg *config.Config) error {
+	if !auditEnabled(cfg) {
+		return nil
+	}
+	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (table_name TEXT NOT NULL, column_name TEXT NOT NULL, pk TEXT NOT NULL, original, masked)", schema.QuoteIdent(auditTable(cfg)))
+	if _, err := outDB.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("create audit table: %w", err)
+	}
+	return nil
+}
+
+// auditTransformer records the original and masked value of every row it
+// transforms. The key is stored as a JSON array so composite keys survive.
+type auditTransformer struct {
+	inner  transform.Transformer
+	table  string
+	column string
+	ctx    context.Context
+	stmt   *sql.Stmt
+}
+
+func (t *auditTransformer) Name() string { return t.inner.Name() }
+
+func (t *auditTransformer) Transform(value any, row transform.RowContext) (any, error) {
+	out, err := t.inner.Transform(value, row)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := json.Marshal(row.PK)
+	if err != nil {
+		return nil, fmt.Errorf("encode audit key: %w", err)
+	}
+	if _, err := t.stmt.ExecContext(t.ctx, t.table, t.column, string(pk), value, out); err != nil {
+		return nil, fmt.Errorf("write audit row: %w", err)
+	}
+	return out, nil
+}
+
+// withAudit wraps the transformers of audited columns. The returned
+// statement must be closed once the table is copied; it is nil when the
+// table has no audited columns.
+func withAudit(ctx context.Context, outDB *sql.DB, tbl *schema.Table, cfg *config.Config, transformers []columnTransformer) (*sql.Stmt, error) {
+	cols := auditColumns(cfg, tbl.Name)
+	if len(cols) == 0 {
+		return nil, nil
+	}
+	stmt, err := outDB.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (table_name, column_name, pk, original, masked) VALUES (?, ?, ?, ?, ?)", schema.QuoteIdent(auditTable(cfg))))
+	if err != nil {
+		return nil, fmt.Errorf("prepare audit insert: %w", err)
+	}
+	for i, ct := range transformers {
+		if containsString(cols, ct.column) {
+			transformers[i].tr = &auditTransformer{inner: ct.tr, table: tbl.Name, column: ct.column, ctx: ctx, stmt: stmt}
+		}
+	}
+	return stmt, nil
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 
@@ -17,48 +18,227 @@ import (
 	"github.com/dyne/pinkmask/internal/schema"
 	"github.com/dyne/pinkmask/internal/subset"
 	"github.com/dyne/pinkmask/internal/transform"
+	"github.com/dyne/pinkmask/internal/vault"
 	_ "modernc.org/sqlite"
 )
 
 type Options struct {
-	InPath   string
-	OutPath  string
-	Config   *config.Config
-	Salt     string
-	Seed     int64
-	FKMode   string
-	Triggers string
-	Jobs     int
-	TempDir  string
-	Subset   bool
-	Logger   *log.Logger
+	InPath           string
+	OutPath          string
+	Config           *config.Config
+	Salt             string
+	Seed             int64
+	FKMode           string
+	Triggers         string
+	Jobs             int
+	TempDir          string
+	Subset           bool
+	Logger           *log.Logger
+	SkipFailedSchema bool
+	Incremental      bool
+	RequireSalt      bool
+	DumpConfigPath   string
+	// DropDanglingFKs removes foreign key constraints that reference tables
+	// excluded from the copy instead of failing.
+	DropDanglingFKs bool
+	// NoIndexes and NoViews skip recreating the source's indexes and
+	// views, e.g. to load faster and build indexes later. Triggers on
+	// skipped views are skipped too.
+	NoIndexes bool
+	NoViews   bool
+	// DeferUnique creates the tables without their inline UNIQUE
+	// constraints and adds equivalent unique indexes after the data is
+	// loaded, which is faster for large tables.
+	DeferUnique bool
+	// NoVersionPragmas leaves the output's user_version and application_id
+	// at 0 instead of copying them from the input.
+	NoVersionPragmas bool
+	// CheckFK runs PRAGMA foreign_key_check on the finished output and
+	// fails with every row whose parent is missing.
+	CheckFK bool
+	// ChunkSize is the number of keys bound per IN (...) query when
+	// copying a subset or shard; 0 uses subset.DefaultChunkSize.
+	ChunkSize int
+	// OutMode is the permission of the output file, applied regardless of
+	// the umask. SQLite gives its journal and WAL files the same mode. 0
+	// leaves the file to SQLite, which creates it 0644 minus the umask.
+	OutMode os.FileMode
+	// FailOnEmpty fails the run when a copied table ends up empty although
+	// the input has rows in it, or when the selection is empty.
+	FailOnEmpty bool
+	// Tags selects the tagged transforms that apply; see
+	// config.TransformConfig.Tags.
+	Tags []string
+	// Shards splits the output into that many files, named after OutPath
+	// with the shard number before the extension, routing rows by a hash
+	// of ShardKey ("table.column"). 0 or 1 writes a single file.
+	Shards   int
+	ShardKey string
+	// VaultPath is the encrypted file receiving the originals of the
+	// vault_columns, sealed with VaultKey.
+	VaultPath string
+	VaultKey  []byte
+	// NoClobber fails the run when the output, or the vault file, already
+	// exists instead of replacing it.
+	NoClobber bool
+
+	shard     int
+	vault     *vault.Writer
+	summary   *maskSummary
+	bindLimit int
 }
 
+// Run copies and masks the input database. When ctx is cancelled or its
+// deadline passes, a partially written output is removed unless the copy
+// is incremental, so an aborted run never leaves a half-masked database.
 func Run(ctx context.Context, opts Options) error {
+	if opts.Shards < 0 {
+		return fmt.Errorf("invalid shards: %d", opts.Shards)
+	}
+	if err := checkNoClobber(opts); err != nil {
+		return err
+	}
+	if opts.Shards <= 1 {
+		return runOutput(ctx, opts)
+	}
+	if _, _, ok := strings.Cut(opts.ShardKey, "."); !ok {
+		return fmt.Errorf("--shards requires --shard-key <table.column>, got %q", opts.ShardKey)
+	}
+	if opts.Subset || (opts.Config != nil && opts.Config.Subset != nil) {
+		return fmt.Errorf("--shards cannot be combined with subsetting")
+	}
+	for i := 0; i < opts.Shards; i++ {
+		shardOpts := opts
+		shardOpts.shard = i
+		shardOpts.OutPath = shardPath(opts.OutPath, i)
+		if opts.VaultPath != "" {
+			shardOpts.VaultPath = shardPath(opts.VaultPath, i)
+		}
+		if opts.Logger != nil {
+			opts.Logger.Infof("shard %d/%d: %s", i+1, opts.Shards, shardOpts.OutPath)
+		}
+		if err := runOutput(ctx, shardOpts); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// shardPath inserts the shard number before the extension of path:
+// out.sqlite becomes out.0.sqlite.
+func shardPath(path string, shard int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), shard, ext)
+}
+
+// checkNoClobber fails when NoClobber is set and a file the run would
+// replace, the output or the vault of any shard, already exists.
+func checkNoClobber(opts Options) error {
+	if !opts.NoClobber {
+		return nil
+	}
+	if opts.Incremental {
+		return fmt.Errorf("--no-clobber cannot be combined with --incremental, which writes into an existing output")
+	}
+	var paths []string
+	for _, p := range []string{opts.OutPath, opts.VaultPath} {
+		if p == "" {
+			continue
+		}
+		if opts.Shards <= 1 {
+			paths = append(paths, p)
+			continue
+		}
+		for i := 0; i < opts.Shards; i++ {
+			paths = append(paths, shardPath(p, i))
+		}
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("%s already exists; remove it or drop --no-clobber", p)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("check output: %w", err)
+		}
+	}
+	return nil
+}
+
+// outputSuffixes name the output file and the journal, WAL, and
+// shared-memory files SQLite may keep next to it.
+var outputSuffixes = []string{"", "-journal", "-wal", "-shm"}
+
+func runOutput(ctx context.Context, opts Options) error {
+	err := run(ctx, opts)
+	if err != nil && ctx.Err() != nil && !opts.Incremental && opts.OutPath != "" {
+		removed := false
+		for _, suffix := range outputSuffixes {
+			rmErr := os.Remove(opts.OutPath + suffix)
+			if rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				return fmt.Errorf("%w (remove partial output: %v)", err, rmErr)
+			}
+			removed = removed || rmErr == nil
+		}
+		if removed && opts.Logger != nil {
+			opts.Logger.Infof("aborted, removed partial output %s", opts.OutPath)
+		}
+	}
+	return err
+}
+
+func run(ctx context.Context, opts Options) error {
 	if opts.InPath == "" || opts.OutPath == "" {
 		return fmt.Errorf("input and output paths are required")
 	}
 	if opts.Config == nil {
 		opts.Config = &config.Config{}
 	}
-	if err := os.RemoveAll(opts.OutPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("remove output: %w", err)
+	jobs, err := effectiveJobs(opts.Jobs)
+	if err != nil {
+		return err
+	}
+	if jobs != opts.Jobs && opts.Logger != nil {
+		opts.Logger.Debugf("using %d jobs (requested %d, %d CPUs)", jobs, opts.Jobs, runtime.NumCPU())
+	}
+	opts.Jobs = jobs
+	if opts.ChunkSize < 0 {
+		return fmt.Errorf("invalid chunk size: %d", opts.ChunkSize)
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = subset.DefaultChunkSize
+	}
+	if !opts.Incremental {
+		if _, err := os.Stat(opts.OutPath); err == nil && opts.Logger != nil {
+			opts.Logger.Infof("overwrite existing output %s", opts.OutPath)
+		}
+		// Sidecars of an earlier output must go with it, or SQLite could
+		// take a stale WAL for part of the new file.
+		for _, suffix := range outputSuffixes {
+			if err := os.RemoveAll(opts.OutPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("remove output: %w", err)
+			}
+		}
 	}
 	if err := os.MkdirAll(filepath.Dir(opts.OutPath), 0o755); err != nil {
 		return fmt.Errorf("create output dir: %w", err)
 	}
+	if opts.OutMode != 0 {
+		if err := createOutputFile(opts.OutPath, opts.OutMode); err != nil {
+			return err
+		}
+	}
 
-	inDB, err := sql.Open("sqlite", sqliteDSN(opts.InPath))
+	inDB, err := sql.Open("sqlite", schema.DSN(opts.InPath))
 	if err != nil {
 		return fmt.Errorf("open input: %w", err)
 	}
 	defer inDB.Close()
 
-	outDB, err := sql.Open("sqlite", sqliteDSN(opts.OutPath))
+	outDB, err := sql.Open("sqlite", schema.DSN(opts.OutPath))
 	if err != nil {
 		return fmt.Errorf("open output: %w", err)
 	}
 	defer outDB.Close()
+	outDB.SetMaxOpenConns(1)
 
 	if err := setFKMode(ctx, outDB, opts.FKMode); err != nil {
 		return err
@@ -68,37 +248,197 @@ func Run(ctx context.Context, opts Options) error {
 	if err != nil {
 		return err
 	}
+	logSkippedTables(s, opts.Logger)
+	if err := checkSalt(s, opts); err != nil {
+		return err
+	}
+
+	if err := validateDropColumns(ctx, inDB, s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := validateAddColumns(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := validateStrictTypes(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := validateTableSeeds(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := validateAuditColumns(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := checkDanglingFKs(s, opts); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := validateVault(opts); err != nil {
+		return err
+	}
+	if err := validateRenames(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if opts.DumpConfigPath != "" {
+		if err := dumpConfig(opts.DumpConfigPath, s, opts); err != nil {
+			return err
+		}
+	}
 
 	order := schema.TableOrder(s)
 	var selection *subset.Selection
-	if opts.Subset || opts.Config.Subset != nil {
-		selection, err = subset.BuildSelection(ctx, inDB, s, opts.Config)
+	if opts.Shards > 1 || opts.Subset || opts.Config.Subset != nil {
+		opts.bindLimit = subset.BindLimit(ctx, inDB)
+	}
+	switch {
+	case opts.Shards > 1:
+		table, column, _ := strings.Cut(opts.ShardKey, ".")
+		selection, err = subset.BuildShardSelection(ctx, inDB, s, opts.Config, table, column, opts.shard, opts.Shards, opts.ChunkSize)
+		if err != nil {
+			return err
+		}
+	case opts.Subset || opts.Config.Subset != nil:
+		selection, err = subset.BuildSelection(ctx, inDB, s, opts.Config, opts.ChunkSize, opts.Jobs)
 		if err != nil {
 			return err
 		}
 	}
-	if err := createSchema(ctx, outDB, s, order, opts); err != nil {
+	if selection == nil {
+		violations, err := checkFilterIntegrity(ctx, inDB, s, opts.Config)
+		if err != nil {
+			return err
+		}
+		if err := reportViolations(violations, opts); err != nil {
+			return inClass(ErrIntegrity, err)
+		}
+	}
+
+	renames := tableRenames(s, opts.Config)
+	if opts.Incremental {
+		if err := renameTables(ctx, outDB, renames, true, opts); err != nil {
+			return err
+		}
+	}
+	existing, err := existingObjects(ctx, outDB)
+	if err != nil {
+		return err
+	}
+	skipped, deferred, err := createSchema(ctx, outDB, s, order, opts, existing)
+	if err != nil {
+		return err
+	}
+	if err := createAuditTable(ctx, outDB, opts.Config); err != nil {
 		return err
 	}
 
-	if err := copyData(ctx, inDB, outDB, s, order, opts, selection); err != nil {
+	if opts.VaultPath != "" {
+		if opts.vault, err = vault.Create(opts.VaultPath, opts.VaultKey); err != nil {
+			return err
+		}
+		defer opts.vault.Abort()
+	}
+	opts.summary = &maskSummary{}
+	if err := copyData(ctx, inDB, outDB, s, order, opts, selection, skipped); err != nil {
+		return err
+	}
+	if opts.FailOnEmpty {
+		if err := checkEmptyTables(ctx, inDB, outDB, order, opts.Config, selection, skipped); err != nil {
+			return inClass(ErrIntegrity, err)
+		}
+	}
+	if opts.vault != nil {
+		if err := opts.vault.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := createPostDataSchema(ctx, outDB, s, opts, existing, deferred); err != nil {
 		return err
 	}
+	if err := renameTables(ctx, outDB, renames, false, opts); err != nil {
+		return err
+	}
+	if opts.CheckFK {
+		if err := checkForeignKeys(ctx, outDB, opts.Logger); err != nil {
+			return inClass(ErrIntegrity, err)
+		}
+	}
+	if !opts.NoVersionPragmas {
+		if err := copyVersionPragmas(ctx, inDB, outDB, opts.Logger); err != nil {
+			return err
+		}
+	}
 
-	if err := createPostDataSchema(ctx, outDB, s, opts); err != nil {
+	if err := leaveWAL(ctx, outDB, opts.Logger); err != nil {
 		return err
 	}
 
+	opts.summary.log(opts.Logger)
 	if opts.Logger != nil {
 		opts.Logger.Infof("copy complete")
 	}
 	return nil
 }
 
-func sqliteDSN(path string) string {
-	return fmt.Sprintf("file:%s?_busy_timeout=5000", path)
+// createOutputFile creates path, unless it exists, and sets its mode, so
+// the output is never readable beyond mode, even while it is written.
+func createOutputFile(path string, mode os.FileMode) error {
+	if mode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid output mode: %#o", mode)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	for _, suffix := range outputSuffixes {
+		if err := os.Chmod(path+suffix, mode); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("set output mode: %w", err)
+		}
+	}
+	return nil
+}
+
+// leaveWAL switches an output in WAL mode, e.g. an incremental target an
+// application opened, back to a rollback journal. SQLite checkpoints the
+// WAL into the database and deletes the -wal and -shm files, so the output
+// ships as a single file.
+func leaveWAL(ctx context.Context, db *sql.DB, logger *log.Logger) error {
+	var mode string
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil {
+		return fmt.Errorf("read journal_mode: %w", err)
+	}
+	if !strings.EqualFold(mode, "wal") {
+		return nil
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode = DELETE").Scan(&mode); err != nil {
+		return fmt.Errorf("leave WAL mode: %w", err)
+	}
+	if !strings.EqualFold(mode, "delete") {
+		return fmt.Errorf("leave WAL mode: journal_mode is still %s; is the output open elsewhere?", mode)
+	}
+	if logger != nil {
+		logger.Debugf("checkpointed the output WAL and set journal_mode = DELETE")
+	}
+	return nil
+}
+
+func effectiveJobs(jobs int) (int, error) {
+	cpus := runtime.NumCPU()
+	switch {
+	case jobs < 0:
+		return 0, fmt.Errorf("invalid jobs: %d (use 0 for auto)", jobs)
+	case jobs == 0:
+		return cpus, nil
+	case jobs > cpus:
+		return cpus, nil
+	default:
+		return jobs, nil
+	}
 }
 
+const attachedSchema = "pinkmask_src"
+
 func setFKMode(ctx context.Context, db *sql.DB, mode string) error {
 	mode = strings.ToLower(mode)
 	switch mode {
@@ -113,69 +453,230 @@ func setFKMode(ctx context.Context, db *sql.DB, mode string) error {
 	}
 }
 
-func createSchema(ctx context.Context, outDB *sql.DB, s *schema.Schema, order []string, opts Options) error {
+// versionPragmas are the header fields applications use to recognize their
+// databases and schema versions.
+var versionPragmas = []string{"user_version", "application_id"}
+
+// copyVersionPragmas sets the output's version pragmas to the input's, so
+// applications opening the masked copy do not take it for an unversioned
+// database and try to migrate it.
+func copyVersionPragmas(ctx context.Context, inDB, outDB *sql.DB, logger *log.Logger) error {
+	for _, pragma := range versionPragmas {
+		var v int64
+		if err := inDB.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(&v); err != nil {
+			return fmt.Errorf("read %s: %w", pragma, err)
+		}
+		if v == 0 {
+			continue
+		}
+		if _, err := outDB.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %d", pragma, v)); err != nil {
+			return fmt.Errorf("set %s: %w", pragma, err)
+		}
+		if logger != nil {
+			logger.Debugf("%s = %d", pragma, v)
+		}
+	}
+	return nil
+}
+
+func existingObjects(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master`)
+	if err != nil {
+		return nil, fmt.Errorf("output sqlite_master: %w", err)
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan output sqlite_master: %w", err)
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate output sqlite_master: %w", err)
+	}
+	return existing, nil
+}
+
+// createSchema creates the copied tables. It returns the tables that
+// failed to be created and, with DeferUnique, the unique indexes to create
+// once the data is loaded.
+func createSchema(ctx context.Context, outDB *sql.DB, s *schema.Schema, order []string, opts Options, existing map[string]bool) (map[string]bool, []schema.SQLItem, error) {
+	skipped := map[string]bool{}
 	tx, err := outDB.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("begin schema tx: %w", err)
+		return nil, nil, fmt.Errorf("begin schema tx: %w", err)
 	}
 	defer tx.Rollback()
+	var dangling map[string][]string
+	if opts.DropDanglingFKs {
+		dangling = danglingFKs(s, opts.Config)
+	}
+	var deferred []schema.SQLItem
+	var taken map[string]bool
+	if opts.DeferUnique {
+		taken = schemaNames(s)
+		for name := range existing {
+			taken[strings.ToLower(name)] = true
+		}
+	}
 	for _, name := range order {
 		if !tableIncluded(opts.Config, name) {
 			continue
 		}
 		tbl := s.Tables[name]
-		if tbl == nil {
+		if tbl == nil || existing[name] {
 			continue
 		}
-		if _, err := tx.ExecContext(ctx, tbl.SQL); err != nil {
-			return fmt.Errorf("create table %s: %w", name, err)
+		ddl := tbl.SQL
+		if parents := dangling[name]; len(parents) > 0 {
+			if ddl, err = dropForeignKeys(ddl, parents); err != nil {
+				return nil, nil, inClass(ErrSchema, fmt.Errorf("table %s: %w", name, err))
+			}
+			if opts.Logger != nil {
+				opts.Logger.Infof("drop foreign keys %s -> %s", name, strings.Join(parents, ", "))
+			}
+		}
+		var unique []schema.SQLItem
+		if opts.DeferUnique {
+			if ddl, unique, err = deferUniqueConstraints(ddl, name, uniqueKeepColumns(s, opts, name), taken); err != nil {
+				return nil, nil, inClass(ErrSchema, fmt.Errorf("table %s: %w", name, err))
+			}
+		}
+		if _, err := tx.ExecContext(ctx, ddl); err != nil {
+			err = schemaError("table", name, err)
+			if !opts.SkipFailedSchema {
+				return nil, nil, err
+			}
+			if opts.Logger != nil {
+				opts.Logger.Infof("skip table %s: %v", name, err)
+			}
+			skipped[name] = true
+			continue
+		}
+		deferred = append(deferred, unique...)
+		for _, col := range droppedColumns(opts.Config, name) {
+			stmt := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", schema.QuoteIdent(name), schema.QuoteIdent(col))
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return nil, nil, inClass(ErrSchema, &ColumnError{Op: "drop column", Table: name, Column: col, Err: err})
+			}
+		}
+		for _, ac := range addedColumns(opts.Config, name) {
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", schema.QuoteIdent(name), schema.QuoteIdent(ac.Name), ac.Type)
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return nil, nil, inClass(ErrSchema, &ColumnError{Op: "add column", Table: name, Column: ac.Name, Err: err})
+			}
 		}
 	}
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit schema: %w", err)
+		return nil, nil, fmt.Errorf("commit schema: %w", err)
 	}
-	return nil
+	return skipped, deferred, nil
 }
 
-func createPostDataSchema(ctx context.Context, outDB *sql.DB, s *schema.Schema, opts Options) error {
+func createPostDataSchema(ctx context.Context, outDB *sql.DB, s *schema.Schema, opts Options, existing map[string]bool, deferred []schema.SQLItem) error {
 	tx, err := outDB.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin post-data tx: %w", err)
 	}
 	defer tx.Rollback()
-	for _, v := range s.Views {
-		if v.SQL == "" {
-			continue
+	// A deferred UNIQUE constraint would have failed the insert of a
+	// duplicate row, so its index is never skipped. It fails on duplicates
+	// the masking produced, an integrity failure of the data.
+	for _, item := range deferred {
+		if _, err := tx.ExecContext(ctx, item.SQL); err != nil {
+			return inClass(ErrIntegrity, fmt.Errorf("deferred unique index %s: %w", item.Name, err))
 		}
-		if _, err := tx.ExecContext(ctx, v.SQL); err != nil {
-			return fmt.Errorf("create view %s: %w", v.Name, err)
+	}
+	// Indexes are created here, after the data, which loads faster than
+	// maintaining them row by row.
+	items := make([]schema.SQLItem, 0, len(s.Views)+len(s.Indexes)+len(s.Triggers))
+	views := map[string]bool{}
+	for _, v := range s.Views {
+		views[v.Name] = true
+	}
+	if !opts.NoViews {
+		items = append(items, s.Views...)
+	}
+	if !opts.NoIndexes {
+		items = append(items, s.Indexes...)
+	}
+	if strings.ToLower(opts.Triggers) == "on" {
+		for _, trg := range s.Triggers {
+			if opts.NoViews && views[trg.Table] {
+				if opts.Logger != nil {
+					opts.Logger.Infof("skip trigger %s: view %s is not created", trg.Name, trg.Table)
+				}
+				continue
+			}
+			items = append(items, trg)
 		}
 	}
-	for _, idx := range s.Indexes {
-		if idx.SQL == "" {
+	var collationIndexes []string
+	for _, item := range items {
+		if item.SQL == "" || existing[item.Name] {
 			continue
 		}
-		if _, err := tx.ExecContext(ctx, idx.SQL); err != nil {
-			return fmt.Errorf("create index %s: %w", idx.Name, err)
-		}
-	}
-	if strings.ToLower(opts.Triggers) == "on" {
-		for _, tr := range s.Triggers {
-			if tr.SQL == "" {
+		if _, err := tx.ExecContext(ctx, item.SQL); err != nil {
+			// Collations are often registered by the application at
+			// runtime. An index can be rebuilt once it is available, so
+			// one that needs such a collation is left out and reported
+			// rather than failing the copy.
+			if what, missing, ok := missingDependency(err); ok && what == "collation" && item.Type == "index" {
+				if opts.Logger != nil {
+					opts.Logger.Infof("skip index %s: collation %q is not available", item.Name, missing)
+				}
+				collationIndexes = append(collationIndexes, item.Name)
 				continue
 			}
-			if _, err := tx.ExecContext(ctx, tr.SQL); err != nil {
-				return fmt.Errorf("create trigger %s: %w", tr.Name, err)
+			err = schemaError(item.Type, item.Name, err)
+			if !opts.SkipFailedSchema {
+				return err
+			}
+			if opts.Logger != nil {
+				opts.Logger.Infof("skip %s %s: %v", item.Type, item.Name, err)
 			}
 		}
 	}
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit post-data: %w", err)
 	}
+	if len(collationIndexes) > 0 && opts.Logger != nil {
+		opts.Logger.Infof("skipped %d index(es) using unavailable collations, recreate them with the application: %s", len(collationIndexes), strings.Join(collationIndexes, ", "))
+	}
 	return nil
 }
 
-func copyData(ctx context.Context, inDB, outDB *sql.DB, s *schema.Schema, order []string, opts Options, selection *subset.Selection) error {
+func schemaError(kind, name string, err error) error {
+	if what, missing, ok := missingDependency(err); ok {
+		return inClass(ErrSchema, fmt.Errorf("create %s %s: missing %s %q, probably provided by an extension loaded when the database was created (use --skip-failed-schema to continue without it): %w", kind, name, what, missing, err))
+	}
+	return inClass(ErrSchema, fmt.Errorf("create %s %s: %w", kind, name, err))
+}
+
+// missingDependency reports the kind and name of the collation, virtual
+// table module, or function whose absence caused err.
+func missingDependency(err error) (what, missing string, ok bool) {
+	msg := err.Error()
+	for _, dep := range []struct{ marker, what string }{
+		{"no such collation sequence: ", "collation"},
+		{"no such module: ", "virtual table module"},
+		{"no such function: ", "function"},
+	} {
+		if i := strings.Index(msg, dep.marker); i >= 0 {
+			missing, _, _ := strings.Cut(msg[i+len(dep.marker):], " ")
+			return dep.what, missing, true
+		}
+	}
+	return "", "", false
+}
+
+func copyData(ctx context.Context, inDB, outDB *sql.DB, s *schema.Schema, order []string, opts Options, selection *subset.Selection, skipped map[string]bool) error {
+	// ATTACH parses the input schema again on the output connection, which
+	// rejects tables without a CREATE statement as corruption, so such an
+	// input is read row by row.
+	attach := len(s.Skipped) == 0
 	for _, name := range order {
 		if !tableIncluded(opts.Config, name) {
 			if opts.Logger != nil {
@@ -183,12 +684,15 @@ func copyData(ctx context.Context, inDB, outDB *sql.DB, s *schema.Schema, order
 			}
 			continue
 		}
+		if skipped[name] {
+			continue
+		}
 		bl := s.Tables[name]
 		if bl == nil {
 			continue
 		}
 		var selSet *subset.PKSet
-		if selection != nil {
+		if selection != nil && !selection.Whole[name] {
 			selSet = selection.Sets[name]
 			if selSet == nil {
 				if opts.Logger != nil {
@@ -200,19 +704,24 @@ func copyData(ctx context.Context, inDB, outDB *sql.DB, s *schema.Schema, order
 		if opts.Logger != nil {
 			opts.Logger.Infof("copy table %s", name)
 		}
-		if err := copyTable(ctx, inDB, outDB, bl, opts, selSet); err != nil {
+		if err := copyTable(ctx, inDB, outDB, s, bl, opts, selSet, attach); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func copyTable(ctx context.Context, inDB, outDB *sql.DB, tbl *schema.Table, opts Options, selSet *subset.PKSet) error {
+func copyTable(ctx context.Context, inDB, outDB *sql.DB, s *schema.Schema, tbl *schema.Table, opts Options, selSet *subset.PKSet, attach bool) error {
+	opts.Seed = tableSeed(opts, tbl.Name)
+	dropped := droppedColumns(opts.Config, tbl.Name)
 	colNames := make([]string, 0, len(tbl.Columns))
 	colIndex := map[string]int{}
-	for i, c := range tbl.Columns {
+	for _, c := range tbl.Columns {
+		if containsString(dropped, c.Name) {
+			continue
+		}
+		colIndex[c.Name] = len(colNames)
 		colNames = append(colNames, c.Name)
-		colIndex[c.Name] = i
 	}
 	pkCols := tbl.PrimaryKeys
 	useRowID := len(pkCols) == 0 && !tbl.WithoutRowID
@@ -223,36 +732,87 @@ func copyTable(ctx context.Context, inDB, outDB *sql.DB, tbl *schema.Table, opts
 	for _, c := range colNames {
 		selectCols = append(selectCols, schema.QuoteIdent(c))
 	}
-	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", schema.QuoteIdent(tbl.Name), strings.Join(quotedCols(colNames), ", "), placeholders(len(colNames)))
+	srcCount := len(colNames)
+	for _, ac := range addedColumns(opts.Config, tbl.Name) {
+		colIndex[ac.Name] = len(colNames)
+		colNames = append(colNames, ac.Name)
+	}
+	keepRowID := useRowID && preserveRowID(opts.Config, tbl.Name)
+	insertCols := quotedCols(colNames)
+	if keepRowID {
+		insertCols = append([]string{"rowid"}, insertCols...)
+	}
+	transformers, err := buildTransformers(ctx, inDB, s, opts.Config, tbl, opts.Salt, opts.Tags)
+	if err != nil {
+		return err
+	}
+	if err := preloadTransformers(ctx, inDB, tbl, opts, transformers); err != nil {
+		return err
+	}
+	rawTimeColumns(selectCols, colNames[:srcCount], tbl, transformers, useRowID)
+	// Taken before the wrappers below, so masking a key to look it up
+	// records nothing.
+	keyTransformers := keyColumnTransformers(tbl, transformers)
+	opts.summary.wrap(tbl.Name, transformers)
+	withVault(opts.vault, tbl.Name, opts.Config, transformers)
+	auditStmt, err := withAudit(ctx, outDB, tbl, opts.Config, transformers)
+	if err != nil {
+		return err
+	}
+	if auditStmt != nil {
+		defer auditStmt.Close()
+	}
+
+	shuffle := shuffleRows(opts.Config, tbl.Name)
+	if attach && len(transformers) == 0 && selSet == nil && !opts.Incremental && !shuffle {
+		srcCols := make([]string, 0, len(insertCols))
+		if keepRowID {
+			srcCols = append(srcCols, "rowid")
+		}
+		srcCols = append(srcCols, quotedCols(colNames[:srcCount])...)
+		for range colNames[srcCount:] {
+			srcCols = append(srcCols, "NULL")
+		}
+		return copyTableAttached(ctx, outDB, tbl, opts, insertCols, srcCols, useRowID)
+	}
+
+	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", schema.QuoteIdent(tbl.Name), strings.Join(insertCols, ", "), placeholders(len(insertCols)))
 	stmt, err := outDB.PrepareContext(ctx, insertSQL)
 	if err != nil {
-		return fmt.Errorf("prepare insert %s: %w", tbl.Name, err)
+		return &TableError{Op: "prepare insert", Table: tbl.Name, Err: err}
 	}
 	defer stmt.Close()
 
-	transformers, err := buildTransformers(ctx, inDB, opts.Config, tbl.Name, opts.Salt)
-	if err != nil {
-		return err
+	var present *presentKeys
+	if opts.Incremental {
+		present, err = loadPresentKeys(ctx, outDB, tbl, useRowID, keepRowID, keyTransformers)
+		if err != nil {
+			return err
+		}
 	}
 
+	// The ordinal counts every row scanned, including rows an incremental
+	// copy skips, and runs on across the chunks of a subset.
+	var ordinal int64
 	processRows := func(rows *sql.Rows) error {
 		defer rows.Close()
 		jobs := opts.Jobs
-		if jobs < 1 {
-			jobs = 1
-		}
 		if jobs == 1 || len(transformers) == 0 {
-			return processRowsSequential(ctx, rows, stmt, selectCols, colIndex, pkCols, useRowID, transformers, opts, tbl)
+			return processRowsSequential(ctx, rows, stmt, selectCols, colIndex, pkCols, useRowID, keepRowID, present, transformers, opts, tbl, &ordinal)
 		}
-		return processRowsParallel(ctx, rows, stmt, selectCols, colIndex, pkCols, useRowID, transformers, opts, tbl, jobs)
+		return processRowsParallel(ctx, rows, stmt, selectCols, colIndex, pkCols, useRowID, keepRowID, present, transformers, opts, tbl, &ordinal, jobs)
 	}
 
 	if selSet == nil {
-		orderBy := buildOrderBy(tbl, useRowID)
-		query := fmt.Sprintf("SELECT %s FROM %s %s", strings.Join(selectCols, ", "), schema.QuoteIdent(tbl.Name), orderBy)
-		rows, err := inDB.QueryContext(ctx, query)
+		query := fmt.Sprintf("SELECT %s FROM %s%s", strings.Join(selectCols, ", "), schema.QuoteIdent(tbl.Name), tableFilter(tbl, opts.Config.Tables[tbl.Name], useRowID))
+		var args []any
+		if shuffle {
+			query = shuffleQuery(query, tbl, useRowID)
+			args = append(args, shuffleKey(tbl, opts))
+		}
+		rows, err := inDB.QueryContext(ctx, query, args...)
 		if err != nil {
-			return fmt.Errorf("select %s: %w", tbl.Name, err)
+			return &TableError{Op: "select", Table: tbl.Name, Err: err}
 		}
 		return processRows(rows)
 	}
@@ -261,15 +821,23 @@ func copyTable(ctx context.Context, inDB, outDB *sql.DB, tbl *schema.Table, opts
 	if err != nil {
 		return err
 	}
-	sortRows(pkValues)
-	chunks := chunkValues(pkValues, 500)
+	orderBy := buildOrderBy(tbl, useRowID)
+	if shuffle {
+		sortShuffled(pkValues, shuffleKey(tbl, opts))
+		orderBy = shuffleOrderBy(tbl, useRowID)
+	} else {
+		sortRows(pkValues)
+	}
+	chunks := chunkValues(pkValues, subset.EffectiveChunkSize(opts.ChunkSize, len(selSet.Cols), opts.bindLimit))
 	for _, chunk := range chunks {
 		whereIn, args := buildTupleIn(selSet.Cols, chunk, useRowID)
-		orderBy := buildOrderBy(tbl, useRowID)
+		if shuffle {
+			args = append(args, shuffleKey(tbl, opts))
+		}
 		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s %s", strings.Join(selectCols, ", "), schema.QuoteIdent(tbl.Name), whereIn, orderBy)
 		rows, err := inDB.QueryContext(ctx, query, args...)
 		if err != nil {
-			return fmt.Errorf("select subset %s: %w", tbl.Name, err)
+			return &TableError{Op: "select subset", Table: tbl.Name, Err: err}
 		}
 		if err := processRows(rows); err != nil {
 			return err
@@ -278,36 +846,72 @@ func copyTable(ctx context.Context, inDB, outDB *sql.DB, tbl *schema.Table, opts
 	return nil
 }
 
-func processRowsSequential(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, selectCols []string, colIndex map[string]int, pkCols []string, useRowID bool, transformers map[string]transform.Transformer, opts Options, tbl *schema.Table) error {
+func copyTableAttached(ctx context.Context, outDB *sql.DB, tbl *schema.Table, opts Options, insertCols, srcCols []string, useRowID bool) error {
+	conn, err := outDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("output connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+attachedSchema, opts.InPath); err != nil {
+		return fmt.Errorf("attach input: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE "+attachedSchema)
+	query := fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM %s.%s%s",
+		schema.QuoteIdent(tbl.Name), strings.Join(insertCols, ", "), strings.Join(srcCols, ", "),
+		attachedSchema, schema.QuoteIdent(tbl.Name), tableFilter(tbl, opts.Config.Tables[tbl.Name], useRowID))
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return &TableError{Op: "insert", Table: tbl.Name, Err: err}
+	}
+	return nil
+}
+
+func processRowsSequential(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, selectCols []string, colIndex map[string]int, pkCols []string, useRowID, keepRowID bool, present *presentKeys, transformers []columnTransformer, opts Options, tbl *schema.Table, ordinal *int64) error {
+	scanTargets := make([]any, len(selectCols))
+	rowValues := make([]any, len(selectCols))
+	for i := range scanTargets {
+		scanTargets[i] = &rowValues[i]
+	}
+	buf := make([]any, len(colIndex)+1)
+	pkBuf := make([]any, 0, len(pkCols)+1)
 	for rows.Next() {
-		scanTargets := make([]any, len(selectCols))
-		rowValues := make([]any, len(selectCols))
-		for i := range scanTargets {
-			scanTargets[i] = &rowValues[i]
-		}
 		if err := rows.Scan(scanTargets...); err != nil {
-			return fmt.Errorf("scan row %s: %w", tbl.Name, err)
+			return &TableError{Op: "scan row", Table: tbl.Name, Err: err}
 		}
-		values, rowCtx := buildRowContext(rowValues, colIndex, pkCols, useRowID, opts, tbl)
-		for col, tr := range transformers {
-			idx := colIndex[col]
-			newVal, err := tr.Transform(values[idx], rowCtx)
+		row, rowCtx := buildRowContext(buf, pkBuf, rowValues, colIndex, pkCols, useRowID, opts, tbl)
+		*ordinal++
+		rowCtx.Ordinal = *ordinal
+		skip, err := isPresent(present, rowCtx.PK, rowCtx, tbl.Name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		values := row[1:]
+		for _, ct := range transformers {
+			idx := colIndex[ct.column]
+			rowCtx.Column, rowCtx.ColumnType = ct.column, ct.colType
+			newVal, err := ct.tr.Transform(values[idx], rowCtx)
 			if err != nil {
-				return fmt.Errorf("transform %s.%s: %w", tbl.Name, col, err)
+				return &ColumnError{Op: "transform", Table: tbl.Name, Column: ct.column, Err: err}
 			}
 			values[idx] = newVal
 		}
-		if _, err := stmt.ExecContext(ctx, values...); err != nil {
-			return fmt.Errorf("insert %s: %w", tbl.Name, err)
+		args := values
+		if keepRowID {
+			args = row
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return &TableError{Op: "insert", Table: tbl.Name, Err: err}
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("iterate %s: %w", tbl.Name, err)
+		return &TableError{Op: "iterate", Table: tbl.Name, Err: err}
 	}
 	return nil
 }
 
-func processRowsParallel(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, selectCols []string, colIndex map[string]int, pkCols []string, useRowID bool, transformers map[string]transform.Transformer, opts Options, tbl *schema.Table, jobs int) error {
+func processRowsParallel(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, selectCols []string, colIndex map[string]int, pkCols []string, useRowID, keepRowID bool, present *presentKeys, transformers []columnTransformer, opts Options, tbl *schema.Table, ordinal *int64, jobs int) error {
 	type job struct {
 		index  int
 		values []any
@@ -323,17 +927,20 @@ func processRowsParallel(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, se
 	for i := 0; i < jobs; i++ {
 		go func() {
 			for j := range jobsCh {
-				values := make([]any, len(j.values))
-				copy(values, j.values)
+				values := j.values[1:]
 				var err error
-				for col, tr := range transformers {
-					idx := colIndex[col]
-					values[idx], err = tr.Transform(values[idx], j.rowCtx)
+				for _, ct := range transformers {
+					idx := colIndex[ct.column]
+					j.rowCtx.Column, j.rowCtx.ColumnType = ct.column, ct.colType
+					values[idx], err = ct.tr.Transform(values[idx], j.rowCtx)
 					if err != nil {
-						resultsCh <- result{index: j.index, err: err}
+						resultsCh <- result{index: j.index, err: &ColumnError{Op: "transform", Table: tbl.Name, Column: ct.column, Err: err}}
 						goto next
 					}
 				}
+				if keepRowID {
+					values = j.values
+				}
 				resultsCh <- result{index: j.index, values: values}
 			next:
 			}
@@ -354,25 +961,35 @@ func processRowsParallel(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, se
 				break
 			}
 			if _, err := stmt.ExecContext(ctx, r.values...); err != nil {
-				return fmt.Errorf("insert %s: %w", tbl.Name, err)
+				return &TableError{Op: "insert", Table: tbl.Name, Err: err}
 			}
 			delete(pending, nextIndex)
 			nextIndex++
 		}
 		return nil
 	}
+	scanTargets := make([]any, len(selectCols))
+	rowValues := make([]any, len(selectCols))
+	for i := range scanTargets {
+		scanTargets[i] = &rowValues[i]
+	}
 	for rows.Next() {
-		scanTargets := make([]any, len(selectCols))
-		rowValues := make([]any, len(selectCols))
-		for i := range scanTargets {
-			scanTargets[i] = &rowValues[i]
-		}
 		if err := rows.Scan(scanTargets...); err != nil {
 			close(jobsCh)
-			return fmt.Errorf("scan row %s: %w", tbl.Name, err)
+			return &TableError{Op: "scan row", Table: tbl.Name, Err: err}
+		}
+		row, rowCtx := buildRowContext(nil, nil, rowValues, colIndex, pkCols, useRowID, opts, tbl)
+		*ordinal++
+		rowCtx.Ordinal = *ordinal
+		skip, err := isPresent(present, rowCtx.PK, rowCtx, tbl.Name)
+		if err != nil {
+			close(jobsCh)
+			return err
+		}
+		if skip {
+			continue
 		}
-		values, rowCtx := buildRowContext(rowValues, colIndex, pkCols, useRowID, opts, tbl)
-		jobsCh <- job{index: index, values: values, rowCtx: rowCtx}
+		jobsCh <- job{index: index, values: row, rowCtx: rowCtx}
 		inflight++
 		index++
 		for inflight > jobs*2 {
@@ -392,21 +1009,122 @@ func processRowsParallel(ctx context.Context, rows *sql.Rows, stmt *sql.Stmt, se
 		}
 	}
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("iterate %s: %w", tbl.Name, err)
+		return &TableError{Op: "iterate", Table: tbl.Name, Err: err}
 	}
 	return nil
 }
 
-func buildRowContext(rowValues []any, colIndex map[string]int, pkCols []string, useRowID bool, opts Options, tbl *schema.Table) ([]any, transform.RowContext) {
+// presentKeys are the keys an incremental copy finds in the output. Those
+// keys are masked, so a source key is masked by the transformers of its
+// columns before it is looked up.
+type presentKeys struct {
+	keys         map[string]struct{}
+	transformers []columnTransformer
+}
+
+// keyColumnTransformers returns the transformer of each primary key column
+// in key order, with a nil tr for a column copied as is, or nil when no
+// key column is masked.
+func keyColumnTransformers(tbl *schema.Table, transformers []columnTransformer) []columnTransformer {
+	var out []columnTransformer
+	for i, col := range tbl.PrimaryKeys {
+		for _, ct := range transformers {
+			if ct.column != col {
+				continue
+			}
+			if out == nil {
+				out = make([]columnTransformer, len(tbl.PrimaryKeys))
+			}
+			out[i] = ct
+		}
+	}
+	return out
+}
+
+func isPresent(present *presentKeys, pk []any, row transform.RowContext, table string) (bool, error) {
+	if present == nil {
+		return false, nil
+	}
+	key := pk
+	if present.transformers != nil {
+		key = make([]any, len(pk))
+		for i, v := range pk {
+			ct := present.transformers[i]
+			if ct.tr == nil {
+				key[i] = v
+				continue
+			}
+			row.Column, row.ColumnType = ct.column, ct.colType
+			var err error
+			if key[i], err = ct.tr.Transform(v, row); err != nil {
+				return false, &ColumnError{Op: "transform", Table: table, Column: ct.column, Err: err}
+			}
+		}
+	}
+	_, ok := present.keys[keyFor(key)]
+	return ok, nil
+}
+
+func loadPresentKeys(ctx context.Context, outDB *sql.DB, tbl *schema.Table, useRowID, keepRowID bool, keyTransformers []columnTransformer) (*presentKeys, error) {
+	var cols []string
+	switch {
+	case len(tbl.PrimaryKeys) > 0:
+		cols = quotedCols(tbl.PrimaryKeys)
+	case keepRowID:
+		cols = []string{"rowid"}
+	case useRowID:
+		return nil, fmt.Errorf("incremental copy of %s requires a primary key or tables.%s.preserve_rowid", tbl.Name, tbl.Name)
+	default:
+		return nil, fmt.Errorf("incremental copy of %s requires a primary key", tbl.Name)
+	}
+	rows, err := outDB.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), schema.QuoteIdent(tbl.Name)))
+	if err != nil {
+		return nil, &TableError{Op: "select existing keys", Table: tbl.Name, Err: err}
+	}
+	defer rows.Close()
+	present := &presentKeys{keys: map[string]struct{}{}, transformers: keyTransformers}
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, &TableError{Op: "scan existing keys", Table: tbl.Name, Err: err}
+		}
+		present.keys[keyFor(vals)] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &TableError{Op: "iterate existing keys", Table: tbl.Name, Err: err}
+	}
+	return present, nil
+}
+
+// buildRowContext lays the output row out as [rowid, columns...] so callers
+// can pass either the full slice or row[1:] to the insert. row and pkBuf are
+// reused when non-nil; the parallel path passes nil since rows outlive the
+// scan buffer there.
+func buildRowContext(row, pkBuf []any, rowValues []any, colIndex map[string]int, pkCols []string, useRowID bool, opts Options, tbl *schema.Table) ([]any, transform.RowContext) {
+	if row == nil {
+		row = make([]any, len(colIndex)+1)
+	}
 	var rowid any
 	start := 0
 	if useRowID {
 		rowid = rowValues[0]
 		start = 1
 	}
-	values := make([]any, len(rowValues[start:]))
-	copy(values, rowValues[start:])
-	pkValues := make([]any, 0, len(pkCols))
+	row[0] = rowid
+	values := row[1:]
+	srcLen := copy(values, rowValues[start:])
+	clear(values[srcLen:])
+	for i, v := range values[:srcLen] {
+		// The driver scans an empty blob as a nil slice, which binds as NULL.
+		if b, ok := v.([]byte); ok && b == nil {
+			values[i] = []byte{}
+		}
+	}
+	pkValues := pkBuf[:0]
 	if len(pkCols) > 0 {
 		for _, pk := range pkCols {
 			idx := colIndex[pk]
@@ -415,10 +1133,57 @@ func buildRowContext(rowValues []any, colIndex map[string]int, pkCols []string,
 	} else if useRowID {
 		pkValues = append(pkValues, rowid)
 	} else {
-		pkValues = append(pkValues, rowFingerprint(values))
+		pkValues = append(pkValues, rowFingerprint(values[:srcLen]))
+	}
+	rowCtx := transform.RowContext{Table: identityTable(opts.Config, tbl.Name), PK: pkValues, Seed: opts.Seed, Salt: opts.Salt}
+	return row, rowCtx
+}
+
+// rawTimeColumns selects the DATE, DATETIME and TIMESTAMP columns that no
+// transform reads as +column. The driver parses text in such columns into a
+// time.Time and writes it back in another format; the expression has no
+// declared type, so the value is copied as stored. Key columns keep the
+// parsed value because it feeds the row hash.
+func rawTimeColumns(selectCols, colNames []string, tbl *schema.Table, transformers []columnTransformer, useRowID bool) {
+	offset := 0
+	if useRowID {
+		offset = 1
+	}
+	types := map[string]string{}
+	for _, c := range tbl.Columns {
+		types[c.Name] = strings.ToUpper(c.Type)
+	}
+	for i, name := range colNames {
+		if containsString(tbl.PrimaryKeys, name) {
+			continue
+		}
+		switch types[name] {
+		case "DATE", "DATETIME", "TIMESTAMP":
+		default:
+			continue
+		}
+		transformed := false
+		for _, ct := range transformers {
+			transformed = transformed || ct.column == name
+		}
+		if !transformed {
+			selectCols[offset+i] = "+" + selectCols[offset+i]
+		}
+	}
+}
+
+func tableFilter(tbl *schema.Table, tc *config.TableConfig, useRowID bool) string {
+	var clause string
+	if tc != nil && tc.Where != "" {
+		clause += " WHERE " + tc.Where
+	}
+	if orderBy := buildOrderBy(tbl, useRowID); orderBy != "" {
+		clause += " " + orderBy
+	}
+	if tc != nil && tc.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d", tc.Limit)
 	}
-	rowCtx := transform.RowContext{Table: tbl.Name, PK: pkValues, Seed: opts.Seed, Salt: opts.Salt}
-	return values, rowCtx
+	return clause
 }
 
 func buildOrderBy(tbl *schema.Table, useRowID bool) string {
@@ -447,41 +1212,189 @@ func placeholders(n int) string {
 	return strings.Join(vals, ", ")
 }
 
-func buildTransformers(ctx context.Context, db *sql.DB, cfg *config.Config, table string, salt string) (map[string]transform.Transformer, error) {
-	result := map[string]transform.Transformer{}
+type columnTransformer struct {
+	column  string
+	colType string
+	tr      transform.Transformer
+}
+
+func buildTransformers(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config, table *schema.Table, salt string, tags []string) ([]columnTransformer, error) {
+	var result []columnTransformer
+	for _, cc := range columnTransforms(s, cfg, table, tags) {
+		tr, err := buildTransformerForColumn(ctx, db, cc.tc, salt, cc.colType)
+		if err != nil {
+			return nil, inClass(config.ErrInvalid, &ColumnError{Op: "build transformer", Table: table.Name, Column: cc.column, Err: err})
+		}
+		if tr != nil {
+			result = append(result, columnTransformer{column: cc.column, colType: cc.colType, tr: tr})
+		}
+	}
+	return result, nil
+}
+
+// columnConfig is the transform config a column of the output is built
+// from.
+type columnConfig struct {
+	column  string
+	colType string
+	tc      *config.TransformConfig
+}
+
+// columnTransforms resolves the transform of every output column of table
+// as buildTransformers builds it, sorted by column: the column's own, an
+// inherited IntPermute, or by_type, then the add_columns. Dropped columns
+// and transforms whose tags are not selected are left out.
+func columnTransforms(s *schema.Schema, cfg *config.Config, table *schema.Table, tags []string) []columnConfig {
+	var result []columnConfig
 	if cfg == nil {
-		return result, nil
+		return result
 	}
-	tbl := cfg.Tables[table]
+	tbl := cfg.Tables[table.Name]
 	if tbl == nil {
-		return result, nil
+		tbl = &config.TableConfig{}
 	}
+	columns := map[string]*config.TransformConfig{}
 	for col, tc := range tbl.Columns {
-		if tc == nil {
+		if tc != nil {
+			columns[col] = tc
+		}
+	}
+	for col, tc := range inheritedKeyTransforms(s, cfg, table) {
+		if _, ok := columns[col]; !ok {
+			columns[col] = tc
+		}
+	}
+	for _, c := range table.Columns {
+		if _, ok := columns[c.Name]; ok {
 			continue
 		}
-		tr, err := buildTransformerForColumn(ctx, db, tc, salt)
-		if err != nil {
-			return nil, fmt.Errorf("build transformer %s.%s: %w", table, col, err)
+		if tc := cfg.ColumnTransform(table.Name, c.Name, schema.Affinity(c.Type)); tc != nil {
+			columns[c.Name] = tc
 		}
-		if tr != nil {
-			result[col] = tr
+	}
+	for col, tc := range columns {
+		if containsString(tbl.DropColumns, col) || !tagged(tc, tags) {
+			continue
 		}
+		result = append(result, columnConfig{column: col, colType: columnType(table, col), tc: tc})
 	}
-	return result, nil
+	for _, ac := range tbl.AddColumns {
+		if ac.Transform == nil || !tagged(ac.Transform, tags) {
+			continue
+		}
+		result = append(result, columnConfig{column: ac.Name, colType: ac.Type, tc: ac.Transform})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].column < result[j].column
+	})
+	return result
+}
+
+// tagged reports whether tc applies in a run selecting tags: it is
+// untagged, or one of its tags is selected.
+func tagged(tc *config.TransformConfig, tags []string) bool {
+	if len(tc.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tc.Tags {
+		for _, selected := range tags {
+			if strings.EqualFold(tag, selected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inheritedKeyTransforms returns the IntPermute configs of the keys the
+// table's foreign keys reference, directly or through the foreign keys of
+// the referenced columns (A -> B -> C), so a remapped key is remapped the
+// same way wherever it is referenced.
+func inheritedKeyTransforms(s *schema.Schema, cfg *config.Config, tbl *schema.Table) map[string]*config.TransformConfig {
+	out := map[string]*config.TransformConfig{}
+	for _, fk := range tbl.ForeignKeys {
+		if tc := keyTransform(s, cfg, fk.Table, referencedColumn(s, fk), map[string]bool{}); tc != nil {
+			out[fk.From] = tc
+		}
+	}
+	return out
+}
+
+// keyTransform returns the IntPermute config of table.column: its own, or,
+// when the config does not list the column, the one it inherits from the
+// key it references. seen stops reference cycles.
+func keyTransform(s *schema.Schema, cfg *config.Config, table, column string, seen map[string]bool) *config.TransformConfig {
+	key := table + "." + column
+	if column == "" || seen[key] {
+		return nil
+	}
+	seen[key] = true
+	if tc := cfg.Tables[table]; tc != nil {
+		if own, ok := tc.Columns[column]; ok {
+			if own != nil && strings.EqualFold(own.Type, "IntPermute") {
+				return own
+			}
+			return nil
+		}
+	}
+	parent := s.Tables[table]
+	if parent == nil {
+		return nil
+	}
+	for _, fk := range parent.ForeignKeys {
+		if fk.From != column {
+			continue
+		}
+		if tc := keyTransform(s, cfg, fk.Table, referencedColumn(s, fk), seen); tc != nil {
+			return tc
+		}
+	}
+	return nil
+}
+
+// referencedColumn is the parent column fk references. A reference that
+// names no column (user_id REFERENCES users) is to the parent's primary
+// key, matched by position for a composite key.
+func referencedColumn(s *schema.Schema, fk schema.ForeignKey) string {
+	if fk.To != "" {
+		return fk.To
+	}
+	parent := s.Tables[fk.Table]
+	if parent == nil || fk.Seq >= len(parent.PrimaryKeys) {
+		return ""
+	}
+	return parent.PrimaryKeys[fk.Seq]
+}
+
+// columnType returns the declared type of the named column, or "" when the
+// table has no such column.
+func columnType(table *schema.Table, name string) string {
+	for _, c := range table.Columns {
+		if c.Name == name {
+			return c.Type
+		}
+	}
+	return ""
 }
 
-func buildTransformerForColumn(ctx context.Context, db *sql.DB, tc *config.TransformConfig, salt string) (transform.Transformer, error) {
+func buildTransformerForColumn(ctx context.Context, db *sql.DB, tc *config.TransformConfig, salt, colType string) (transform.Transformer, error) {
 	if tc.LookupTable != "" {
+		if err := transform.Allowed(tc.Type); err != nil {
+			return nil, err
+		}
 		mapping, err := loadLookupMap(ctx, db, tc)
 		if err != nil {
 			return nil, err
 		}
 		if len(mapping) > 0 {
-			return transform.NewMapReplace(mapping), nil
+			tr, err := transform.WithCache(transform.NewMapReplace(mapping), tc)
+			if err != nil {
+				return nil, err
+			}
+			return transform.WithMatch(tr, tc)
 		}
 	}
-	return transform.Build(tc, salt)
+	return transform.BuildForColumn(tc, salt, colType)
 }
 
 func loadLookupMap(ctx context.Context, db *sql.DB, tc *config.TransformConfig) (map[string]string, error) {
@@ -506,7 +1419,7 @@ func loadLookupMap(ctx context.Context, db *sql.DB, tc *config.TransformConfig)
 		if err := rows.Scan(&key, &val); err != nil {
 			return nil, fmt.Errorf("scan lookup table %s: %w", tc.LookupTable, err)
 		}
-		result[fmt.Sprint(key)] = fmt.Sprint(val)
+		result[transform.CanonicalString(key)] = transform.CanonicalString(val)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterate lookup table %s: %w", tc.LookupTable, err)
@@ -514,6 +1427,307 @@ func loadLookupMap(ctx context.Context, db *sql.DB, tc *config.TransformConfig)
 	return result, nil
 }
 
+func preserveRowID(cfg *config.Config, name string) bool {
+	if cfg == nil {
+		return false
+	}
+	tbl := cfg.Tables[name]
+	return tbl != nil && tbl.PreserveRowID
+}
+
+// identityTable is the name that seeds the table's deterministic masks:
+// its identity_table, or its own name.
+func identityTable(cfg *config.Config, name string) string {
+	if cfg != nil {
+		if tbl := cfg.Tables[name]; tbl != nil && tbl.IdentityTable != "" {
+			return tbl.IdentityTable
+		}
+	}
+	return name
+}
+
+// tableSeed is the seed of the table's masks: the global seed mixed with
+// its seed, if it has one. copyTable and maskTable set it as opts.Seed for
+// everything below them.
+func tableSeed(opts Options, name string) int64 {
+	if tbl := opts.Config.Tables[name]; tbl != nil {
+		return transform.MixSeed(opts.Seed, tbl.Seed)
+	}
+	return opts.Seed
+}
+
+func droppedColumns(cfg *config.Config, name string) []string {
+	if cfg == nil {
+		return nil
+	}
+	tbl := cfg.Tables[name]
+	if tbl == nil {
+		return nil
+	}
+	return tbl.DropColumns
+}
+
+func addedColumns(cfg *config.Config, name string) []config.AddColumnConfig {
+	if cfg == nil {
+		return nil
+	}
+	tbl := cfg.Tables[name]
+	if tbl == nil {
+		return nil
+	}
+	return tbl.AddColumns
+}
+
+// checkSalt guards against hashing without a salt: unsalted digests of
+// low-entropy values (emails, phone numbers) are reversible with a
+// dictionary. It warns, or fails with RequireSalt. The columns are those
+// the transformers are built for, so by_type, inherited keys, and
+// add_columns count too.
+func checkSalt(s *schema.Schema, opts Options) error {
+	if opts.Salt != "" {
+		return nil
+	}
+	var cols []string
+	for name, tbl := range s.Tables {
+		if !tableIncluded(opts.Config, name) {
+			continue
+		}
+		for _, cc := range columnTransforms(s, opts.Config, tbl, opts.Tags) {
+			if saltKeyed(cc.tc) {
+				cols = append(cols, name+"."+cc.column)
+			}
+		}
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	sort.Strings(cols)
+	if opts.RequireSalt {
+		return fmt.Errorf("--salt is required: hash transforms configured on %s", strings.Join(cols, ", "))
+	}
+	if opts.Logger != nil {
+		opts.Logger.Infof("salt warning: no --salt given, hashes on %s are unsalted and reversible with a dictionary", strings.Join(cols, ", "))
+	}
+	return nil
+}
+
+// saltKeyed reports whether tc, or a transform nested in it, is a hash or
+// permutation keyed by the salt.
+func saltKeyed(tc *config.TransformConfig) bool {
+	if tc == nil {
+		return false
+	}
+	switch strings.ToLower(tc.Type) {
+	case "hashsha256", "hmacsha256", "pbkdf2", "stabletokenize", "intpermute":
+		return true
+	}
+	for _, g := range tc.Groups {
+		if saltKeyed(g) {
+			return true
+		}
+	}
+	return saltKeyed(tc.Element)
+}
+
+// validateStrictTypes rejects transforms that would store text in an
+// INTEGER or REAL column of a STRICT table, which SQLite fails at insert
+// time, leaving a half-written output.
+func validateStrictTypes(s *schema.Schema, cfg *config.Config) error {
+	names := make([]string, 0, len(cfg.Tables))
+	for name := range cfg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tbl := s.Tables[name]
+		tc := cfg.Tables[name]
+		if tbl == nil || tc == nil || !tbl.Strict {
+			continue
+		}
+		for _, col := range tbl.Columns {
+			cfgCol := tc.Columns[col.Name]
+			if cfgCol == nil || containsString(tc.DropColumns, col.Name) {
+				continue
+			}
+			out := transform.OutputTypeForColumn(cfgCol, col.Type)
+			if want := schema.Affinity(col.Type); schema.TypeChanges(out, want) {
+				return fmt.Errorf("STRICT table %s: %s on column %s produces %s, column type is %s", name, cfgCol.Type, col.Name, out, col.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTableSeeds rejects table seeds that would split a remapped key
+// from its references: IntPermute is keyed by the seed, so a key and the
+// foreign keys that inherit its IntPermute need the same seed.
+func validateTableSeeds(s *schema.Schema, cfg *config.Config) error {
+	seed := func(name string) int64 {
+		if tc := cfg.Tables[name]; tc != nil {
+			return tc.Seed
+		}
+		return 0
+	}
+	names := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tbl := s.Tables[name]
+		for col, tc := range inheritedKeyTransforms(s, cfg, tbl) {
+			if own := cfg.Tables[name]; own != nil && own.Columns[col] != nil && !strings.EqualFold(own.Columns[col].Type, "IntPermute") {
+				continue
+			}
+			for owner, otc := range cfg.Tables {
+				if otc == nil {
+					continue
+				}
+				for key, ktc := range otc.Columns {
+					if ktc != tc || seed(name) == seed(owner) {
+						continue
+					}
+					return fmt.Errorf("tables.%s.seed: %s.%s inherits the IntPermute of %s.%s, which remaps by seed; give both tables the same seed", name, name, col, owner, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateAddColumns(s *schema.Schema, cfg *config.Config) error {
+	names := make([]string, 0, len(cfg.Tables))
+	for name := range cfg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		added := addedColumns(cfg, name)
+		if len(added) == 0 {
+			continue
+		}
+		tbl := s.Tables[name]
+		if tbl == nil {
+			return fmt.Errorf("add_columns: %w", &schema.NotFoundError{Table: name})
+		}
+		seen := map[string]bool{}
+		for _, c := range tbl.Columns {
+			seen[strings.ToLower(c.Name)] = true
+		}
+		for _, ac := range added {
+			if ac.Name == "" {
+				return fmt.Errorf("add_columns: %s: column name is required", name)
+			}
+			if seen[strings.ToLower(ac.Name)] {
+				return fmt.Errorf("add_columns: column %s.%s already exists", name, ac.Name)
+			}
+			seen[strings.ToLower(ac.Name)] = true
+		}
+	}
+	return nil
+}
+
+func validateDropColumns(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config) error {
+	names := make([]string, 0, len(cfg.Tables))
+	for name := range cfg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dropped := droppedColumns(cfg, name)
+		if len(dropped) == 0 {
+			continue
+		}
+		tbl := s.Tables[name]
+		if tbl == nil {
+			return fmt.Errorf("drop_columns: %w", &schema.NotFoundError{Table: name})
+		}
+		for _, col := range dropped {
+			found := false
+			for _, c := range tbl.Columns {
+				if c.Name == col {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("drop_columns: %w", &schema.NotFoundError{Table: name, Column: col})
+			}
+			if containsString(tbl.PrimaryKeys, col) {
+				return fmt.Errorf("drop_columns: %s.%s is part of the primary key", name, col)
+			}
+			for _, fk := range tbl.ForeignKeys {
+				if fk.From == col {
+					return fmt.Errorf("drop_columns: %s.%s is part of a foreign key referencing %s", name, col, fk.Table)
+				}
+			}
+			for _, other := range s.Tables {
+				for _, fk := range other.ForeignKeys {
+					if fk.Table == name && fk.To == col {
+						return fmt.Errorf("drop_columns: %s.%s is referenced by a foreign key from %s", name, col, other.Name)
+					}
+				}
+			}
+			idx, err := indexUsingColumn(ctx, db, name, col)
+			if err != nil {
+				return err
+			}
+			if idx != "" {
+				return fmt.Errorf("drop_columns: %s.%s is used by index %s", name, col, idx)
+			}
+			if kind, obj := objectUsingColumn(s, name, col); obj != "" {
+				return fmt.Errorf("drop_columns: %s.%s is used by %s %s", name, col, kind, obj)
+			}
+		}
+	}
+	return nil
+}
+
+func indexUsingColumn(ctx context.Context, db *sql.DB, table, col string) (string, error) {
+	var name string
+	err := db.QueryRowContext(ctx, `SELECT il.name FROM pragma_index_list(?) AS il JOIN pragma_index_info(il.name) AS ii WHERE ii.name = ? ORDER BY il.name LIMIT 1`, table, col).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("index_list %s: %w", table, err)
+	}
+	return name, nil
+}
+
+// objectUsingColumn returns the first view or trigger whose SQL names both
+// table and col, which SQLite would fail to recreate once col is dropped.
+// The SQL is read token by token, as schema.ViewLeaks does, so the match
+// is by name and may report an object that uses another table's col.
+func objectUsingColumn(s *schema.Schema, table, col string) (string, string) {
+	for _, group := range []struct {
+		kind  string
+		items []schema.SQLItem
+	}{{"view", s.Views}, {"trigger", s.Triggers}} {
+		for _, item := range group.items {
+			var hasTable, hasCol bool
+			for _, t := range schema.Tokenize(item.SQL) {
+				name := schema.IdentName(t.Text)
+				hasTable = hasTable || strings.EqualFold(name, table)
+				hasCol = hasCol || strings.EqualFold(name, col)
+			}
+			if (hasTable || strings.EqualFold(item.Table, table)) && hasCol {
+				return group.kind, item.Name
+			}
+		}
+	}
+	return "", ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func tableIncluded(cfg *config.Config, name string) bool {
 	if cfg == nil {
 		return true
@@ -590,7 +1804,7 @@ func sortRows(values [][]any) {
 func keyFor(values []any) string {
 	parts := make([]string, len(values))
 	for i, v := range values {
-		parts[i] = fmt.Sprint(v)
+		parts[i] = transform.CanonicalString(v)
 	}
 	return strings.Join(parts, "|")
 }
@@ -598,7 +1812,7 @@ func keyFor(values []any) string {
 func rowFingerprint(values []any) string {
 	h := sha256.New()
 	for _, v := range values {
-		_, _ = h.Write([]byte(fmt.Sprint(v)))
+		_, _ = h.Write([]byte(transform.CanonicalString(v)))
 		_, _ = h.Write([]byte{0})
 	}
 	return hex.EncodeToString(h.Sum(nil))
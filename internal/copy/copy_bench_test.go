@@ -0,0 +1,197 @@
+package copy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/schema"
+	_ "modernc.org/sqlite"
+)
+
+func BenchmarkCopyJobs(b *testing.B) {
+	ctx := context.Background()
+	tmp := b.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createBenchDB(inPath, 1000); err != nil {
+		b.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns: map[string]*config.TransformConfig{
+					"email":     {Type: "HmacSha256"},
+					"full_name": {Type: "FakerName"},
+				},
+			},
+		},
+	}
+	counts := []int{1}
+	if runtime.NumCPU() > 1 {
+		counts = append(counts, runtime.NumCPU())
+	}
+	for _, jobs := range counts {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			opts := Options{
+				InPath:  inPath,
+				OutPath: filepath.Join(tmp, fmt.Sprintf("out-%d.sqlite", jobs)),
+				Config:  cfg,
+				Salt:    "salt",
+				FKMode:  "on",
+				Jobs:    jobs,
+				Logger:  log.New(log.LevelInfo, io.Discard),
+			}
+			for i := 0; i < b.N; i++ {
+				if err := Run(ctx, opts); err != nil {
+					b.Fatalf("run: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCopyTable(b *testing.B) {
+	ctx := context.Background()
+	tmp := b.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createBenchDB(inPath, 2000); err != nil {
+		b.Fatalf("create db: %v", err)
+	}
+	inDB, err := sql.Open("sqlite", schema.DSN(inPath))
+	if err != nil {
+		b.Fatalf("open in: %v", err)
+	}
+	defer inDB.Close()
+	s, err := schema.Load(ctx, inDB)
+	if err != nil {
+		b.Fatalf("load schema: %v", err)
+	}
+	tbl := s.Tables["users"]
+	opts := Options{
+		Config: &config.Config{
+			Tables: map[string]*config.TableConfig{
+				"users": {
+					Columns: map[string]*config.TransformConfig{
+						"email":      {Type: "HmacSha256", MaxLen: 24},
+						"full_name":  {Type: "FakerName"},
+						"phone":      {Type: "FakerPhone"},
+						"created_at": {Type: "DateShift"},
+					},
+				},
+			},
+		},
+		Salt: "salt",
+		Jobs: 1,
+	}
+	outDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		b.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	outDB.SetMaxOpenConns(1)
+	if _, err := outDB.Exec(tbl.SQL); err != nil {
+		b.Fatalf("create table: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if _, err := outDB.Exec(`DELETE FROM users`); err != nil {
+			b.Fatalf("reset: %v", err)
+		}
+		b.StartTimer()
+		if err := copyTable(ctx, inDB, outDB, s, tbl, opts, nil, true); err != nil {
+			b.Fatalf("copy table: %v", err)
+		}
+	}
+}
+
+func createBenchDB(path string, rows int) error {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, full_name TEXT, phone TEXT, address TEXT, country TEXT, created_at TEXT, balance REAL)`); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := 1; i <= rows; i++ {
+		if _, err := tx.Exec(`INSERT INTO users (id, email, full_name, phone, address, country, created_at, balance) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			i, fmt.Sprintf("user%d@example.com", i), fmt.Sprintf("User %d", i), fmt.Sprintf("555-%04d", i%10000),
+			fmt.Sprintf("%d Main St", i), "US", "2024-03-01T10:00:00Z", float64(i)*1.5); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func BenchmarkCopyUntransformed(b *testing.B) {
+	ctx := context.Background()
+	tmp := b.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createBenchDB(inPath, 2000); err != nil {
+		b.Fatalf("create db: %v", err)
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: filepath.Join(tmp, "out.sqlite"),
+		Config:  &config.Config{},
+		FKMode:  "on",
+		Jobs:    1,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	for i := 0; i < b.N; i++ {
+		if err := Run(ctx, opts); err != nil {
+			b.Fatalf("run: %v", err)
+		}
+	}
+}
+
+func BenchmarkCopyDeferUnique(b *testing.B) {
+	ctx := context.Background()
+	tmp := b.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		b.Fatalf("open in: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, phone TEXT UNIQUE, handle TEXT UNIQUE)`); err != nil {
+		b.Fatalf("create table: %v", err)
+	}
+	// Random-looking keys, as masked values are, so index inserts are not
+	// appends.
+	if _, err := db.Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 50000)
+		INSERT INTO users SELECT i, hex(randomblob(12)), hex(randomblob(8)), hex(randomblob(10)) FROM n`); err != nil {
+		b.Fatalf("fill table: %v", err)
+	}
+	db.Close()
+	for _, deferUnique := range []bool{false, true} {
+		b.Run(fmt.Sprintf("defer=%t", deferUnique), func(b *testing.B) {
+			opts := Options{
+				InPath:      inPath,
+				OutPath:     filepath.Join(tmp, fmt.Sprintf("out-%t.sqlite", deferUnique)),
+				Config:      &config.Config{},
+				FKMode:      "on",
+				Jobs:        1,
+				DeferUnique: deferUnique,
+				Logger:      log.New(log.LevelInfo, io.Discard),
+			}
+			for i := 0; i < b.N; i++ {
+				if err := Run(ctx, opts); err != nil {
+					b.Fatalf("run: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -1,15 +1,25 @@
 package copy
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dyne/pinkmask/internal/config"
 	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/schema"
 	"github.com/dyne/pinkmask/internal/transform"
+	"github.com/dyne/pinkmask/internal/vault"
+	"gopkg.in/yaml.v3"
 	_ "modernc.org/sqlite"
 )
 
@@ -106,47 +116,2937 @@ func TestSubsetCopy(t *testing.T) {
 	}
 }
 
+func TestSubsetRootKeys(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Subset: &config.SubsetConfig{
+			Roots: []config.RootConfig{{Table: "users", Keys: []any{2}}},
+		},
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  cfg,
+		FKMode:  "on",
+		Jobs:    1,
+		Subset:  true,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var orderID int
+	if err := outDB.QueryRow(`SELECT id FROM orders`).Scan(&orderID); err != nil {
+		t.Fatalf("select order: %v", err)
+	}
+	if orderID != 11 {
+		t.Fatalf("unexpected order selected: %d", orderID)
+	}
+}
+
+func TestSubsetStratified(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	inDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := inDB.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, country TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 1; i <= 100; i++ {
+		country := "US"
+		if i%4 == 0 {
+			country = "CA"
+		}
+		if _, err := inDB.Exec(`INSERT INTO users (id, country) VALUES (?, ?)`, i, country); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	inDB.Close()
+	cfg := &config.Config{
+		Subset: &config.SubsetConfig{
+			Roots: []config.RootConfig{{Table: "users", StratifyBy: "country", Limit: 20}},
+		},
+	}
+	counts := func() (map[string]int, string) {
+		outPath := filepath.Join(tmp, "out.sqlite")
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Jobs: 1, Subset: true, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer outDB.Close()
+		out := map[string]int{}
+		var ids string
+		if err := outDB.QueryRow(`SELECT group_concat(id) FROM users`).Scan(&ids); err != nil {
+			t.Fatalf("ids: %v", err)
+		}
+		rows, err := outDB.Query(`SELECT country, COUNT(1) FROM users GROUP BY country`)
+		if err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c string
+			var n int
+			if err := rows.Scan(&c, &n); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			out[c] = n
+		}
+		return out, ids
+	}
+	got, ids := counts()
+	if got["US"] != 15 || got["CA"] != 5 {
+		t.Fatalf("unexpected strata: %v", got)
+	}
+	if _, again := counts(); again != ids {
+		t.Fatalf("stratified selection not deterministic: %s vs %s", ids, again)
+	}
+}
+
+func TestIncrementalCopy(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  &config.Config{},
+		FKMode:  "on",
+		Jobs:    1,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	if _, err := outDB.Exec(`UPDATE users SET full_name = 'kept' WHERE id = 1`); err != nil {
+		t.Fatalf("mark output: %v", err)
+	}
+	inDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := inDB.Exec(`INSERT INTO users (id, email, full_name, country) VALUES (3, 'user3@example.com', 'User Three', 'US')`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	inDB.Close()
+	opts.Incremental = true
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("incremental run: %v", err)
+	}
+	var count int
+	if err := outDB.QueryRow(`SELECT COUNT(1) FROM users`).Scan(&count); err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("unexpected user count: %d", count)
+	}
+	var name string
+	if err := outDB.QueryRow(`SELECT full_name FROM users WHERE id = 1`).Scan(&name); err != nil {
+		t.Fatalf("select name: %v", err)
+	}
+	if name != "kept" {
+		t.Fatalf("existing row was rewritten: %s", name)
+	}
+}
+
+func TestIncrementalMaskedKeys(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	exec := func(stmts ...string) {
+		t.Helper()
+		db, err := sql.Open("sqlite", inPath)
+		if err != nil {
+			t.Fatalf("open in: %v", err)
+		}
+		defer db.Close()
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatalf("exec %s: %v", stmt, err)
+			}
+		}
+	}
+	exec(`CREATE TABLE tags (name TEXT PRIMARY KEY, color TEXT)`, `INSERT INTO tags VALUES ('red', 'r'), ('blue', 'b')`)
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config: &config.Config{Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{"id": {Type: "IntPermute"}}},
+			"tags":  {Columns: map[string]*config.TransformConfig{"name": {Type: "HmacSha256"}}},
+		}},
+		Salt:   "salt",
+		FKMode: "on",
+		Jobs:   1,
+		Logger: log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	counts := func() string {
+		db, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer db.Close()
+		if err := checkFK(db); err != nil {
+			t.Fatalf("fk check: %v", err)
+		}
+		return fmt.Sprint(queryRows(t, db, `SELECT (SELECT COUNT(*) FROM users) || ' ' || (SELECT COUNT(*) FROM orders) || ' ' || (SELECT COUNT(*) FROM tags)`))
+	}
+	// Rerunning on an unchanged source finds every masked key present.
+	opts.Incremental = true
+	for _, jobs := range []int{1, 4} {
+		opts.Jobs = jobs
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("rerun with %d jobs: %v", jobs, err)
+		}
+		if got := counts(); got != "[2 2 2]" {
+			t.Fatalf("rerun with %d jobs changed the output: %s", jobs, got)
+		}
+	}
+	exec(`INSERT INTO users VALUES (3, 'user3@example.com', 'User Three', 'US')`, `INSERT INTO orders VALUES (12, 3, 'pending')`, `INSERT INTO tags VALUES ('green', 'g')`)
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("incremental run: %v", err)
+	}
+	if got := counts(); got != "[3 3 3]" {
+		t.Fatalf("new rows not copied: %s", got)
+	}
+}
+
+func TestTableWhereLimit(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"orders": {Where: "status = 'shipped'", Limit: 1},
+		},
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  cfg,
+		FKMode:  "on",
+		Jobs:    1,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var ids string
+	if err := outDB.QueryRow(`SELECT group_concat(id) FROM orders`).Scan(&ids); err != nil {
+		t.Fatalf("select orders: %v", err)
+	}
+	if ids != "11" {
+		t.Fatalf("unexpected orders copied: %s", ids)
+	}
+}
+
+func TestFilterIntegrityViolation(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {Where: "id = 1"},
+		},
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: filepath.Join(tmp, "out.sqlite"),
+		Config:  cfg,
+		FKMode:  "on",
+		Jobs:    1,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	err := Run(ctx, opts)
+	if err == nil || !strings.Contains(err.Error(), "foreign key") {
+		t.Fatalf("expected integrity error, got %v", err)
+	}
+	opts.FKMode = "off"
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run with fk off: %v", err)
+	}
+}
+
+func TestPreserveRowID(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	inDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	stmts := []string{
+		`CREATE TABLE events (name TEXT)`,
+		`INSERT INTO events (rowid, name) VALUES (3, 'a'), (7, 'b'), (42, 'c')`,
+	}
+	for _, stmt := range stmts {
+		if _, err := inDB.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	inDB.Close()
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"events": {
+				PreserveRowID: true,
+				Columns: map[string]*config.TransformConfig{
+					"name": {Type: "HashSha256"},
+				},
+			},
+		},
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  cfg,
+		FKMode:  "on",
+		Jobs:    2,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	rows, err := outDB.Query(`SELECT rowid FROM events ORDER BY rowid`)
+	if err != nil {
+		t.Fatalf("select rowids: %v", err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan rowid: %v", err)
+		}
+		got = append(got, id)
+	}
+	if fmt.Sprint(got) != "[3 7 42]" {
+		t.Fatalf("unexpected rowids: %v", got)
+	}
+}
+
+func TestDropColumns(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				DropColumns: []string{"country"},
+				Columns: map[string]*config.TransformConfig{
+					"full_name": {Type: "FakerName"},
+				},
+			},
+		},
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  cfg,
+		FKMode:  "on",
+		Jobs:    2,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var n int
+	if err := outDB.QueryRow(`SELECT COUNT(1) FROM pragma_table_info('users') WHERE name = 'country'`).Scan(&n); err != nil {
+		t.Fatalf("table_info: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("country column was not dropped")
+	}
+	var email string
+	if err := outDB.QueryRow(`SELECT email FROM users WHERE id = 2`).Scan(&email); err != nil {
+		t.Fatalf("select email: %v", err)
+	}
+	if email != "user2@example.com" {
+		t.Fatalf("unexpected email: %s", email)
+	}
+
+	cfg.Tables["orders"] = &config.TableConfig{DropColumns: []string{"user_id"}}
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "foreign key") {
+		t.Fatalf("expected foreign key error, got %v", err)
+	}
+	delete(cfg.Tables, "orders")
+
+	inDB, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	defer inDB.Close()
+	for _, tc := range []struct {
+		create, drop, want string
+	}{
+		{`CREATE VIEW by_country AS SELECT country, COUNT(1) AS n FROM users GROUP BY country`, `DROP VIEW by_country`, "used by view by_country"},
+		{`CREATE TRIGGER country_check BEFORE UPDATE ON users WHEN NEW."Country" = '' BEGIN SELECT RAISE(ABORT, 'empty'); END`, `DROP TRIGGER country_check`, "used by trigger country_check"},
+	} {
+		if _, err := inDB.Exec(tc.create); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("expected %q error, got %v", tc.want, err)
+		}
+		if _, err := inDB.Exec(tc.drop); err != nil {
+			t.Fatalf("drop: %v", err)
+		}
+	}
+}
+
+func TestAddColumns(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				AddColumns: []config.AddColumnConfig{
+					{Name: "tenant", Type: "TEXT", Transform: &config.TransformConfig{Type: "SetValue", Value: "acme"}},
+				},
+			},
+		},
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  cfg,
+		FKMode:  "on",
+		Jobs:    2,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var tenant string
+	if err := outDB.QueryRow(`SELECT tenant FROM users WHERE id = 1`).Scan(&tenant); err != nil {
+		t.Fatalf("select tenant: %v", err)
+	}
+	if tenant != "acme" {
+		t.Fatalf("unexpected tenant: %s", tenant)
+	}
+
+	cfg.Tables["users"].AddColumns = []config.AddColumnConfig{{Name: "Email", Type: "TEXT"}}
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+}
+
 func createTestDB(path string) error {
 	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", path))
 	if err != nil {
-		return err
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+		return err
+	}
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, full_name TEXT, country TEXT)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, status TEXT, FOREIGN KEY(user_id) REFERENCES users(id))`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO users (id, email, full_name, country) VALUES (1, 'user1@example.com', 'User One', 'US')`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO users (id, email, full_name, country) VALUES (2, 'user2@example.com', 'User Two', 'CA')`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO orders (id, user_id, status) VALUES (10, 1, 'pending')`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO orders (id, user_id, status) VALUES (11, 2, 'shipped')`); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkFK(db *sql.DB) error {
+	rows, err := db.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return fmt.Errorf("foreign key check failed")
+	}
+	return nil
+}
+
+func TestIntPermuteKeepsJoins(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns: map[string]*config.TransformConfig{
+					"id": {Type: "IntPermute"},
+				},
+			},
+		},
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  cfg,
+		Salt:    "salt",
+		FKMode:  "on",
+		Jobs:    1,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	if err := checkFK(outDB); err != nil {
+		t.Fatalf("fk check: %v", err)
+	}
+	var remapped, joined int
+	if err := outDB.QueryRow(`SELECT COUNT(*) FROM users WHERE id NOT IN (1, 2)`).Scan(&remapped); err != nil {
+		t.Fatalf("count users: %v", err)
+	}
+	if remapped != 2 {
+		t.Fatalf("expected both user ids to be remapped, got %d", remapped)
+	}
+	if err := outDB.QueryRow(`SELECT COUNT(*) FROM orders o JOIN users u ON u.id = o.user_id WHERE (o.id = 10 AND u.email = 'user1@example.com') OR (o.id = 11 AND u.email = 'user2@example.com')`).Scan(&joined); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if joined != 2 {
+		t.Fatalf("expected orders to follow their users, got %d", joined)
+	}
+}
+
+func TestIntPermuteImplicitReferences(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	// orders references users without naming a column, and visits reaches
+	// users through profiles, whose key references users.
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users)`,
+		`CREATE TABLE profiles (user_id INTEGER PRIMARY KEY REFERENCES users(id), bio TEXT)`,
+		`CREATE TABLE visits (id INTEGER PRIMARY KEY, profile_id INTEGER REFERENCES profiles)`,
+		`INSERT INTO users VALUES (1, 'a@example.com'), (2, 'b@example.com')`,
+		`INSERT INTO orders VALUES (10, 1), (11, 2)`,
+		`INSERT INTO profiles VALUES (1, 'a'), (2, 'b')`,
+		`INSERT INTO visits VALUES (20, 2)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {Columns: map[string]*config.TransformConfig{"id": {Type: "IntPermute"}}},
+	}}
+	if err := Run(ctx, Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	if err := checkFK(outDB); err != nil {
+		t.Fatalf("fk check: %v", err)
+	}
+	got := queryRows(t, outDB, `SELECT o.id || ' ' || p.bio || ' ' || u.email FROM orders o JOIN users u ON u.id = o.user_id JOIN profiles p ON p.user_id = u.id ORDER BY o.id`)
+	if want := []string{"10 a a@example.com", "11 b b@example.com"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("joins broken: %v", got)
+	}
+	if got := queryRows(t, outDB, `SELECT p.bio FROM visits v JOIN profiles p ON p.user_id = v.profile_id`); fmt.Sprint(got) != "[b]" {
+		t.Fatalf("visits lost their profile: %v", got)
+	}
+	if got := queryRows(t, outDB, `SELECT COUNT(*) FROM users WHERE id IN (1, 2)`); fmt.Sprint(got) != "[0]" {
+		t.Fatalf("user ids not remapped: %v", got)
+	}
+}
+
+func TestStrictTableTypes(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	inDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := inDB.Exec(`CREATE TABLE accounts (id INTEGER PRIMARY KEY, balance INTEGER, owner TEXT) STRICT`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := inDB.Exec(`INSERT INTO accounts (id, balance, owner) VALUES (1, 100, 'alice')`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	inDB.Close()
+	run := func(cols map[string]*config.TransformConfig) error {
+		return Run(ctx, Options{
+			InPath:  inPath,
+			OutPath: filepath.Join(tmp, "out.sqlite"),
+			Config:  &config.Config{Tables: map[string]*config.TableConfig{"accounts": {Columns: cols}}},
+			Salt:    "salt",
+			FKMode:  "on",
+			Jobs:    1,
+			Logger:  log.New(log.LevelInfo, io.Discard),
+		})
+	}
+	err = run(map[string]*config.TransformConfig{"balance": {Type: "HashSha256"}})
+	if err == nil || !strings.Contains(err.Error(), "STRICT table accounts") || !strings.Contains(err.Error(), "column balance") {
+		t.Fatalf("expected STRICT type error naming accounts.balance, got %v", err)
+	}
+	if err := run(map[string]*config.TransformConfig{"owner": {Type: "FakerName"}, "id": {Type: "IntPermute"}}); err != nil {
+		t.Fatalf("compatible transforms: %v", err)
+	}
+}
+
+func TestShuffleRows(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	inDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := inDB.Exec(`CREATE TABLE events (name TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 1; i <= 50; i++ {
+		if _, err := inDB.Exec(`INSERT INTO events (name) VALUES (?)`, fmt.Sprintf("e%02d", i)); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	inDB.Close()
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"events": {ShuffleRows: true, Limit: 20},
+	}}
+	order := func() string {
+		outPath := filepath.Join(tmp, "out.sqlite")
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", Seed: 3, FKMode: "on", Jobs: 1, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer outDB.Close()
+		var names, sorted string
+		if err := outDB.QueryRow(`SELECT group_concat(name) FROM (SELECT name FROM events ORDER BY rowid)`).Scan(&names); err != nil {
+			t.Fatalf("names: %v", err)
+		}
+		if err := outDB.QueryRow(`SELECT group_concat(name) FROM (SELECT name FROM events ORDER BY name)`).Scan(&sorted); err != nil {
+			t.Fatalf("sorted: %v", err)
+		}
+		var want []string
+		for i := 1; i <= 20; i++ {
+			want = append(want, fmt.Sprintf("e%02d", i))
+		}
+		if sorted != strings.Join(want, ",") {
+			t.Fatalf("shuffle changed the selected rows: %s", sorted)
+		}
+		if names == sorted {
+			t.Fatalf("rows were not reordered")
+		}
+		return names
+	}
+	if first, second := order(), order(); first != second {
+		t.Fatalf("shuffle not reproducible: %s vs %s", first, second)
+	}
+}
+
+func TestRequireSalt(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	var logged strings.Builder
+	opts := Options{
+		InPath:  inPath,
+		OutPath: filepath.Join(tmp, "out.sqlite"),
+		Config: &config.Config{Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{"email": {Type: "HashSha256"}}},
+		}},
+		FKMode: "on",
+		Jobs:   1,
+		Logger: log.New(log.LevelInfo, &logged),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(logged.String(), "salt warning") || !strings.Contains(logged.String(), "users.email") {
+		t.Fatalf("expected a salt warning, got %q", logged.String())
+	}
+	opts.RequireSalt = true
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "--salt is required") {
+		t.Fatalf("expected --require-salt to fail, got %v", err)
+	}
+	opts.Salt = "salt"
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run with salt: %v", err)
+	}
+
+	// by_type, add_columns, and inherited keys are checked as applied.
+	opts.Salt = ""
+	opts.Config = &config.Config{
+		ByType: map[string]*config.TransformConfig{"TEXT": {Type: "HmacSha256"}},
+		Tables: map[string]*config.TableConfig{
+			"users":  {Columns: map[string]*config.TransformConfig{"id": {Type: "IntPermute"}}},
+			"orders": {AddColumns: []config.AddColumnConfig{{Name: "token", Type: "TEXT", Transform: &config.TransformConfig{Type: "StableTokenize"}}}},
+		},
+	}
+	err := Run(ctx, opts)
+	for _, col := range []string{"users.country", "users.id", "orders.user_id", "orders.token"} {
+		if err == nil || !strings.Contains(err.Error(), col) {
+			t.Fatalf("expected --require-salt to name %s, got %v", col, err)
+		}
+	}
+}
+
+func TestDumpConfig(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	dumpPath := filepath.Join(tmp, "effective.yml")
+	opts := Options{
+		InPath:  inPath,
+		OutPath: filepath.Join(tmp, "out.sqlite"),
+		Config: &config.Config{
+			IncludeTables: []string{"*"},
+			Tables: map[string]*config.TableConfig{
+				"users": {Columns: map[string]*config.TransformConfig{"id": {Type: "IntPermute"}}},
+			},
+		},
+		Salt:           "secret-salt",
+		FKMode:         "on",
+		Jobs:           1,
+		Logger:         log.New(log.LevelInfo, io.Discard),
+		DumpConfigPath: dumpPath,
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	data, err := os.ReadFile(dumpPath)
+	if err != nil {
+		t.Fatalf("read dump: %v", err)
+	}
+	if strings.Contains(string(data), "secret-salt") {
+		t.Fatalf("dump leaks the salt:\n%s", data)
+	}
+	var got config.Config
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("parse dump: %v", err)
+	}
+	if len(got.IncludeTables) != 0 || got.Tables["orders"] == nil {
+		t.Fatalf("table patterns not expanded: %+v", got)
+	}
+	if tc := got.Tables["orders"].Columns["user_id"]; tc == nil || tc.Type != "IntPermute" {
+		t.Fatalf("inherited key transform missing from dump:\n%s", data)
+	}
+}
+
+func TestAuditColumns(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns:      map[string]*config.TransformConfig{"email": {Type: "HmacSha256"}},
+				AuditColumns: []string{"email"},
+			},
+		},
+	}
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Jobs: 2, Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var original, masked, stored string
+	if err := outDB.QueryRow(`SELECT original, masked FROM pinkmask_audit WHERE table_name = 'users' AND column_name = 'email' AND pk = '[1]'`).Scan(&original, &masked); err != nil {
+		t.Fatalf("select audit: %v", err)
+	}
+	if err := outDB.QueryRow(`SELECT email FROM users WHERE id = 1`).Scan(&stored); err != nil {
+		t.Fatalf("select user: %v", err)
+	}
+	if original != "user1@example.com" || masked != stored {
+		t.Fatalf("unexpected audit row: %s -> %s (stored %s)", original, masked, stored)
+	}
+	cfg.Tables["users"].AuditColumns = []string{"country"}
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "users.country has no transform") {
+		t.Fatalf("expected error for unmasked audit column, got %v", err)
+	}
+}
+
+type columnLabel struct{}
+
+func (columnLabel) Name() string { return "ColumnLabel" }
+
+func (columnLabel) Transform(value any, row transform.RowContext) (any, error) {
+	return fmt.Sprintf("%s:%s@example.com", row.Column, row.ColumnType), nil
+}
+
+func TestTransformerSeesColumn(t *testing.T) {
+	transform.Register("ColumnLabel", func(cfg *config.TransformConfig, salt string) (transform.Transformer, error) {
+		return columnLabel{}, nil
+	})
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	label := &config.TransformConfig{Type: "ColumnLabel"}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{"email": label, "full_name": label}},
+		},
+	}
+	for _, jobs := range []int{1, 2} {
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Jobs: jobs, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		var email, name string
+		err = outDB.QueryRow(`SELECT email, full_name FROM users WHERE id = 1`).Scan(&email, &name)
+		outDB.Close()
+		if err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if email != "email:TEXT@example.com" || name != "full_name:TEXT@example.com" {
+			t.Fatalf("jobs=%d: unexpected values %q, %q", jobs, email, name)
+		}
+	}
+}
+
+func TestDropForeignKeys(t *testing.T) {
+	cases := []struct {
+		ddl, want string
+	}{
+		{
+			`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, FOREIGN KEY(user_id) REFERENCES users(id))`,
+			`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL)`,
+		},
+		{
+			`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER CONSTRAINT fk_user REFERENCES "Users"(id) ON DELETE SET NULL DEFERRABLE INITIALLY DEFERRED NOT NULL, item_id INTEGER REFERENCES items)`,
+			`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER  NOT NULL, item_id INTEGER REFERENCES items)`,
+		},
+		{
+			"CREATE TABLE `a,b` (x INTEGER, y INTEGER, CONSTRAINT c1 FOREIGN KEY (x, y) REFERENCES [users] (a, b) ON UPDATE NO ACTION, CHECK (x > 0)) STRICT",
+			"CREATE TABLE `a,b` (x INTEGER, y INTEGER, CHECK (x > 0)) STRICT",
+		},
+	}
+	for _, c := range cases {
+		got, err := dropForeignKeys(c.ddl, []string{"users"})
+		if err != nil {
+			t.Fatalf("%s: %v", c.ddl, err)
+		}
+		if got != c.want {
+			t.Fatalf("got  %s\nwant %s", got, c.want)
+		}
+	}
+}
+
+func TestDanglingForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{
+		InPath:  inPath,
+		OutPath: outPath,
+		Config:  &config.Config{ExcludeTables: []string{"users"}},
+		FKMode:  "on",
+		Jobs:    1,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "orders -> users") {
+		t.Fatalf("expected dangling foreign key error, got %v", err)
+	}
+	opts.DropDanglingFKs = true
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var fks, orders int
+	if err := outDB.QueryRow(`SELECT COUNT(1) FROM pragma_foreign_key_list('orders')`).Scan(&fks); err != nil {
+		t.Fatalf("foreign_key_list: %v", err)
+	}
+	if err := outDB.QueryRow(`SELECT COUNT(1) FROM orders`).Scan(&orders); err != nil {
+		t.Fatalf("count orders: %v", err)
+	}
+	if fks != 0 || orders == 0 {
+		t.Fatalf("expected orders copied without foreign keys, got %d fks and %d rows", fks, orders)
+	}
+}
+
+type waitForDeadline struct{ ctx context.Context }
+
+func (w waitForDeadline) Name() string { return "WaitForDeadline" }
+
+func (w waitForDeadline) Transform(value any, row transform.RowContext) (any, error) {
+	<-w.ctx.Done()
+	return value, nil
+}
+
+func TestDeadlineRemovesPartialOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	transform.Register("WaitForDeadline", func(cfg *config.TransformConfig, salt string) (transform.Transformer, error) {
+		return waitForDeadline{ctx: ctx}, nil
+	})
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{"email": {Type: "WaitForDeadline"}}},
+		},
+	}
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Jobs: 1, Logger: log.New(log.LevelInfo, io.Discard)}
+	err := Run(ctx, opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if _, err := os.Stat(outPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial output was not removed: %v", err)
+	}
+}
+
+func TestShards(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	inDB, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	stmts := []string{
+		`CREATE TABLE countries (code TEXT PRIMARY KEY)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, country TEXT REFERENCES countries(code))`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id))`,
+		`CREATE TABLE order_items (id INTEGER PRIMARY KEY, order_id INTEGER NOT NULL REFERENCES orders(id), sku TEXT)`,
+		`INSERT INTO countries (code) VALUES ('US'), ('CA'), ('DE')`,
+	}
+	for i := 1; i <= 20; i++ {
+		stmts = append(stmts,
+			fmt.Sprintf(`INSERT INTO users (id, country) VALUES (%d, '%s')`, i, []string{"US", "CA"}[i%2]),
+			fmt.Sprintf(`INSERT INTO orders (id, user_id) VALUES (%d, %d), (%d, %d)`, 100+i, i, 200+i, i),
+			fmt.Sprintf(`INSERT INTO order_items (order_id, sku) VALUES (%d, 'a'), (%d, 'b')`, 100+i, 200+i),
+		)
+	}
+	for _, stmt := range stmts {
+		if _, err := inDB.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	inDB.Close()
+
+	opts := Options{
+		InPath:   inPath,
+		OutPath:  filepath.Join(tmp, "out.sqlite"),
+		FKMode:   "on",
+		Jobs:     1,
+		Shards:   3,
+		ShardKey: "users.id",
+		Logger:   log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	userShard := map[int64]int{}
+	orders, items := 0, 0
+	for shard := 0; shard < 3; shard++ {
+		outDB, err := sql.Open("sqlite", filepath.Join(tmp, fmt.Sprintf("out.%d.sqlite", shard)))
+		if err != nil {
+			t.Fatalf("open shard %d: %v", shard, err)
+		}
+		if err := checkFK(outDB); err != nil {
+			t.Fatalf("shard %d: %v", shard, err)
+		}
+		var countries, orphans, n, m int
+		if err := outDB.QueryRow(`SELECT COUNT(1) FROM countries`).Scan(&countries); err != nil {
+			t.Fatalf("count countries: %v", err)
+		}
+		if err := outDB.QueryRow(`SELECT COUNT(1) FROM orders o WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = o.user_id)`).Scan(&orphans); err != nil {
+			t.Fatalf("count orphans: %v", err)
+		}
+		if err := outDB.QueryRow(`SELECT (SELECT COUNT(1) FROM orders), (SELECT COUNT(1) FROM order_items)`).Scan(&n, &m); err != nil {
+			t.Fatalf("count orders: %v", err)
+		}
+		if countries != 3 || orphans != 0 {
+			t.Fatalf("shard %d: %d countries, %d orphaned orders", shard, countries, orphans)
+		}
+		orders += n
+		items += m
+		rows, err := outDB.Query(`SELECT id FROM users`)
+		if err != nil {
+			t.Fatalf("select users: %v", err)
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			if prev, ok := userShard[id]; ok {
+				t.Fatalf("user %d in shards %d and %d", id, prev, shard)
+			}
+			userShard[id] = shard
+		}
+		rows.Close()
+		outDB.Close()
+	}
+	if len(userShard) != 20 || orders != 40 || items != 40 {
+		t.Fatalf("shards hold %d users, %d orders, %d items; want 20, 40, 40", len(userShard), orders, items)
+	}
+
+	opts.ShardKey = "users"
+	if err := Run(ctx, opts); err == nil {
+		t.Fatalf("expected error for shard key without column")
+	}
+}
+
+func TestShardsKeepNullReferences(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	inDB, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id))`,
+		`CREATE TABLE order_items (id INTEGER PRIMARY KEY, order_id INTEGER REFERENCES orders(id))`,
+		`INSERT INTO users VALUES (1), (2), (3), (4)`,
+		`INSERT INTO orders VALUES (10, 1), (11, 2), (12, NULL), (13, NULL)`,
+		`INSERT INTO order_items VALUES (100, 10), (101, 12), (102, 13), (103, NULL)`,
+	} {
+		if _, err := inDB.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	inDB.Close()
+
+	opts := Options{InPath: inPath, OutPath: filepath.Join(tmp, "out.sqlite"), FKMode: "on", Shards: 2, ShardKey: "users.id", Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	total := map[string]int{}
+	for shard := 0; shard < 2; shard++ {
+		outDB, err := sql.Open("sqlite", filepath.Join(tmp, fmt.Sprintf("out.%d.sqlite", shard)))
+		if err != nil {
+			t.Fatalf("open shard %d: %v", shard, err)
+		}
+		if err := checkFK(outDB); err != nil {
+			t.Fatalf("shard %d: %v", shard, err)
+		}
+		for _, table := range []string{"users", "orders", "order_items"} {
+			var n int
+			if err := outDB.QueryRow(`SELECT COUNT(1) FROM ` + table).Scan(&n); err != nil {
+				t.Fatalf("count %s: %v", table, err)
+			}
+			total[table] += n
+		}
+		if shard == 1 {
+			var nulls int
+			if err := outDB.QueryRow(`SELECT COUNT(1) FROM orders WHERE user_id IS NULL`).Scan(&nulls); err != nil {
+				t.Fatalf("count nulls: %v", err)
+			}
+			if nulls != 0 {
+				t.Fatalf("orders without a user copied into shard 1")
+			}
+		}
+		outDB.Close()
+	}
+	if want := map[string]int{"users": 4, "orders": 4, "order_items": 4}; fmt.Sprint(total) != fmt.Sprint(want) {
+		t.Fatalf("shards hold %v, want the source counts %v", total, want)
+	}
+}
+
+func TestTransformOrder(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	// Transforms come from a map, from by_type, and in any vault_columns
+	// order; within a row they still run in column-name order, so the
+	// first failing column and the vault entries are the same every run.
+	failing := &config.Config{
+		ByType: map[string]*config.TransformConfig{"TEXT": {Type: "IntPermute"}},
+		Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{
+				"full_name": {Type: "IntPermute"},
+				"email":     {Type: "IntPermute"},
+			}},
+		},
+	}
+	for run := 0; run < 10; run++ {
+		opts := Options{InPath: inPath, OutPath: filepath.Join(tmp, "fail.sqlite"), Config: failing, FKMode: "on", Jobs: 1, Logger: log.New(log.LevelInfo, io.Discard)}
+		var colErr *ColumnError
+		if err := Run(ctx, opts); !errors.As(err, &colErr) || colErr.Column != "country" {
+			t.Fatalf("run %d: expected the first error on users.country, got %v", run, err)
+		}
+	}
+
+	key := []byte(strings.Repeat("k", vault.KeySize))
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns: map[string]*config.TransformConfig{
+					"full_name": {Type: "StableTokenize"},
+					"email":     {Type: "StableTokenize"},
+					"country":   {Type: "StableTokenize"},
+				},
+				VaultColumns: []string{"full_name", "email", "country"},
+			},
+		},
+	}
+	var first string
+	for run := 0; run < 10; run++ {
+		vaultPath := filepath.Join(tmp, fmt.Sprintf("vault-%d.pmv", run))
+		opts := Options{InPath: inPath, OutPath: filepath.Join(tmp, "out.sqlite"), Config: cfg, Salt: "salt", FKMode: "on", Jobs: 1, VaultPath: vaultPath, VaultKey: key, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		var columns []string
+		if err := vault.Read(vaultPath, key, func(e vault.Entry) error {
+			columns = append(columns, fmt.Sprint(e.Original)+"@"+e.Column)
+			return nil
+		}); err != nil {
+			t.Fatalf("read vault: %v", err)
+		}
+		got := strings.Join(columns, " ")
+		if run == 0 {
+			first = got
+		}
+		if got != first {
+			t.Fatalf("run %d: vault order changed:\n%s\n%s", run, first, got)
+		}
+	}
+	if want := "US@country user1@example.com@email User One@full_name"; !strings.HasPrefix(first, want) {
+		t.Fatalf("vault entries not in column order: %s", first)
+	}
+}
+
+func TestVaultColumns(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	vaultPath := filepath.Join(tmp, "vault.pmv")
+	key := []byte(strings.Repeat("k", vault.KeySize))
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns:      map[string]*config.TransformConfig{"email": {Type: "StableTokenize"}},
+				VaultColumns: []string{"email"},
+			},
+		},
+	}
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Jobs: 2, VaultPath: vaultPath, VaultKey: key, Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", outPath))
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	var token string
+	if err := outDB.QueryRow(`SELECT email FROM users WHERE id = 1`).Scan(&token); err != nil {
+		t.Fatalf("select user: %v", err)
+	}
+	originals := map[string]any{}
+	if err := vault.Read(vaultPath, key, func(e vault.Entry) error {
+		originals[fmt.Sprint(e.Masked)] = e.Original
+		return nil
+	}); err != nil {
+		t.Fatalf("read vault: %v", err)
+	}
+	if len(originals) != 2 || originals[token] != "user1@example.com" {
+		t.Fatalf("unexpected vault contents: %v (token %s)", originals, token)
+	}
+
+	opts.VaultPath = ""
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "require --vault") {
+		t.Fatalf("expected error for vault_columns without --vault, got %v", err)
+	}
+}
+
+func TestSkipIndexesAndViews(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE INDEX idx_users_email ON users(email)`,
+		`CREATE VIEW user_emails AS SELECT id, email FROM users`,
+		`CREATE TRIGGER user_emails_insert INSTEAD OF INSERT ON user_emails BEGIN INSERT INTO users(id, email) VALUES (NEW.id, NEW.email); END`,
+		`CREATE TRIGGER users_touch AFTER UPDATE ON users BEGIN SELECT 1; END`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	objects := func(outPath string) map[string]bool {
+		outDB, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer outDB.Close()
+		out, err := existingObjects(ctx, outDB)
+		if err != nil {
+			t.Fatalf("objects: %v", err)
+		}
+		return out
+	}
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{}}
+	for _, c := range []struct {
+		name               string
+		noIndexes, noViews bool
+		want, missing      []string
+	}{
+		{"default", false, false, []string{"idx_users_email", "user_emails", "user_emails_insert", "users_touch"}, nil},
+		{"no-indexes", true, false, []string{"user_emails", "user_emails_insert", "users_touch"}, []string{"idx_users_email"}},
+		{"no-views", false, true, []string{"idx_users_email", "users_touch"}, []string{"user_emails", "user_emails_insert"}},
+	} {
+		outPath := filepath.Join(tmp, c.name+".sqlite")
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Triggers: "on", NoIndexes: c.noIndexes, NoViews: c.noViews, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("%s: run: %v", c.name, err)
+		}
+		got := objects(outPath)
+		for _, name := range c.want {
+			if !got[name] {
+				t.Fatalf("%s: expected %s in output", c.name, name)
+			}
+		}
+		for _, name := range c.missing {
+			if got[name] {
+				t.Fatalf("%s: expected no %s in output", c.name, name)
+			}
+		}
+	}
+}
+
+func TestDeferUniqueConstraints(t *testing.T) {
+	cases := []struct {
+		ddl, want string
+		indexes   []string
+	}{
+		{
+			`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL UNIQUE, code TEXT CONSTRAINT uq_code UNIQUE CHECK (code <> 'unique'), UNIQUE (email COLLATE NOCASE, code))`,
+			`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL , code TEXT  CHECK (code <> 'unique'))`,
+			[]string{
+				`CREATE UNIQUE INDEX "users_unique_1" ON "users" ("email")`,
+				`CREATE UNIQUE INDEX "users_unique_3" ON "users" ("code")`,
+				`CREATE UNIQUE INDEX "users_unique_4" ON "users" (email COLLATE NOCASE, code)`,
+			},
+		},
+		{
+			`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE ON CONFLICT REPLACE, ref TEXT UNIQUE, CONSTRAINT c UNIQUE (ref, id))`,
+			`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE ON CONFLICT REPLACE, ref TEXT UNIQUE, CONSTRAINT c UNIQUE (ref, id))`,
+			nil,
+		},
+	}
+	for _, c := range cases {
+		taken := map[string]bool{"users": true, "users_unique_2": true}
+		got, items, err := deferUniqueConstraints(c.ddl, "users", map[string]bool{"ref": true}, taken)
+		if err != nil {
+			t.Fatalf("%s: %v", c.ddl, err)
+		}
+		if got != c.want {
+			t.Fatalf("got  %s\nwant %s", got, c.want)
+		}
+		if len(items) != len(c.indexes) {
+			t.Fatalf("%s: got %d indexes, want %d", c.ddl, len(items), len(c.indexes))
+		}
+		for i, item := range items {
+			if item.SQL != c.indexes[i] {
+				t.Fatalf("got  %s\nwant %s", item.SQL, c.indexes[i])
+			}
+		}
+	}
+}
+
+func TestDeferUnique(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE accounts (id INTEGER PRIMARY KEY, handle TEXT UNIQUE, email TEXT UNIQUE)`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, author TEXT REFERENCES accounts(handle))`,
+		`INSERT INTO accounts VALUES (1, 'ann', 'ann@example.com'), (2, 'bob', 'bob@example.com')`,
+		`INSERT INTO posts VALUES (1, 'ann'), (2, 'bob')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", DeferUnique: true, Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	var ddl string
+	if err := outDB.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'accounts'`).Scan(&ddl); err != nil {
+		t.Fatalf("select ddl: %v", err)
+	}
+	if !strings.Contains(ddl, "handle TEXT UNIQUE") || strings.Contains(ddl, "email TEXT UNIQUE") {
+		t.Fatalf("unexpected accounts ddl: %s", ddl)
+	}
+	if _, err := outDB.Exec(`INSERT INTO accounts VALUES (3, 'cy', 'ann@example.com')`); err == nil {
+		t.Fatalf("expected the deferred unique index to reject a duplicate email")
+	}
+	outDB.Close()
+
+	// A transform that collapses the unique column fails the run.
+	opts.Config = &config.Config{Tables: map[string]*config.TableConfig{
+		"accounts": {Columns: map[string]*config.TransformConfig{"email": {Type: "SetValue", Value: "x@example.com"}}},
+	}}
+	if err := Run(ctx, opts); !errors.Is(err, ErrIntegrity) || !strings.Contains(err.Error(), "deferred unique index") {
+		t.Fatalf("expected deferred unique index error, got %v", err)
+	}
+}
+
+func TestVersionPragmas(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 42; PRAGMA application_id = 1347243853`); err != nil {
+		t.Fatalf("set pragmas: %v", err)
+	}
+	db.Close()
+
+	for _, skip := range []bool{false, true} {
+		outPath := filepath.Join(tmp, fmt.Sprintf("out-%t.sqlite", skip))
+		opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", NoVersionPragmas: skip, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		outDB, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		var version, appID int64
+		if err := outDB.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+			t.Fatalf("user_version: %v", err)
+		}
+		if err := outDB.QueryRow(`PRAGMA application_id`).Scan(&appID); err != nil {
+			t.Fatalf("application_id: %v", err)
+		}
+		outDB.Close()
+		want := [2]int64{42, 1347243853}
+		if skip {
+			want = [2]int64{}
+		}
+		if got := [2]int64{version, appID}; got != want {
+			t.Fatalf("skip=%t: got %v, want %v", skip, got, want)
+		}
+	}
+}
+
+func TestSkipIndexWithMissingCollation(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	// Stand in for an application collation: create the index with a
+	// built-in one, then rename it in the stored schema.
+	for _, stmt := range []string{
+		`CREATE INDEX idx_users_name ON users(full_name COLLATE NOCASE)`,
+		`CREATE INDEX idx_users_email ON users(email)`,
+		`PRAGMA writable_schema = ON`,
+		`UPDATE sqlite_master SET sql = 'CREATE INDEX idx_users_name ON users(full_name COLLATE app_collation)' WHERE name = 'idx_users_name'`,
+		`PRAGMA writable_schema = OFF`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	var logs bytes.Buffer
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", Logger: log.New(log.LevelInfo, &logs)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	objects, err := existingObjects(ctx, outDB)
+	if err != nil {
+		t.Fatalf("objects: %v", err)
+	}
+	if objects["idx_users_name"] || !objects["idx_users_email"] {
+		t.Fatalf("unexpected objects: %v", objects)
+	}
+	if !strings.Contains(logs.String(), "skipped 1 index(es) using unavailable collations, recreate them with the application: idx_users_name") {
+		t.Fatalf("missing skipped index report in logs:\n%s", logs.String())
+	}
+}
+
+func TestSkipFailedSchema(t *testing.T) {
+	ctx := context.Background()
+	// Each case stands in for an object created with an application
+	// collation or function: it is created with a built-in one, which
+	// rewrite then replaces in the stored schema.
+	cases := []struct {
+		kind    string
+		setup   []string
+		rewrite []string
+		// wantErr is the error without --skip-failed-schema, empty when
+		// the copy succeeds.
+		wantErr string
+		// object is the object the case is about; gone is whether it is
+		// left out of the output with --skip-failed-schema.
+		object  string
+		gone    bool
+		wantLog string
+	}{
+		{
+			kind:    "table",
+			setup:   []string{`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT COLLATE NOCASE)`, `INSERT INTO notes VALUES (1, 'a')`},
+			rewrite: []string{`UPDATE sqlite_master SET sql = 'CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT COLLATE app_collation)' WHERE name = 'notes'`},
+			wantErr: `create table notes: missing collation "app_collation", probably provided by an extension loaded when the database was created (use --skip-failed-schema to continue without it)`,
+			object:  "notes",
+			gone:    true,
+			wantLog: `skip table notes: create table notes: missing collation "app_collation"`,
+		},
+		{
+			kind:    "index",
+			setup:   []string{`CREATE INDEX idx_users_code ON users(lower(email))`},
+			rewrite: []string{`UPDATE sqlite_master SET sql = 'CREATE INDEX idx_users_code ON users(app_code(email))' WHERE name = 'idx_users_code'`},
+			wantErr: `create index idx_users_code: missing function "app_code"`,
+			object:  "idx_users_code",
+			gone:    true,
+			wantLog: `skip index idx_users_code: create index idx_users_code: missing function "app_code"`,
+		},
+		{
+			// A trigger cannot outlive the table it is on, which the
+			// missing collation makes the copy skip.
+			kind: "trigger",
+			setup: []string{
+				`CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT COLLATE NOCASE)`,
+				`CREATE TRIGGER notes_stamp AFTER INSERT ON notes BEGIN UPDATE users SET country = 'x' WHERE id = NEW.id; END`,
+			},
+			rewrite: []string{`UPDATE sqlite_master SET sql = 'CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT COLLATE app_collation)' WHERE name = 'notes'`},
+			wantErr: `create table notes: missing collation "app_collation"`,
+			object:  "notes_stamp",
+			gone:    true,
+			wantLog: `skip trigger notes_stamp: create trigger notes_stamp: SQL logic error: no such table: main.notes`,
+		},
+		{
+			// SQLite resolves the functions of a view when it is queried,
+			// so the view is recreated as written, for the application to
+			// use once it registers the function.
+			kind:    "view",
+			setup:   []string{`CREATE VIEW user_codes AS SELECT id, lower(email) AS code FROM users`},
+			rewrite: []string{`UPDATE sqlite_master SET sql = 'CREATE VIEW user_codes AS SELECT id, app_code(email) AS code FROM users' WHERE name = 'user_codes'`},
+			object:  "user_codes",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.kind, func(t *testing.T) {
+			tmp := t.TempDir()
+			inPath := filepath.Join(tmp, "in.sqlite")
+			if err := createTestDB(inPath); err != nil {
+				t.Fatalf("create db: %v", err)
+			}
+			db, err := sql.Open("sqlite", inPath)
+			if err != nil {
+				t.Fatalf("open in: %v", err)
+			}
+			db.SetMaxOpenConns(1)
+			stmts := append(append(append(tc.setup, `PRAGMA writable_schema = ON`), tc.rewrite...), `PRAGMA writable_schema = OFF`)
+			for _, stmt := range stmts {
+				if _, err := db.Exec(stmt); err != nil {
+					t.Fatalf("exec %s: %v", stmt, err)
+				}
+			}
+			db.Close()
+
+			outPath := filepath.Join(tmp, "out.sqlite")
+			opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", Triggers: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+			err = Run(ctx, opts)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if tc.wantErr != "" && (!errors.Is(err, ErrSchema) || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("expected schema error %q, got %v", tc.wantErr, err)
+			}
+
+			var logs bytes.Buffer
+			opts.SkipFailedSchema = true
+			opts.Logger = log.New(log.LevelInfo, &logs)
+			if err := Run(ctx, opts); err != nil {
+				t.Fatalf("run with --skip-failed-schema: %v", err)
+			}
+			if !strings.Contains(logs.String(), tc.wantLog) {
+				t.Fatalf("missing %q in logs:\n%s", tc.wantLog, logs.String())
+			}
+			outDB, err := sql.Open("sqlite", outPath)
+			if err != nil {
+				t.Fatalf("open out: %v", err)
+			}
+			defer outDB.Close()
+			objects, err := existingObjects(ctx, outDB)
+			if err != nil {
+				t.Fatalf("objects: %v", err)
+			}
+			if objects[tc.object] == tc.gone || !objects["users"] || !objects["orders"] {
+				t.Fatalf("unexpected objects: %v", objects)
+			}
+			if err := checkFK(outDB); err != nil {
+				t.Fatalf("foreign keys: %v", err)
+			}
+		})
+	}
+}
+
+func TestIdentityTable(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	oldPath := filepath.Join(tmp, "old.sqlite")
+	if err := createTestDB(oldPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	newPath := filepath.Join(tmp, "new.sqlite")
+	if err := createTestDB(newPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", newPath)
+	if err != nil {
+		t.Fatalf("open new: %v", err)
+	}
+	if _, err := db.Exec(`ALTER TABLE users RENAME TO clients`); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	db.Close()
+
+	names := func(inPath, table string, tc *config.TableConfig) []string {
+		outPath := filepath.Join(tmp, "out.sqlite")
+		cfg := &config.Config{Tables: map[string]*config.TableConfig{table: tc}, AuditTable: "audit"}
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", Seed: 3, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		outDB, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer outDB.Close()
+		var audited string
+		if err := outDB.QueryRow(`SELECT DISTINCT table_name FROM audit`).Scan(&audited); err != nil || audited != table {
+			t.Fatalf("audit table_name %q, want %q (%v)", audited, table, err)
+		}
+		rows, err := outDB.Query(fmt.Sprintf(`SELECT full_name FROM %s ORDER BY id`, table))
+		if err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatalf("scan: %v", err)
+			}
+			out = append(out, name)
+		}
+		return out
+	}
+	columns := map[string]*config.TransformConfig{"full_name": {Type: "FakerName"}}
+	before := names(oldPath, "users", &config.TableConfig{Columns: columns, AuditColumns: []string{"full_name"}})
+	renamed := names(newPath, "clients", &config.TableConfig{Columns: columns, AuditColumns: []string{"full_name"}})
+	aliased := names(newPath, "clients", &config.TableConfig{Columns: columns, AuditColumns: []string{"full_name"}, IdentityTable: "users"})
+	if fmt.Sprint(aliased) != fmt.Sprint(before) {
+		t.Fatalf("identity_table should keep pseudonyms: %v vs %v", aliased, before)
+	}
+	if fmt.Sprint(renamed) == fmt.Sprint(before) {
+		t.Fatalf("expected a renamed table without identity_table to get new pseudonyms")
+	}
+}
+
+func TestRedistributeKeepsGroupTotals(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE lines (id INTEGER PRIMARY KEY, order_id INTEGER, amount REAL, qty INTEGER)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 1; i <= 60; i++ {
+		if _, err := db.Exec(`INSERT INTO lines VALUES (?, ?, ?, ?)`, i, i%7, float64(i)*1.25, i%5+1); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	db.Close()
+
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"lines": {
+			Columns: map[string]*config.TransformConfig{
+				"amount": {Type: "Redistribute", Params: map[string]any{"group_by": "order_id"}},
+				"qty":    {Type: "Redistribute", Params: map[string]any{"group_by": []any{"order_id"}}},
+			},
+		},
+	}}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Jobs: 2, Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	if _, err := outDB.Exec(`ATTACH DATABASE ? AS src`, inPath); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	var mismatched, changed, nonInteger int
+	if err := outDB.QueryRow(`SELECT
+		(SELECT count(*) FROM (SELECT o.order_id FROM lines o GROUP BY o.order_id
+			HAVING round(sum(o.amount), 2) <> (SELECT round(sum(amount), 2) FROM src.lines s WHERE s.order_id = o.order_id)
+			OR sum(o.qty) <> (SELECT sum(qty) FROM src.lines s WHERE s.order_id = o.order_id))),
+		(SELECT count(*) FROM lines o JOIN src.lines s USING (id) WHERE o.amount <> s.amount),
+		(SELECT count(*) FROM lines WHERE typeof(qty) <> 'integer')`).Scan(&mismatched, &changed, &nonInteger); err != nil {
+		t.Fatalf("compare: %v", err)
+	}
+	if mismatched != 0 || changed < 30 || nonInteger != 0 {
+		t.Fatalf("mismatched groups %d, changed amounts %d, non-integer qty %d", mismatched, changed, nonInteger)
+	}
+}
+
+func TestResampleKeepsDistribution(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE people (id INTEGER PRIMARY KEY, age INTEGER, income REAL)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	// Ages 20-69 and a skewed income, with NULLs.
+	if _, err := db.Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 3000)
+		INSERT INTO people SELECT i, 20 + i % 50, CASE WHEN i % 10 = 0 THEN NULL ELSE (i % 97) * (i % 97) * 10.0 END FROM n`); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	db.Close()
+
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"people": {Columns: map[string]*config.TransformConfig{
+			"age":    {Type: "Resample"},
+			"income": {Type: "Resample"},
+		}},
+	}}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	outDB, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	if _, err := outDB.Exec(`ATTACH DATABASE ? AS src`, inPath); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	type stats struct {
+		minAge, maxAge, nulls, nonInteger int
+		avgAge, avgIncome                 float64
+	}
+	query := func(table string) stats {
+		var s stats
+		q := fmt.Sprintf(`SELECT min(age), max(age), avg(age), avg(income), count(*) - count(income), sum(typeof(age) <> 'integer') FROM %s`, table)
+		if err := outDB.QueryRow(q).Scan(&s.minAge, &s.maxAge, &s.avgAge, &s.avgIncome, &s.nulls, &s.nonInteger); err != nil {
+			t.Fatalf("stats %s: %v", table, err)
+		}
+		return s
+	}
+	got, want := query("main.people"), query("src.people")
+	if got.minAge < want.minAge || got.maxAge > want.maxAge || got.nulls != want.nulls || got.nonInteger != 0 {
+		t.Fatalf("got %+v, source %+v", got, want)
+	}
+	if math.Abs(got.avgAge-want.avgAge) > 2 || math.Abs(got.avgIncome-want.avgIncome)/want.avgIncome > 0.1 {
+		t.Fatalf("averages drifted: got %+v, source %+v", got, want)
+	}
+	var unchanged int
+	if err := outDB.QueryRow(`SELECT count(*) FROM main.people o JOIN src.people s USING (id) WHERE o.income = s.income`).Scan(&unchanged); err != nil {
+		t.Fatalf("compare: %v", err)
+	}
+	if unchanged > 300 {
+		t.Fatalf("%d incomes kept their value", unchanged)
+	}
+}
+
+func TestRenameTo(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE INDEX idx_users_country ON users(country)`,
+		`CREATE VIEW us_users AS SELECT id, email FROM users WHERE country = 'US'`,
+		`CREATE TRIGGER orders_status AFTER UPDATE ON users BEGIN UPDATE orders SET status = 'review' WHERE user_id = NEW.id; END`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {RenameTo: "clients", Columns: map[string]*config.TransformConfig{"email": {Type: "HmacSha256"}}},
+	}}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Triggers: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+	check := func() {
+		outDB, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer outDB.Close()
+		objects, err := existingObjects(ctx, outDB)
+		if err != nil {
+			t.Fatalf("objects: %v", err)
+		}
+		if objects["users"] || !objects["clients"] {
+			t.Fatalf("unexpected objects: %v", objects)
+		}
+		var ddl string
+		if err := outDB.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'orders'`).Scan(&ddl); err != nil {
+			t.Fatalf("select ddl: %v", err)
+		}
+		if !strings.Contains(ddl, `REFERENCES "clients"`) {
+			t.Fatalf("foreign key not renamed: %s", ddl)
+		}
+		if err := checkFK(outDB); err != nil {
+			t.Fatalf("fk check: %v", err)
+		}
+		var users, viewRows int
+		if err := outDB.QueryRow(`SELECT (SELECT count(*) FROM clients), (SELECT count(*) FROM us_users)`).Scan(&users, &viewRows); err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if users != 2 || viewRows != 1 {
+			t.Fatalf("got %d clients and %d view rows", users, viewRows)
+		}
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	check()
+	opts.Incremental = true
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("incremental run: %v", err)
+	}
+	check()
+
+	opts.Incremental = false
+	for _, tables := range []map[string]*config.TableConfig{
+		{"users": {RenameTo: "people"}, "orders": {RenameTo: "PEOPLE"}},
+		{"users": {RenameTo: "orders"}},
+	} {
+		opts.Config = &config.Config{Tables: tables}
+		if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "rename_to") {
+			t.Fatalf("expected rename_to conflict for %v, got %v", tables, err)
+		}
+	}
+}
+
+func TestCheckFKReportsViolations(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	opts := Options{
+		InPath:  inPath,
+		OutPath: filepath.Join(tmp, "out.sqlite"),
+		Config:  &config.Config{},
+		FKMode:  "off",
+		CheckFK: true,
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO orders (id, user_id, status) VALUES (12, 99, 'lost')`); err != nil {
+		t.Fatalf("insert orphan: %v", err)
+	}
+	db.Close()
+	var out bytes.Buffer
+	opts.Logger = log.New(log.LevelInfo, &out)
+	err = Run(ctx, opts)
+	if err == nil || !strings.Contains(err.Error(), "1 row(s) without a parent") {
+		t.Fatalf("expected fk check failure, got %v", err)
+	}
+	if !strings.Contains(out.String(), "fk violation: orders rowid 12: (user_id) -> users(id) has no parent row") {
+		t.Fatalf("violation not reported:\n%s", out.String())
+	}
+}
+
+func TestOutMode(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+	// 0660 is not a subset of the usual 0644 default, so it also shows
+	// that the umask does not apply.
+	for _, mode := range []os.FileMode{0o600, 0o660} {
+		opts.OutMode = mode
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		info, err := os.Stat(outPath)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Fatalf("output mode %#o, want %#o", info.Mode().Perm(), mode)
+		}
+	}
+
+	// An incremental run tightens an existing output too.
+	if err := os.Chmod(outPath, 0o644); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	opts.OutMode = 0o600
+	opts.Incremental = true
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("incremental run: %v", err)
+	}
+	if info, err := os.Stat(outPath); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("incremental output mode %v, %v", info.Mode(), err)
+	}
+}
+
+func TestOutputLeavesNoSidecars(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+	sidecars := func() []string {
+		var found []string
+		for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+			if _, err := os.Stat(outPath + suffix); err == nil {
+				found = append(found, suffix)
+			}
+		}
+		return found
+	}
+
+	// Leftovers of an earlier output are removed with it.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.WriteFile(outPath+suffix, []byte("stale"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", suffix, err)
+		}
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if found := sidecars(); len(found) > 0 {
+		t.Fatalf("sidecars left after run: %v", found)
+	}
+
+	// An incremental target in WAL mode is switched back to one file.
+	db, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	if _, err := db.Exec(`PRAGMA journal_mode = WAL`); err != nil {
+		t.Fatalf("set wal: %v", err)
+	}
+	db.Close()
+	opts.Incremental = true
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("incremental run: %v", err)
+	}
+	if found := sidecars(); len(found) > 0 {
+		t.Fatalf("sidecars left after incremental run: %v", found)
+	}
+	db, err = sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer db.Close()
+	var mode string
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+		t.Fatalf("journal_mode: %v", err)
+	}
+	if mode != "delete" {
+		t.Fatalf("journal_mode %s, want delete", mode)
+	}
+}
+
+func TestFailOnEmpty(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	opts := Options{
+		InPath:      inPath,
+		OutPath:     filepath.Join(tmp, "out.sqlite"),
+		Config:      &config.Config{},
+		FKMode:      "off",
+		FailOnEmpty: true,
+		Logger:      log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	opts.Config = &config.Config{Tables: map[string]*config.TableConfig{"orders": {Where: "status = 'typo'"}}}
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "no rows copied into orders,") {
+		t.Fatalf("expected empty orders to fail, got %v", err)
+	}
+
+	opts.Config = &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "users", Where: "id = 999"}},
+	}}
+	if err := Run(ctx, opts); err == nil || !strings.Contains(err.Error(), "selection matched no rows") {
+		t.Fatalf("expected empty selection to fail, got %v", err)
+	}
+	opts.FailOnEmpty = false
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run without the guard: %v", err)
+	}
+}
+
+func TestSequenceFollowsCopyOrder(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	// Rows inserted in reverse key order, so rowid order differs from key
+	// order.
+	if _, err := db.Exec(`CREATE TABLE accounts (code TEXT PRIMARY KEY, handle TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 30 UNION ALL SELECT i - 1 FROM n WHERE i > 1)
+		INSERT INTO accounts SELECT printf('c%02d', i), 'real' || i FROM n`); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	db.Close()
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"accounts": {Columns: map[string]*config.TransformConfig{
+			"handle": {Type: "Sequence", Params: map[string]any{"format": "user_%04d"}},
+		}},
+	}}
+	subsetCfg := &config.Config{Tables: cfg.Tables, Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "accounts"}},
+	}}
+	for _, tc := range []struct {
+		name string
+		cfg  *config.Config
+		jobs int
+	}{{"sequential", cfg, 1}, {"parallel", cfg, 4}, {"subset chunks", subsetCfg, 1}} {
+		t.Run(tc.name, func(t *testing.T) {
+			outPath := filepath.Join(tmp, strings.ReplaceAll(tc.name, " ", "-")+".sqlite")
+			opts := Options{InPath: inPath, OutPath: outPath, Config: tc.cfg, Jobs: tc.jobs, ChunkSize: 7, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+			if err := Run(ctx, opts); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			outDB, err := sql.Open("sqlite", outPath)
+			if err != nil {
+				t.Fatalf("open out: %v", err)
+			}
+			defer outDB.Close()
+			rows, err := outDB.Query(`SELECT code, handle FROM accounts ORDER BY code`)
+			if err != nil {
+				t.Fatalf("select: %v", err)
+			}
+			defer rows.Close()
+			i := 0
+			for rows.Next() {
+				var code, handle string
+				if err := rows.Scan(&code, &handle); err != nil {
+					t.Fatalf("scan: %v", err)
+				}
+				i++
+				if want := fmt.Sprintf("user_%04d", i); handle != want {
+					t.Fatalf("%s: got %s, want %s", code, handle, want)
+				}
+			}
+			if i != 30 {
+				t.Fatalf("copied %d rows, want 30", i)
+			}
+		})
+	}
+}
+
+func TestTransformTags(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {Columns: map[string]*config.TransformConfig{
+			"email":   {Type: "SetValue", Value: "hidden"},
+			"country": {Type: "SetValue", Value: "XX", Tags: []string{"eu", "strict"}},
+		}},
+	}}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	dumpPath := filepath.Join(tmp, "effective.yml")
+	for _, tc := range []struct {
+		tags    []string
+		country string
+	}{{nil, "US"}, {[]string{"prod"}, "US"}, {[]string{"prod", "EU"}, "XX"}} {
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Tags: tc.tags, DumpConfigPath: dumpPath, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		outDB, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		var email, country string
+		err = outDB.QueryRow(`SELECT email, country FROM users WHERE id = 1`).Scan(&email, &country)
+		outDB.Close()
+		if err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if email != "hidden" || country != tc.country {
+			t.Fatalf("tags %v: got %s, %s", tc.tags, email, country)
+		}
+		data, err := os.ReadFile(dumpPath)
+		if err != nil {
+			t.Fatalf("read dump: %v", err)
+		}
+		if dumped := strings.Contains(string(data), "XX"); dumped != (tc.country == "XX") {
+			t.Fatalf("tags %v: dump does not match the applied transforms:\n%s", tc.tags, data)
+		}
+	}
+}
+
+func TestInputURI(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{
+		InPath:  "file:" + inPath + "?vfs=unix-none&mode=ro",
+		OutPath: outPath,
+		Config:  &config.Config{},
+		FKMode:  "on",
+		Logger:  log.New(log.LevelInfo, io.Discard),
+	}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	db, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&n); err != nil || n != 2 {
+		t.Fatalf("orders: %d, %v", n, err)
+	}
+
+	// The vfs parameter reaches SQLite, so an unknown one is an error.
+	opts.InPath = "file:" + inPath + "?vfs=pinkmask-missing"
+	if err := Run(ctx, opts); err == nil {
+		t.Fatalf("expected an error for an unknown vfs")
+	}
+}
+
+func TestMaskInPlace(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns: map[string]*config.TransformConfig{
+					"email":     {Type: "HmacSha256", MaxLen: 16},
+					"full_name": {Type: "FakerName"},
+				},
+			},
+			"orders": {
+				Columns: map[string]*config.TransformConfig{
+					"status": {Type: "Sequence", Params: map[string]any{"format": "s%d"}},
+				},
+			},
+		},
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := Run(ctx, Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", Seed: 7, FKMode: "on", Jobs: 1, Logger: log.New(log.LevelInfo, io.Discard)}); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	data, err := os.ReadFile(inPath)
+	if err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	dbPath := filepath.Join(tmp, "db.sqlite")
+	if err := os.WriteFile(dbPath, data, 0o644); err != nil {
+		t.Fatalf("write db: %v", err)
+	}
+	backupPath := filepath.Join(tmp, "backup.sqlite")
+	mopts := MaskOptions{DBPath: dbPath, BackupPath: backupPath, Config: cfg, Salt: "salt", Seed: 7, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Mask(ctx, mopts); err != nil {
+		t.Fatalf("mask: %v", err)
+	}
+
+	dump := func(path string) string {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("open %s: %v", path, err)
+		}
+		defer db.Close()
+		var out []string
+		for _, query := range []string{`SELECT id, email, full_name, country FROM users ORDER BY id`, `SELECT id, user_id, status FROM orders ORDER BY id`} {
+			rows, err := db.Query(query)
+			if err != nil {
+				t.Fatalf("query %s: %v", path, err)
+			}
+			for rows.Next() {
+				var a, b, c, d any
+				dest := []any{&a, &b, &c}
+				if strings.Contains(query, "country") {
+					dest = append(dest, &d)
+				}
+				if err := rows.Scan(dest...); err != nil {
+					t.Fatalf("scan %s: %v", path, err)
+				}
+				out = append(out, fmt.Sprint(a, b, c, d))
+			}
+			rows.Close()
+		}
+		return strings.Join(out, "\n")
+	}
+	if got, want := dump(dbPath), dump(outPath); got != want {
+		t.Fatalf("masked in place:\n%s\nwant the copy's values:\n%s", got, want)
+	}
+	if got := dump(backupPath); !strings.Contains(got, "user1@example.com") {
+		t.Fatalf("backup lost the original values:\n%s", got)
+	}
+	masked, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("read masked: %v", err)
 	}
-	defer db.Close()
-	if _, err := db.Exec(`PRAGMA foreign_keys = ON`); err != nil {
-		return err
+	if bytes.Contains(masked, []byte("user1@example.com")) {
+		t.Fatalf("original value left in the database file")
+	}
+
+	// The backup is never overwritten.
+	if err := Mask(ctx, mopts); err == nil {
+		t.Fatalf("expected an error for an existing backup")
+	}
+	mopts.BackupPath = ""
+	for name, tc := range map[string]*config.TableConfig{
+		"where":       {Where: "id = 1"},
+		"primary key": {Columns: map[string]*config.TransformConfig{"id": {Type: "IntPermute"}}},
+	} {
+		mopts.Config = &config.Config{Tables: map[string]*config.TableConfig{"users": tc}}
+		if err := Mask(ctx, mopts); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
 	}
+}
+
+func TestRealPrimaryKey(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// The parent stores its keys as REAL, the child's INTEGER affinity
+	// stores the same values as integers, and the driver scans them as
+	// float64 and int64 respectively.
 	stmts := []string{
-		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, full_name TEXT, country TEXT)`,
-		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, status TEXT, FOREIGN KEY(user_id) REFERENCES users(id))`,
+		`CREATE TABLE sensors (k REAL PRIMARY KEY, label TEXT)`,
+		`CREATE TABLE readings (id INTEGER PRIMARY KEY, sensor_k INTEGER REFERENCES sensors(k), label TEXT)`,
+		`INSERT INTO sensors VALUES (100000000, 'a'), (2.5, 'b'), (-0.0, 'c')`,
+		`INSERT INTO readings VALUES (1, 100000000, 'a'), (2, 2.5, 'b'), (3, 0, 'c')`,
 	}
 	for _, stmt := range stmts {
 		if _, err := db.Exec(stmt); err != nil {
-			return err
+			t.Fatalf("setup: %v", err)
 		}
 	}
-	if _, err := db.Exec(`INSERT INTO users (id, email, full_name, country) VALUES (1, 'user1@example.com', 'User One', 'US')`); err != nil {
-		return err
+	db.Close()
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"sensors": {Columns: map[string]*config.TransformConfig{
+			"k":     {Type: "HmacSha256", MaxLen: 12},
+			"label": {Type: "FakerName"},
+		}},
+		// Only IntPermute is inherited, so the foreign key repeats the
+		// parent key's transform.
+		"readings": {Columns: map[string]*config.TransformConfig{
+			"sensor_k": {Type: "HmacSha256", MaxLen: 12},
+		}},
+	}}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", Seed: 3, FKMode: "on", Jobs: 1, Subset: true, Logger: log.New(log.LevelInfo, io.Discard)}
+	cfg.Subset = &config.SubsetConfig{Roots: []config.RootConfig{{Table: "readings"}}}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
 	}
-	if _, err := db.Exec(`INSERT INTO users (id, email, full_name, country) VALUES (2, 'user2@example.com', 'User Two', 'CA')`); err != nil {
-		return err
+	out, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
 	}
-	if _, err := db.Exec(`INSERT INTO orders (id, user_id, status) VALUES (10, 1, 'pending')`); err != nil {
-		return err
+	defer out.Close()
+	if err := checkFK(out); err != nil {
+		t.Fatalf("fk check: %v", err)
 	}
-	if _, err := db.Exec(`INSERT INTO orders (id, user_id, status) VALUES (11, 2, 'shipped')`); err != nil {
-		return err
+	// The subset reached every sensor through the integer foreign keys, and
+	// each masked key still matches the reading pointing at it.
+	var n int
+	if err := out.QueryRow(`SELECT COUNT(*) FROM readings r JOIN sensors s ON s.k = r.sensor_k`).Scan(&n); err != nil || n != 3 {
+		t.Fatalf("joined readings: %d, %v", n, err)
 	}
-	return nil
 }
 
-func checkFK(db *sql.DB) error {
-	rows, err := db.Query(`PRAGMA foreign_key_check`)
+func TestCanonicalKeys(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
 	if err != nil {
-		return err
+		t.Fatalf("open: %v", err)
+	}
+	// The driver scans the DATE key as a time.Time and the BLOB key as
+	// []byte, but the TEXT columns referencing them as strings.
+	stmts := []string{
+		`CREATE TABLE days (day DATE PRIMARY KEY, note TEXT)`,
+		`CREATE TABLE tokens (token BLOB PRIMARY KEY, note TEXT)`,
+		`CREATE TABLE events (id INTEGER PRIMARY KEY, day TEXT REFERENCES days(day), token TEXT)`,
+		`INSERT INTO days VALUES ('2024-03-01', 'a'), ('2024-03-02 09:30:00', 'b')`,
+		`INSERT INTO tokens VALUES (CAST('ab' AS BLOB), 'a')`,
+		`INSERT INTO events VALUES (1, '2024-03-01', 'ab'), (2, '2024-03-02 09:30:00', 'ab')`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	db.Close()
+	hmac := &config.TransformConfig{Type: "HmacSha256", MaxLen: 12}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"days":   {Columns: map[string]*config.TransformConfig{"day": hmac}},
+			"tokens": {Columns: map[string]*config.TransformConfig{"token": hmac}},
+			"events": {Columns: map[string]*config.TransformConfig{"day": hmac, "token": hmac}},
+		},
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "salt", FKMode: "on", Jobs: 1, Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	out, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer out.Close()
+	var days, tokens int
+	if err := out.QueryRow(`SELECT COUNT(*) FROM events e JOIN days d ON d.day = e.day`).Scan(&days); err != nil || days != 2 {
+		t.Fatalf("events joined to days: %d, %v", days, err)
+	}
+	if err := out.QueryRow(`SELECT COUNT(*) FROM events e JOIN tokens k ON k.token = e.token`).Scan(&tokens); err != nil || tokens != 2 {
+		t.Fatalf("events joined to tokens: %d, %v", tokens, err)
+	}
+}
+
+func TestTableWithoutSQL(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	// SQLite reports a table row without SQL as corruption unless the
+	// schema is writable, so the input is opened that way below.
+	for _, stmt := range []string{
+		`PRAGMA writable_schema = ON`,
+		`INSERT INTO sqlite_master (type, name, tbl_name, rootpage, sql) VALUES ('table', 'ghost', 'ghost', 0, NULL)`,
+		`PRAGMA writable_schema = OFF`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	var logs bytes.Buffer
+	outPath := filepath.Join(tmp, "out.sqlite")
+	opts := Options{InPath: "file:" + inPath + "?_pragma=writable_schema(1)", OutPath: outPath, Config: &config.Config{}, FKMode: "on", Logger: log.New(log.LevelInfo, &logs)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(logs.String(), "skip table ghost: no CREATE statement in sqlite_master") {
+		t.Fatalf("missing skipped table report in logs:\n%s", logs.String())
+	}
+	outDB, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer outDB.Close()
+	objects, err := existingObjects(ctx, outDB)
+	if err != nil {
+		t.Fatalf("objects: %v", err)
+	}
+	if objects["ghost"] || !objects["users"] || !objects["orders"] {
+		t.Fatalf("unexpected objects: %v", objects)
+	}
+}
+
+func TestNoClobber(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := os.WriteFile(outPath, []byte("keep me"), 0o644); err != nil {
+		t.Fatalf("write output: %v", err)
+	}
+	opts := Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", NoClobber: true, Logger: log.New(log.LevelInfo, io.Discard)}
+	err := Run(ctx, opts)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an existing output error, got %v", err)
+	}
+	if data, _ := os.ReadFile(outPath); string(data) != "keep me" {
+		t.Fatalf("output was modified: %q", data)
+	}
+
+	opts.Shards, opts.ShardKey = 2, "users.id"
+	opts.OutPath = filepath.Join(tmp, "shard.sqlite")
+	if err := os.WriteFile(filepath.Join(tmp, "shard.1.sqlite"), nil, 0o644); err != nil {
+		t.Fatalf("write shard: %v", err)
+	}
+	if err := Run(ctx, opts); err == nil {
+		t.Fatalf("expected an existing shard error")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "shard.0.sqlite")); !os.IsNotExist(err) {
+		t.Fatalf("shard 0 written before the check: %v", err)
+	}
+
+	var logs bytes.Buffer
+	opts = Options{InPath: inPath, OutPath: outPath, Config: &config.Config{}, FKMode: "on", Logger: log.New(log.LevelInfo, &logs)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(logs.String(), "overwrite existing output "+outPath) {
+		t.Fatalf("overwrite not logged:\n%s", logs.String())
+	}
+}
+
+func TestMaskSummary(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {Columns: map[string]*config.TransformConfig{
+			"email":   {Type: "HmacSha256"},
+			"country": {Type: "Redact", Params: map[string]any{"match": "^US$"}},
+		}},
+	}}
+	for _, jobs := range []int{1, 4} {
+		var logs bytes.Buffer
+		opts := Options{InPath: inPath, OutPath: filepath.Join(tmp, fmt.Sprintf("out%d.sqlite", jobs)), Config: cfg, FKMode: "on", Jobs: jobs, Logger: log.New(log.LevelInfo, &logs)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		for _, want := range []string{
+			"masked users.country (Redact): 1 changed, 1 unchanged",
+			"masked users.email (HmacSha256): 2 changed, 0 unchanged",
+		} {
+			if !strings.Contains(logs.String(), want) {
+				t.Fatalf("jobs %d: missing %q in logs:\n%s", jobs, want, logs.String())
+			}
+		}
+	}
+}
+
+func TestByType(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	outPath := filepath.Join(tmp, "out.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		ByType: map[string]*config.TransformConfig{"text": {Type: "Redact", Params: map[string]any{"keep_length": false, "length": 3}}},
+		Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{
+				"country":   nil,
+				"full_name": {Type: "SetValue", Value: "anon"},
+			}},
+		},
+	}
+	opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+	if err := Run(ctx, opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	out, err := sql.Open("sqlite", outPath)
+	if err != nil {
+		t.Fatalf("open out: %v", err)
+	}
+	defer out.Close()
+	var id int64
+	var email, name, country, status string
+	if err := out.QueryRow(`SELECT u.id, u.email, u.full_name, u.country, o.status FROM users u JOIN orders o ON o.user_id = u.id WHERE u.id = 1`).Scan(&id, &email, &name, &country, &status); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if id != 1 || email != "***" || name != "anon" || country != "US" || status != "***" {
+		t.Fatalf("unexpected row: %d %q %q %q %q", id, email, name, country, status)
+	}
+}
+
+func TestSampleMatchesCopy(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, full_name TEXT, phone TEXT, born TEXT, income REAL)`,
+		`CREATE TABLE orders (code TEXT, region TEXT, user_id INTEGER REFERENCES users(id), total REAL, PRIMARY KEY (code, region)) WITHOUT ROWID`,
+		`CREATE TABLE notes (user_id INTEGER REFERENCES users(id), body TEXT)`,
+	}
+	for i := 1; i <= 20; i++ {
+		stmts = append(stmts,
+			fmt.Sprintf(`INSERT INTO users VALUES (%d, 'u%d@example.com', 'User %d', '555-01%02d', '1980-01-%02d', %d.5)`, i, i, i, i, i, i*1000),
+			fmt.Sprintf(`INSERT INTO orders VALUES ('o%d', 'r%d', %d, %d.25)`, i, i%3, i, i*10),
+			fmt.Sprintf(`INSERT INTO notes VALUES (%d, 'note for user %d')`, i, i),
+		)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{
+				"email":     {Type: "FakerEmail"},
+				"full_name": {Type: "FakerName"},
+				"phone":     {Type: "FakerPhone"},
+				"born":      {Type: "DateShift"},
+				"income":    {Type: "Resample"},
+			}},
+			"orders": {Columns: map[string]*config.TransformConfig{
+				"code":  {Type: "HmacSha256"},
+				"total": {Type: "Redistribute", Params: map[string]any{"group_by": "region"}},
+			}},
+			"notes": {Columns: map[string]*config.TransformConfig{
+				"body": {Type: "StableTokenize"},
+			}},
+		},
+		Subset: &config.SubsetConfig{Roots: []config.RootConfig{{Table: "users", Where: "id IN (3, 7, 11)"}}},
+	}
+	run := func(name string, subset bool, jobs int) *sql.DB {
+		outPath := filepath.Join(tmp, name)
+		opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, Salt: "s", Seed: 9, FKMode: "on", Jobs: jobs, Subset: subset, Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		out, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		t.Cleanup(func() { out.Close() })
+		return out
+	}
+	full := run("copy.sqlite", false, 1)
+	sample := run("sample.sqlite", true, 4)
+	for _, query := range []string{
+		`SELECT id, email, full_name, phone, born, income FROM users ORDER BY id`,
+		`SELECT code, region, user_id, total FROM orders ORDER BY user_id`,
+		`SELECT user_id, body FROM notes ORDER BY user_id`,
+	} {
+		sampled := queryRows(t, sample, query)
+		if len(sampled) != 3 {
+			t.Fatalf("%s: sample has %d rows, want 3", query, len(sampled))
+		}
+		all := map[string]bool{}
+		for _, r := range queryRows(t, full, query) {
+			all[r] = true
+		}
+		for _, r := range sampled {
+			if !all[r] {
+				t.Fatalf("%s: sampled row %s differs from the full copy", query, r)
+			}
+		}
+	}
+}
+
+// queryRows returns every row of query, each printed as one string.
+func queryRows(t *testing.T, db *sql.DB, query string) []string {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
 	}
 	defer rows.Close()
-	if rows.Next() {
-		return fmt.Errorf("foreign key check failed")
+	cols, _ := rows.Columns()
+	var out []string
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		out = append(out, fmt.Sprint(vals...))
+	}
+	return out
+}
+
+func TestPassThroughStorage(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	stmts := []string{
+		`CREATE TABLE vals (id INTEGER PRIMARY KEY, i INTEGER, r REAL, n NUMERIC, t TEXT, b BLOB, x, d DATE, ts DATETIME, masked TEXT)`,
+		`INSERT INTO vals VALUES (1, 5, 5.0, 5.0, '5.0', x'00ff', 5.0, '2024-01-02', '2024-01-02 03:04:05', 'a')`,
+		`INSERT INTO vals VALUES (2, 9223372036854775807, -0.0, '1e3', '007', 'text', x'', 'not a date', 1700000000, 'b')`,
+		`INSERT INTO vals VALUES (3, 5.5, 1e300, 'abc', x'41', 3, '', '2024-01-02T03:04:05Z', '2024-01-02 03:04:05.123', 'c')`,
+		`INSERT INTO vals VALUES (4, NULL, NULL, NULL, NULL, NULL, NULL, 20240102, 2.5, 'd')`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	const query = `SELECT id, typeof(i), quote(i), typeof(r), quote(r), typeof(n), quote(n), typeof(t), quote(t), typeof(b), quote(b), typeof(x), quote(x), typeof(d), quote(d), typeof(ts), quote(ts) FROM vals ORDER BY id`
+	in, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	defer in.Close()
+	want := queryRows(t, in, query)
+	// A masked column sends the rows through the scan and insert path; an
+	// unmasked table is copied with INSERT ... SELECT.
+	for _, masked := range []bool{false, true} {
+		cfg := &config.Config{}
+		if masked {
+			cfg.Tables = map[string]*config.TableConfig{"vals": {Columns: map[string]*config.TransformConfig{"masked": {Type: "SetNull"}}}}
+		}
+		for _, jobs := range []int{1, 4} {
+			outPath := filepath.Join(tmp, fmt.Sprintf("out-%t-%d.sqlite", masked, jobs))
+			opts := Options{InPath: inPath, OutPath: outPath, Config: cfg, FKMode: "on", Jobs: jobs, Logger: log.New(log.LevelInfo, io.Discard)}
+			if err := Run(ctx, opts); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			out, err := sql.Open("sqlite", outPath)
+			if err != nil {
+				t.Fatalf("open out: %v", err)
+			}
+			got := queryRows(t, out, query)
+			out.Close()
+			for i := range want {
+				if i >= len(got) || got[i] != want[i] {
+					t.Fatalf("masked=%t jobs=%d: row changed\n in: %s\nout: %v", masked, jobs, want[i], got)
+				}
+			}
+		}
+	}
+}
+
+func TestAttachMatchesRowByRow(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open in: %v", err)
+	}
+	stmts := []string{
+		`CREATE TABLE notes (body TEXT, n, extra BLOB)`,
+		`INSERT INTO notes (rowid, body, n, extra) VALUES (5, 'e', 1, x'01'), (2, 'b', 2.5, NULL), (9, NULL, '3', x''), (4, 'd', 4, 'x'), (7, 'g', NULL, x'ff')`,
+		`CREATE TABLE codes (code TEXT PRIMARY KEY, region TEXT, v REAL) WITHOUT ROWID`,
+		`INSERT INTO codes VALUES ('b', 'eu', 1.5), ('a', 'us', NULL), ('c', 'eu', 7)`,
+		`CREATE TABLE events (name TEXT, at DATETIME)`,
+		`INSERT INTO events (rowid, name, at) VALUES (3, 'a', '2024-01-02'), (42, 'c', 1700000000), (7, 'b', NULL)`,
+		`CREATE TABLE items (id INTEGER PRIMARY KEY, label TEXT)`,
+		`INSERT INTO items VALUES (10, 'x'), (3, 'y'), (30, NULL)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("exec %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	// Every table gets an added column. Without a transform it is filled
+	// with NULL inside SQLite; SetNull gives the same value but makes the
+	// table take the row-by-row path.
+	tablesFor := func(rowByRow bool) *config.Config {
+		added := func() []config.AddColumnConfig {
+			ac := config.AddColumnConfig{Name: "note", Type: "TEXT"}
+			if rowByRow {
+				ac.Transform = &config.TransformConfig{Type: "SetNull"}
+			}
+			return []config.AddColumnConfig{ac}
+		}
+		return &config.Config{Tables: map[string]*config.TableConfig{
+			"notes": {
+				Where:       "n IS NOT NULL OR body IS NULL",
+				Limit:       3,
+				DropColumns: []string{"extra"},
+				AddColumns:  added(),
+			},
+			"codes":  {AddColumns: added()},
+			"events": {PreserveRowID: true, AddColumns: added()},
+			"items":  {AddColumns: added()},
+		}}
+	}
+	queries := []string{
+		`SELECT rowid, typeof(body), quote(body), typeof(n), quote(n), quote(note) FROM notes ORDER BY rowid`,
+		`SELECT quote(code), quote(region), typeof(v), quote(v), quote(note) FROM codes ORDER BY code`,
+		`SELECT rowid, quote(name), typeof(at), quote(at), quote(note) FROM events ORDER BY rowid`,
+		`SELECT rowid, id, quote(label), quote(note) FROM items ORDER BY rowid`,
+		`SELECT name, sql FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY name`,
+	}
+	dump := func(rowByRow bool) []string {
+		outPath := filepath.Join(tmp, fmt.Sprintf("out-%t.sqlite", rowByRow))
+		opts := Options{InPath: inPath, OutPath: outPath, Config: tablesFor(rowByRow), FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); err != nil {
+			t.Fatalf("run rowByRow=%t: %v", rowByRow, err)
+		}
+		out, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer out.Close()
+		var rows []string
+		for _, q := range queries {
+			rows = append(rows, queryRows(t, out, q)...)
+			rows = append(rows, "--")
+		}
+		return rows
+	}
+	attached, rowByRow := dump(false), dump(true)
+	if strings.Join(attached, "\n") != strings.Join(rowByRow, "\n") {
+		t.Fatalf("ATTACH and row-by-row copies differ\nattach:\n%s\nrow by row:\n%s", strings.Join(attached, "\n"), strings.Join(rowByRow, "\n"))
+	}
+	if got := strings.Join(attached, "\n"); !strings.Contains(got, "3'a'text'2024-01-02'") || !strings.Contains(got, "42'c'integer1700000000") {
+		t.Fatalf("preserve_rowid rowids were not kept:\n%s", got)
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	for _, jobs := range []int{1, 4} {
+		cfg := &config.Config{Tables: map[string]*config.TableConfig{
+			"users": {Columns: map[string]*config.TransformConfig{"email": {Type: "IntPermute"}}},
+		}}
+		opts := Options{InPath: inPath, OutPath: filepath.Join(tmp, fmt.Sprintf("out-%d.sqlite", jobs)), Config: cfg, FKMode: "on", Jobs: jobs, Logger: log.New(log.LevelInfo, io.Discard)}
+		err := Run(ctx, opts)
+		var colErr *ColumnError
+		if !errors.As(err, &colErr) || colErr.Op != "transform" || colErr.Table != "users" || colErr.Column != "email" {
+			t.Fatalf("jobs=%d: expected a transform error on users.email, got %v", jobs, err)
+		}
+		if !strings.HasPrefix(err.Error(), "transform users.email: IntPermute:") {
+			t.Fatalf("jobs=%d: unexpected message %q", jobs, err)
+		}
+	}
+
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {DropColumns: []string{"nickname"}},
+	}}
+	err := Run(ctx, Options{InPath: inPath, OutPath: filepath.Join(tmp, "drop.sqlite"), Config: cfg, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)})
+	var notFound *schema.NotFoundError
+	if !errors.Is(err, schema.ErrMissingColumn) || !errors.As(err, &notFound) || notFound.Table != "users" || notFound.Column != "nickname" {
+		t.Fatalf("expected a missing column error, got %v", err)
+	}
+	if err.Error() != "drop_columns: column not found: users.nickname" {
+		t.Fatalf("unexpected message %q", err)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cases := []struct {
+		name  string
+		table *config.TableConfig
+		class error
+	}{
+		{"unknown transformer", &config.TableConfig{Columns: map[string]*config.TransformConfig{"email": {Type: "Nope"}}}, config.ErrInvalid},
+		{"bad params", &config.TableConfig{Columns: map[string]*config.TransformConfig{"email": {Type: "Pbkdf2", Params: map[string]any{"iterations": -1}}}}, config.ErrInvalid},
+		{"dropped key", &config.TableConfig{DropColumns: []string{"id"}}, ErrSchema},
+		{"bad added column", &config.TableConfig{AddColumns: []config.AddColumnConfig{{Name: "extra", Type: "TEXT UNIQUE"}}}, ErrSchema},
+		{"broken filter", &config.TableConfig{Where: "id = 2"}, ErrIntegrity},
+	}
+	for i, tc := range cases {
+		cfg := &config.Config{Tables: map[string]*config.TableConfig{"users": tc.table}}
+		opts := Options{InPath: inPath, OutPath: filepath.Join(tmp, fmt.Sprintf("out-%d.sqlite", i)), Config: cfg, FKMode: "on", Logger: log.New(log.LevelInfo, io.Discard)}
+		if err := Run(ctx, opts); !errors.Is(err, tc.class) {
+			t.Fatalf("%s: got %v, want an error matching %v", tc.name, err, tc.class)
+		}
+	}
+}
+
+func TestTableSeed(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	if err := createTestDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	run := func(out string, usersSeed int64) (emails, names, orders []string) {
+		cfg := &config.Config{Tables: map[string]*config.TableConfig{
+			"users": {Seed: usersSeed, Columns: map[string]*config.TransformConfig{
+				"email":     {Type: "HmacSha256"},
+				"full_name": {Type: "FakerName"},
+			}},
+			"orders": {Columns: map[string]*config.TransformConfig{"status": {Type: "FakerName"}}},
+		}}
+		outPath := filepath.Join(tmp, out)
+		if err := Run(ctx, Options{InPath: inPath, OutPath: outPath, Config: cfg, Seed: 3, FKMode: "on"}); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		db, err := sql.Open("sqlite", outPath)
+		if err != nil {
+			t.Fatalf("open out: %v", err)
+		}
+		defer db.Close()
+		return queryRows(t, db, `SELECT email FROM users ORDER BY id`), queryRows(t, db, `SELECT full_name FROM users ORDER BY id`), queryRows(t, db, `SELECT status FROM orders ORDER BY id`)
+	}
+	baseEmails, baseNames, baseOrders := run("base.sqlite", 0)
+	seededEmails, seededNames, seededOrders := run("seeded.sqlite", 7)
+	if fmt.Sprint(baseOrders) != fmt.Sprint(seededOrders) {
+		t.Fatalf("orders changed with the users seed: %v vs %v", baseOrders, seededOrders)
+	}
+	if fmt.Sprint(baseNames) == fmt.Sprint(seededNames) {
+		t.Fatalf("users.full_name unchanged by the table seed: %v", seededNames)
+	}
+	if fmt.Sprint(baseEmails) != fmt.Sprint(seededEmails) {
+		t.Fatalf("email depends on the table seed: %v vs %v", baseEmails, seededEmails)
+	}
+
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {Seed: 7, Columns: map[string]*config.TransformConfig{"id": {Type: "IntPermute"}}},
+	}}
+	err := Run(ctx, Options{InPath: inPath, OutPath: filepath.Join(tmp, "split.sqlite"), Config: cfg, FKMode: "on"})
+	if !errors.Is(err, ErrSchema) || !strings.Contains(err.Error(), "orders.user_id") {
+		t.Fatalf("expected a seed mismatch on orders.user_id, got %v", err)
 	}
-	return nil
 }
@@ -0,0 +1,217 @@
+package copy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+)
+
+// danglingFKs maps each copied table to the tables its foreign keys
+// reference that include_tables/exclude_tables leave out of the copy.
+func danglingFKs(s *schema.Schema, cfg *config.Config) map[string][]string {
+	out := map[string][]string{}
+	for name, tbl := range s.Tables {
+		if !tableIncluded(cfg, name) {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, fk := range tbl.ForeignKeys {
+			if s.Tables[fk.Table] == nil || tableIncluded(cfg, fk.Table) || seen[fk.Table] {
+				continue
+			}
+			seen[fk.Table] = true
+			out[name] = append(out[name], fk.Table)
+		}
+		sort.Strings(out[name])
+	}
+	return out
+}
+
+// checkDanglingFKs reports foreign keys to excluded tables. Unless they are
+// dropped with --drop-dangling-fks, inserts into the child table fail with
+// --fk on, so that case is an error; with --fk off they are only logged.
+func checkDanglingFKs(s *schema.Schema, opts Options) error {
+	dangling := danglingFKs(s, opts.Config)
+	if len(dangling) == 0 || opts.DropDanglingFKs {
+		return nil
+	}
+	children := make([]string, 0, len(dangling))
+	for name := range dangling {
+		children = append(children, name)
+	}
+	sort.Strings(children)
+	refs := make([]string, 0, len(children))
+	for _, child := range children {
+		for _, parent := range dangling[child] {
+			refs = append(refs, fmt.Sprintf("%s -> %s", child, parent))
+		}
+	}
+	if opts.Logger != nil {
+		for _, ref := range refs {
+			opts.Logger.Infof("fk warning: %s: referenced table is excluded from the copy", ref)
+		}
+	}
+	if strings.ToLower(opts.FKMode) == "on" {
+		return fmt.Errorf("foreign keys reference tables excluded from the copy: %s; include them, use --drop-dangling-fks, or use --fk off", strings.Join(refs, ", "))
+	}
+	return nil
+}
+
+// dropForeignKeys rewrites a CREATE TABLE statement without the foreign key
+// constraints, column or table level, that reference any of the given
+// tables.
+func dropForeignKeys(ddl string, parents []string) (string, error) {
+	drop := map[string]bool{}
+	for _, p := range parents {
+		drop[strings.ToLower(p)] = true
+	}
+	toks := schema.Tokenize(ddl)
+	defs, err := tableDefinitions(ddl, toks)
+	if err != nil {
+		return "", fmt.Errorf("drop foreign keys: %w", err)
+	}
+	var cuts []sqlCut
+	for _, d := range defs {
+		if d.start >= d.end {
+			continue
+		}
+		first := strings.ToUpper(toks[d.start].Text)
+		tableConstraint := first == "FOREIGN" || (first == "CONSTRAINT" && d.start+2 < d.end && strings.EqualFold(toks[d.start+2].Text, "FOREIGN"))
+		for k := d.start; k < d.end; k++ {
+			if !strings.EqualFold(toks[k].Text, "REFERENCES") || k+1 >= d.end || !drop[strings.ToLower(schema.IdentName(toks[k+1].Text))] {
+				continue
+			}
+			if tableConstraint {
+				// The first definition is always a column, so a table
+				// constraint has a comma to remove with it.
+				cuts = append(cuts, sqlCut{toks[d.comma].Start, toks[d.end-1].End})
+				break
+			}
+			from := k
+			if k-2 >= d.start && strings.EqualFold(toks[k-2].Text, "CONSTRAINT") {
+				from = k - 2
+			}
+			to := fkClauseEnd(toks[:d.end], k)
+			cuts = append(cuts, sqlCut{toks[from].Start, toks[to].End})
+			k = to
+		}
+	}
+	return removeCuts(ddl, cuts), nil
+}
+
+// sqlCut is a byte range of a statement to remove.
+type sqlCut struct{ start, end int }
+
+// removeCuts returns sqlText without the given ranges, which must be in
+// order and not overlap.
+func removeCuts(sqlText string, cuts []sqlCut) string {
+	if len(cuts) == 0 {
+		return sqlText
+	}
+	var b strings.Builder
+	pos := 0
+	for _, c := range cuts {
+		b.WriteString(sqlText[pos:c.start])
+		pos = c.end
+	}
+	b.WriteString(sqlText[pos:])
+	return b.String()
+}
+
+// tableDefinition is a top-level entry of a CREATE TABLE column list: a
+// column definition or a table constraint.
+type tableDefinition struct {
+	comma      int // token index of the preceding comma, -1 for the first
+	start, end int // token range [start, end)
+}
+
+// tableDefinitions splits the column list of a CREATE TABLE statement into
+// its top-level definitions.
+func tableDefinitions(ddl string, toks []schema.Token) ([]tableDefinition, error) {
+	open := -1
+	for i, t := range toks {
+		if t.Text == "(" {
+			open = i
+			break
+		}
+	}
+	if open < 0 {
+		return nil, fmt.Errorf("no column list in %q", ddl)
+	}
+	var defs []tableDefinition
+	depth := 0
+	cur := tableDefinition{comma: -1, start: open + 1}
+	for i := open + 1; i < len(toks); i++ {
+		switch toks[i].Text {
+		case "(":
+			depth++
+		case ")":
+			if depth == 0 {
+				cur.end = i
+				return append(defs, cur), nil
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				cur.end = i
+				defs = append(defs, cur)
+				cur = tableDefinition{comma: i, start: i + 1}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unbalanced parentheses in %q", ddl)
+}
+
+// fkClauseEnd returns the index of the last token of the foreign key clause
+// starting with REFERENCES at toks[k].
+func fkClauseEnd(toks []schema.Token, k int) int {
+	last := k + 1
+	i := k + 2
+	word := func(j int) string {
+		if j < len(toks) {
+			return strings.ToUpper(toks[j].Text)
+		}
+		return ""
+	}
+	if word(i) == "(" {
+		for i < len(toks) && toks[i].Text != ")" {
+			i++
+		}
+		last = min(i, len(toks)-1)
+		i++
+	}
+	for i < len(toks) {
+		switch word(i) {
+		case "ON":
+			switch word(i + 2) {
+			case "SET", "NO":
+				last = i + 3
+			default:
+				last = i + 2
+			}
+		case "MATCH":
+			last = i + 1
+		case "DEFERRABLE":
+			last = i
+			if word(i+1) == "INITIALLY" {
+				last = i + 2
+			}
+		case "NOT":
+			if word(i+1) != "DEFERRABLE" {
+				return last
+			}
+			last = i + 1
+			if word(i+2) == "INITIALLY" {
+				last = i + 3
+			}
+		default:
+			return last
+		}
+		last = min(last, len(toks)-1)
+		i = last + 1
+	}
+	return last
+}
@@ -0,0 +1,63 @@
+package copy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TableError is a failure of a step of copying or masking a table, such as
+// the select, a row scan, or an insert. Err is the driver's error.
+type TableError struct {
+	Op    string
+	Table string
+	Err   error
+}
+
+func (e *TableError) Error() string { return fmt.Sprintf("%s %s: %v", e.Op, e.Table, e.Err) }
+
+func (e *TableError) Unwrap() error { return e.Err }
+
+// ColumnError is a failure tied to one column of a table: building or
+// running its transformer, or preloading what the transformer needs.
+type ColumnError struct {
+	Op     string
+	Table  string
+	Column string
+	Err    error
+}
+
+func (e *ColumnError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("%s.%s: %v", e.Table, e.Column, e.Err)
+	}
+	return fmt.Sprintf("%s %s.%s: %v", e.Op, e.Table, e.Column, e.Err)
+}
+
+func (e *ColumnError) Unwrap() error { return e.Err }
+
+var (
+	// ErrSchema matches the errors of a config that does not fit the
+	// input's schema, such as a dropped key column or a STRICT type
+	// mismatch, and of schema objects the output cannot create.
+	ErrSchema = errors.New("schema validation failed")
+	// ErrIntegrity matches the errors of an output that fails an
+	// integrity check: broken foreign keys, duplicates masking put in a
+	// deferred unique column, or, with FailOnEmpty, empty tables.
+	ErrIntegrity = errors.New("integrity check failed")
+)
+
+// classError puts err in a class such as ErrSchema without changing its
+// message.
+type classError struct {
+	error
+	class error
+}
+
+func (e *classError) Unwrap() []error { return []error{e.error, e.class} }
+
+func inClass(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classError{error: err, class: class}
+}
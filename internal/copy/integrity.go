@@ -0,0 +1,152 @@
+package copy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/subset"
+)
+
+type violation struct {
+	Table      string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	Rows       int64
+}
+
+func (v violation) String() string {
+	return fmt.Sprintf("%s(%s) -> %s(%s): %d row(s) reference parents excluded by where/limit", v.Table, strings.Join(v.Columns, ", "), v.RefTable, strings.Join(v.RefColumns, ", "), v.Rows)
+}
+
+func checkFilterIntegrity(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config) ([]violation, error) {
+	names := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []violation
+	for _, name := range names {
+		child := s.Tables[name]
+		if !tableIncluded(cfg, name) {
+			continue
+		}
+		for _, fk := range subset.GroupFKs(child) {
+			parent := s.Tables[fk.RefTable]
+			if parent == nil || !tableIncluded(cfg, parent.Name) {
+				continue
+			}
+			if !filtered(cfg, child.Name) && !filtered(cfg, parent.Name) {
+				continue
+			}
+			conds := make([]string, 0, len(fk.FromCols))
+			joins := make([]string, 0, len(fk.FromCols))
+			for i, from := range fk.FromCols {
+				conds = append(conds, fmt.Sprintf("c.%s IS NOT NULL", schema.QuoteIdent(from)))
+				joins = append(joins, fmt.Sprintf("p.%s = c.%s", schema.QuoteIdent(fk.ToCols[i]), schema.QuoteIdent(from)))
+			}
+			query := fmt.Sprintf("SELECT COUNT(1) FROM (%s) AS c WHERE %s AND NOT EXISTS (SELECT 1 FROM (%s) AS p WHERE %s)",
+				filteredSource(child, cfg), strings.Join(conds, " AND "), filteredSource(parent, cfg), strings.Join(joins, " AND "))
+			var n int64
+			if err := db.QueryRowContext(ctx, query).Scan(&n); err != nil {
+				return nil, fmt.Errorf("integrity check %s -> %s: %w", child.Name, parent.Name, err)
+			}
+			if n > 0 {
+				out = append(out, violation{Table: child.Name, Columns: fk.FromCols, RefTable: parent.Name, RefColumns: fk.ToCols, Rows: n})
+			}
+		}
+	}
+	return out, nil
+}
+
+func reportViolations(violations []violation, opts Options) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	if opts.Logger != nil {
+		for _, v := range violations {
+			opts.Logger.Infof("fk warning: %s", v)
+		}
+	}
+	if strings.ToLower(opts.FKMode) == "on" {
+		return fmt.Errorf("table filters break %d foreign key relationship(s) (see warnings above); adjust where/limit or use --fk off", len(violations))
+	}
+	return nil
+}
+
+func filtered(cfg *config.Config, name string) bool {
+	tc := cfg.Tables[name]
+	return tc != nil && (tc.Where != "" || tc.Limit > 0)
+}
+
+func filteredSource(tbl *schema.Table, cfg *config.Config) string {
+	useRowID := len(tbl.PrimaryKeys) == 0 && !tbl.WithoutRowID
+	return fmt.Sprintf("SELECT * FROM %s%s", schema.QuoteIdent(tbl.Name), tableFilter(tbl, cfg.Tables[tbl.Name], useRowID))
+}
+
+// checkForeignKeys runs PRAGMA foreign_key_check on the output and logs
+// every row whose parent is missing, naming the foreign key columns, so a
+// broken subset or filter can be traced to its rows.
+func checkForeignKeys(ctx context.Context, db *sql.DB, logger *log.Logger) error {
+	violations, err := schema.CheckForeignKeys(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		if logger != nil {
+			logger.Infof("foreign key check passed")
+		}
+		return nil
+	}
+	if logger != nil {
+		for _, v := range violations {
+			logger.Infof("fk violation: %s", v)
+		}
+	}
+	return fmt.Errorf("foreign key check found %d row(s) without a parent (see violations above)", len(violations))
+}
+
+// checkEmptyTables fails when a copied table has no rows in the output
+// although it has some in the input, or when the selection is empty,
+// which usually means a subset root or where filter that matches nothing.
+func checkEmptyTables(ctx context.Context, inDB, outDB *sql.DB, order []string, cfg *config.Config, selection *subset.Selection, skipped map[string]bool) error {
+	if selection != nil && len(selection.Whole) == 0 {
+		selected := false
+		for _, set := range selection.Sets {
+			selected = selected || set.Len() > 0
+		}
+		if !selected {
+			return fmt.Errorf("--fail-on-empty: the selection matched no rows; check the subset roots")
+		}
+	}
+	var empty []string
+	for _, name := range order {
+		if !tableIncluded(cfg, name) || skipped[name] {
+			continue
+		}
+		query := "SELECT EXISTS (SELECT 1 FROM " + schema.QuoteIdent(name) + ")"
+		var hasOut, hasIn bool
+		if err := outDB.QueryRowContext(ctx, query).Scan(&hasOut); err != nil {
+			return &TableError{Op: "count output", Table: name, Err: err}
+		}
+		if hasOut {
+			continue
+		}
+		if err := inDB.QueryRowContext(ctx, query).Scan(&hasIn); err != nil {
+			return &TableError{Op: "count input", Table: name, Err: err}
+		}
+		if hasIn {
+			empty = append(empty, name)
+		}
+	}
+	if len(empty) > 0 {
+		return fmt.Errorf("--fail-on-empty: no rows copied into %s, which have rows in the input; check their where/limit or the subset roots", strings.Join(empty, ", "))
+	}
+	return nil
+}
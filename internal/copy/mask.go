@@ -0,0 +1,299 @@
+package copy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/schema"
+)
+
+// MaskOptions configures Mask. The fields shared with Options mean the
+// same there.
+type MaskOptions struct {
+	DBPath string
+	// BackupPath, when set, receives a copy of the database made with
+	// VACUUM INTO before anything is masked. It must not exist yet.
+	BackupPath  string
+	Config      *config.Config
+	Salt        string
+	Seed        int64
+	FKMode      string
+	RequireSalt bool
+	Tags        []string
+	Logger      *log.Logger
+}
+
+// Mask applies the configured transforms to the database at DBPath in
+// place, with one UPDATE per row of every masked table, all in a single
+// transaction. A row gets the same values as copy would give it, since the
+// transformers are built, and Redistribute and Resample loaded, from the
+// unmasked data. Config that changes which rows or columns a table has is
+// rejected, as is masking a primary key, whose new values could collide
+// with keys not updated yet. The old values are overwritten on disk
+// (secure_delete, then VACUUM), so they do not linger in free pages.
+func Mask(ctx context.Context, mopts MaskOptions) error {
+	if mopts.DBPath == "" {
+		return fmt.Errorf("database path is required")
+	}
+	opts := Options{
+		InPath:      mopts.DBPath,
+		Config:      mopts.Config,
+		Salt:        mopts.Salt,
+		Seed:        mopts.Seed,
+		FKMode:      mopts.FKMode,
+		RequireSalt: mopts.RequireSalt,
+		Tags:        mopts.Tags,
+		Logger:      mopts.Logger,
+	}
+	if opts.Config == nil {
+		opts.Config = &config.Config{}
+	}
+	if mode := strings.ToLower(opts.FKMode); mode != "on" && mode != "off" {
+		return fmt.Errorf("invalid fk mode: %s", opts.FKMode)
+	}
+	if err := validateMaskConfig(opts.Config); err != nil {
+		return inClass(config.ErrInvalid, err)
+	}
+	if _, err := os.Stat(mopts.DBPath); err != nil && !strings.HasPrefix(mopts.DBPath, "file:") {
+		return fmt.Errorf("open database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", schema.DSN(mopts.DBPath))
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		return err
+	}
+	logSkippedTables(s, opts.Logger)
+	if err := checkSalt(s, opts); err != nil {
+		return err
+	}
+	if err := validateStrictTypes(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if err := validateTableSeeds(s, opts.Config); err != nil {
+		return inClass(ErrSchema, err)
+	}
+	if mopts.BackupPath != "" {
+		if _, err := db.ExecContext(ctx, "VACUUM INTO ?", mopts.BackupPath); err != nil {
+			return fmt.Errorf("backup to %s: %w", mopts.BackupPath, err)
+		}
+		if opts.Logger != nil {
+			opts.Logger.Infof("backed up %s to %s", mopts.DBPath, mopts.BackupPath)
+		}
+	}
+
+	type maskedTable struct {
+		tbl          *schema.Table
+		transformers []columnTransformer
+	}
+	var tables []maskedTable
+	for _, name := range schema.TableOrder(s) {
+		tbl := s.Tables[name]
+		if tbl == nil {
+			continue
+		}
+		transformers, err := buildTransformers(ctx, db, s, opts.Config, tbl, opts.Salt, opts.Tags)
+		if err != nil {
+			return err
+		}
+		if len(transformers) == 0 {
+			continue
+		}
+		for _, ct := range transformers {
+			if !hasColumn(tbl, ct.column) {
+				return inClass(ErrSchema, fmt.Errorf("mask: %w", &schema.NotFoundError{Table: name, Column: ct.column}))
+			}
+			if containsString(tbl.PrimaryKeys, ct.column) {
+				return inClass(ErrSchema, fmt.Errorf("mask %s.%s: cannot mask a primary key column in place; use copy", name, ct.column))
+			}
+		}
+		topts := opts
+		topts.Seed = tableSeed(opts, name)
+		if err := preloadTransformers(ctx, db, tbl, topts, transformers); err != nil {
+			return err
+		}
+		tables = append(tables, maskedTable{tbl: tbl, transformers: transformers})
+	}
+
+	// PRAGMAs apply per connection, so the updates and the VACUUM run on
+	// the connection they are set on.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %s", strings.ToUpper(opts.FKMode))); err != nil {
+		return fmt.Errorf("set foreign_keys: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA secure_delete = ON"); err != nil {
+		return fmt.Errorf("set secure_delete: %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+	for _, mt := range tables {
+		if opts.Logger != nil {
+			opts.Logger.Infof("mask table %s", mt.tbl.Name)
+		}
+		if err := maskTable(ctx, tx, mt.tbl, opts, mt.transformers); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// logSkippedTables reports the tables schema.Load left out because
+// sqlite_master has no CREATE statement for them; their rows are not copied
+// or masked.
+func logSkippedTables(s *schema.Schema, logger *log.Logger) {
+	if logger == nil {
+		return
+	}
+	for _, name := range s.Skipped {
+		logger.Infof("skip table %s: no CREATE statement in sqlite_master", name)
+	}
+}
+
+// validateMaskConfig rejects the config Mask cannot apply to a database in
+// place: anything that removes tables, rows, or columns, adds columns,
+// reorders rows, or writes outside the database.
+func validateMaskConfig(cfg *config.Config) error {
+	switch {
+	case len(cfg.IncludeTables) > 0 || len(cfg.ExcludeTables) > 0:
+		return fmt.Errorf("mask in place does not support include_tables/exclude_tables; use copy")
+	case cfg.Subset != nil:
+		return fmt.Errorf("mask in place does not support subset; use sample")
+	}
+	for name, tc := range cfg.Tables {
+		if tc == nil {
+			continue
+		}
+		var field string
+		switch {
+		case tc.Where != "":
+			field = "where"
+		case tc.Limit > 0:
+			field = "limit"
+		case len(tc.DropColumns) > 0:
+			field = "drop_columns"
+		case len(tc.AddColumns) > 0:
+			field = "add_columns"
+		case tc.ShuffleRows:
+			field = "shuffle_rows"
+		case len(tc.AuditColumns) > 0:
+			field = "audit_columns"
+		case len(tc.VaultColumns) > 0:
+			field = "vault_columns"
+		case tc.RenameTo != "":
+			field = "rename_to"
+		default:
+			continue
+		}
+		return fmt.Errorf("mask in place does not support tables.%s.%s; use copy", name, field)
+	}
+	return nil
+}
+
+// maskTable updates every row of tbl in primary key order, so row ordinals
+// match a copy's.
+func maskTable(ctx context.Context, tx *sql.Tx, tbl *schema.Table, opts Options, transformers []columnTransformer) error {
+	opts.Seed = tableSeed(opts, tbl.Name)
+	colIndex := map[string]int{}
+	pkCols := tbl.PrimaryKeys
+	useRowID := len(pkCols) == 0 && !tbl.WithoutRowID
+	var selectCols []string
+	if useRowID {
+		selectCols = append(selectCols, "rowid")
+	}
+	for i, c := range tbl.Columns {
+		colIndex[c.Name] = i
+		selectCols = append(selectCols, schema.QuoteIdent(c.Name))
+	}
+	sets := make([]string, 0, len(transformers))
+	for _, ct := range transformers {
+		sets = append(sets, schema.QuoteIdent(ct.column)+" = ?")
+	}
+	keyCols := []string{"rowid"}
+	if !useRowID {
+		keyCols = quotedCols(pkCols)
+	}
+	conds := make([]string, 0, len(keyCols))
+	for _, c := range keyCols {
+		conds = append(conds, c+" = ?")
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE %s", schema.QuoteIdent(tbl.Name), strings.Join(sets, ", "), strings.Join(conds, " AND "))
+	stmt, err := tx.PrepareContext(ctx, update)
+	if err != nil {
+		return &TableError{Op: "prepare update", Table: tbl.Name, Err: err}
+	}
+	defer stmt.Close()
+
+	// The rows are updated while the scan is running. That is safe because
+	// the scan follows the key, which is never updated.
+	query := fmt.Sprintf("SELECT %s FROM %s %s", strings.Join(selectCols, ", "), schema.QuoteIdent(tbl.Name), buildOrderBy(tbl, useRowID))
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return &TableError{Op: "select", Table: tbl.Name, Err: err}
+	}
+	defer rows.Close()
+	scanTargets := make([]any, len(selectCols))
+	rowValues := make([]any, len(selectCols))
+	for i := range scanTargets {
+		scanTargets[i] = &rowValues[i]
+	}
+	buf := make([]any, len(colIndex)+1)
+	pkBuf := make([]any, 0, len(pkCols)+1)
+	args := make([]any, 0, len(transformers)+len(keyCols))
+	var ordinal int64
+	for rows.Next() {
+		if err := rows.Scan(scanTargets...); err != nil {
+			return &TableError{Op: "scan row", Table: tbl.Name, Err: err}
+		}
+		row, rowCtx := buildRowContext(buf, pkBuf, rowValues, colIndex, pkCols, useRowID, opts, tbl)
+		ordinal++
+		rowCtx.Ordinal = ordinal
+		values := row[1:]
+		args = args[:0]
+		for _, ct := range transformers {
+			rowCtx.Column, rowCtx.ColumnType = ct.column, ct.colType
+			newVal, err := ct.tr.Transform(values[colIndex[ct.column]], rowCtx)
+			if err != nil {
+				return &ColumnError{Op: "transform", Table: tbl.Name, Column: ct.column, Err: err}
+			}
+			args = append(args, newVal)
+		}
+		if useRowID {
+			args = append(args, row[0])
+		} else {
+			args = append(args, rowCtx.PK...)
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return &TableError{Op: "update", Table: tbl.Name, Err: err}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return &TableError{Op: "iterate", Table: tbl.Name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package copy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/transform"
+)
+
+// preloadTransformers runs the pass over the input table that Redistribute
+// and Resample need before any row is masked. They read the whole input
+// table, regardless of where, limit, or subsetting, so their statistics are
+// the source's.
+func preloadTransformers(ctx context.Context, db *sql.DB, tbl *schema.Table, opts Options, transformers []columnTransformer) error {
+	for _, ct := range transformers {
+		var err error
+		switch tr := ct.tr.(type) {
+		case *transform.Redistribute:
+			err = loadRedistribution(ctx, db, tbl, opts, ct.column, tr)
+		case *transform.Resample:
+			err = loadResample(ctx, db, tbl, opts, ct.column, tr)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRedistribution reads the key, group, and value of every row and
+// computes the redistributed values.
+func loadRedistribution(ctx context.Context, db *sql.DB, tbl *schema.Table, opts Options, column string, r *transform.Redistribute) error {
+	for _, col := range append([]string{column}, r.GroupBy()...) {
+		if !hasColumn(tbl, col) {
+			return fmt.Errorf("Redistribute %s.%s: %w", tbl.Name, column, &schema.NotFoundError{Table: tbl.Name, Column: col})
+		}
+	}
+	keyCols := []string{"rowid"}
+	if len(tbl.PrimaryKeys) > 0 {
+		keyCols = quotedCols(tbl.PrimaryKeys)
+	}
+	groupCols := quotedCols(r.GroupBy())
+	query := fmt.Sprintf("SELECT %s, %s, %s FROM %s", strings.Join(keyCols, ", "), strings.Join(groupCols, ", "), schema.QuoteIdent(column), schema.QuoteIdent(tbl.Name))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return &ColumnError{Op: "redistribute", Table: tbl.Name, Column: column, Err: err}
+	}
+	var loaded []transform.RedistributeRow
+	width := len(keyCols) + len(groupCols) + 1
+	for rows.Next() {
+		vals := make([]any, width)
+		ptrs := make([]any, width)
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return &ColumnError{Op: "scan", Table: tbl.Name, Column: column, Err: err}
+		}
+		loaded = append(loaded, transform.RedistributeRow{
+			PK:    vals[:len(keyCols)],
+			Group: vals[len(keyCols) : width-1],
+			Value: vals[width-1],
+		})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return &ColumnError{Op: "iterate", Table: tbl.Name, Column: column, Err: err}
+	}
+	row := transform.RowContext{Table: identityTable(opts.Config, tbl.Name), Column: column, Seed: opts.Seed, Salt: opts.Salt}
+	if err := r.Load(loaded, row); err != nil {
+		return &ColumnError{Table: tbl.Name, Column: column, Err: err}
+	}
+	if opts.Logger != nil {
+		opts.Logger.Debugf("redistribute %s.%s: %d rows loaded", tbl.Name, column, len(loaded))
+	}
+	return nil
+}
+
+// loadResample reads the column in ascending order and keeps up to
+// transform.MaxResampleKnots evenly spaced quantiles, so memory stays
+// bounded however large the table is.
+func loadResample(ctx context.Context, db *sql.DB, tbl *schema.Table, opts Options, column string, r *transform.Resample) error {
+	if !hasColumn(tbl, column) {
+		return fmt.Errorf("Resample: %w", &schema.NotFoundError{Table: tbl.Name, Column: column})
+	}
+	col := schema.QuoteIdent(column)
+	var n int
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(%s) FROM %s", col, schema.QuoteIdent(tbl.Name))).Scan(&n); err != nil {
+		return &ColumnError{Op: "resample", Table: tbl.Name, Column: column, Err: err}
+	}
+	k := min(n, transform.MaxResampleKnots)
+	knots := make([]float64, 0, k)
+	integers := true
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL ORDER BY %s", col, schema.QuoteIdent(tbl.Name), col, col))
+	if err != nil {
+		return &ColumnError{Op: "resample", Table: tbl.Name, Column: column, Err: err}
+	}
+	defer rows.Close()
+	for i := 0; rows.Next() && len(knots) < k; i++ {
+		var v any
+		if err := rows.Scan(&v); err != nil {
+			return &ColumnError{Op: "scan", Table: tbl.Name, Column: column, Err: err}
+		}
+		// The j-th knot is the value at rank round(j*(n-1)/(k-1)).
+		j := len(knots)
+		if k > 1 && i != (j*(n-1)+(k-1)/2)/(k-1) {
+			continue
+		}
+		switch v := v.(type) {
+		case int64:
+			knots = append(knots, float64(v))
+		case float64:
+			knots = append(knots, v)
+			integers = false
+		default:
+			return fmt.Errorf("Resample %s.%s: non-numeric value %v", tbl.Name, column, v)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return &ColumnError{Op: "iterate", Table: tbl.Name, Column: column, Err: err}
+	}
+	r.Load(knots, integers)
+	if opts.Logger != nil {
+		opts.Logger.Debugf("resample %s.%s: %d quantiles of %d values", tbl.Name, column, len(knots), n)
+	}
+	return nil
+}
+
+func hasColumn(tbl *schema.Table, name string) bool {
+	for _, c := range tbl.Columns {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
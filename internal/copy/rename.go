@@ -0,0 +1,95 @@
+package copy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+)
+
+// tableRenames maps each copied table with a rename_to to its output name.
+func tableRenames(s *schema.Schema, cfg *config.Config) map[string]string {
+	out := map[string]string{}
+	for name, tc := range cfg.Tables {
+		if tc == nil || tc.RenameTo == "" || tc.RenameTo == name || s.Tables[name] == nil || !tableIncluded(cfg, name) {
+			continue
+		}
+		out[name] = tc.RenameTo
+	}
+	return out
+}
+
+// validateRenames rejects rename_to targets that two tables share or that
+// name another copied table. SQLite compares names case-insensitively.
+func validateRenames(s *schema.Schema, cfg *config.Config) error {
+	renames := tableRenames(s, cfg)
+	sources := make([]string, 0, len(renames))
+	for name := range renames {
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	targets := map[string]string{}
+	for _, name := range sources {
+		dst := renames[name]
+		key := strings.ToLower(dst)
+		if other, ok := targets[key]; ok {
+			return fmt.Errorf("rename_to: %s and %s are both renamed to %s", other, name, dst)
+		}
+		targets[key] = name
+		for other := range s.Tables {
+			if strings.EqualFold(other, dst) && tableIncluded(cfg, other) {
+				return fmt.Errorf("rename_to: %s cannot be renamed to %s, which is a copied table", name, dst)
+			}
+		}
+		if strings.EqualFold(dst, auditTable(cfg)) && auditEnabled(cfg) {
+			return fmt.Errorf("rename_to: %s cannot be renamed to the audit table %s", name, dst)
+		}
+	}
+	return nil
+}
+
+// renameTables renames the copied tables to their rename_to names once the
+// whole schema exists. SQLite's ALTER TABLE ... RENAME rewrites the foreign
+// keys of other tables and the indexes, triggers, and views that refer to
+// the table, so the copy itself runs under the source names. With reverse,
+// renamed tables left by an earlier incremental run get their source names
+// back first.
+func renameTables(ctx context.Context, outDB *sql.DB, renames map[string]string, reverse bool, opts Options) error {
+	if len(renames) == 0 {
+		return nil
+	}
+	existing, err := existingObjects(ctx, outDB)
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for name := range existing {
+		present[strings.ToLower(name)] = true
+	}
+	sources := make([]string, 0, len(renames))
+	for name := range renames {
+		sources = append(sources, name)
+	}
+	sort.Strings(sources)
+	for _, src := range sources {
+		from, to := src, renames[src]
+		if reverse {
+			from, to = to, from
+		}
+		if !present[strings.ToLower(from)] || present[strings.ToLower(to)] {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s RENAME TO %s", schema.QuoteIdent(from), schema.QuoteIdent(to))
+		if _, err := outDB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("rename table %s to %s: %w", from, to, err)
+		}
+		if opts.Logger != nil {
+			opts.Logger.Infof("rename table %s -> %s", from, to)
+		}
+	}
+	return nil
+}
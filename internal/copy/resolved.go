@@ -0,0 +1,77 @@
+package copy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// resolvedConfig is the config as the copy applies it: table patterns are
+// expanded to the tables actually copied, foreign key columns carry the
+// transforms they inherit from their parents, and by_type is spelled out
+// per column. Transforms whose tags
+// are not selected are left out.
+func resolvedConfig(s *schema.Schema, cfg *config.Config, tags []string) *config.Config {
+	out := &config.Config{Tables: map[string]*config.TableConfig{}, Subset: cfg.Subset, AuditTable: cfg.AuditTable}
+	for name, tbl := range s.Tables {
+		if !tableIncluded(cfg, name) {
+			continue
+		}
+		tc := config.TableConfig{}
+		if src := cfg.Tables[name]; src != nil {
+			tc = *src
+		}
+		columns := map[string]*config.TransformConfig{}
+		for col, tr := range tc.Columns {
+			if tr != nil && !containsString(tc.DropColumns, col) && tagged(tr, tags) {
+				columns[col] = tr
+			}
+		}
+		for col, tr := range inheritedKeyTransforms(s, cfg, tbl) {
+			if _, ok := columns[col]; !ok && tagged(tr, tags) {
+				columns[col] = tr
+			}
+		}
+		for _, c := range tbl.Columns {
+			if _, ok := columns[c.Name]; ok || containsString(tc.DropColumns, c.Name) {
+				continue
+			}
+			if tr := cfg.ColumnTransform(name, c.Name, schema.Affinity(c.Type)); tr != nil && tagged(tr, tags) {
+				columns[c.Name] = tr
+			}
+		}
+		tc.Columns = nil
+		if len(columns) > 0 {
+			tc.Columns = columns
+		}
+		out.Tables[name] = &tc
+	}
+	return out
+}
+
+func dumpConfig(path string, s *schema.Schema, opts Options) error {
+	data, err := yaml.Marshal(resolvedConfig(s, opts.Config, opts.Tags))
+	if err != nil {
+		return fmt.Errorf("encode effective config: %w", err)
+	}
+	header := fmt.Sprintf("# Effective pinkmask config (seed %d, salt %s)\n", opts.Seed, saltState(opts.Salt))
+	if len(opts.Tags) > 0 {
+		header += fmt.Sprintf("# Tags: %s\n", strings.Join(opts.Tags, ", "))
+	}
+	if err := os.WriteFile(path, append([]byte(header), data...), 0o600); err != nil {
+		return fmt.Errorf("write effective config: %w", err)
+	}
+	return nil
+}
+
+// saltState records whether a salt was used without writing it out.
+func saltState(salt string) string {
+	if salt == "" {
+		return "not set"
+	}
+	return "set"
+}
@@ -0,0 +1,80 @@
+package copy
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/transform"
+	"modernc.org/sqlite"
+)
+
+// shuffleFunc orders rows of tables with shuffle_rows by a keyed hash of
+// their primary key. The key is passed as the first argument because the
+// function is registered once for every connection in the process.
+const shuffleFunc = "pinkmask_shuffle"
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction(shuffleFunc, -1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s: expected a key and at least one value", shuffleFunc)
+		}
+		key, _ := args[0].(string)
+		pk := make([]any, len(args)-1)
+		for i, v := range args[1:] {
+			pk[i] = v
+		}
+		return shuffleRank(key, pk), nil
+	})
+}
+
+func shuffleRows(cfg *config.Config, name string) bool {
+	if cfg == nil {
+		return false
+	}
+	tbl := cfg.Tables[name]
+	return tbl != nil && tbl.ShuffleRows
+}
+
+// shuffleKey derives the per-table ordering key from the salt and seed, so
+// the same inputs always produce the same order.
+func shuffleKey(tbl *schema.Table, opts Options) string {
+	sum := transform.RowHash(transform.RowContext{Table: "shuffle:" + identityTable(opts.Config, tbl.Name), Seed: opts.Seed, Salt: opts.Salt})
+	return hex.EncodeToString(sum[:])
+}
+
+func shuffleRank(key string, pk []any) int64 {
+	sum := transform.RowHash(transform.RowContext{Table: key, PK: pk})
+	return int64(binary.BigEndian.Uint64(sum[:8]) >> 1)
+}
+
+// shuffleOrderBy orders by the shuffle rank of the key columns; the key is
+// bound as the single placeholder.
+func shuffleOrderBy(tbl *schema.Table, useRowID bool) string {
+	cols := quotedCols(tbl.PrimaryKeys)
+	if len(cols) == 0 && useRowID {
+		cols = []string{"rowid"}
+	}
+	return fmt.Sprintf("ORDER BY %s(?, %s)", shuffleFunc, strings.Join(cols, ", "))
+}
+
+// shuffleQuery reorders the rows selected by query without changing which
+// rows are selected, so a table limit still picks the first rows by key.
+func shuffleQuery(query string, tbl *schema.Table, useRowID bool) string {
+	return fmt.Sprintf("SELECT * FROM (%s) %s", query, shuffleOrderBy(tbl, useRowID))
+}
+
+func sortShuffled(values [][]any, key string) {
+	ranks := make(map[string]int64, len(values))
+	for _, v := range values {
+		ranks[keyFor(v)] = shuffleRank(key, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return ranks[keyFor(values[i])] < ranks[keyFor(values[j])]
+	})
+}
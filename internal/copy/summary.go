@@ -0,0 +1,74 @@
+package copy
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/transform"
+)
+
+// columnCounts counts the values a masked column's transformer changed and
+// the ones it returned as they were, such as NULLs or values a
+// params.match guard let through.
+type columnCounts struct {
+	table     string
+	column    string
+	transform string
+	changed   atomic.Int64
+	unchanged atomic.Int64
+}
+
+// countingTransformer updates counts for every value it transforms. It
+// is safe for the concurrent use processRowsParallel makes of it.
+type countingTransformer struct {
+	inner  transform.Transformer
+	counts *columnCounts
+}
+
+func (t *countingTransformer) Name() string { return t.inner.Name() }
+
+func (t *countingTransformer) Transform(value any, row transform.RowContext) (any, error) {
+	out, err := t.inner.Transform(value, row)
+	if err != nil {
+		return nil, err
+	}
+	if reflect.DeepEqual(value, out) {
+		t.counts.unchanged.Add(1)
+	} else {
+		t.counts.changed.Add(1)
+	}
+	return out, nil
+}
+
+// maskSummary collects the counts of every masked column of a run, in the
+// order the columns were copied.
+type maskSummary struct {
+	mu      sync.Mutex
+	columns []*columnCounts
+}
+
+// wrap wraps the table's transformers in counting ones.
+func (s *maskSummary) wrap(tableName string, transformers []columnTransformer) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, ct := range transformers {
+		counts := &columnCounts{table: tableName, column: ct.column, transform: ct.tr.Name()}
+		s.columns = append(s.columns, counts)
+		transformers[i].tr = &countingTransformer{inner: ct.tr, counts: counts}
+	}
+}
+
+// log writes one line per masked column.
+func (s *maskSummary) log(logger *log.Logger) {
+	if s == nil || logger == nil {
+		return
+	}
+	for _, c := range s.columns {
+		logger.Infof("masked %s.%s (%s): %d changed, %d unchanged", c.table, c.column, c.transform, c.changed.Load(), c.unchanged.Load())
+	}
+}
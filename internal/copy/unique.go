@@ -0,0 +1,171 @@
+package copy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/schema"
+)
+
+// deferUniqueConstraints rewrites a CREATE TABLE statement without its
+// inline UNIQUE constraints and returns the CREATE UNIQUE INDEX statements
+// that enforce them once the data is loaded, so the load does not maintain
+// their indexes row by row. Constraints with an ON CONFLICT clause, which an
+// index cannot express, and constraints on a column in keep are left in
+// place. taken holds the schema object names in use; the new index names
+// are added to it.
+func deferUniqueConstraints(ddl, table string, keep, taken map[string]bool) (string, []schema.SQLItem, error) {
+	toks := schema.Tokenize(ddl)
+	defs, err := tableDefinitions(ddl, toks)
+	if err != nil {
+		return "", nil, fmt.Errorf("defer unique: %w", err)
+	}
+	word := func(j int) string {
+		if j < len(toks) {
+			return strings.ToUpper(toks[j].Text)
+		}
+		return ""
+	}
+	var cuts []sqlCut
+	var columnLists []string
+	for _, d := range defs {
+		if d.start >= d.end {
+			continue
+		}
+		switch first := word(d.start); first {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			k := d.start
+			if first == "CONSTRAINT" {
+				k += 2
+			}
+			if word(k) != "UNIQUE" || word(k+1) != "(" {
+				continue
+			}
+			closeParen := k + 2
+			for depth := 0; closeParen < d.end; closeParen++ {
+				if toks[closeParen].Text == "(" {
+					depth++
+				} else if toks[closeParen].Text == ")" {
+					if depth == 0 {
+						break
+					}
+					depth--
+				}
+			}
+			if closeParen >= d.end || closeParen == k+2 || word(closeParen+1) == "ON" || d.comma < 0 {
+				continue
+			}
+			if uniqueColumnsKept(toks[k+2:closeParen], keep) {
+				continue
+			}
+			cuts = append(cuts, sqlCut{toks[d.comma].Start, toks[d.end-1].End})
+			columnLists = append(columnLists, ddl[toks[k+2].Start:toks[closeParen-1].End])
+		default:
+			column := schema.IdentName(toks[d.start].Text)
+			if keep[strings.ToLower(column)] {
+				continue
+			}
+			depth := 0
+			for k := d.start + 1; k < d.end; k++ {
+				switch toks[k].Text {
+				case "(":
+					depth++
+					continue
+				case ")":
+					depth--
+					continue
+				}
+				if depth != 0 || word(k) != "UNIQUE" || word(k+1) == "ON" {
+					continue
+				}
+				from := k
+				if k-2 > d.start && word(k-2) == "CONSTRAINT" {
+					from = k - 2
+				}
+				cuts = append(cuts, sqlCut{toks[from].Start, toks[k].End})
+				columnLists = append(columnLists, schema.QuoteIdent(column))
+				break
+			}
+		}
+	}
+	items := make([]schema.SQLItem, 0, len(columnLists))
+	n := 0
+	for _, cols := range columnLists {
+		var name string
+		for {
+			n++
+			name = fmt.Sprintf("%s_unique_%d", table, n)
+			if !taken[strings.ToLower(name)] {
+				break
+			}
+		}
+		taken[strings.ToLower(name)] = true
+		items = append(items, schema.SQLItem{
+			Name:  name,
+			SQL:   fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", schema.QuoteIdent(name), schema.QuoteIdent(table), cols),
+			Type:  "index",
+			Table: table,
+		})
+	}
+	return removeCuts(ddl, cuts), items, nil
+}
+
+// uniqueColumnsKept reports whether an indexed column list of a UNIQUE
+// table constraint names a column in keep.
+func uniqueColumnsKept(list []schema.Token, keep map[string]bool) bool {
+	expectName := true
+	depth := 0
+	for _, t := range list {
+		switch t.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				expectName = true
+				continue
+			}
+		}
+		if expectName && depth == 0 {
+			if keep[strings.ToLower(schema.IdentName(t.Text))] {
+				return true
+			}
+			expectName = false
+		}
+	}
+	return false
+}
+
+// uniqueKeepColumns lists the columns of table whose UNIQUE constraints
+// must stay inline: parent keys of foreign keys, which SQLite resolves
+// while rows are inserted, and columns the config drops, which SQLite
+// refuses to drop while an index covers them.
+func uniqueKeepColumns(s *schema.Schema, opts Options, table string) map[string]bool {
+	keep := map[string]bool{}
+	for _, tbl := range s.Tables {
+		for _, fk := range tbl.ForeignKeys {
+			if strings.EqualFold(fk.Table, table) && fk.To != "" {
+				keep[strings.ToLower(fk.To)] = true
+			}
+		}
+	}
+	for _, col := range droppedColumns(opts.Config, table) {
+		keep[strings.ToLower(col)] = true
+	}
+	return keep
+}
+
+// schemaNames returns the lowercased names of every object in s.
+func schemaNames(s *schema.Schema) map[string]bool {
+	names := map[string]bool{}
+	for name := range s.Tables {
+		names[strings.ToLower(name)] = true
+	}
+	for _, items := range [][]schema.SQLItem{s.Views, s.Indexes, s.Triggers} {
+		for _, item := range items {
+			names[strings.ToLower(item.Name)] = true
+		}
+	}
+	return names
+}
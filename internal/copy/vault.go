@@ -0,0 +1,90 @@
+package copy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/transform"
+	"github.com/dyne/pinkmask/internal/vault"
+)
+
+func vaultColumns(cfg *config.Config, name string) []string {
+	tbl := cfg.Tables[name]
+	if tbl == nil {
+		return nil
+	}
+	return tbl.VaultColumns
+}
+
+func vaultEnabled(cfg *config.Config) bool {
+	for _, tc := range cfg.Tables {
+		if tc != nil && len(tc.VaultColumns) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVault requires vault_columns and --vault to come together, and
+// every vaulted column to be masked.
+func validateVault(opts Options) error {
+	cfg := opts.Config
+	if !vaultEnabled(cfg) {
+		if opts.VaultPath != "" {
+			return fmt.Errorf("--vault given but no table sets vault_columns")
+		}
+		return nil
+	}
+	if opts.VaultPath == "" || len(opts.VaultKey) == 0 {
+		return fmt.Errorf("vault_columns require --vault and --vault-key-file")
+	}
+	names := make([]string, 0, len(cfg.Tables))
+	for name := range cfg.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, col := range vaultColumns(cfg, name) {
+			if cfg.Tables[name].Columns[col] == nil {
+				return fmt.Errorf("vault_columns: %s.%s has no transform", name, col)
+			}
+		}
+	}
+	return nil
+}
+
+// vaultTransformer records the original behind every masked value it
+// produces. NULLs are passed through without an entry.
+type vaultTransformer struct {
+	inner  transform.Transformer
+	table  string
+	column string
+	w      *vault.Writer
+}
+
+func (t *vaultTransformer) Name() string { return t.inner.Name() }
+
+func (t *vaultTransformer) Transform(value any, row transform.RowContext) (any, error) {
+	out, err := t.inner.Transform(value, row)
+	if err != nil || value == nil {
+		return out, err
+	}
+	if err := t.w.Add(t.table, t.column, out, value); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// withVault wraps the transformers of the table's vault columns.
+func withVault(w *vault.Writer, tableName string, cfg *config.Config, transformers []columnTransformer) {
+	cols := vaultColumns(cfg, tableName)
+	if w == nil || len(cols) == 0 {
+		return
+	}
+	for i, ct := range transformers {
+		if containsString(cols, ct.column) {
+			transformers[i].tr = &vaultTransformer{inner: ct.tr, table: tableName, column: ct.column, w: w}
+		}
+	}
+}
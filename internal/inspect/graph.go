@@ -0,0 +1,104 @@
+package inspect
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/schema"
+)
+
+// writeGraph writes the foreign key graph of s in Graphviz DOT format to
+// path, or to stdout when path is "-".
+func writeGraph(path string, s *schema.Schema) error {
+	if path == "-" {
+		return encodeGraph(os.Stdout, s)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create graph: %w", err)
+	}
+	defer file.Close()
+	if err := encodeGraph(file, s); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// encodeGraph draws one node per table and one edge per foreign key, from
+// the referencing table to the referenced one and labeled with the
+// columns. Like schema.TableOrder, it ignores references to tables missing
+// from the schema.
+func encodeGraph(w io.Writer, s *schema.Schema) error {
+	names := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("digraph pinkmask {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\t%s;\n", dotQuote(name))
+	}
+	for _, name := range names {
+		for _, fk := range foreignKeyEdges(s.Tables[name]) {
+			if _, ok := s.Tables[fk.parent]; !ok {
+				continue
+			}
+			fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(name), dotQuote(fk.parent), dotQuote(fk.label()))
+		}
+	}
+	b.WriteString("}\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("write graph: %w", err)
+	}
+	return nil
+}
+
+type fkEdge struct {
+	parent string
+	from   []string
+	to     []string
+}
+
+// label names the columns of the edge, "user_id -> id"; a foreign key to
+// the parent's implicit primary key shows it as such.
+func (e fkEdge) label() string {
+	to := strings.Join(e.to, ", ")
+	if to == "" {
+		to = "(primary key)"
+	}
+	return strings.Join(e.from, ", ") + " -> " + to
+}
+
+// foreignKeyEdges groups the columns of tbl's foreign keys by constraint,
+// in constraint order.
+func foreignKeyEdges(tbl *schema.Table) []fkEdge {
+	byID := map[int]*fkEdge{}
+	var ids []int
+	for _, fk := range tbl.ForeignKeys {
+		e, ok := byID[fk.ID]
+		if !ok {
+			e = &fkEdge{parent: fk.Table}
+			byID[fk.ID] = e
+			ids = append(ids, fk.ID)
+		}
+		e.from = append(e.from, fk.From)
+		if fk.To != "" {
+			e.to = append(e.to, fk.To)
+		}
+	}
+	sort.Ints(ids)
+	edges := make([]fkEdge, 0, len(ids))
+	for _, id := range ids {
+		edges = append(edges, *byID[id])
+	}
+	return edges
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -4,18 +4,43 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/dyne/pinkmask/internal/config"
 	"github.com/dyne/pinkmask/internal/log"
 	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/transform"
 	"gopkg.in/yaml.v3"
 	_ "modernc.org/sqlite"
 )
 
-func Run(ctx context.Context, inPath string, draftPath string, logger *log.Logger) error {
-	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+type Options struct {
+	InPath    string
+	DraftPath string
+	// GraphPath receives the foreign key graph in Graphviz DOT format
+	// ("-" for stdout).
+	GraphPath string
+	// ColumnStats adds distinct counts and null fractions per column,
+	// computed over the first StatsSample rows (all rows when 0). Tables
+	// without a primary key are always sampled to find candidate keys.
+	ColumnStats bool
+	StatsSample int
+	// FKCheck reports rows of the input whose foreign keys reference
+	// missing parents, so output failures can be told apart from a source
+	// that was already inconsistent.
+	FKCheck bool
+	// Config supplies extra pii_keywords and the masked columns checked
+	// against views; it may be nil.
+	Config *config.Config
+	Logger *log.Logger
+}
+
+func Run(ctx context.Context, opts Options) error {
+	db, err := sql.Open("sqlite", schema.DSN(opts.InPath))
 	if err != nil {
 		return fmt.Errorf("open input: %w", err)
 	}
@@ -25,7 +50,12 @@ func Run(ctx context.Context, inPath string, draftPath string, logger *log.Logge
 	if err != nil {
 		return err
 	}
+	rules, err := piiRules(opts.Config)
+	if err != nil {
+		return err
+	}
 
+	detected := map[string][]valueMatch{}
 	fmt.Println("Tables:")
 	order := schema.TableOrder(s)
 	for _, name := range order {
@@ -38,18 +68,116 @@ func Run(ctx context.Context, inPath string, draftPath string, logger *log.Logge
 			return err
 		}
 		fmt.Printf("- %s (%d rows)\n", name, count)
-		pii := piiCandidates(tbl)
+		pii := piiCandidates(tbl, rules)
 		if len(pii) > 0 {
 			fmt.Printf("  PII candidates: %s\n", strings.Join(pii, ", "))
 		}
+		if count > 0 {
+			matches, err := valueColumns(ctx, db, tbl)
+			if err != nil {
+				return err
+			}
+			for _, d := range valueDetectors {
+				var cols []string
+				for _, m := range matches {
+					if m.Category == d.category {
+						cols = append(cols, m.Column)
+					}
+				}
+				if len(cols) > 0 {
+					fmt.Printf("  %s values: %s\n", d.label, strings.Join(cols, ", "))
+				}
+			}
+			detected[name] = matches
+		}
+		keyless := len(tbl.PrimaryKeys) == 0 && count > 0
+		if (opts.ColumnStats || keyless) && len(tbl.Columns) > 0 {
+			stats, err := columnStats(ctx, db, tbl, count, opts.StatsSample)
+			if err != nil {
+				return err
+			}
+			if keys := candidateKeys(stats); keyless && len(keys) > 0 {
+				fmt.Printf("  Candidate keys (no primary key declared): %s\n", strings.Join(keys, ", "))
+			}
+			if opts.ColumnStats {
+				printColumnStats(stats)
+			}
+		}
+	}
+	if opts.Config != nil {
+		if leaks := schema.ViewLeaks(s, opts.Config.Masks); len(leaks) > 0 {
+			fmt.Println("View warnings:")
+			for _, l := range leaks {
+				fmt.Printf("- %s\n", l)
+			}
+		}
+	}
+	if opts.FKCheck {
+		if err := printOrphans(ctx, db); err != nil {
+			return err
+		}
+	}
+	if opts.DraftPath != "" {
+		if err := writeDraftConfig(opts.DraftPath, buildDraftConfig(s, rules, detected), suggestRoots(s)); err != nil {
+			return err
+		}
 	}
-	if draftPath != "" {
-		if err := writeDraftConfig(draftPath, buildDraftConfig(s)); err != nil {
+	if opts.GraphPath != "" {
+		if err := writeGraph(opts.GraphPath, s); err != nil {
 			return err
 		}
 	}
-	if logger != nil {
-		logger.Infof("inspect complete")
+	if opts.Logger != nil {
+		opts.Logger.Infof("inspect complete")
+	}
+	return nil
+}
+
+// maxOrphanRowIDs caps the rowids listed per foreign key by printOrphans.
+const maxOrphanRowIDs = 10
+
+// printOrphans lists, per table and foreign key, the input rows whose
+// parent is missing, with the first few rowids.
+func printOrphans(ctx context.Context, db *sql.DB) error {
+	violations, err := schema.CheckForeignKeys(ctx, db)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		fmt.Println("Orphaned rows: none")
+		return nil
+	}
+	type group struct {
+		table, key string
+		rows       int
+		rowids     []string
+	}
+	var groups []*group
+	byKey := map[string]*group{}
+	for _, v := range violations {
+		id := v.Table + "\x00" + v.Key
+		g := byKey[id]
+		if g == nil {
+			g = &group{table: v.Table, key: v.Key}
+			byKey[id] = g
+			groups = append(groups, g)
+		}
+		g.rows++
+		if v.RowID.Valid && len(g.rowids) < maxOrphanRowIDs {
+			g.rowids = append(g.rowids, fmt.Sprint(v.RowID.Int64))
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].table < groups[j].table })
+	fmt.Printf("Orphaned rows (%d, already in the input):\n", len(violations))
+	for _, g := range groups {
+		line := fmt.Sprintf("- %s: %d row(s) %s", g.table, g.rows, g.key)
+		if len(g.rowids) > 0 {
+			line += ", rowids " + strings.Join(g.rowids, ", ")
+			if g.rows > len(g.rowids) {
+				line += ", ..."
+			}
+		}
+		fmt.Println(line)
 	}
 	return nil
 }
@@ -63,79 +191,279 @@ func rowCount(ctx context.Context, db *sql.DB, table string) (int64, error) {
 	return count, nil
 }
 
-func piiCandidates(tbl *schema.Table) []string {
+type ColumnStat struct {
+	Column   string
+	Distinct int64
+	Nulls    int64
+	Rows     int64
+	// Sampled is set when the table has more rows than were scanned, so
+	// the distinct count is a lower bound.
+	Sampled bool
+}
+
+// columnStats counts distinct and NULL values for every column in one
+// pass over the first sample rows of the table (all rows when sample is 0);
+// total is the table's row count.
+func columnStats(ctx context.Context, db *sql.DB, tbl *schema.Table, total int64, sample int) ([]ColumnStat, error) {
+	exprs := []string{"COUNT(1)"}
+	for _, c := range tbl.Columns {
+		col := schema.QuoteIdent(c.Name)
+		exprs = append(exprs, fmt.Sprintf("COUNT(DISTINCT %s)", col), fmt.Sprintf("COALESCE(SUM(%s IS NULL), 0)", col))
+	}
+	from := schema.QuoteIdent(tbl.Name)
+	if sample > 0 {
+		from = fmt.Sprintf("(SELECT * FROM %s LIMIT %d)", from, sample)
+	}
+	values := make([]int64, len(exprs))
+	targets := make([]any, len(exprs))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), from)
+	if err := db.QueryRowContext(ctx, query).Scan(targets...); err != nil {
+		return nil, fmt.Errorf("column stats %s: %w", tbl.Name, err)
+	}
+	rows := values[0]
+	sampled := rows < total
+	out := make([]ColumnStat, 0, len(tbl.Columns))
+	for i, c := range tbl.Columns {
+		out = append(out, ColumnStat{Column: c.Name, Distinct: values[1+2*i], Nulls: values[2+2*i], Rows: rows, Sampled: sampled})
+	}
+	return out, nil
+}
+
+// valueSample is how many non-NULL values per column are checked when
+// looking for PII by content.
+const valueSample = 100
+
+// valueDetector recognizes values of a PII category regardless of the
+// column name.
+type valueDetector struct {
+	category string
+	label    string
+	match    func(string) bool
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+var valueDetectors = []valueDetector{
+	{category: "iban", label: "IBAN", match: transform.ValidIBAN},
+	{category: "email", label: "Email", match: emailPattern.MatchString},
+}
+
+// valueMatch is a column whose sampled values mostly belong to category:
+// Matched of the Seen sampled values were recognized.
+type valueMatch struct {
+	Column   string
+	Category string
+	Matched  int
+	Seen     int
+}
+
+// valueColumns lists text columns whose sampled values are (at least 90%)
+// recognized by one of the value detectors.
+func valueColumns(ctx context.Context, db *sql.DB, tbl *schema.Table) ([]valueMatch, error) {
+	var out []valueMatch
+	for _, c := range tbl.Columns {
+		if aff := schema.Affinity(c.Type); aff != "TEXT" && aff != "BLOB" {
+			continue
+		}
+		col := schema.QuoteIdent(c.Name)
+		query := fmt.Sprintf("SELECT CAST(%s AS TEXT) FROM %s WHERE %s IS NOT NULL LIMIT %d", col, schema.QuoteIdent(tbl.Name), col, valueSample)
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("sample %s.%s: %w", tbl.Name, c.Name, err)
+		}
+		seen := 0
+		matched := make([]int, len(valueDetectors))
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan %s.%s: %w", tbl.Name, c.Name, err)
+			}
+			seen++
+			for i, d := range valueDetectors {
+				if d.match(v) {
+					matched[i]++
+				}
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("iterate %s.%s: %w", tbl.Name, c.Name, err)
+		}
+		for i, d := range valueDetectors {
+			if seen > 0 && matched[i]*10 >= seen*9 {
+				out = append(out, valueMatch{Column: c.Name, Category: d.category, Matched: matched[i], Seen: seen})
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// candidateKeys lists columns that look like keys: every scanned value is
+// distinct and none is NULL.
+func candidateKeys(stats []ColumnStat) []string {
+	var out []string
+	for _, st := range stats {
+		if st.Rows > 0 && st.Nulls == 0 && st.Distinct == st.Rows {
+			out = append(out, st.Column)
+		}
+	}
+	return out
+}
+
+func printColumnStats(stats []ColumnStat) {
+	fmt.Println("  Columns:")
+	for _, st := range stats {
+		approx := ""
+		if st.Sampled {
+			approx = ">="
+		}
+		nullPct := 0.0
+		if st.Rows > 0 {
+			nullPct = float64(st.Nulls) * 100 / float64(st.Rows)
+		}
+		fmt.Printf("  - %s: %s%d distinct, %.1f%% null\n", st.Column, approx, st.Distinct, nullPct)
+	}
+}
+
+func piiCandidates(tbl *schema.Table, rules []piiRule) []string {
 	var out []string
 	for _, c := range tbl.Columns {
-		name := strings.ToLower(c.Name)
-		if strings.Contains(name, "email") || strings.Contains(name, "name") || strings.Contains(name, "phone") ||
-			strings.Contains(name, "ssn") || strings.Contains(name, "address") || strings.Contains(name, "street") {
+		if r := matchRule(rules, c.Name); r != nil && r.pii {
 			out = append(out, c.Name)
 		}
 	}
 	return out
 }
 
-func buildDraftConfig(s *schema.Schema) map[string]any {
-	tables := map[string]any{}
+// suggestion is a draft transformer for a column and why it was chosen.
+type suggestion struct {
+	transform  *config.TransformConfig
+	confidence string
+}
+
+// buildDraftConfig suggests a transformer for every column matched by name
+// and, failing that, for every column whose sampled values were detected as
+// PII. Results are keyed by table, then column.
+func buildDraftConfig(s *schema.Schema, rules []piiRule, detected map[string][]valueMatch) map[string]map[string]suggestion {
+	tables := map[string]map[string]suggestion{}
 	for _, tbl := range s.Tables {
 		if tbl == nil {
 			continue
 		}
-		columns := map[string]any{}
+		columns := map[string]suggestion{}
 		for _, col := range tbl.Columns {
-			if tr := suggestTransformer(col.Name); tr != nil {
-				columns[col.Name] = minimalTransformConfig(tr)
+			if r := matchRule(rules, col.Name); r != nil {
+				columns[col.Name] = suggestion{transform: r.transform, confidence: fmt.Sprintf("column name suggests %s", r.category)}
+			}
+		}
+		for _, m := range detected[tbl.Name] {
+			if _, ok := columns[m.Column]; ok {
+				continue
+			}
+			columns[m.Column] = suggestion{
+				transform:  categories[m.Category].transform,
+				confidence: fmt.Sprintf("%d of %d sampled values look like %s (%d%%)", m.Matched, m.Seen, m.Category, m.Matched*100/m.Seen),
 			}
 		}
 		if len(columns) > 0 {
-			tables[tbl.Name] = map[string]any{
-				"columns": columns,
-			}
-		}
-	}
-	if len(tables) == 0 {
-		return map[string]any{}
-	}
-	return map[string]any{"tables": tables}
-}
-
-func suggestTransformer(name string) *config.TransformConfig {
-	n := strings.ToLower(name)
-	switch {
-	case strings.Contains(n, "email"):
-		return &config.TransformConfig{Type: "FakerEmail"}
-	case strings.Contains(n, "name"):
-		return &config.TransformConfig{Type: "FakerName"}
-	case strings.Contains(n, "phone"):
-		return &config.TransformConfig{Type: "FakerPhone"}
-	case strings.Contains(n, "ssn"):
-		return &config.TransformConfig{Type: "SetNull"}
-	case strings.Contains(n, "password"), strings.Contains(n, "passwd"), strings.Contains(n, "pwd"):
-		return &config.TransformConfig{Type: "SetValue", Value: "redacted"}
-	case strings.Contains(n, "birth"), strings.Contains(n, "birthday"), strings.Contains(n, "dob"):
-		return &config.TransformConfig{Type: "DateShift", Params: map[string]any{"max_days": 60}}
-	case strings.Contains(n, "createdat"), strings.Contains(n, "updatedat"), strings.Contains(n, "modifiedat"),
-		strings.Contains(n, "created_at"), strings.Contains(n, "updated_at"), strings.Contains(n, "modified_at"),
-		strings.Contains(n, "date"), strings.HasSuffix(n, "_at"), strings.Contains(n, "timestamp"):
-		return &config.TransformConfig{Type: "DateShift", Params: map[string]any{"max_days": 30}}
-	case strings.Contains(n, "address"), strings.Contains(n, "street"):
-		return &config.TransformConfig{Type: "FakerAddress"}
-	default:
-		return nil
+			tables[tbl.Name] = columns
+		}
+	}
+	return tables
+}
+
+// draftNode renders the draft as YAML, with the confidence of each
+// suggestion as a comment on its column.
+func draftNode(draft map[string]map[string]suggestion) (*yaml.Node, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	if len(draft) == 0 {
+		return root, nil
 	}
+	tablesNode := &yaml.Node{Kind: yaml.MappingNode}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "tables"}, tablesNode)
+	for _, table := range sortedKeys(draft) {
+		columnsNode := &yaml.Node{Kind: yaml.MappingNode}
+		tableNode := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "columns"}, columnsNode}}
+		tablesNode.Content = append(tablesNode.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: table}, tableNode)
+		for _, column := range sortedKeys(draft[table]) {
+			sg := draft[table][column]
+			value := &yaml.Node{}
+			if err := value.Encode(minimalTransformConfig(sg.transform)); err != nil {
+				return nil, fmt.Errorf("encode draft %s.%s: %w", table, column, err)
+			}
+			key := &yaml.Node{Kind: yaml.ScalarNode, Value: column, LineComment: "confidence: " + sg.confidence}
+			columnsNode.Content = append(columnsNode.Content, key, value)
+		}
+	}
+	return root, nil
 }
 
-func writeDraftConfig(path string, cfg map[string]any) error {
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// suggestRoots lists tables that look like top-level entities for subset
+// roots: referenced by more tables than they reference themselves, best
+// candidates first.
+func suggestRoots(s *schema.Schema) []string {
+	in := map[string]map[string]bool{}
+	for parent, deps := range schema.Dependents(s) {
+		for _, dep := range deps {
+			if dep == parent {
+				continue
+			}
+			if in[parent] == nil {
+				in[parent] = map[string]bool{}
+			}
+			in[parent][dep] = true
+		}
+	}
+	score := map[string]int{}
+	var roots []string
+	for name, refs := range in {
+		out := map[string]bool{}
+		for _, fk := range s.Tables[name].ForeignKeys {
+			if fk.Table != name {
+				out[fk.Table] = true
+			}
+		}
+		if len(refs) > len(out) {
+			score[name] = len(refs) - len(out)
+			roots = append(roots, name)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if score[roots[i]] != score[roots[j]] {
+			return score[roots[i]] > score[roots[j]]
+		}
+		return roots[i] < roots[j]
+	})
+	if len(roots) > maxSuggestedRoots {
+		roots = roots[:maxSuggestedRoots]
+	}
+	return roots
+}
+
+// maxSuggestedRoots caps the commented-out subset roots in a draft config.
+const maxSuggestedRoots = 3
+
+func writeDraftConfig(path string, draft map[string]map[string]suggestion, roots []string) error {
 	if path == "-" {
 		if _, err := fmt.Fprintln(os.Stdout, "\n# Draft mask config"); err != nil {
 			return fmt.Errorf("write draft header: %w", err)
 		}
-		enc := yaml.NewEncoder(os.Stdout)
-		enc.SetIndent(2)
-		if err := enc.Encode(cfg); err != nil {
-			return fmt.Errorf("encode draft config: %w", err)
-		}
-		return enc.Close()
+		return encodeDraft(os.Stdout, draft, roots)
 	}
 	file, err := os.Create(path)
 	if err != nil {
@@ -145,12 +473,38 @@ func writeDraftConfig(path string, cfg map[string]any) error {
 	if _, err := fmt.Fprintln(file, "# Draft mask config"); err != nil {
 		return fmt.Errorf("write draft header: %w", err)
 	}
-	enc := yaml.NewEncoder(file)
+	return encodeDraft(file, draft, roots)
+}
+
+// encodeDraft writes the draft YAML followed by the suggested subset roots
+// as a commented-out subset block.
+func encodeDraft(w io.Writer, draft map[string]map[string]suggestion, roots []string) error {
+	cfg, err := draftNode(draft)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
 	enc.SetIndent(2)
 	if err := enc.Encode(cfg); err != nil {
 		return fmt.Errorf("encode draft config: %w", err)
 	}
-	return enc.Close()
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("encode draft config: %w", err)
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("\n# Suggested subset roots: tables referenced by many others and referencing few.\n")
+	b.WriteString("# Uncomment and adjust where/limit to sample the database.\n")
+	b.WriteString("# subset:\n#   roots:\n")
+	for _, r := range roots {
+		fmt.Fprintf(&b, "#     - table: %s\n#       limit: 100\n", r)
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("write subset suggestion: %w", err)
+	}
+	return nil
 }
 
 func minimalTransformConfig(tr *config.TransformConfig) map[string]any {
@@ -188,5 +542,14 @@ func minimalTransformConfig(tr *config.TransformConfig) map[string]any {
 	if tr.LookupValue != "" {
 		out["lookup_value"] = tr.LookupValue
 	}
+	if len(tr.Groups) > 0 {
+		groups := map[string]any{}
+		for name, g := range tr.Groups {
+			if g != nil {
+				groups[name] = minimalTransformConfig(g)
+			}
+		}
+		out["groups"] = groups
+	}
 	return out
 }
@@ -0,0 +1,314 @@
+package inspect
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+	"gopkg.in/yaml.v3"
+	_ "modernc.org/sqlite"
+)
+
+func TestColumnStats(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, country TEXT, nickname TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 1; i <= 10; i++ {
+		country := "US"
+		if i%2 == 0 {
+			country = "CA"
+		}
+		var nickname any
+		if i <= 4 {
+			nickname = "n"
+		}
+		if _, err := db.Exec(`INSERT INTO users (id, country, nickname) VALUES (?, ?, ?)`, i, country, nickname); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	stats, err := columnStats(ctx, db, s.Tables["users"], 10, 0)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	want := []ColumnStat{
+		{Column: "id", Distinct: 10, Rows: 10},
+		{Column: "country", Distinct: 2, Rows: 10},
+		{Column: "nickname", Distinct: 1, Nulls: 6, Rows: 10},
+	}
+	for i, w := range want {
+		if stats[i] != w {
+			t.Fatalf("column %s: got %+v, want %+v", w.Column, stats[i], w)
+		}
+	}
+	sampled, err := columnStats(ctx, db, s.Tables["users"], 10, 4)
+	if err != nil {
+		t.Fatalf("sampled stats: %v", err)
+	}
+	if !sampled[0].Sampled || sampled[0].Rows != 4 || sampled[0].Distinct != 4 {
+		t.Fatalf("unexpected sampled stats: %+v", sampled[0])
+	}
+}
+
+func TestCandidateKeys(t *testing.T) {
+	stats := []ColumnStat{
+		{Column: "code", Distinct: 5, Rows: 5},
+		{Column: "email", Distinct: 4, Nulls: 1, Rows: 5},
+		{Column: "status", Distinct: 2, Rows: 5},
+	}
+	keys := candidateKeys(stats)
+	if len(keys) != 1 || keys[0] != "code" {
+		t.Fatalf("unexpected candidate keys: %v", keys)
+	}
+}
+
+func TestPIIKeywords(t *testing.T) {
+	cfg := &config.Config{PIIKeywords: map[string]string{"codicefiscale": "ssn", "iban": "password"}}
+	rules, err := piiRules(cfg)
+	if err != nil {
+		t.Fatalf("rules: %v", err)
+	}
+	tbl := &schema.Table{Columns: []schema.Column{{Name: "CodiceFiscale"}, {Name: "iban"}, {Name: "email"}, {Name: "created_at"}, {Name: "status"}}}
+	got := piiCandidates(tbl, rules)
+	if strings.Join(got, ",") != "CodiceFiscale,iban,email" {
+		t.Fatalf("unexpected candidates: %v", got)
+	}
+	if r := matchRule(rules, "codicefiscale"); r == nil || r.transform.Type != "SetNull" {
+		t.Fatalf("unexpected suggestion: %+v", r)
+	}
+	if r := matchRule(rules, "created_at"); r == nil || r.transform.Type != "DateShift" {
+		t.Fatalf("built-in rules lost: %+v", r)
+	}
+	if _, err := piiRules(&config.Config{PIIKeywords: map[string]string{"x": "bogus"}}); err == nil {
+		t.Fatalf("expected error for unknown category")
+	}
+}
+
+func TestValueColumns(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE accounts (id INTEGER PRIMARY KEY, account TEXT, note TEXT, contact TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO accounts (account, note, contact) VALUES ('DE89 3704 0044 0532 0130 00', 'DE89370400440532013001', 'ann@example.com'), ('GB29NWBK60161331926819', 'hello', 'bob@mail.test')`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	got, err := valueColumns(ctx, db, s.Tables["accounts"])
+	if err != nil {
+		t.Fatalf("value columns: %v", err)
+	}
+	want := []valueMatch{
+		{Column: "account", Category: "iban", Matched: 2, Seen: 2},
+		{Column: "contact", Category: "email", Matched: 2, Seen: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected value columns: %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("value column %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDraftConfig(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	inPath := filepath.Join(tmp, "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, status TEXT, contact TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (email, status, contact) VALUES ('a@example.com', 'active', 'ann@mail.test')`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	db.Close()
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	os.Stdout = w
+	captured := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		captured <- string(b)
+	}()
+	draftPath := filepath.Join(tmp, "mask.draft.yml")
+	err = Run(ctx, Options{InPath: inPath, DraftPath: draftPath})
+	if err == nil {
+		err = Run(ctx, Options{InPath: inPath, DraftPath: "-"})
+	}
+	os.Stdout = stdout
+	w.Close()
+	out := <-captured
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	idx := strings.Index(out, "# Draft mask config")
+	if idx < 0 {
+		t.Fatalf("no draft config on stdout: %q", out)
+	}
+	data, err := os.ReadFile(draftPath)
+	if err != nil {
+		t.Fatalf("read draft: %v", err)
+	}
+	for name, text := range map[string]string{"file": string(data), "stdout": out[idx:]} {
+		var cfg config.Config
+		if err := yaml.Unmarshal([]byte(text), &cfg); err != nil {
+			t.Fatalf("%s: parse draft: %v", name, err)
+		}
+		cols := cfg.Tables["users"].Columns
+		if len(cols) != 2 || cols["email"] == nil || cols["email"].Type != "FakerEmail" || cols["contact"] == nil || cols["contact"].Type != "FakerEmail" {
+			t.Fatalf("%s: unexpected draft columns: %+v", name, cols)
+		}
+		if !strings.Contains(text, "contact: # confidence: 1 of 1 sampled values look like email (100%)") || !strings.Contains(text, "email: # confidence: column name suggests email") {
+			t.Fatalf("%s: missing confidence comments:\n%s", name, text)
+		}
+	}
+}
+
+func TestSuggestRoots(t *testing.T) {
+	fk := func(tables ...string) []schema.ForeignKey {
+		var out []schema.ForeignKey
+		for _, tbl := range tables {
+			out = append(out, schema.ForeignKey{Table: tbl})
+		}
+		return out
+	}
+	s := &schema.Schema{Tables: map[string]*schema.Table{
+		"users":       {Name: "users"},
+		"products":    {Name: "products"},
+		"orders":      {Name: "orders", ForeignKeys: fk("users")},
+		"order_items": {Name: "order_items", ForeignKeys: fk("orders", "products")},
+		"reviews":     {Name: "reviews", ForeignKeys: fk("users", "products")},
+		"employees":   {Name: "employees", ForeignKeys: fk("employees")},
+	}}
+	roots := suggestRoots(s)
+	if strings.Join(roots, ",") != "products,users" {
+		t.Fatalf("unexpected roots: %v", roots)
+	}
+	var b strings.Builder
+	if err := encodeDraft(&b, nil, roots); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !strings.Contains(b.String(), "# subset:\n#   roots:\n#     - table: products\n#       limit: 100\n#     - table: users\n") {
+		t.Fatalf("missing subset suggestion:\n%s", b.String())
+	}
+}
+
+func TestGraph(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, manager_id INTEGER REFERENCES users(id))`,
+		`CREATE TABLE "order items" (order_id INTEGER, line INTEGER, PRIMARY KEY (order_id, line))`,
+		`CREATE TABLE refunds (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users, order_id INTEGER, line INTEGER,
+			FOREIGN KEY (order_id, line) REFERENCES "order items" (order_id, line))`,
+		`CREATE TABLE notes (id INTEGER PRIMARY KEY, ghost_id INTEGER REFERENCES ghosts(id))`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	var out strings.Builder
+	if err := encodeGraph(&out, s); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	want := `digraph pinkmask {
+	rankdir=LR;
+	node [shape=box];
+	"notes";
+	"order items";
+	"refunds";
+	"users";
+	"refunds" -> "order items" [label="order_id, line -> order_id, line"];
+	"refunds" -> "users" [label="user_id -> (primary key)"];
+	"users" -> "users" [label="manager_id -> id"];
+}
+`
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestFKCheckReportsOrphans(t *testing.T) {
+	ctx := context.Background()
+	inPath := filepath.Join(t.TempDir(), "in.sqlite")
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id))`,
+		`INSERT INTO users (id) VALUES (1)`,
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 12) INSERT INTO orders SELECT i, CASE WHEN i = 1 THEN 1 ELSE 100 + i END FROM n`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	db.Close()
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	os.Stdout = w
+	captured := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		captured <- string(b)
+	}()
+	err = Run(ctx, Options{InPath: inPath, FKCheck: true})
+	os.Stdout = stdout
+	w.Close()
+	out := <-captured
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "Orphaned rows (11, already in the input):\n- orders: 11 row(s) (user_id) -> users(id), rowids 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, ...\n"
+	if !strings.Contains(out, want) {
+		t.Fatalf("orphans not reported:\n%s", out)
+	}
+}
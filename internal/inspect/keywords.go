@@ -0,0 +1,105 @@
+package inspect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+)
+
+// piiRule maps a column name pattern to a PII category and the transformer
+// suggested for it in draft configs. Rules are tried in order and the first
+// match wins.
+type piiRule struct {
+	category string
+	contains []string
+	suffixes []string
+	// pii marks categories reported as PII candidates; the others (dates)
+	// only get a draft transformer.
+	pii       bool
+	transform *config.TransformConfig
+}
+
+var categories = map[string]piiRule{
+	"email":     {pii: true, transform: &config.TransformConfig{Type: "FakerEmail"}},
+	"name":      {pii: true, transform: &config.TransformConfig{Type: "FakerName"}},
+	"phone":     {pii: true, transform: &config.TransformConfig{Type: "FakerPhone"}},
+	"ssn":       {pii: true, transform: &config.TransformConfig{Type: "SetNull"}},
+	"password":  {pii: true, transform: &config.TransformConfig{Type: "SetValue", Value: "redacted"}},
+	"birthdate": {pii: true, transform: &config.TransformConfig{Type: "DateShift", Params: map[string]any{"max_days": 60}}},
+	"date":      {transform: &config.TransformConfig{Type: "DateShift", Params: map[string]any{"max_days": 30}}},
+	"address":   {pii: true, transform: &config.TransformConfig{Type: "FakerAddress"}},
+	"iban":      {pii: true, transform: &config.TransformConfig{Type: "FakerIBAN"}},
+}
+
+var builtinRules = []piiRule{
+	{category: "email", contains: []string{"email"}},
+	{category: "name", contains: []string{"name"}},
+	{category: "phone", contains: []string{"phone"}},
+	{category: "ssn", contains: []string{"ssn"}},
+	{category: "password", contains: []string{"password", "passwd", "pwd"}},
+	{category: "birthdate", contains: []string{"birth", "dob"}},
+	{category: "date", contains: []string{"createdat", "updatedat", "modifiedat", "created_at", "updated_at", "modified_at", "date", "timestamp"}, suffixes: []string{"_at"}},
+	{category: "address", contains: []string{"address", "street"}},
+	{category: "iban", contains: []string{"iban"}},
+}
+
+// piiRules returns the user's pii_keywords followed by the built-in rules,
+// so user entries take precedence. Longer keywords are tried first so that
+// "codicefiscale" wins over a shorter keyword it contains.
+func piiRules(cfg *config.Config) ([]piiRule, error) {
+	var keywords []string
+	if cfg != nil {
+		for kw := range cfg.PIIKeywords {
+			keywords = append(keywords, kw)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if len(keywords[i]) != len(keywords[j]) {
+			return len(keywords[i]) > len(keywords[j])
+		}
+		return keywords[i] < keywords[j]
+	})
+	rules := make([]piiRule, 0, len(keywords)+len(builtinRules))
+	for _, kw := range keywords {
+		category := strings.ToLower(cfg.PIIKeywords[kw])
+		if _, ok := categories[category]; !ok {
+			return nil, fmt.Errorf("pii_keywords: %s: unknown category %q (expected one of %s)", kw, cfg.PIIKeywords[kw], strings.Join(categoryNames(), ", "))
+		}
+		rules = append(rules, piiRule{category: category, contains: []string{strings.ToLower(kw)}})
+	}
+	rules = append(rules, builtinRules...)
+	for i := range rules {
+		c := categories[rules[i].category]
+		rules[i].pii = c.pii
+		rules[i].transform = c.transform
+	}
+	return rules, nil
+}
+
+func matchRule(rules []piiRule, column string) *piiRule {
+	n := strings.ToLower(column)
+	for i := range rules {
+		for _, kw := range rules[i].contains {
+			if strings.Contains(n, kw) {
+				return &rules[i]
+			}
+		}
+		for _, sfx := range rules[i].suffixes {
+			if strings.HasSuffix(n, sfx) {
+				return &rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+func categoryNames() []string {
+	names := make([]string, 0, len(categories))
+	for name := range categories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,120 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/transform"
+)
+
+const dummySalt = "lint"
+
+type Problem struct {
+	Table   string
+	Column  string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Column == "" {
+		return fmt.Sprintf("%s: %s", p.Table, p.Message)
+	}
+	return fmt.Sprintf("%s.%s: %s", p.Table, p.Column, p.Message)
+}
+
+func Run(cfg *config.Config, logger *log.Logger) error {
+	problems := Check(cfg)
+	fmt.Println("Lint:")
+	if len(problems) == 0 {
+		fmt.Println("  no problems found")
+	}
+	for _, p := range problems {
+		fmt.Printf("- %s\n", p)
+	}
+	if logger != nil {
+		logger.Infof("lint complete")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("lint: %d problem(s) found", len(problems))
+	}
+	return nil
+}
+
+func Check(cfg *config.Config) []Problem {
+	if cfg == nil {
+		return nil
+	}
+	var problems []Problem
+	tables := make([]string, 0, len(cfg.Tables))
+	for name := range cfg.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		tbl := cfg.Tables[name]
+		if tbl == nil {
+			continue
+		}
+		cols := make([]string, 0, len(tbl.Columns))
+		for c := range tbl.Columns {
+			cols = append(cols, c)
+		}
+		sort.Strings(cols)
+		for _, col := range cols {
+			for _, msg := range checkTransform(tbl.Columns[col]) {
+				problems = append(problems, Problem{Table: name, Column: col, Message: msg})
+			}
+		}
+		for _, ac := range tbl.AddColumns {
+			if ac.Name == "" {
+				problems = append(problems, Problem{Table: name, Message: "add_columns entry without a name"})
+				continue
+			}
+			for _, msg := range checkTransform(ac.Transform) {
+				problems = append(problems, Problem{Table: name, Column: ac.Name, Message: msg})
+			}
+		}
+	}
+	affinities := make([]string, 0, len(cfg.ByType))
+	for a := range cfg.ByType {
+		affinities = append(affinities, a)
+	}
+	sort.Strings(affinities)
+	for _, a := range affinities {
+		for _, msg := range checkTransform(cfg.ByType[a]) {
+			problems = append(problems, Problem{Table: "by_type", Column: a, Message: msg})
+		}
+	}
+	return problems
+}
+
+func checkTransform(tc *config.TransformConfig) []string {
+	if tc == nil {
+		return nil
+	}
+	var msgs []string
+	if tc.Type == "" {
+		msgs = append(msgs, "missing transformer type")
+	}
+	if tc.MaxLen < 0 {
+		msgs = append(msgs, fmt.Sprintf("maxlen must not be negative: %d", tc.MaxLen))
+	}
+	if tc.LookupTable != "" && (tc.LookupKey == "" || tc.LookupValue == "") {
+		msgs = append(msgs, "lookup_table requires lookup_key and lookup_value")
+	}
+	switch strings.ToLower(tc.Type) {
+	case "":
+		return msgs
+	case "map":
+		if len(tc.Map) == 0 && tc.LookupTable == "" {
+			msgs = append(msgs, "Map requires map or lookup_table")
+		}
+	}
+	if _, err := transform.Build(tc, dummySalt); err != nil {
+		msgs = append(msgs, err.Error())
+	}
+	return msgs
+}
@@ -0,0 +1,39 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/dyne/pinkmask/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"users": {
+				Columns: map[string]*config.TransformConfig{
+					"email":  {Type: "HmacSha256"},
+					"name":   {Type: "FakerNmae"},
+					"note":   {Type: "RegexReplace", Pattern: "[0-9"},
+					"joined": {Type: "DateShift", Params: map[string]any{"max_days": "ten"}},
+					// YAML reads 30.0 as a float, which the factory accepts.
+					"seen": {Type: "DateShift", Params: map[string]any{"max_days": 30.0}},
+				},
+			},
+		},
+	}
+	problems := Check(cfg)
+	got := map[string]bool{}
+	for _, p := range problems {
+		got[p.Column] = true
+	}
+	for _, col := range []string{"name", "note", "joined"} {
+		if !got[col] {
+			t.Fatalf("expected a problem for %s, got %v", col, problems)
+		}
+	}
+	for _, col := range []string{"email", "seen"} {
+		if got[col] {
+			t.Fatalf("unexpected problem for %s: %v", col, problems)
+		}
+	}
+}
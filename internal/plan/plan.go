@@ -5,19 +5,28 @@ import (
 	"database/sql"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/dyne/pinkmask/internal/config"
 	"github.com/dyne/pinkmask/internal/log"
 	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/subset"
 	"github.com/dyne/pinkmask/internal/transform"
 	_ "modernc.org/sqlite"
 )
 
-func Run(ctx context.Context, inPath string, cfg *config.Config, logger *log.Logger) error {
+// Run prints the transformation plan. Transforms registered by a plugin
+// are annotated with the plugin's file, and plugins that replace a
+// built-in are listed as warnings. Transforms whose output would not
+// fit the column's declared type affinity are flagged; with strictTypes
+// they also make Run fail. With showSubset it also selects the config's
+// subset, without copying anything, and prints how many keys of each table
+// it selects.
+func Run(ctx context.Context, inPath string, cfg *config.Config, strictTypes, showSubset bool, logger *log.Logger) error {
 	if cfg == nil {
 		cfg = &config.Config{}
 	}
-	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_busy_timeout=5000", inPath))
+	db, err := sql.Open("sqlite", schema.DSN(inPath))
 	if err != nil {
 		return fmt.Errorf("open input: %w", err)
 	}
@@ -29,40 +38,158 @@ func Run(ctx context.Context, inPath string, cfg *config.Config, logger *log.Log
 	}
 
 	order := schema.TableOrder(s)
+	var mismatches []string
 	fmt.Println("Plan:")
 	for _, name := range order {
 		if !tableIncluded(cfg, name) {
 			continue
 		}
-		tbl := cfg.Tables[name]
+		columns := map[string]*config.TransformConfig{}
+		if tbl := cfg.Tables[name]; tbl != nil {
+			for c, tc := range tbl.Columns {
+				columns[c] = tc
+			}
+		}
+		byType := map[string]bool{}
+		for _, c := range s.Tables[name].Columns {
+			if _, ok := columns[c.Name]; ok {
+				continue
+			}
+			if tc := cfg.ColumnTransform(name, c.Name, schema.Affinity(c.Type)); tc != nil {
+				columns[c.Name] = tc
+				byType[c.Name] = true
+			}
+		}
 		fmt.Printf("- %s\n", name)
-		if tbl == nil || len(tbl.Columns) == 0 {
+		if len(columns) == 0 {
 			fmt.Println("  (no transforms)")
 			continue
 		}
-		cols := make([]string, 0, len(tbl.Columns))
-		for c := range tbl.Columns {
+		cols := make([]string, 0, len(columns))
+		for c := range columns {
 			cols = append(cols, c)
 		}
 		sort.Strings(cols)
 		for _, c := range cols {
-			tr, err := transform.Build(tbl.Columns[c], "")
+			if columns[c] == nil {
+				fmt.Printf("  - %s: (no transform)\n", c)
+				continue
+			}
+			col := findColumn(s.Tables[name], c)
+			var colType string
+			if col != nil {
+				colType = col.Type
+			}
+			tr, err := transform.BuildForColumn(columns[c], "", colType)
 			if err != nil {
 				return err
 			}
-			name := "unknown"
+			trName := "unknown"
 			if tr != nil {
-				name = tr.Name()
+				trName = tr.Name()
+			}
+			line := fmt.Sprintf("  - %s: %s", c, trName)
+			if path := transform.PluginPath(columns[c].Type); path != "" {
+				line += fmt.Sprintf(" [plugin: %s]", path)
+			}
+			if byType[c] {
+				line += " (by_type)"
+			}
+			if col != nil {
+				out := transform.OutputTypeForColumn(columns[c], col.Type)
+				if want := schema.Affinity(col.Type); schema.TypeChanges(out, want) {
+					note := ""
+					if s.Tables[name].Strict {
+						note = ", rejected by STRICT table"
+					}
+					line += fmt.Sprintf(" (type change: %s output into %s column%s)", out, want, note)
+					mismatches = append(mismatches, name+"."+c)
+				}
 			}
-			fmt.Printf("  - %s: %s\n", c, name)
+			fmt.Println(line)
 		}
 	}
+	for _, name := range s.Skipped {
+		if tableIncluded(cfg, name) {
+			fmt.Printf("- %s\n  (skipped: no CREATE statement)\n", name)
+		}
+	}
+	if leaks := schema.ViewLeaks(s, cfg.Masks); len(leaks) > 0 {
+		fmt.Println("View warnings:")
+		for _, l := range leaks {
+			fmt.Printf("- %s\n", l)
+		}
+	}
+	if overrides := transform.Overrides(); len(overrides) > 0 {
+		fmt.Println("Plugin warnings:")
+		for _, info := range overrides {
+			from := info.Path
+			if from == "" {
+				from = "a Register call"
+			}
+			fmt.Printf("- %s from %s replaces the built-in transformer of the same name\n", info.Name, from)
+		}
+	}
+	if showSubset {
+		if err := printSubset(ctx, db, s, order, cfg); err != nil {
+			return err
+		}
+	}
+	if strictTypes && len(mismatches) > 0 {
+		return fmt.Errorf("transforms change column types: %s", strings.Join(mismatches, ", "))
+	}
 	if logger != nil {
 		logger.Infof("plan complete")
 	}
 	return nil
 }
 
+// printSubset prints how many keys the subset selects from each copied
+// table next to the table's row count. The keys are those of the
+// selection, not a count of copied rows: an explicit roots[].keys entry is
+// counted even when no row has it.
+func printSubset(ctx context.Context, db *sql.DB, s *schema.Schema, order []string, cfg *config.Config) error {
+	if cfg.Subset == nil || len(cfg.Subset.Roots) == 0 {
+		return fmt.Errorf("--subset: the config has no subset roots")
+	}
+	selection, err := subset.BuildSelection(ctx, db, s, cfg, 0, 1)
+	if err != nil {
+		return err
+	}
+	var selected, total int64
+	fmt.Println("Subset:")
+	for _, name := range order {
+		if !tableIncluded(cfg, name) {
+			continue
+		}
+		var n int64
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+schema.QuoteIdent(name)).Scan(&n); err != nil {
+			return fmt.Errorf("count %s: %w", name, err)
+		}
+		var picked int64
+		if set := selection.Sets[name]; set != nil {
+			picked = int64(set.Len())
+		}
+		fmt.Printf("- %s: %d key(s) selected, %d row(s) in the table\n", name, picked, n)
+		selected += picked
+		total += n
+	}
+	fmt.Printf("Total: %d key(s) selected, %d row(s)\n", selected, total)
+	return nil
+}
+
+func findColumn(tbl *schema.Table, name string) *schema.Column {
+	if tbl == nil {
+		return nil
+	}
+	for i := range tbl.Columns {
+		if strings.EqualFold(tbl.Columns[i].Name, name) {
+			return &tbl.Columns[i]
+		}
+	}
+	return nil
+}
+
 func tableIncluded(cfg *config.Config, name string) bool {
 	if cfg == nil {
 		return true
@@ -8,10 +8,12 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/dyne/pinkmask/internal/config"
 	"github.com/dyne/pinkmask/internal/log"
+	"github.com/dyne/pinkmask/internal/transform"
 	_ "modernc.org/sqlite"
 )
 
@@ -33,7 +35,7 @@ func TestPlanOutput(t *testing.T) {
 		},
 	}
 	out := captureStdout(func() error {
-		return Run(ctx, inPath, cfg, log.New(log.LevelInfo, io.Discard))
+		return Run(ctx, inPath, cfg, false, false, log.New(log.LevelInfo, io.Discard))
 	})
 	goldenPath := filepath.Join("testdata", "plan_golden.txt")
 	golden, err := os.ReadFile(goldenPath)
@@ -45,6 +47,41 @@ func TestPlanOutput(t *testing.T) {
 	}
 }
 
+func TestPlanStrictTypes(t *testing.T) {
+	ctx := context.Background()
+	inPath := filepath.Join(t.TempDir(), "plan.sqlite")
+	if err := createPlanDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{
+			"orders": {
+				Columns: map[string]*config.TransformConfig{
+					"user_id": {Type: "HashSha256"},
+				},
+			},
+		},
+	}
+	var err error
+	out := captureStdout(func() error {
+		err = Run(ctx, inPath, cfg, false, false, log.New(log.LevelInfo, io.Discard))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(out, "user_id: HashSha256 (type change: TEXT output into INTEGER column)") {
+		t.Fatalf("type change not reported:\n%s", out)
+	}
+	captureStdout(func() error {
+		err = Run(ctx, inPath, cfg, true, false, log.New(log.LevelInfo, io.Discard))
+		return err
+	})
+	if err == nil {
+		t.Fatalf("expected strict types to fail")
+	}
+}
+
 func captureStdout(fn func() error) string {
 	old := os.Stdout
 	r, w, _ := os.Pipe()
@@ -75,3 +112,103 @@ func createPlanDB(path string) error {
 	}
 	return nil
 }
+
+func TestPlanSubset(t *testing.T) {
+	ctx := context.Background()
+	inPath := filepath.Join(t.TempDir(), "plan.sqlite")
+	if err := createPlanDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	stmts := []string{
+		`INSERT INTO users (id) VALUES (1), (2), (3)`,
+		`INSERT INTO orders (id, user_id) VALUES (10, 1), (11, 1), (12, 2), (13, 3)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	db.Close()
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "users", Where: "id = 1"}},
+	}}
+	var runErr error
+	out := captureStdout(func() error {
+		runErr = Run(ctx, inPath, cfg, false, true, log.New(log.LevelInfo, io.Discard))
+		return runErr
+	})
+	if runErr != nil {
+		t.Fatalf("run: %v", runErr)
+	}
+	want := "Subset:\n- users: 1 key(s) selected, 3 row(s) in the table\n- orders: 2 key(s) selected, 4 row(s) in the table\nTotal: 3 key(s) selected, 7 row(s)\n"
+	if !strings.Contains(out, want) {
+		t.Fatalf("subset sizes not reported:\n%s", out)
+	}
+
+	captureStdout(func() error {
+		runErr = Run(ctx, inPath, &config.Config{}, false, true, log.New(log.LevelInfo, io.Discard))
+		return runErr
+	})
+	if runErr == nil {
+		t.Fatalf("expected an error without subset roots")
+	}
+}
+
+func TestPlanViewWarnings(t *testing.T) {
+	ctx := context.Background()
+	inPath := filepath.Join(t.TempDir(), "plan.sqlite")
+	if err := createPlanDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	db, err := sql.Open("sqlite", inPath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE VIEW contacts AS SELECT u.email AS contact, substr(u.email, instr(u.email, '@') + 1) AS domain, full_name FROM users u`); err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+	db.Close()
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {Columns: map[string]*config.TransformConfig{"email": {Type: "HmacSha256"}}},
+	}}
+	out := captureStdout(func() error {
+		return Run(ctx, inPath, cfg, false, false, log.New(log.LevelInfo, io.Discard))
+	})
+	want := "View warnings:\n- contacts.domain: computed from masked users.email; review the view, it may leak\n"
+	if !strings.Contains(out, want) || strings.Contains(out, "contacts.contact") {
+		t.Fatalf("unexpected view warnings:\n%s", out)
+	}
+}
+
+func TestPlanPluginSource(t *testing.T) {
+	ctx := context.Background()
+	inPath := filepath.Join(t.TempDir(), "plan.sqlite")
+	if err := createPlanDB(inPath); err != nil {
+		t.Fatalf("create db: %v", err)
+	}
+	transform.RegisterWithInfo(transform.Info{Name: "PlanRot13", Source: "plugin", Path: "./rot13.so"}, func(cfg *config.TransformConfig, salt string) (transform.Transformer, error) {
+		return rot13{}, nil
+	})
+	cfg := &config.Config{Tables: map[string]*config.TableConfig{
+		"users": {Columns: map[string]*config.TransformConfig{
+			"email":     {Type: "PlanRot13"},
+			"full_name": {Type: "FakerName"},
+		}},
+	}}
+	out := captureStdout(func() error {
+		return Run(ctx, inPath, cfg, false, false, log.New(log.LevelInfo, io.Discard))
+	})
+	if !strings.Contains(out, "  - email: PlanRot13 [plugin: ./rot13.so]\n") || strings.Contains(out, "full_name: FakerName [") {
+		t.Fatalf("plugin source not reported:\n%s", out)
+	}
+}
+
+type rot13 struct{}
+
+func (rot13) Name() string { return "PlanRot13" }
+
+func (rot13) Transform(value any, row transform.RowContext) (any, error) { return value, nil }
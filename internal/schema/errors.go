@@ -0,0 +1,31 @@
+package schema
+
+import "errors"
+
+var (
+	// ErrMissingTable matches a NotFoundError for a table.
+	ErrMissingTable = errors.New("table not found")
+	// ErrMissingColumn matches a NotFoundError for a column.
+	ErrMissingColumn = errors.New("column not found")
+)
+
+// NotFoundError reports a table, or a column of one when Column is set,
+// that a config or option names but the schema does not have.
+type NotFoundError struct {
+	Table  string
+	Column string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Column == "" {
+		return ErrMissingTable.Error() + ": " + e.Table
+	}
+	return ErrMissingColumn.Error() + ": " + e.Table + "." + e.Column
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	if e.Column == "" {
+		return target == ErrMissingTable
+	}
+	return target == ErrMissingColumn
+}
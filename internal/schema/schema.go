@@ -14,12 +14,17 @@ type Schema struct {
 	Views    []SQLItem
 	Indexes  []SQLItem
 	Triggers []SQLItem
+	// Skipped lists the tables sqlite_master has no CREATE statement for.
+	// They cannot be recreated, so they are left out of Tables.
+	Skipped []string
 }
 
 type SQLItem struct {
 	Name string
 	SQL  string
 	Type string
+	// Table is the table or view an index or trigger belongs to.
+	Table string
 }
 
 type Table struct {
@@ -29,6 +34,7 @@ type Table struct {
 	PrimaryKeys  []string
 	ForeignKeys  []ForeignKey
 	WithoutRowID bool
+	Strict       bool
 }
 
 type Column struct {
@@ -51,33 +57,43 @@ type ForeignKey struct {
 
 func Load(ctx context.Context, db *sql.DB) (*Schema, error) {
 	s := &Schema{Tables: map[string]*Table{}}
-	rows, err := db.QueryContext(ctx, `SELECT name, type, sql FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY name`)
+	rows, err := db.QueryContext(ctx, `SELECT name, type, tbl_name, sql FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY name`)
 	if err != nil {
 		return nil, fmt.Errorf("sqlite_master: %w", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var name, typ string
+		var name, typ, tblName string
 		var sqlText sql.NullString
-		if err := rows.Scan(&name, &typ, &sqlText); err != nil {
+		if err := rows.Scan(&name, &typ, &tblName, &sqlText); err != nil {
 			return nil, fmt.Errorf("scan sqlite_master: %w", err)
 		}
-		item := SQLItem{Name: name, SQL: sqlText.String, Type: typ}
+		if !sqlText.Valid {
+			// Objects SQLite creates itself have no SQL. A table like that
+			// is noted so callers can report it; anything else is dropped.
+			if typ == "table" {
+				s.Skipped = append(s.Skipped, name)
+			}
+			continue
+		}
+		item := SQLItem{Name: name, SQL: sqlText.String, Type: typ, Table: tblName}
 		switch typ {
 		case "table":
-			if !sqlText.Valid {
-				continue
-			}
 			tbl := &Table{Name: name, SQL: sqlText.String}
 			tbl.WithoutRowID = strings.Contains(strings.ToUpper(sqlText.String), "WITHOUT ROWID")
 			cols, pkCols, err := loadTableInfo(ctx, db, name)
 			if err != nil {
 				return nil, err
 			}
-			fks, err := loadForeignKeys(ctx, db, name)
+			fks, err := LoadForeignKeys(ctx, db, name)
 			if err != nil {
 				return nil, err
 			}
+			strict, err := tableStrict(ctx, db, name)
+			if err != nil {
+				return nil, err
+			}
+			tbl.Strict = strict
 			tbl.Columns = cols
 			tbl.PrimaryKeys = pkCols
 			tbl.ForeignKeys = fks
@@ -139,7 +155,30 @@ func loadTableInfo(ctx context.Context, db *sql.DB, table string) ([]Column, []s
 	return cols, pkCols, nil
 }
 
-func loadForeignKeys(ctx context.Context, db *sql.DB, table string) ([]ForeignKey, error) {
+func tableStrict(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA main.table_list(%s)", QuoteIdent(table)))
+	if err != nil {
+		return false, fmt.Errorf("table_list %s: %w", table, err)
+	}
+	defer rows.Close()
+	var strict bool
+	for rows.Next() {
+		var schemaName, name, typ string
+		var ncol, wr, st int
+		if err := rows.Scan(&schemaName, &name, &typ, &ncol, &wr, &st); err != nil {
+			return false, fmt.Errorf("scan table_list %s: %w", table, err)
+		}
+		strict = st == 1
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("iterate table_list %s: %w", table, err)
+	}
+	return strict, nil
+}
+
+// LoadForeignKeys lists the foreign key columns of table, one entry per
+// column, as PRAGMA foreign_key_list reports them.
+func LoadForeignKeys(ctx context.Context, db *sql.DB, table string) ([]ForeignKey, error) {
 	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA foreign_key_list(%s)", QuoteIdent(table)))
 	if err != nil {
 		return nil, fmt.Errorf("foreign_key_list %s: %w", table, err)
@@ -149,11 +188,14 @@ func loadForeignKeys(ctx context.Context, db *sql.DB, table string) ([]ForeignKe
 	for rows.Next() {
 		var fk ForeignKey
 		var id, seq int
-		if err := rows.Scan(&id, &seq, &fk.Table, &fk.From, &fk.To, &fk.OnUpdate, &fk.OnDelete, new(string)); err != nil {
+		// "to" is NULL for a reference to the parent's primary key.
+		var to sql.NullString
+		if err := rows.Scan(&id, &seq, &fk.Table, &fk.From, &to, &fk.OnUpdate, &fk.OnDelete, new(string)); err != nil {
 			return nil, fmt.Errorf("scan foreign_key_list %s: %w", table, err)
 		}
 		fk.ID = id
 		fk.Seq = seq
+		fk.To = to.String
 		fks = append(fks, fk)
 	}
 	if err := rows.Err(); err != nil {
@@ -162,6 +204,44 @@ func loadForeignKeys(ctx context.Context, db *sql.DB, table string) ([]ForeignKe
 	return fks, nil
 }
 
+// Affinity applies SQLite's affinity rules to a declared column type.
+func Affinity(declType string) string {
+	t := strings.ToUpper(declType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return "INTEGER"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "TEXT"
+	case t == "" || t == "ANY" || strings.Contains(t, "BLOB"):
+		return "BLOB"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return "REAL"
+	default:
+		return "NUMERIC"
+	}
+}
+
+// TypeChanges reports whether values of the given storage class would be
+// stored with a different type than the affinity promises. Text in a
+// numeric column is the case that breaks consumers (and STRICT tables
+// reject it); numbers in a TEXT column are converted to text by SQLite.
+func TypeChanges(storage, affinity string) bool {
+	if storage != "TEXT" {
+		return false
+	}
+	return affinity == "INTEGER" || affinity == "REAL" || affinity == "NUMERIC"
+}
+
+// DSN returns the data source name for opening the SQLite database at
+// path. A path that is already a SQLite URI ("file:...", e.g. with a vfs
+// or mode parameter) is used as it is.
+func DSN(path string) string {
+	if strings.HasPrefix(path, "file:") {
+		return path
+	}
+	return fmt.Sprintf("file:%s?_busy_timeout=5000", path)
+}
+
 func QuoteIdent(name string) string {
 	escaped := strings.ReplaceAll(name, "\"", "\"\"")
 	return "\"" + escaped + "\""
@@ -179,19 +259,31 @@ func MatchAny(patterns []string, name string) bool {
 	return false
 }
 
-func TableOrder(s *Schema) []string {
+// Dependents maps each table to the tables whose foreign keys reference it,
+// once per foreign key column. References to tables missing from the
+// schema are ignored.
+func Dependents(s *Schema) map[string][]string {
 	graph := map[string][]string{}
-	indeg := map[string]int{}
-	for name := range s.Tables {
-		indeg[name] = 0
-	}
 	for name, tbl := range s.Tables {
 		for _, fk := range tbl.ForeignKeys {
 			if _, ok := s.Tables[fk.Table]; !ok {
 				continue
 			}
 			graph[fk.Table] = append(graph[fk.Table], name)
-			indeg[name]++
+		}
+	}
+	return graph
+}
+
+func TableOrder(s *Schema) []string {
+	graph := Dependents(s)
+	indeg := map[string]int{}
+	for name := range s.Tables {
+		indeg[name] = 0
+	}
+	for _, deps := range graph {
+		for _, dep := range deps {
+			indeg[dep]++
 		}
 	}
 	var queue []string
@@ -215,16 +307,13 @@ func TableOrder(s *Schema) []string {
 		sort.Strings(queue)
 	}
 	if len(order) != len(s.Tables) {
+		seen := make(map[string]bool, len(order))
+		for _, o := range order {
+			seen[o] = true
+		}
 		var missing []string
 		for name := range s.Tables {
-			found := false
-			for _, o := range order {
-				if o == name {
-					found = true
-					break
-				}
-			}
-			if !found {
+			if !seen[name] {
 				missing = append(missing, name)
 			}
 		}
@@ -233,3 +322,88 @@ func TableOrder(s *Schema) []string {
 	}
 	return order
 }
+
+// FKViolation is a row whose foreign key references a missing parent row,
+// as reported by PRAGMA foreign_key_check.
+type FKViolation struct {
+	Table string
+	// RowID is the row's rowid; it is not valid for WITHOUT ROWID tables.
+	RowID  sql.NullInt64
+	Parent string
+	// Key describes the foreign key, "(user_id) -> users(id)".
+	Key string
+}
+
+func (v FKViolation) String() string {
+	row := "rowid NULL"
+	if v.RowID.Valid {
+		row = fmt.Sprintf("rowid %d", v.RowID.Int64)
+	}
+	return fmt.Sprintf("%s %s: %s has no parent row", v.Table, row, v.Key)
+}
+
+// CheckForeignKeys runs PRAGMA foreign_key_check on db and returns every
+// row whose parent is missing. It works whether or not foreign keys are
+// enforced on the connection.
+func CheckForeignKeys(ctx context.Context, db *sql.DB) ([]FKViolation, error) {
+	type check struct {
+		FKViolation
+		fkid int
+	}
+	rows, err := db.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return nil, fmt.Errorf("foreign key check: %w", err)
+	}
+	var checks []check
+	for rows.Next() {
+		var c check
+		if err := rows.Scan(&c.Table, &c.RowID, &c.Parent, &c.fkid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("foreign key check: %w", err)
+		}
+		checks = append(checks, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("foreign key check: %w", err)
+	}
+	keys := map[string]map[int]string{}
+	out := make([]FKViolation, 0, len(checks))
+	for _, c := range checks {
+		if keys[c.Table] == nil {
+			if keys[c.Table], err = foreignKeyLabels(ctx, db, c.Table); err != nil {
+				return nil, err
+			}
+		}
+		var ok bool
+		if c.Key, ok = keys[c.Table][c.fkid]; !ok {
+			c.Key = "-> " + c.Parent
+		}
+		out = append(out, c.FKViolation)
+	}
+	return out, nil
+}
+
+// foreignKeyLabels describes the foreign keys of table by id, as
+// "(child columns) -> parent(parent columns)".
+func foreignKeyLabels(ctx context.Context, db *sql.DB, table string) (map[int]string, error) {
+	fks, err := LoadForeignKeys(ctx, db, table)
+	if err != nil {
+		return nil, err
+	}
+	from := map[int][]string{}
+	to := map[int][]string{}
+	parents := map[int]string{}
+	for _, fk := range fks {
+		parents[fk.ID] = fk.Table
+		from[fk.ID] = append(from[fk.ID], fk.From)
+		if fk.To != "" {
+			to[fk.ID] = append(to[fk.ID], fk.To)
+		}
+	}
+	out := make(map[int]string, len(parents))
+	for id, parent := range parents {
+		out[id] = fmt.Sprintf("(%s) -> %s(%s)", strings.Join(from[id], ", "), parent, strings.Join(to[id], ", "))
+	}
+	return out, nil
+}
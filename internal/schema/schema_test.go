@@ -0,0 +1,56 @@
+package schema
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTableOrderWithCycle(t *testing.T) {
+	s := &Schema{Tables: map[string]*Table{
+		"users":  {Name: "users"},
+		"orders": {Name: "orders", ForeignKeys: []ForeignKey{{Table: "users", From: "user_id", To: "id"}}},
+		"a":      {Name: "a", ForeignKeys: []ForeignKey{{Table: "b", From: "b_id", To: "id"}}},
+		"b":      {Name: "b", ForeignKeys: []ForeignKey{{Table: "a", From: "a_id", To: "id"}}},
+	}}
+	got := fmt.Sprint(TableOrder(s))
+	if got != "[users orders a b]" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+}
+
+func BenchmarkTableOrderCycles(b *testing.B) {
+	s := &Schema{Tables: map[string]*Table{}}
+	for i := 0; i < 2000; i++ {
+		name := fmt.Sprintf("t%04d", i)
+		next := fmt.Sprintf("t%04d", (i+1)%2000)
+		s.Tables[name] = &Table{Name: name, ForeignKeys: []ForeignKey{{Table: next, From: "ref", To: "id"}}}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		TableOrder(s)
+	}
+}
+
+func TestViewLeaks(t *testing.T) {
+	s := &Schema{
+		Tables: map[string]*Table{
+			"users":  {Name: "users", Columns: []Column{{Name: "id"}, {Name: "email"}, {Name: "full_name"}}},
+			"orders": {Name: "orders", Columns: []Column{{Name: "id"}, {Name: "user_id"}, {Name: "note"}}},
+		},
+		Views: []SQLItem{
+			{Name: "plain", SQL: `CREATE VIEW plain AS SELECT "email", u.email AS e, users.email mail, * FROM users u`},
+			{Name: "computed", SQL: `CREATE VIEW computed (lower_email, n) AS SELECT lower(email), count(*) FROM users GROUP BY email`},
+			{Name: "joined", SQL: `CREATE VIEW joined AS SELECT o.id, o.note || ' ' || u.full_name AS label, -u.id FROM orders o JOIN users u ON u.id = o.user_id WHERE u.email LIKE '%x'`},
+			{Name: "compound", SQL: `CREATE VIEW compound AS SELECT email FROM users UNION ALL SELECT upper(note) FROM orders`},
+		},
+	}
+	masked := map[string]bool{"users.email": true, "users.full_name": true, "orders.note": true}
+	var got []string
+	for _, l := range ViewLeaks(s, func(table, column string) bool { return masked[table+"."+column] }) {
+		got = append(got, l.View+"."+l.Column+"<"+l.Table+"."+l.Source)
+	}
+	want := "[computed.lower_email<users.email joined.label<orders.note joined.label<users.full_name compound.email<orders.note]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("leaks = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package schema
+
+import "strings"
+
+// Token is a token of an SQL statement; Start and End are byte offsets
+// into the statement.
+type Token struct {
+	Text       string
+	Start, End int
+}
+
+// Tokenize splits a statement into words, quoted identifiers, string
+// literals, and single punctuation characters, skipping whitespace and
+// comments.
+func Tokenize(sqlText string) []Token {
+	var toks []Token
+	for i := 0; i < len(sqlText); {
+		c := sqlText[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(sqlText[i:], "--"):
+			for i < len(sqlText) && sqlText[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(sqlText[i:], "/*"):
+			end := strings.Index(sqlText[i+2:], "*/")
+			if end < 0 {
+				i = len(sqlText)
+			} else {
+				i += end + 4
+			}
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			j := i + 1
+			for j < len(sqlText) {
+				if sqlText[j] == closer {
+					if closer != ']' && j+1 < len(sqlText) && sqlText[j+1] == closer {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			end := min(j+1, len(sqlText))
+			toks = append(toks, Token{Text: sqlText[i:end], Start: i, End: end})
+			i = end
+		case isWordByte(c):
+			j := i
+			for j < len(sqlText) && isWordByte(sqlText[j]) {
+				j++
+			}
+			toks = append(toks, Token{Text: sqlText[i:j], Start: i, End: j})
+			i = j
+		default:
+			toks = append(toks, Token{Text: sqlText[i : i+1], Start: i, End: i + 1})
+			i++
+		}
+	}
+	return toks
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// IdentName unquotes an identifier token.
+func IdentName(tok string) string {
+	if len(tok) >= 2 {
+		switch tok[0] {
+		case '"', '`':
+			q := string(tok[0])
+			return strings.ReplaceAll(tok[1:len(tok)-1], q+q, q)
+		case '[':
+			return tok[1 : len(tok)-1]
+		}
+	}
+	return tok
+}
@@ -0,0 +1,217 @@
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ViewLeak is a view column computed by an expression over a masked column.
+// The view is recreated as written, so the expression runs on the masked
+// values, but it can still expose what the transform was meant to hide.
+type ViewLeak struct {
+	View   string
+	Column string
+	Table  string
+	Source string
+}
+
+func (l ViewLeak) String() string {
+	return fmt.Sprintf("%s.%s: computed from masked %s.%s; review the view, it may leak", l.View, l.Column, l.Table, l.Source)
+}
+
+// ViewLeaks reports the view columns that compute a value from a column
+// masked reports as masked. Columns that only select a masked column, under
+// any alias, are not reported: they show the masked value itself. The view
+// SQL is read token by token, so the match is by name: a masked column
+// counts when a table that has it is named anywhere in the view.
+func ViewLeaks(s *Schema, masked func(table, column string) bool) []ViewLeak {
+	var leaks []ViewLeak
+	for _, v := range s.Views {
+		toks := Tokenize(v.SQL)
+		var tables []*Table
+		seen := map[string]bool{}
+		for _, t := range toks {
+			name := strings.ToLower(IdentName(t.Text))
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			for tname, tbl := range s.Tables {
+				if strings.ToLower(tname) == name {
+					tables = append(tables, tbl)
+				}
+			}
+		}
+		sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+		reported := map[ViewLeak]bool{}
+		for _, col := range viewColumns(v.SQL, toks) {
+			if !col.computed {
+				continue
+			}
+			for _, ref := range col.refs {
+				for _, tbl := range tables {
+					for _, c := range tbl.Columns {
+						leak := ViewLeak{View: v.Name, Column: col.name, Table: tbl.Name, Source: c.Name}
+						if !strings.EqualFold(c.Name, ref) || !masked(tbl.Name, c.Name) || reported[leak] {
+							continue
+						}
+						reported[leak] = true
+						leaks = append(leaks, leak)
+					}
+				}
+			}
+		}
+	}
+	return leaks
+}
+
+// viewColumn is a result column of a view's SELECT list.
+type viewColumn struct {
+	// name is the column's alias, or its expression when it has none.
+	name string
+	// computed is set unless the column is a plain, possibly qualified,
+	// column reference.
+	computed bool
+	// refs are the unqualified names the expression mentions.
+	refs []string
+}
+
+// selectListEnd holds the keywords that end a SELECT list.
+var selectListEnd = map[string]bool{
+	"FROM": true, "WHERE": true, "GROUP": true, "HAVING": true, "WINDOW": true,
+	"ORDER": true, "LIMIT": true, "UNION": true, "INTERSECT": true, "EXCEPT": true,
+}
+
+// viewColumns parses the result columns of every top-level SELECT of a
+// CREATE VIEW statement. Columns of a compound SELECT appear once per arm,
+// named by the column list after the view name or else by the first arm.
+func viewColumns(ddl string, toks []Token) []viewColumn {
+	var names []string
+	depth := 0
+	body := len(toks)
+	for i, t := range toks {
+		if depth == 0 && strings.EqualFold(t.Text, "AS") {
+			body = i + 1
+			break
+		}
+		switch t.Text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		default:
+			if depth == 1 && (toks[i-1].Text == "(" || toks[i-1].Text == ",") {
+				names = append(names, IdentName(t.Text))
+			}
+		}
+	}
+
+	var cols []viewColumn
+	depth = 0
+	for i := body; i < len(toks); i++ {
+		switch t := toks[i].Text; {
+		case t == "(":
+			depth++
+		case t == ")":
+			depth--
+		case depth == 0 && strings.EqualFold(t, "SELECT"):
+			arm := len(cols)
+			for n, item := range selectItems(toks, i+1) {
+				col := parseViewColumn(ddl, item)
+				if n < len(names) {
+					col.name = names[n]
+				}
+				cols = append(cols, col)
+			}
+			// Like SQLite, name the columns of later arms after the first.
+			if names == nil {
+				for _, col := range cols[arm:] {
+					names = append(names, col.name)
+				}
+			}
+		}
+	}
+	return cols
+}
+
+// selectItems splits the SELECT list starting at toks[start] on its
+// top-level commas.
+func selectItems(toks []Token, start int) [][]Token {
+	if start < len(toks) && (strings.EqualFold(toks[start].Text, "DISTINCT") || strings.EqualFold(toks[start].Text, "ALL")) {
+		start++
+	}
+	var items [][]Token
+	depth := 0
+	from := start
+	i := start
+	for ; i < len(toks); i++ {
+		t := toks[i].Text
+		if depth == 0 && (t == ")" || t == ";" || selectListEnd[strings.ToUpper(t)]) {
+			break
+		}
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				items = append(items, toks[from:i])
+				from = i + 1
+			}
+		}
+	}
+	if from < i {
+		items = append(items, toks[from:i])
+	}
+	return items
+}
+
+func parseViewColumn(ddl string, item []Token) viewColumn {
+	expr, alias := item, ""
+	if n := len(expr); n >= 2 && strings.EqualFold(expr[n-2].Text, "AS") {
+		expr, alias = expr[:n-2], IdentName(expr[n-1].Text)
+	} else if n >= 2 && isIdentToken(expr[n-1].Text) && isColumnRef(expr[:n-1]) {
+		expr, alias = expr[:n-1], IdentName(expr[n-1].Text)
+	}
+	col := viewColumn{name: alias, computed: !isColumnRef(expr)}
+	if col.name == "" && len(expr) > 0 {
+		col.name = IdentName(ddl[expr[0].Start:expr[len(expr)-1].End])
+	}
+	for k, t := range expr {
+		if !isIdentToken(t.Text) || (k+1 < len(expr) && (expr[k+1].Text == "." || expr[k+1].Text == "(")) {
+			continue
+		}
+		col.refs = append(col.refs, IdentName(t.Text))
+	}
+	return col
+}
+
+// isColumnRef reports whether expr is name, qualifier.name, or either with
+// * in place of the name.
+func isColumnRef(expr []Token) bool {
+	switch {
+	case len(expr) == 1:
+		return expr[0].Text == "*" || isIdentToken(expr[0].Text)
+	case len(expr) == 3:
+		return isIdentToken(expr[0].Text) && expr[1].Text == "." && (expr[2].Text == "*" || isIdentToken(expr[2].Text))
+	}
+	return false
+}
+
+// isIdentToken reports whether tok can name a column: a quoted identifier
+// or a word that is not a number.
+func isIdentToken(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	switch c := tok[0]; {
+	case c == '"' || c == '`' || c == '[':
+		return true
+	case c >= '0' && c <= '9':
+		return false
+	default:
+		return isWordByte(c)
+	}
+}
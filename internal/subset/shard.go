@@ -0,0 +1,236 @@
+package subset
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/transform"
+)
+
+// ShardOf assigns a shard key value to one of n shards. NULL keys go to
+// shard 0.
+func ShardOf(v any, n int) int {
+	if v == nil || n <= 1 {
+		return 0
+	}
+	sum := sha256.Sum256([]byte(transform.CanonicalString(v)))
+	return int(binary.BigEndian.Uint64(sum[:8]) % uint64(n))
+}
+
+// BuildShardSelection selects the rows of one shard out of shards: the rows
+// of table whose column hashes to the shard, the rows of tables that
+// reference them directly or transitively, and the parents those rows
+// need. Rows of those tables that reference no row of them (their foreign
+// keys are NULL) go to shard 0, like NULL keys. Tables without a foreign
+// key path to table are marked Whole and go into every shard, so each
+// shard keeps its foreign keys intact. Rows referenced from several shards
+// are copied into each of them. chunkSize is as for BuildSelection.
+func BuildShardSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config, table, column string, shard, shards, chunkSize int) (*Selection, error) {
+	ch, err := newChunker(ctx, db, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	tbl := s.Tables[table]
+	if tbl == nil {
+		return nil, fmt.Errorf("shard key %w", &schema.NotFoundError{Table: table})
+	}
+	if !tableIncluded(cfg, table) {
+		return nil, fmt.Errorf("shard key table %s is excluded by include_tables/exclude_tables", table)
+	}
+	found := false
+	for _, c := range tbl.Columns {
+		if c.Name == column {
+			found = true
+			break
+		}
+	}
+	if !found && !(column == "rowid" && !tbl.WithoutRowID) {
+		return nil, fmt.Errorf("shard key %w", &schema.NotFoundError{Table: table, Column: column})
+	}
+	pkCols, useRowID, err := tablePKColumns(tbl)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := ownedTables(s, cfg, table)
+	selection := &Selection{Sets: map[string]*PKSet{}, Whole: map[string]bool{}}
+	for name := range s.Tables {
+		if !owned[name] && tableIncluded(cfg, name) {
+			selection.Whole[name] = true
+		}
+	}
+
+	set := NewPKSet(pkCols)
+	selection.Sets[table] = set
+	keyCol := schema.QuoteIdent(column)
+	if column == "rowid" {
+		keyCol = "rowid"
+	}
+	cols := quotedCols(pkCols, useRowID)
+	query := fmt.Sprintf("SELECT %s, %s FROM %s ORDER BY %s", strings.Join(cols, ", "), keyCol, schema.QuoteIdent(table), strings.Join(cols, ", "))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("shard key query %s: %w", table, err)
+	}
+	for rows.Next() {
+		vals := make([]any, len(pkCols)+1)
+		ptrs := make([]any, len(vals))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("shard key scan %s: %w", table, err)
+		}
+		if ShardOf(vals[len(pkCols)], shards) == shard {
+			set.Add(vals[:len(pkCols)])
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("shard key iterate %s: %w", table, err)
+	}
+	rows.Close()
+
+	names := make([]string, 0, len(owned))
+	for name := range owned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if shard == 0 {
+		for _, name := range names {
+			if name == table {
+				continue
+			}
+			if err := addUnreferencingRows(ctx, db, s.Tables[name], owned, selection); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Pull in the rows that reference the shard's rows, following foreign
+	// keys from parent to child only, so other shards' rows stay out.
+	for changed := true; changed; {
+		changed = false
+		for _, childName := range names {
+			if childName == table {
+				continue
+			}
+			childTbl := s.Tables[childName]
+			for _, fk := range GroupFKs(childTbl) {
+				parentSet := selection.Sets[fk.RefTable]
+				if fk.RefTable == childName || !owned[fk.RefTable] || parentSet == nil || parentSet.Len() == 0 {
+					continue
+				}
+				added, err := addChildKeys(ctx, db, childTbl, fk, parentSet, selection, ch)
+				if err != nil {
+					return nil, err
+				}
+				changed = changed || added
+			}
+		}
+	}
+	// Then add every parent those rows reference, so the shard's foreign
+	// keys resolve even when a row belongs to more than one shard.
+	for changed := true; changed; {
+		changed = false
+		for _, childName := range names {
+			childSet := selection.Sets[childName]
+			if childSet == nil || childSet.Len() == 0 {
+				continue
+			}
+			childTbl := s.Tables[childName]
+			for _, fk := range GroupFKs(childTbl) {
+				parentTbl := s.Tables[fk.RefTable]
+				if parentTbl == nil || !owned[fk.RefTable] {
+					continue
+				}
+				refVals, err := selectFKValues(ctx, db, childTbl, fk, childSet, ch)
+				if err != nil {
+					return nil, err
+				}
+				added, err := addParentKeys(ctx, db, parentTbl, fk, refVals, selection, ch)
+				if err != nil {
+					return nil, err
+				}
+				changed = changed || added
+			}
+		}
+	}
+	return selection, nil
+}
+
+// addUnreferencingRows adds the rows of tbl whose foreign keys to owned
+// tables are all NULL: no shard key reaches them through their parents.
+func addUnreferencingRows(ctx context.Context, db *sql.DB, tbl *schema.Table, owned map[string]bool, sel *Selection) error {
+	var conds []string
+	for _, fk := range GroupFKs(tbl) {
+		if fk.RefTable == tbl.Name || !owned[fk.RefTable] {
+			continue
+		}
+		nulls := make([]string, 0, len(fk.FromCols))
+		for _, c := range fk.FromCols {
+			nulls = append(nulls, schema.QuoteIdent(c)+" IS NULL")
+		}
+		conds = append(conds, "("+strings.Join(nulls, " OR ")+")")
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+	pkCols, useRowID, err := tablePKColumns(tbl)
+	if err != nil {
+		return err
+	}
+	set, err := selectionSet(sel, tbl)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(quotedCols(pkCols, useRowID), ", "), schema.QuoteIdent(tbl.Name), strings.Join(conds, " AND "))
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("shard select unreferencing %s: %w", tbl.Name, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		vals := make([]any, len(pkCols))
+		ptrs := make([]any, len(vals))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("shard scan unreferencing %s: %w", tbl.Name, err)
+		}
+		set.Add(vals)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("shard iterate unreferencing %s: %w", tbl.Name, err)
+	}
+	return nil
+}
+
+// ownedTables lists the copied tables with a foreign key path to table,
+// table included: their rows are split across shards.
+func ownedTables(s *schema.Schema, cfg *config.Config, table string) map[string]bool {
+	dependents := schema.Dependents(s)
+	owned := map[string]bool{table: true}
+	queue := []string{table}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dep := range dependents[name] {
+			if owned[dep] || !tableIncluded(cfg, dep) {
+				continue
+			}
+			owned[dep] = true
+			queue = append(queue, dep)
+		}
+	}
+	return owned
+}
@@ -2,17 +2,26 @@ package subset
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/dyne/pinkmask/internal/config"
 	"github.com/dyne/pinkmask/internal/schema"
+	"github.com/dyne/pinkmask/internal/transform"
 )
 
 type Selection struct {
 	Sets map[string]*PKSet
+	// Whole lists tables copied in full rather than by their set.
+	Whole map[string]bool
 }
 
 type PKSet struct {
@@ -80,7 +89,16 @@ func (s *PKSet) ValuesByColumns(cols []string) ([][]any, error) {
 	return out, nil
 }
 
-func BuildSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config) (*Selection, error) {
+// BuildSelection selects the rows reachable from the subset roots. Keys
+// are looked up chunkSize at a time in IN (...) lists, fewer for keys too
+// wide for the bind variable limit; 0 uses DefaultChunkSize. Up to jobs
+// foreign keys are followed at once; the selection is the same for any
+// jobs.
+func BuildSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config, chunkSize, jobs int) (*Selection, error) {
+	ch, err := newChunker(ctx, db, chunkSize)
+	if err != nil {
+		return nil, err
+	}
 	selection := &Selection{Sets: map[string]*PKSet{}}
 	if cfg == nil || cfg.Subset == nil {
 		return selection, nil
@@ -94,7 +112,10 @@ func BuildSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *conf
 		}
 		tbl := s.Tables[root.Table]
 		if tbl == nil {
-			return nil, fmt.Errorf("subset root table not found: %s", root.Table)
+			return nil, fmt.Errorf("subset root %w", &schema.NotFoundError{Table: root.Table})
+		}
+		if !tableIncluded(cfg, root.Table) {
+			return nil, fmt.Errorf("subset root table %s is excluded by include_tables/exclude_tables", root.Table)
 		}
 		pkCols, useRowID, err := tablePKColumns(tbl)
 		if err != nil {
@@ -105,20 +126,42 @@ func BuildSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *conf
 			set = NewPKSet(pkCols)
 			selection.Sets[root.Table] = set
 		}
-		cols := make([]string, 0, len(pkCols))
-		for _, c := range pkCols {
-			if c == "rowid" && useRowID {
-				cols = append(cols, "rowid")
-			} else {
-				cols = append(cols, schema.QuoteIdent(c))
+		if len(root.Keys) > 0 {
+			for _, k := range root.Keys {
+				vals, ok := k.([]any)
+				if !ok {
+					vals = []any{k}
+				}
+				if len(vals) != len(pkCols) {
+					return nil, fmt.Errorf("subset root %s: key %v has %d values, primary key has %d columns", root.Table, k, len(vals), len(pkCols))
+				}
+				set.Add(vals)
+			}
+			continue
+		}
+		if root.StratifyBy != "" {
+			keys, err := stratifiedKeys(ctx, db, tbl, root, pkCols, useRowID)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range keys {
+				set.Add(k)
 			}
+			continue
 		}
+		cols := quotedCols(pkCols, useRowID)
 		query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), schema.QuoteIdent(root.Table))
 		if root.Where != "" {
 			query += " WHERE " + root.Where
 		}
-		if len(pkCols) > 0 {
-			query += " ORDER BY " + strings.Join(quotedCols(pkCols, useRowID), ", ")
+		// Always order by the key (rowid for tables without one) so a
+		// limited root picks the same rows on every run.
+		orderBy := append([]string(nil), cols...)
+		if root.OrderBy != "" {
+			orderBy = append([]string{root.OrderBy}, orderBy...)
+		}
+		if len(orderBy) > 0 {
+			query += " ORDER BY " + strings.Join(orderBy, ", ")
 		}
 		if root.Limit > 0 {
 			query += fmt.Sprintf(" LIMIT %d", root.Limit)
@@ -145,58 +188,173 @@ func BuildSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *conf
 		}
 		rows.Close()
 	}
-	if err := expandSelection(ctx, db, s, selection); err != nil {
+	if err := expandSelection(ctx, db, s, cfg, selection, ch, jobs); err != nil {
 		return nil, err
 	}
 	return selection, nil
 }
 
-func expandSelection(ctx context.Context, db *sql.DB, s *schema.Schema, selection *Selection) error {
-	fkGroups := map[string][]FKGroup{}
-	for name, tbl := range s.Tables {
-		fkGroups[name] = groupFKs(tbl)
+func stratifiedKeys(ctx context.Context, db *sql.DB, tbl *schema.Table, root config.RootConfig, pkCols []string, useRowID bool) ([][]any, error) {
+	if root.OrderBy != "" {
+		return nil, fmt.Errorf("subset root %s: order_by cannot be combined with stratify_by", root.Table)
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s", strings.Join(quotedCols(pkCols, useRowID), ", "), schema.QuoteIdent(root.StratifyBy), schema.QuoteIdent(root.Table))
+	if root.Where != "" {
+		query += " WHERE " + root.Where
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("subset stratify query %s: %w", root.Table, err)
+	}
+	defer rows.Close()
+	type member struct {
+		hash string
+		key  []any
+	}
+	groups := map[string][]member{}
+	total := 0
+	for rows.Next() {
+		vals := make([]any, len(pkCols)+1)
+		ptrs := make([]any, len(vals))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("subset stratify scan %s: %w", root.Table, err)
+		}
+		key := vals[:len(pkCols)]
+		sum := sha256.Sum256([]byte(root.Table + "|" + keyFor(key)))
+		stratum := transform.CanonicalString(vals[len(pkCols)])
+		groups[stratum] = append(groups[stratum], member{hash: hex.EncodeToString(sum[:]), key: key})
+		total++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("subset stratify iterate %s: %w", root.Table, err)
+	}
+	strata := make([]string, 0, len(groups))
+	for stratum := range groups {
+		strata = append(strata, stratum)
+	}
+	sort.Strings(strata)
+	counts := make(map[string]int, len(groups))
+	for stratum, members := range groups {
+		counts[stratum] = len(members)
+	}
+	quotas := stratumQuotas(strata, counts, total, root.Limit)
+	var out [][]any
+	for _, stratum := range strata {
+		members := groups[stratum]
+		sort.Slice(members, func(i, j int) bool { return members[i].hash < members[j].hash })
+		for _, m := range members[:quotas[stratum]] {
+			out = append(out, m.key)
+		}
+	}
+	return out, nil
+}
+
+func stratumQuotas(strata []string, counts map[string]int, total, limit int) map[string]int {
+	quotas := make(map[string]int, len(strata))
+	if limit <= 0 || limit >= total {
+		for _, stratum := range strata {
+			quotas[stratum] = counts[stratum]
+		}
+		return quotas
+	}
+	remainders := make(map[string]int, len(strata))
+	assigned := 0
+	for _, stratum := range strata {
+		share := limit * counts[stratum]
+		quotas[stratum] = share / total
+		remainders[stratum] = share % total
+		assigned += quotas[stratum]
+	}
+	byRemainder := make([]string, len(strata))
+	copy(byRemainder, strata)
+	sort.SliceStable(byRemainder, func(i, j int) bool {
+		return remainders[byRemainder[i]] > remainders[byRemainder[j]]
+	})
+	for i := 0; assigned < limit; i++ {
+		stratum := byRemainder[i%len(byRemainder)]
+		if quotas[stratum] < counts[stratum] {
+			quotas[stratum]++
+			assigned++
+		}
+	}
+	return quotas
+}
+
+// expandSelection follows foreign keys in both directions until the
+// selection is closed. Tables excluded by the config are neither selected
+// nor traversed, so the selection matches what is copied. Each round looks
+// every foreign key up against the selection as it stood when the round
+// began, up to jobs lookups at a time, and only then adds the keys found,
+// in table and foreign key order, so the selection does not depend on jobs.
+func expandSelection(ctx context.Context, db *sql.DB, s *schema.Schema, cfg *config.Config, selection *Selection, ch chunker, jobs int) error {
+	type edge struct {
+		child, parent *schema.Table
+		fk            FKGroup
 	}
 	tableNames := make([]string, 0, len(s.Tables))
 	for name := range s.Tables {
-		tableNames = append(tableNames, name)
+		if tableIncluded(cfg, name) {
+			tableNames = append(tableNames, name)
+		}
 	}
 	sort.Strings(tableNames)
-	changed := true
-	for changed {
+	var edges []edge
+	for _, childName := range tableNames {
+		childTbl := s.Tables[childName]
+		for _, fk := range GroupFKs(childTbl) {
+			parentTbl := s.Tables[fk.RefTable]
+			if parentTbl == nil || !tableIncluded(cfg, fk.RefTable) {
+				continue
+			}
+			edges = append(edges, edge{child: childTbl, parent: parentTbl, fk: fk})
+		}
+	}
+	type found struct {
+		parents, children [][]any
+		err               error
+	}
+	for changed := true; changed; {
 		changed = false
-		for _, childName := range tableNames {
-			groups := fkGroups[childName]
-			childSet := selection.Sets[childName]
-			childTbl := s.Tables[childName]
-			for _, fk := range groups {
-				parentTbl := s.Tables[fk.RefTable]
-				if parentTbl == nil {
+		results := make([]found, len(edges))
+		parallel(len(edges), jobs, func(i int) {
+			e := edges[i]
+			res := &results[i]
+			if childSet := selection.Sets[e.child.Name]; childSet != nil && childSet.Len() > 0 {
+				refVals, err := selectFKValues(ctx, db, e.child, e.fk, childSet, ch)
+				if err != nil {
+					res.err = err
+					return
+				}
+				if res.parents, err = parentKeys(ctx, db, e.parent, e.fk, refVals, ch); err != nil {
+					res.err = err
+					return
+				}
+			}
+			if parentSet := selection.Sets[e.parent.Name]; parentSet != nil && parentSet.Len() > 0 {
+				res.children, res.err = childKeys(ctx, db, e.child, e.fk, parentSet, ch)
+			}
+		})
+		for i, e := range edges {
+			res := results[i]
+			if res.err != nil {
+				return res.err
+			}
+			for _, add := range []struct {
+				tbl  *schema.Table
+				keys [][]any
+			}{{e.parent, res.parents}, {e.child, res.children}} {
+				if len(add.keys) == 0 {
 					continue
 				}
-				parentSet := selection.Sets[fk.RefTable]
-				if childSet != nil && childSet.Len() > 0 {
-					refVals, err := selectFKValues(ctx, db, childTbl, fk, childSet)
-					if err != nil {
-						return err
-					}
-					if len(refVals) > 0 {
-						added, err := addParentKeys(ctx, db, parentTbl, fk, refVals, selection)
-						if err != nil {
-							return err
-						}
-						if added {
-							changed = true
-						}
-					}
+				set, err := selectionSet(selection, add.tbl)
+				if err != nil {
+					return err
 				}
-				if parentSet != nil && parentSet.Len() > 0 {
-					added, err := addChildKeys(ctx, db, childTbl, fk, parentSet, selection)
-					if err != nil {
-						return err
-					}
-					if added {
-						changed = true
-					}
+				for _, k := range add.keys {
+					changed = set.Add(k) || changed
 				}
 			}
 		}
@@ -204,13 +362,51 @@ func expandSelection(ctx context.Context, db *sql.DB, s *schema.Schema, selectio
 	return nil
 }
 
+// parallel calls fn for 0..n-1, at most jobs calls at a time.
+func parallel(n, jobs int, fn func(i int)) {
+	if jobs <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, jobs)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// selectionSet returns the set of tbl, adding an empty one if needed.
+func selectionSet(sel *Selection, tbl *schema.Table) (*PKSet, error) {
+	if set := sel.Sets[tbl.Name]; set != nil {
+		return set, nil
+	}
+	pkCols, _, err := tablePKColumns(tbl)
+	if err != nil {
+		return nil, err
+	}
+	set := NewPKSet(pkCols)
+	sel.Sets[tbl.Name] = set
+	return set, nil
+}
+
 type FKGroup struct {
 	RefTable string
 	FromCols []string
 	ToCols   []string
 }
 
-func groupFKs(tbl *schema.Table) []FKGroup {
+func GroupFKs(tbl *schema.Table) []FKGroup {
 	byID := map[int]*FKGroup{}
 	order := make([]int, 0)
 	for _, fk := range tbl.ForeignKeys {
@@ -231,7 +427,7 @@ func groupFKs(tbl *schema.Table) []FKGroup {
 	return out
 }
 
-func selectFKValues(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk FKGroup, childSet *PKSet) ([][]any, error) {
+func selectFKValues(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk FKGroup, childSet *PKSet, ch chunker) ([][]any, error) {
 	pkCols, useRowID, err := tablePKColumns(childTbl)
 	if err != nil {
 		return nil, err
@@ -244,12 +440,14 @@ func selectFKValues(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk
 		return nil, nil
 	}
 	var results [][]any
-	chunks := chunkValues(childPKVals, 500)
-	for _, chunk := range chunks {
-		whereIn, args := buildTupleIn(pkCols, chunk, useRowID)
-		query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", strings.Join(quotedCols(fk.FromCols, false), ", "), schema.QuoteIdent(childTbl.Name), whereIn)
-		query += notNullClause(fk.FromCols)
-		rows, err := db.QueryContext(ctx, query, args...)
+	q := chunkQuery{db: db}
+	defer q.close()
+	for _, chunk := range ch.split(childPKVals, len(pkCols)) {
+		rows, err := q.query(ctx, chunk, len(pkCols), func() string {
+			whereIn, _ := buildTupleIn(pkCols, chunk, useRowID)
+			query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", strings.Join(quotedCols(fk.FromCols, false), ", "), schema.QuoteIdent(childTbl.Name), whereIn)
+			return query + notNullClause(fk.FromCols)
+		})
 		if err != nil {
 			return nil, fmt.Errorf("subset select fk values %s: %w", childTbl.Name, err)
 		}
@@ -274,44 +472,46 @@ func selectFKValues(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk
 	return results, nil
 }
 
-func addParentKeys(ctx context.Context, db *sql.DB, parentTbl *schema.Table, fk FKGroup, refVals [][]any, sel *Selection) (bool, error) {
-	if len(refVals) == 0 {
-		return false, nil
+func addParentKeys(ctx context.Context, db *sql.DB, parentTbl *schema.Table, fk FKGroup, refVals [][]any, sel *Selection, ch chunker) (bool, error) {
+	keys, err := parentKeys(ctx, db, parentTbl, fk, refVals, ch)
+	if err != nil || len(keys) == 0 {
+		return false, err
 	}
-	parentSet := sel.Sets[fk.RefTable]
-	if parentSet == nil {
-		pkCols, _, err := tablePKColumns(parentTbl)
-		if err != nil {
-			return false, err
-		}
-		parentSet = NewPKSet(pkCols)
-		sel.Sets[fk.RefTable] = parentSet
+	parentSet, err := selectionSet(sel, parentTbl)
+	if err != nil {
+		return false, err
 	}
-	if sameColumnOrder(parentSet.Cols, fk.ToCols) {
-		added := false
-		for _, row := range refVals {
-			if parentSet.Add(row) {
-				added = true
-			}
-		}
-		return added, nil
+	added := false
+	for _, k := range keys {
+		added = parentSet.Add(k) || added
 	}
-	return selectParentPKs(ctx, db, parentTbl, fk, refVals, parentSet)
+	return added, nil
 }
 
-func selectParentPKs(ctx context.Context, db *sql.DB, parentTbl *schema.Table, fk FKGroup, refVals [][]any, parentSet *PKSet) (bool, error) {
+// parentKeys returns the primary keys of the parentTbl rows that refVals,
+// values of fk's columns, reference. When fk references the primary key
+// itself they are refVals as they are.
+func parentKeys(ctx context.Context, db *sql.DB, parentTbl *schema.Table, fk FKGroup, refVals [][]any, ch chunker) ([][]any, error) {
+	if len(refVals) == 0 {
+		return nil, nil
+	}
 	pkCols, useRowID, err := tablePKColumns(parentTbl)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	chunks := chunkValues(refVals, 500)
-	added := false
-	for _, chunk := range chunks {
-		whereIn, args := buildTupleIn(fk.ToCols, chunk, false)
-		query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(quotedCols(pkCols, useRowID), ", "), schema.QuoteIdent(parentTbl.Name), whereIn)
-		rows, err := db.QueryContext(ctx, query, args...)
+	if sameColumnOrder(pkCols, fk.ToCols) {
+		return refVals, nil
+	}
+	var results [][]any
+	q := chunkQuery{db: db}
+	defer q.close()
+	for _, chunk := range ch.split(refVals, len(fk.ToCols)) {
+		rows, err := q.query(ctx, chunk, len(fk.ToCols), func() string {
+			whereIn, _ := buildTupleIn(fk.ToCols, chunk, false)
+			return fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(quotedCols(pkCols, useRowID), ", "), schema.QuoteIdent(parentTbl.Name), whereIn)
+		})
 		if err != nil {
-			return false, fmt.Errorf("subset select parent %s: %w", parentTbl.Name, err)
+			return nil, fmt.Errorf("subset select parent %s: %w", parentTbl.Name, err)
 		}
 		for rows.Next() {
 			vals := make([]any, len(pkCols))
@@ -321,46 +521,56 @@ func selectParentPKs(ctx context.Context, db *sql.DB, parentTbl *schema.Table, f
 			}
 			if err := rows.Scan(ptrs...); err != nil {
 				rows.Close()
-				return false, fmt.Errorf("subset scan parent %s: %w", parentTbl.Name, err)
-			}
-			if parentSet.Add(vals) {
-				added = true
+				return nil, fmt.Errorf("subset scan parent %s: %w", parentTbl.Name, err)
 			}
+			results = append(results, vals)
 		}
 		if err := rows.Err(); err != nil {
 			rows.Close()
-			return false, fmt.Errorf("subset iterate parent %s: %w", parentTbl.Name, err)
+			return nil, fmt.Errorf("subset iterate parent %s: %w", parentTbl.Name, err)
 		}
 		rows.Close()
 	}
-	return added, nil
+	return results, nil
 }
 
-func addChildKeys(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk FKGroup, parentSet *PKSet, sel *Selection) (bool, error) {
-	childSet := sel.Sets[childTbl.Name]
-	pkCols, useRowID, err := tablePKColumns(childTbl)
+func addChildKeys(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk FKGroup, parentSet *PKSet, sel *Selection, ch chunker) (bool, error) {
+	childSet, err := selectionSet(sel, childTbl)
 	if err != nil {
 		return false, err
 	}
-	if childSet == nil {
-		childSet = NewPKSet(pkCols)
-		sel.Sets[childTbl.Name] = childSet
-	}
-	parentVals, err := parentSet.ValuesByColumns(fk.ToCols)
+	keys, err := childKeys(ctx, db, childTbl, fk, parentSet, ch)
 	if err != nil {
 		return false, err
 	}
-	if len(parentVals) == 0 {
-		return false, nil
-	}
-	chunks := chunkValues(parentVals, 500)
 	added := false
-	for _, chunk := range chunks {
-		whereIn, args := buildTupleIn(fk.FromCols, chunk, false)
-		query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", strings.Join(quotedCols(pkCols, useRowID), ", "), schema.QuoteIdent(childTbl.Name), whereIn)
-		rows, err := db.QueryContext(ctx, query, args...)
+	for _, k := range keys {
+		added = childSet.Add(k) || added
+	}
+	return added, nil
+}
+
+// childKeys returns the primary keys of the childTbl rows that reference,
+// through fk, a row in parentSet.
+func childKeys(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk FKGroup, parentSet *PKSet, ch chunker) ([][]any, error) {
+	pkCols, useRowID, err := tablePKColumns(childTbl)
+	if err != nil {
+		return nil, err
+	}
+	parentVals, err := parentSet.ValuesByColumns(fk.ToCols)
+	if err != nil {
+		return nil, err
+	}
+	var results [][]any
+	q := chunkQuery{db: db}
+	defer q.close()
+	for _, chunk := range ch.split(parentVals, len(fk.FromCols)) {
+		rows, err := q.query(ctx, chunk, len(fk.FromCols), func() string {
+			whereIn, _ := buildTupleIn(fk.FromCols, chunk, false)
+			return fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", strings.Join(quotedCols(pkCols, useRowID), ", "), schema.QuoteIdent(childTbl.Name), whereIn)
+		})
 		if err != nil {
-			return false, fmt.Errorf("subset select child %s: %w", childTbl.Name, err)
+			return nil, fmt.Errorf("subset select child %s: %w", childTbl.Name, err)
 		}
 		for rows.Next() {
 			vals := make([]any, len(pkCols))
@@ -370,19 +580,17 @@ func addChildKeys(ctx context.Context, db *sql.DB, childTbl *schema.Table, fk FK
 			}
 			if err := rows.Scan(ptrs...); err != nil {
 				rows.Close()
-				return false, fmt.Errorf("subset scan child %s: %w", childTbl.Name, err)
-			}
-			if childSet.Add(vals) {
-				added = true
+				return nil, fmt.Errorf("subset scan child %s: %w", childTbl.Name, err)
 			}
+			results = append(results, vals)
 		}
 		if err := rows.Err(); err != nil {
 			rows.Close()
-			return false, fmt.Errorf("subset iterate child %s: %w", childTbl.Name, err)
+			return nil, fmt.Errorf("subset iterate child %s: %w", childTbl.Name, err)
 		}
 		rows.Close()
 	}
-	return added, nil
+	return results, nil
 }
 
 func tablePKColumns(tbl *schema.Table) ([]string, bool, error) {
@@ -407,28 +615,67 @@ func quotedCols(cols []string, useRowID bool) []string {
 	return out
 }
 
+// chunkQuery runs one lookup query per chunk of keys. The query text only
+// depends on the number of keys in the chunk, so it is prepared once and
+// reused while chunks keep the same size: the full-size chunks share one
+// statement and only a shorter last chunk is prepared again.
+type chunkQuery struct {
+	db   *sql.DB
+	size int
+	stmt *sql.Stmt
+}
+
+// query runs the query for chunk, whose keys have width values each;
+// build returns its text and is only called when a statement needs to be
+// prepared.
+func (q *chunkQuery) query(ctx context.Context, chunk [][]any, width int, build func() string) (*sql.Rows, error) {
+	if q.stmt == nil || q.size != len(chunk) {
+		q.close()
+		stmt, err := q.db.PrepareContext(ctx, build())
+		if err != nil {
+			return nil, err
+		}
+		q.stmt, q.size = stmt, len(chunk)
+	}
+	return q.stmt.QueryContext(ctx, tupleArgs(chunk, width)...)
+}
+
+func (q *chunkQuery) close() {
+	if q.stmt != nil {
+		q.stmt.Close()
+		q.stmt = nil
+	}
+}
+
+// tupleArgs flattens values into the arguments of the IN list
+// buildTupleIn writes for them.
+func tupleArgs(values [][]any, width int) []any {
+	args := make([]any, 0, len(values)*width)
+	for _, row := range values {
+		args = append(args, row[:width]...)
+	}
+	return args
+}
+
 func buildTupleIn(cols []string, values [][]any, useRowID bool) (string, []any) {
 	if len(cols) == 1 {
 		place := make([]string, 0, len(values))
-		args := make([]any, 0, len(values))
 		col := cols[0]
 		if col == "rowid" && useRowID {
 			col = "rowid"
 		} else {
 			col = schema.QuoteIdent(col)
 		}
-		for _, v := range values {
+		for range values {
 			place = append(place, "?")
-			args = append(args, v[0])
 		}
-		return fmt.Sprintf("%s IN (%s)", col, strings.Join(place, ", ")), args
+		return fmt.Sprintf("%s IN (%s)", col, strings.Join(place, ", ")), tupleArgs(values, 1)
 	}
 	var builder strings.Builder
 	builder.WriteString("(")
 	builder.WriteString(strings.Join(quotedCols(cols, useRowID), ", "))
 	builder.WriteString(") IN (")
-	args := make([]any, 0, len(values)*len(cols))
-	for i, row := range values {
+	for i := range values {
 		if i > 0 {
 			builder.WriteString(", ")
 		}
@@ -438,12 +685,11 @@ func buildTupleIn(cols []string, values [][]any, useRowID bool) (string, []any)
 				builder.WriteString(", ")
 			}
 			builder.WriteString("?")
-			args = append(args, row[j])
 		}
 		builder.WriteString(")")
 	}
 	builder.WriteString(")")
-	return builder.String(), args
+	return builder.String(), tupleArgs(values, len(cols))
 }
 
 func notNullClause(cols []string) string {
@@ -454,6 +700,65 @@ func notNullClause(cols []string) string {
 	return " AND " + strings.Join(clauses, " AND ")
 }
 
+// DefaultChunkSize is the number of keys bound per IN (...) lookup when no
+// chunk size is set. Lookups of wider keys are cut further to fit the bind
+// variable limit.
+const DefaultChunkSize = 500
+
+// minBindLimit is SQLITE_MAX_VARIABLE_NUMBER before SQLite 3.32, the
+// lowest limit of any build.
+const minBindLimit = 999
+
+var bindLimitPattern = regexp.MustCompile(`between \?1 and \?(\d+)`)
+
+// BindLimit returns db's limit on bind variables per statement. SQLite
+// names it when a statement uses a parameter number beyond it; if the
+// error does not say, the lowest limit of any build is assumed.
+func BindLimit(ctx context.Context, db *sql.DB) int {
+	var v any
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT ?%d", math.MaxInt32)).Scan(&v)
+	if err != nil {
+		if m := bindLimitPattern.FindStringSubmatch(err.Error()); m != nil {
+			if n, err := strconv.Atoi(m[1]); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return minBindLimit
+}
+
+// EffectiveChunkSize lowers size so that a lookup of size keys of width
+// columns, one bind variable per column, keeps one variable to spare under
+// limit. It is at least 1.
+func EffectiveChunkSize(size, width, limit int) int {
+	if width < 1 {
+		width = 1
+	}
+	return max(min(size, (limit-1)/width), 1)
+}
+
+// chunker splits key lists into IN (...) lookups of at most size keys
+// that fit in the bind variable limit.
+type chunker struct {
+	size  int
+	limit int
+}
+
+func newChunker(ctx context.Context, db *sql.DB, size int) (chunker, error) {
+	if size < 0 {
+		return chunker{}, fmt.Errorf("invalid chunk size: %d", size)
+	}
+	if size == 0 {
+		size = DefaultChunkSize
+	}
+	return chunker{size: size, limit: BindLimit(ctx, db)}, nil
+}
+
+// split chunks values, keys of width columns.
+func (c chunker) split(values [][]any, width int) [][][]any {
+	return chunkValues(values, EffectiveChunkSize(c.size, width, c.limit))
+}
+
 func chunkValues(values [][]any, size int) [][][]any {
 	if size <= 0 || len(values) <= size {
 		return [][][]any{values}
@@ -472,11 +777,24 @@ func chunkValues(values [][]any, size int) [][][]any {
 func keyFor(values []any) string {
 	parts := make([]string, len(values))
 	for i, v := range values {
-		parts[i] = fmt.Sprint(v)
+		parts[i] = transform.CanonicalString(v)
 	}
 	return strings.Join(parts, "|")
 }
 
+func tableIncluded(cfg *config.Config, name string) bool {
+	if cfg == nil {
+		return true
+	}
+	if len(cfg.IncludeTables) > 0 && !schema.MatchAny(cfg.IncludeTables, name) {
+		return false
+	}
+	if schema.MatchAny(cfg.ExcludeTables, name) {
+		return false
+	}
+	return true
+}
+
 func sameColumnOrder(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -0,0 +1,364 @@
+package subset
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
+	_ "modernc.org/sqlite"
+)
+
+func TestRowIDRootLimitIsOrdered(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	// The index on name lets SQLite answer the WHERE in name order, which
+	// is the reverse of rowid order, so only an explicit ORDER BY rowid
+	// yields the first rows.
+	if _, err := db.Exec(`CREATE TABLE events (name TEXT); CREATE INDEX idx_events_name ON events(name)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 1; i <= 50; i++ {
+		if _, err := db.Exec(`INSERT INTO events (name) VALUES (?)`, fmt.Sprintf("e%02d", 100-i)); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "events", Where: "name > ''", Limit: 10}},
+	}}
+	var first string
+	for run := 0; run < 2; run++ {
+		sel, err := BuildSelection(ctx, db, s, cfg, 0, 1)
+		if err != nil {
+			t.Fatalf("build selection: %v", err)
+		}
+		got := fmt.Sprint(sel.Sets["events"].Values)
+		if run == 0 {
+			first = got
+			continue
+		}
+		if got != first {
+			t.Fatalf("selection changed between runs: %s vs %s", first, got)
+		}
+	}
+	if first != "[[1] [2] [3] [4] [5] [6] [7] [8] [9] [10]]" {
+		t.Fatalf("expected the first ten rowids, got %s", first)
+	}
+}
+
+func TestRootOrderByPicksLimitedRows(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, plan TEXT, created_at INTEGER)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users VALUES (1, 'b', 10), (2, 'a', 20), (3, 'b', 30), (4, 'a', 40), (5, 'a', 50), (6, 'b', 60)`); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	for _, tc := range []struct {
+		orderBy string
+		want    string
+	}{
+		{"", "[[1] [2]]"},
+		{"created_at DESC", "[[6] [5]]"},
+		// Ties on order_by are broken by the key.
+		{"plan", "[[2] [4]]"},
+	} {
+		cfg := &config.Config{Subset: &config.SubsetConfig{
+			Roots: []config.RootConfig{{Table: "users", OrderBy: tc.orderBy, Limit: 2}},
+		}}
+		sel, err := BuildSelection(ctx, db, s, cfg, 0, 1)
+		if err != nil {
+			t.Fatalf("order_by %q: build selection: %v", tc.orderBy, err)
+		}
+		if got := fmt.Sprint(sel.Sets["users"].Values); got != tc.want {
+			t.Fatalf("order_by %q: selected %s, want %s", tc.orderBy, got, tc.want)
+		}
+	}
+}
+
+func TestSelectionSkipsExcludedTables(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE projects (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE memberships (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), project_id INTEGER REFERENCES projects(id))`,
+		`INSERT INTO users (id) VALUES (1), (2)`,
+		`INSERT INTO projects (id) VALUES (10), (20)`,
+		`INSERT INTO memberships (user_id, project_id) VALUES (1, 10), (2, 20)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "users", Keys: []any{1}}},
+	}}
+	sel, err := BuildSelection(ctx, db, s, cfg, 0, 1)
+	if err != nil {
+		t.Fatalf("build selection: %v", err)
+	}
+	if got := fmt.Sprint(sel.Sets["projects"].Values); got != "[[10]]" {
+		t.Fatalf("expected project 10 through the bridge, got %s", got)
+	}
+
+	cfg.ExcludeTables = []string{"member*"}
+	sel, err = BuildSelection(ctx, db, s, cfg, 0, 1)
+	if err != nil {
+		t.Fatalf("build selection: %v", err)
+	}
+	if sel.Sets["memberships"] != nil || sel.Sets["projects"] != nil {
+		t.Fatalf("excluded bridge was traversed: %v", sel.Sets)
+	}
+	if got := fmt.Sprint(sel.Sets["users"].Values); got != "[[1]]" {
+		t.Fatalf("unexpected users selection: %s", got)
+	}
+
+	cfg.ExcludeTables = []string{"users"}
+	if _, err := BuildSelection(ctx, db, s, cfg, 0, 1); err == nil {
+		t.Fatalf("expected error for excluded root table")
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id))`,
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 50) INSERT INTO users SELECT i FROM n`,
+		`INSERT INTO orders (user_id) SELECT id FROM users`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "users"}},
+	}}
+	// Chunks smaller than the selection must find the same rows.
+	for _, size := range []int{0, 7, 50} {
+		sel, err := BuildSelection(ctx, db, s, cfg, size, 1)
+		if err != nil {
+			t.Fatalf("build selection with chunk size %d: %v", size, err)
+		}
+		if n := sel.Sets["orders"].Len(); n != 50 {
+			t.Fatalf("chunk size %d selected %d orders, want 50", size, n)
+		}
+	}
+
+	if _, err := BuildSelection(ctx, db, s, cfg, -1, 1); err == nil {
+		t.Fatalf("expected negative chunk size to fail")
+	}
+}
+
+func TestCompositeKeyChunksFitBindLimit(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "in.sqlite"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	limit := BindLimit(ctx, db)
+	if limit < minBindLimit {
+		t.Fatalf("bind limit %d below %d", limit, minBindLimit)
+	}
+	// 500 keys of three columns are 1500 variables, over the old default.
+	if got := EffectiveChunkSize(500, 3, 999); got != 332 {
+		t.Fatalf("expected 332 keys per chunk, got %d", got)
+	}
+	if got := EffectiveChunkSize(500, 1, 999); got != 500 {
+		t.Fatalf("expected 500 keys per chunk, got %d", got)
+	}
+
+	// Enough rows that one chunk of every key would exceed the limit.
+	rows := limit/3 + 600
+	stmts := []string{
+		`CREATE TABLE cells (x INTEGER, y INTEGER, z INTEGER, PRIMARY KEY (x, y, z))`,
+		`CREATE TABLE marks (id INTEGER PRIMARY KEY, x INTEGER, y INTEGER, z INTEGER, FOREIGN KEY (x, y, z) REFERENCES cells (x, y, z))`,
+		fmt.Sprintf(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d) INSERT INTO cells SELECT i %% 10, i / 10, i FROM n`, rows),
+		`INSERT INTO marks (x, y, z) SELECT x, y, z FROM cells`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("setup: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "cells"}},
+	}}
+	for _, size := range []int{0, rows} {
+		sel, err := BuildSelection(ctx, db, s, cfg, size, 1)
+		if err != nil {
+			t.Fatalf("build selection with chunk size %d: %v", size, err)
+		}
+		if n := sel.Sets["marks"].Len(); n != rows {
+			t.Fatalf("chunk size %d selected %d marks, want %d", size, n, rows)
+		}
+	}
+}
+
+func BenchmarkBuildSelectionChunkSize(b *testing.B) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(b.TempDir(), "in.sqlite"))
+	if err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id))`,
+		`CREATE INDEX idx_orders_user ON orders(user_id)`,
+		`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 20000) INSERT INTO users SELECT i FROM n`,
+		`INSERT INTO orders (user_id) SELECT id FROM users UNION ALL SELECT id FROM users`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			b.Fatalf("setup: %v", err)
+		}
+	}
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		b.Fatalf("load schema: %v", err)
+	}
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "users", Where: "id % 2 = 0"}},
+	}}
+	for _, size := range []int{100, 500, 5000, 30000} {
+		b.Run(fmt.Sprintf("chunk=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := BuildSelection(ctx, db, s, cfg, size, 1); err != nil {
+					b.Fatalf("build selection: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// createDemoDB builds the schema of examples/make_demo_db.go with users
+// users, each with an address and up to three orders of one to three items.
+func createDemoDB(tb testing.TB, users int) *sql.DB {
+	tb.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(tb.TempDir(), "demo.sqlite"))
+	if err != nil {
+		tb.Fatalf("open: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	stmts := []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL, country TEXT NOT NULL)`,
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id), status TEXT NOT NULL)`,
+		`CREATE TABLE order_items (id INTEGER PRIMARY KEY, order_id INTEGER NOT NULL REFERENCES orders(id), sku TEXT NOT NULL)`,
+		`CREATE TABLE addresses (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id), city TEXT NOT NULL)`,
+		`CREATE INDEX idx_orders_user ON orders(user_id)`,
+		`CREATE INDEX idx_order_items_order ON order_items(order_id)`,
+		`CREATE INDEX idx_addresses_user ON addresses(user_id)`,
+		fmt.Sprintf(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < %d)
+			INSERT INTO users SELECT i, 'user' || i || '@example.com', CASE i %% 3 WHEN 0 THEN 'US' WHEN 1 THEN 'CA' ELSE 'GB' END FROM n`, users),
+		`INSERT INTO addresses (user_id, city) SELECT id, 'Springfield' FROM users`,
+		`WITH RECURSIVE o(k) AS (SELECT 0 UNION ALL SELECT k + 1 FROM o WHERE k < 2)
+			INSERT INTO orders SELECT u.id * 100 + o.k, u.id, 'shipped' FROM users u, o WHERE o.k < u.id % 4`,
+		`WITH RECURSIVE n(k) AS (SELECT 0 UNION ALL SELECT k + 1 FROM n WHERE k < 2)
+			INSERT INTO order_items SELECT o.id * 10 + n.k, o.id, 'SKU-' || (o.id * 10 + n.k) FROM orders o, n WHERE n.k <= o.id % 3`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			tb.Fatalf("setup: %v", err)
+		}
+	}
+	return db
+}
+
+func TestSelectionIndependentOfJobs(t *testing.T) {
+	ctx := context.Background()
+	db := createDemoDB(t, 300)
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		t.Fatalf("load schema: %v", err)
+	}
+	// Rooted at order items, the selection reaches users through orders and
+	// then the users' other orders and addresses, over several rounds.
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "order_items", Where: "sku LIKE '%2'", Limit: 20}},
+	}}
+	var first string
+	for _, jobs := range []int{1, 2, 8} {
+		sel, err := BuildSelection(ctx, db, s, cfg, 7, jobs)
+		if err != nil {
+			t.Fatalf("jobs=%d: build selection: %v", jobs, err)
+		}
+		got := fmt.Sprint(sel.Sets["users"].Values, sel.Sets["orders"].Values, sel.Sets["order_items"].Values, sel.Sets["addresses"].Values)
+		if jobs == 1 {
+			first = got
+			if sel.Sets["addresses"].Len() == 0 || sel.Sets["orders"].Len() <= 20 {
+				t.Fatalf("selection did not expand: %s", got)
+			}
+			continue
+		}
+		if got != first {
+			t.Fatalf("jobs=%d: selection differs from jobs=1:\n%s\n%s", jobs, got, first)
+		}
+	}
+}
+
+func BenchmarkBuildSelectionJobs(b *testing.B) {
+	ctx := context.Background()
+	db := createDemoDB(b, 50000)
+	s, err := schema.Load(ctx, db)
+	if err != nil {
+		b.Fatalf("load schema: %v", err)
+	}
+	cfg := &config.Config{Subset: &config.SubsetConfig{
+		Roots: []config.RootConfig{{Table: "users", Where: "country = 'US'"}},
+	}}
+	for _, jobs := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := BuildSelection(ctx, db, s, cfg, 0, jobs); err != nil {
+					b.Fatalf("build selection: %v", err)
+				}
+			}
+		})
+	}
+}
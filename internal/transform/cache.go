@@ -0,0 +1,129 @@
+package transform
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/dyne/pinkmask/internal/config"
+)
+
+// Cacheable is implemented by transformers that can report whether their
+// output depends only on the value, given the run's salt and seed. Only
+// those can sit behind a Cache; a transformer that does not implement it
+// is assumed to read the row.
+type Cacheable interface {
+	Cacheable() bool
+}
+
+func isCacheable(tr Transformer) bool {
+	c, ok := tr.(Cacheable)
+	return ok && c.Cacheable()
+}
+
+func (t *HashSha256) Cacheable() bool     { return true }
+func (t *HmacSha256) Cacheable() bool     { return true }
+func (t *Pbkdf2) Cacheable() bool         { return true }
+func (t *StableTokenize) Cacheable() bool { return true }
+func (t *MapReplace) Cacheable() bool     { return true }
+func (t *Redact) Cacheable() bool         { return true }
+func (t *IntPermute) Cacheable() bool     { return true }
+
+// Cacheable reports whether every group transform is; the groups see the
+// row as well.
+func (t *RegexReplace) Cacheable() bool {
+	for _, tr := range t.groups {
+		if tr != nil && !isCacheable(tr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *JSONArrayMask) Cacheable() bool { return isCacheable(t.element) }
+func (t *Truncate) Cacheable() bool      { return isCacheable(t.inner) }
+func (t *Match) Cacheable() bool         { return isCacheable(t.inner) }
+
+// cacheKey tells apart values with the same text but different types,
+// which some transformers treat differently.
+type cacheKey struct {
+	typ  reflect.Type
+	text string
+}
+
+type cacheEntry struct {
+	key cacheKey
+	out any
+}
+
+// Cache memoizes inner's output for the size most recently used values.
+// NULLs and errors are not cached.
+type Cache struct {
+	inner Transformer
+	size  int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+func NewCache(inner Transformer, size int) *Cache {
+	return &Cache{inner: inner, size: size, order: list.New(), entries: map[cacheKey]*list.Element{}}
+}
+
+func (t *Cache) Name() string { return t.inner.Name() }
+
+func (t *Cache) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return t.inner.Transform(value, row)
+	}
+	key := cacheKey{typ: reflect.TypeOf(value), text: CanonicalString(value)}
+	t.mu.Lock()
+	if el, ok := t.entries[key]; ok {
+		t.order.MoveToFront(el)
+		out := el.Value.(*cacheEntry).out
+		t.mu.Unlock()
+		return out, nil
+	}
+	t.mu.Unlock()
+	out, err := t.inner.Transform(value, row)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.entries[key]; ok {
+		return out, nil
+	}
+	t.entries[key] = t.order.PushFront(&cacheEntry{key: key, out: out})
+	if t.order.Len() > t.size {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return out, nil
+}
+
+// WithCache puts tr behind a Cache of params.cache entries. Pbkdf2 is
+// cached by default, since every derivation is slow on purpose.
+// BuildForColumn applies it; transformers built another way, such as
+// lookup_table maps, go through it directly.
+func WithCache(tr Transformer, cfg *config.TransformConfig) (Transformer, error) {
+	size := 0
+	if _, ok := tr.(*Pbkdf2); ok {
+		size = defaultPbkdf2Cache
+	}
+	if v, ok := cfg.Params["cache"]; ok {
+		if size, ok = asInt(v); !ok || size < 0 {
+			return nil, fmt.Errorf("%s: params.cache must be a non-negative integer", tr.Name())
+		}
+		if size > 0 && !isCacheable(tr) {
+			return nil, fmt.Errorf("%s: params.cache is not supported, the transform depends on the row", tr.Name())
+		}
+	}
+	if size == 0 {
+		return tr, nil
+	}
+	return NewCache(tr, size), nil
+}
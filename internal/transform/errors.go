@@ -0,0 +1,12 @@
+package transform
+
+import "errors"
+
+var (
+	// ErrUnknownTransformer is returned by Build for a type that is neither
+	// built in nor registered by a plugin.
+	ErrUnknownTransformer = errors.New("unknown transformer type")
+	// ErrDenied is returned by Build and Allowed for a transformer the
+	// policy set with SetPolicy forbids.
+	ErrDenied = errors.New("transform not allowed by policy")
+)
@@ -2,15 +2,88 @@ package transform
 
 import (
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/dyne/pinkmask/internal/config"
+	"github.com/dyne/pinkmask/internal/schema"
 )
 
+var builtins = []Info{
+	{Name: "HashSha256", Description: "salted SHA-256 hex digest", Params: []string{"maxlen"}},
+	{Name: "HmacSha256", Description: "HMAC-SHA256 hex digest keyed by the salt", Params: []string{"maxlen"}},
+	{Name: "Pbkdf2", Description: "slow salted PBKDF2-HMAC-SHA256 hex digest", Params: []string{"maxlen", "params.iterations"}},
+	{Name: "StableTokenize", Description: "short lowercase base32 token", Params: []string{"maxlen"}},
+	{Name: "RegexReplace", Description: "replace regex matches", Params: []string{"pattern", "replace", "groups"}},
+	{Name: "SetNull", Description: "replace with NULL"},
+	{Name: "SetValue", Description: "replace with a constant", Params: []string{"value"}},
+	{Name: "Redact", Description: "repeat a mask character over the value's length", Params: []string{"params.char", "params.keep_length", "params.length"}},
+	{Name: "FakerName", Description: "deterministic fake full name", Params: []string{"params.first_names_file", "params.last_names_file", "params.pool_size"}},
+	{Name: "FakerEmail", Description: "deterministic fake email address", Params: []string{"params.first_names_file", "params.last_names_file", "params.domains_file", "params.pool_size"}},
+	{Name: "FakerAddress", Description: "deterministic fake street address", Params: []string{"params.streets_file", "params.cities_file", "params.states_file", "params.pool_size"}},
+	{Name: "FakerIBAN", Description: "deterministic fake IBAN with valid check digits", Params: []string{"params.country", "params.pool_size"}},
+	{Name: "FakerPhone", Description: "deterministic fake phone number", Params: []string{"locale", "params.format", "params.pool_size"}},
+	{Name: "IntPermute", Description: "keyed one-to-one remapping of non-negative integers", Params: []string{"params.group", "params.max"}},
+	{Name: "DateShift", Description: "shift dates by a deterministic number of days, or times by seconds", Params: []string{"params.max_days", "params.keep_time", "params.shift_time", "params.max_seconds"}},
+	{Name: "Map", Description: "replace values using a mapping", Params: []string{"map", "lookup_table", "lookup_key", "lookup_value"}},
+	{Name: "Choice", Description: "deterministic pick from a list of choices, optionally weighted", Params: []string{"params.choices"}},
+	{Name: "Sequence", Description: "running number of the row in copy order, optionally formatted", Params: []string{"params.format", "params.start"}},
+	{Name: "Redistribute", Description: "random amounts that keep each group's total", Params: []string{"params.group_by", "params.decimals"}},
+	{Name: "JSONArrayMask", Description: "apply a transform to every element of a JSON array", Params: []string{"element"}},
+	{Name: "Resample", Description: "deterministic samples from the column's own distribution", Params: []string{"params.interpolate"}},
+}
+
 func Build(cfg *config.TransformConfig, salt string) (Transformer, error) {
+	return BuildForColumn(cfg, salt, "")
+}
+
+// Transform masks a single value the way a copy masks it in the row that
+// row describes: the transformer is built with row.Salt for a column of
+// row.ColumnType and sees row as its context. PK values compare by their
+// printed form, so an INTEGER key may be given as int or int64. A nil cfg
+// returns the value unchanged. Transform builds the transformer on every
+// call; use Build to mask many values.
+func Transform(cfg *config.TransformConfig, row RowContext, value any) (any, error) {
+	tr, err := BuildForColumn(cfg, row.Salt, row.ColumnType)
+	if err != nil {
+		return nil, err
+	}
+	if tr == nil {
+		return value, nil
+	}
+	return tr.Transform(value, row)
+}
+
+// BuildForColumn is Build for a transformer writing into a column of the
+// given declared type, so constants such as SetValue's value are stored
+// with the storage class the column's affinity expects.
+func BuildForColumn(cfg *config.TransformConfig, salt, colType string) (Transformer, error) {
 	if cfg == nil {
 		return nil, nil
 	}
+	tr, err := build(cfg, salt, colType)
+	if err != nil || tr == nil {
+		return tr, err
+	}
+	if tr, err = withPool(tr, cfg); err != nil {
+		return nil, err
+	}
+	if tr, err = withMaxLen(tr, cfg); err != nil {
+		return nil, err
+	}
+	if tr, err = WithCache(tr, cfg); err != nil {
+		return nil, err
+	}
+	return WithMatch(tr, cfg)
+}
+
+func build(cfg *config.TransformConfig, salt, colType string) (Transformer, error) {
+	if err := Allowed(cfg.Type); err != nil {
+		return nil, err
+	}
 	key := strings.ToLower(cfg.Type)
 	if factory, ok := registry[key]; ok {
 		return factory(cfg, salt)
@@ -20,39 +93,378 @@ func Build(cfg *config.TransformConfig, salt string) (Transformer, error) {
 		return NewHashSha256(salt, cfg.MaxLen), nil
 	case "hmacsha256":
 		return NewHmacSha256(salt, cfg.MaxLen), nil
+	case "pbkdf2":
+		iterations := defaultPbkdf2Iterations
+		if v, ok := cfg.Params["iterations"]; ok {
+			if iterations, ok = asInt(v); !ok || iterations <= 0 {
+				return nil, fmt.Errorf("Pbkdf2: params.iterations must be a positive integer")
+			}
+		}
+		return NewPbkdf2(salt, iterations, cfg.MaxLen), nil
 	case "stabletokenize":
 		return NewStableTokenize(cfg.MaxLen), nil
 	case "regexreplace":
-		return NewRegexReplace(cfg.Pattern, cfg.Replace)
+		if len(cfg.Groups) == 0 {
+			return NewRegexReplace(cfg.Pattern, cfg.Replace)
+		}
+		if cfg.Replace != "" {
+			return nil, fmt.Errorf("RegexReplace: replace and groups are mutually exclusive")
+		}
+		groups := make(map[string]Transformer, len(cfg.Groups))
+		for name, gc := range cfg.Groups {
+			if gc == nil {
+				return nil, fmt.Errorf("RegexReplace: groups.%s has no transform", name)
+			}
+			tr, err := Build(gc, salt)
+			if err != nil {
+				return nil, fmt.Errorf("RegexReplace: groups.%s: %w", name, err)
+			}
+			groups[name] = tr
+		}
+		return NewRegexReplaceGroups(cfg.Pattern, groups)
 	case "setnull":
 		return &SetNull{}, nil
 	case "setvalue":
-		return NewSetValue(cfg.Value), nil
+		if colType == "" {
+			return NewSetValue(cfg.Value), nil
+		}
+		v, err := coerceValue(cfg.Value, schema.Affinity(colType))
+		if err != nil {
+			return nil, fmt.Errorf("SetValue: %w", err)
+		}
+		return NewSetValue(v), nil
+	case "redact":
+		char := "*"
+		keepLength := true
+		var length int
+		if v, ok := cfg.Params["char"]; ok {
+			char = fmt.Sprint(v)
+		}
+		if v, ok := cfg.Params["keep_length"]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Redact: params.keep_length must be a boolean")
+			}
+			keepLength = b
+		}
+		if v, ok := cfg.Params["length"]; ok {
+			if length, ok = asInt(v); !ok || length <= 0 {
+				return nil, fmt.Errorf("Redact: params.length must be a positive integer")
+			}
+		}
+		return NewRedact(char, keepLength, length), nil
 	case "fakername":
-		return &FakerName{}, nil
+		return newFakerName(cfg)
 	case "fakeremail":
-		return &FakerEmail{}, nil
+		return newFakerEmail(cfg)
 	case "fakeraddress":
-		return &FakerAddress{}, nil
+		return newFakerAddress(cfg)
 	case "fakerphone":
-		return &FakerPhone{}, nil
+		var format string
+		if v, ok := cfg.Params["format"]; ok {
+			format = fmt.Sprint(v)
+		}
+		return NewFakerPhone(cfg.Locale, format)
+	case "fakeriban":
+		var country string
+		if v, ok := cfg.Params["country"]; ok {
+			country = fmt.Sprint(v)
+		}
+		return NewFakerIBAN(country)
+	case "intpermute":
+		var group string
+		var max int
+		if v, ok := cfg.Params["group"]; ok {
+			group = fmt.Sprint(v)
+		}
+		if v, ok := cfg.Params["max"]; ok {
+			iv, ok := asInt(v)
+			if !ok || iv <= 0 || uint64(iv) > intPermuteSpace {
+				return nil, fmt.Errorf("IntPermute: params.max must be a positive integer no larger than %d (2^62)", uint64(intPermuteSpace))
+			}
+			max = iv
+		}
+		return NewIntPermute(group, uint64(max)), nil
 	case "dateshift":
 		maxDays := 30
 		if cfg.Params != nil {
 			if v, ok := cfg.Params["max_days"]; ok {
-				if iv, ok := asInt(v); ok {
-					maxDays = iv
+				iv, ok := asInt(v)
+				if !ok {
+					return nil, fmt.Errorf("DateShift: params.max_days must be an integer, got %v", v)
 				}
+				maxDays = iv
 			}
 		}
+		var keepTime, shiftTime bool
+		for name, dst := range map[string]*bool{"keep_time": &keepTime, "shift_time": &shiftTime} {
+			if v, ok := cfg.Params[name]; ok {
+				if *dst, ok = v.(bool); !ok {
+					return nil, fmt.Errorf("DateShift: params.%s must be a boolean", name)
+				}
+			}
+		}
+		_, hasMaxSeconds := cfg.Params["max_seconds"]
+		switch {
+		case keepTime && shiftTime:
+			return nil, fmt.Errorf("DateShift: params.keep_time and params.shift_time are mutually exclusive")
+		case shiftTime:
+			if _, ok := cfg.Params["max_days"]; ok {
+				return nil, fmt.Errorf("DateShift: params.max_days does not apply with shift_time; use max_seconds")
+			}
+			maxSeconds, ok := asInt(cfg.Params["max_seconds"])
+			if !ok || maxSeconds <= 0 {
+				return nil, fmt.Errorf("DateShift: shift_time requires params.max_seconds, a positive integer")
+			}
+			return NewTimeShift(maxSeconds), nil
+		case hasMaxSeconds:
+			return nil, fmt.Errorf("DateShift: params.max_seconds requires shift_time")
+		case keepTime:
+			return NewDateShiftKeepTime(maxDays), nil
+		}
 		return NewDateShift(maxDays), nil
 	case "map":
 		return NewMapReplace(cfg.Map), nil
+	case "choice":
+		return newChoice(cfg)
+	case "sequence":
+		var format string
+		if v, ok := cfg.Params["format"]; ok {
+			if format, ok = v.(string); !ok {
+				return nil, fmt.Errorf("Sequence: params.format must be a string")
+			}
+		}
+		start := 1
+		if v, ok := cfg.Params["start"]; ok {
+			if start, ok = asInt(v); !ok {
+				return nil, fmt.Errorf("Sequence: params.start must be an integer")
+			}
+		}
+		if cfg.MaxLen > 0 {
+			return nil, fmt.Errorf("Sequence: maxlen is not supported; size the format instead")
+		}
+		return NewSequence(format, int64(start))
+	case "jsonarraymask":
+		if cfg.Element == nil {
+			return nil, fmt.Errorf("JSONArrayMask: element is required")
+		}
+		if cfg.MaxLen > 0 {
+			return nil, fmt.Errorf("JSONArrayMask: maxlen would cut the JSON; set it on element")
+		}
+		switch strings.ToLower(cfg.Element.Type) {
+		case "redistribute", "resample", "sequence", "jsonarraymask":
+			return nil, fmt.Errorf("JSONArrayMask: element cannot be %s", cfg.Element.Type)
+		}
+		element, err := Build(cfg.Element, salt)
+		if err != nil {
+			return nil, fmt.Errorf("JSONArrayMask: element: %w", err)
+		}
+		if element == nil {
+			return nil, fmt.Errorf("JSONArrayMask: element has no transform")
+		}
+		return NewJSONArrayMask(element), nil
+	case "resample":
+		interpolate := true
+		if v, ok := cfg.Params["interpolate"]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Resample: params.interpolate must be a boolean")
+			}
+			interpolate = b
+		}
+		if cfg.MaxLen > 0 {
+			return nil, fmt.Errorf("Resample: maxlen does not apply to numbers")
+		}
+		return NewResample(interpolate), nil
+	case "redistribute":
+		var groupBy []string
+		switch v := cfg.Params["group_by"].(type) {
+		case string:
+			groupBy = []string{v}
+		case []any:
+			for _, col := range v {
+				name, ok := col.(string)
+				if !ok {
+					return nil, fmt.Errorf("Redistribute: params.group_by must list column names")
+				}
+				groupBy = append(groupBy, name)
+			}
+		}
+		decimals := -1
+		if v, ok := cfg.Params["decimals"]; ok {
+			if decimals, ok = asInt(v); !ok || decimals < 0 {
+				return nil, fmt.Errorf("Redistribute: params.decimals must be a non-negative integer")
+			}
+		}
+		if cfg.MaxLen > 0 {
+			return nil, fmt.Errorf("Redistribute: maxlen does not apply to numbers")
+		}
+		return NewRedistribute(groupBy, decimals)
 	default:
-		return nil, fmt.Errorf("unknown transformer type: %s", cfg.Type)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTransformer, cfg.Type)
 	}
 }
 
+// newChoice reads params.choices: a list of values picked uniformly, or a
+// map from value to weight.
+func newChoice(cfg *config.TransformConfig) (*Choice, error) {
+	switch choices := cfg.Params["choices"].(type) {
+	case []any:
+		return NewChoice(choices, nil)
+	case map[string]any:
+		keys := make([]string, 0, len(choices))
+		for k := range choices {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]any, len(keys))
+		weights := make([]float64, len(keys))
+		for i, k := range keys {
+			values[i] = k
+			switch w := choices[k].(type) {
+			case int:
+				weights[i] = float64(w)
+			case float64:
+				weights[i] = w
+			default:
+				return nil, fmt.Errorf("Choice: weight of %s must be a number, got %v", k, choices[k])
+			}
+		}
+		return NewChoice(values, weights)
+	case nil:
+		return nil, fmt.Errorf("Choice: params.choices is required")
+	default:
+		return nil, fmt.Errorf("Choice: params.choices must be a list or a map of weights")
+	}
+}
+
+// OutputType is the storage class a built-in transformer produces (TEXT,
+// INTEGER, or REAL), or "" when it preserves the input type or is provided
+// by a plugin.
+func OutputType(cfg *config.TransformConfig) string {
+	key := strings.ToLower(cfg.Type)
+	if _, ok := registry[key]; ok {
+		return ""
+	}
+	switch key {
+	case "hashsha256", "hmacsha256", "pbkdf2", "stabletokenize", "regexreplace", "map", "redact",
+		"fakername", "fakeremail", "fakeraddress", "fakerphone", "fakeriban", "jsonarraymask":
+		return "TEXT"
+	case "intpermute":
+		return "INTEGER"
+	case "sequence":
+		if cfg.Params["format"] != nil {
+			return "TEXT"
+		}
+		return "INTEGER"
+	case "setvalue":
+		return storageClass(cfg.Value)
+	case "choice":
+		switch choices := cfg.Params["choices"].(type) {
+		case map[string]any:
+			return "TEXT"
+		case []any:
+			class := ""
+			for i, v := range choices {
+				c := storageClass(v)
+				if i > 0 && c != class {
+					return ""
+				}
+				class = c
+			}
+			return class
+		}
+	}
+	return ""
+}
+
+// OutputTypeForColumn is OutputType for a transformer writing into a column
+// of the given declared type, accounting for SetValue's coercion.
+func OutputTypeForColumn(cfg *config.TransformConfig, colType string) string {
+	if colType != "" && strings.EqualFold(cfg.Type, "SetValue") {
+		if _, ok := registry["setvalue"]; !ok {
+			if v, err := coerceValue(cfg.Value, schema.Affinity(colType)); err == nil {
+				return storageClass(v)
+			}
+		}
+	}
+	return OutputType(cfg)
+}
+
+func storageClass(v any) string {
+	switch v.(type) {
+	case string:
+		return "TEXT"
+	case int, int64:
+		return "INTEGER"
+	case float64:
+		return "REAL"
+	}
+	return ""
+}
+
+// WithMatch guards tr with params.match, so that only values matching the
+// pattern are masked. BuildForColumn applies it; transformers built another
+// way, such as lookup_table maps, go through it directly.
+func WithMatch(tr Transformer, cfg *config.TransformConfig) (Transformer, error) {
+	v, ok := cfg.Params["match"]
+	if !ok {
+		return tr, nil
+	}
+	pattern, ok := v.(string)
+	if !ok || pattern == "" {
+		return nil, fmt.Errorf("%s: params.match must be a regular expression", tr.Name())
+	}
+	switch tr.(type) {
+	case *Redistribute, *Resample:
+		return nil, fmt.Errorf("%s: params.match is not supported, the transform works on every value of the column", tr.Name())
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: params.match: %w", tr.Name(), err)
+	}
+	return &Match{inner: tr, re: re}, nil
+}
+
+// withPool confines a faker to params.pool_size distinct values.
+func withPool(tr Transformer, cfg *config.TransformConfig) (Transformer, error) {
+	v, ok := cfg.Params["pool_size"]
+	if !ok {
+		return tr, nil
+	}
+	switch tr.(type) {
+	case *FakerName, *FakerEmail, *FakerAddress, *FakerPhone, *FakerIBAN:
+	default:
+		return nil, fmt.Errorf("%s: params.pool_size applies to the Faker transforms only", tr.Name())
+	}
+	size, ok := asInt(v)
+	if !ok || size <= 0 {
+		return nil, fmt.Errorf("%s: params.pool_size must be a positive integer", tr.Name())
+	}
+	return &Pool{inner: tr, size: uint64(size)}, nil
+}
+
+func withMaxLen(tr Transformer, cfg *config.TransformConfig) (Transformer, error) {
+	var runes bool
+	switch strings.ToLower(cfg.MaxLenUnit) {
+	case "", "runes":
+		runes = true
+	case "bytes":
+	default:
+		return nil, fmt.Errorf("invalid maxlen_unit: %s (expected runes or bytes)", cfg.MaxLenUnit)
+	}
+	if cfg.MaxLen <= 0 {
+		return tr, nil
+	}
+	switch tr.(type) {
+	case *HashSha256, *HmacSha256, *Pbkdf2, *StableTokenize:
+		return tr, nil
+	}
+	return &Truncate{inner: tr, maxLen: cfg.MaxLen, runes: runes}, nil
+}
+
+// asInt returns v as an int when it is an integer, or a float with an
+// integral value (YAML reads 30.0 as one) that fits an int.
 func asInt(v any) (int, bool) {
 	switch t := v.(type) {
 	case int:
@@ -60,10 +472,101 @@ func asInt(v any) (int, bool) {
 	case int64:
 		return int(t), true
 	case float64:
+		if t != math.Trunc(t) || t < math.MinInt || t >= -math.MinInt {
+			return 0, false
+		}
 		return int(t), true
 	case float32:
-		return int(t), true
+		return asInt(float64(t))
 	default:
 		return 0, false
 	}
 }
+
+// coerceValue converts a constant parsed from YAML to the storage class of
+// the given affinity: value: 0 into an INTEGER column is stored as an
+// integer rather than whatever the YAML decoder produced. NULL and BLOB
+// columns take the value unchanged.
+func coerceValue(v any, affinity string) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if b, ok := v.(bool); ok && affinity != "TEXT" && affinity != "BLOB" {
+		if b {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	}
+	switch affinity {
+	case "INTEGER":
+		if n, ok := integral(v); ok {
+			return n, nil
+		}
+		if f, ok := v.(float64); ok {
+			return nil, fmt.Errorf("value %v is not an integer for an INTEGER column", f)
+		}
+		if s, ok := v.(string); ok {
+			if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not an integer for an INTEGER column", v)
+	case "REAL":
+		switch t := v.(type) {
+		case int:
+			return float64(t), nil
+		case int64:
+			return float64(t), nil
+		case float64:
+			return t, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not a number for a REAL column", v)
+	case "NUMERIC":
+		if n, ok := integral(v); ok {
+			return n, nil
+		}
+		switch t := v.(type) {
+		case float64:
+			return t, nil
+		case string:
+			s := strings.TrimSpace(t)
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n, nil
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("value %v is not a number for a NUMERIC column", v)
+	case "TEXT":
+		switch t := v.(type) {
+		case string:
+			return t, nil
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64), nil
+		default:
+			return fmt.Sprint(t), nil
+		}
+	}
+	return v, nil
+}
+
+// integral returns v as an int64 when it is an integer, or a float with no
+// fractional part.
+func integral(v any) (int64, bool) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), true
+	case int64:
+		return t, true
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<63 {
+			return int64(t), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package transform
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// ibanFormats describes the BBAN of each supported country as runs of
+// digits (n), uppercase letters (a), or either (c), following the IBAN
+// registry. National check digits inside the BBAN are not computed.
+var ibanFormats = map[string]string{
+	"AT": "16n",
+	"BE": "12n",
+	"CH": "5n12c",
+	"DE": "18n",
+	"ES": "20n",
+	"FR": "10n11c2n",
+	"GB": "4a14n",
+	"IT": "1a10n12c",
+	"NL": "4a10n",
+	"PT": "21n",
+}
+
+type FakerIBAN struct {
+	country string
+	format  string
+}
+
+func NewFakerIBAN(country string) (*FakerIBAN, error) {
+	if country == "" {
+		country = "DE"
+	}
+	country = strings.ToUpper(country)
+	format, ok := ibanFormats[country]
+	if !ok {
+		return nil, fmt.Errorf("FakerIBAN: unsupported country %q (supported: %s)", country, strings.Join(ibanCountries(), ", "))
+	}
+	return &FakerIBAN{country: country, format: format}, nil
+}
+
+func (t *FakerIBAN) Name() string { return "FakerIBAN" }
+
+func (t *FakerIBAN) Transform(value any, row RowContext) (any, error) {
+	const (
+		digits  = "0123456789"
+		letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	)
+	rng := DeterministicRand(row)
+	var bban strings.Builder
+	n := 0
+	for _, ch := range t.format {
+		if ch >= '0' && ch <= '9' {
+			n = n*10 + int(ch-'0')
+			continue
+		}
+		alphabet := digits
+		switch ch {
+		case 'a':
+			alphabet = letters
+		case 'c':
+			alphabet = digits + letters
+		}
+		for ; n > 0; n-- {
+			bban.WriteByte(alphabet[rng.IntN(len(alphabet))])
+		}
+	}
+	check := 98 - ibanMod97(bban.String()+t.country+"00")
+	return fmt.Sprintf("%s%02d%s", t.country, check, bban.String()), nil
+}
+
+// ValidIBAN reports whether s (spaces allowed) has the shape of an IBAN
+// and its check digits verify.
+func ValidIBAN(s string) bool {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isDigit := c >= '0' && c <= '9'
+		isLetter := c >= 'A' && c <= 'Z'
+		switch {
+		case i < 2 && !isLetter, i >= 2 && i < 4 && !isDigit, !isDigit && !isLetter:
+			return false
+		}
+	}
+	return ibanMod97(s[4:]+s[:4]) == 1
+}
+
+// ibanMod97 computes the ISO 7064 MOD 97-10 remainder of s with letters
+// expanded to two digits (A=10 ... Z=35).
+func ibanMod97(s string) int {
+	var b strings.Builder
+	for _, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprintf(&b, "%d", c-'A'+10)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	n, ok := new(big.Int).SetString(b.String(), 10)
+	if !ok {
+		return -1
+	}
+	return int(new(big.Int).Mod(n, big.NewInt(97)).Int64())
+}
+
+func ibanCountries() []string {
+	out := make([]string, 0, len(ibanFormats))
+	for c := range ibanFormats {
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,90 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// JSONArrayMask applies a transformer to every element of a JSON array
+// held in a TEXT or BLOB value, descending into nested arrays, and writes
+// the array back as compact JSON. Objects and nulls inside the array are
+// kept as they are, and a value that is not a JSON array passes through
+// unchanged.
+type JSONArrayMask struct {
+	element Transformer
+}
+
+func NewJSONArrayMask(element Transformer) *JSONArrayMask {
+	return &JSONArrayMask{element: element}
+}
+
+func (t *JSONArrayMask) Name() string { return "JSONArrayMask" }
+
+// Transform masks each element in the context of the row with the
+// element's position appended to the key, so row-derived transformers
+// give the elements of one row different values.
+func (t *JSONArrayMask) Transform(value any, row RowContext) (any, error) {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return value, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var arr []any
+	if err := dec.Decode(&arr); err != nil || arr == nil || dec.More() {
+		return value, nil
+	}
+	masked, err := t.maskArray(arr, row)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(masked); err != nil {
+		return nil, fmt.Errorf("JSONArrayMask: %w", err)
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+func (t *JSONArrayMask) maskArray(arr []any, row RowContext) ([]any, error) {
+	out := make([]any, len(arr))
+	for i, elem := range arr {
+		elemRow := row
+		elemRow.PK = append(append(make([]any, 0, len(row.PK)+1), row.PK...), int64(i))
+		switch v := elem.(type) {
+		case nil, map[string]any:
+			out[i] = v
+			continue
+		case []any:
+			nested, err := t.maskArray(v, elemRow)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = nested
+			continue
+		case json.Number:
+			if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				elem = n
+			} else if f, err := v.Float64(); err == nil {
+				elem = f
+			}
+		}
+		masked, err := t.element.Transform(elem, elemRow)
+		if err != nil {
+			return nil, err
+		}
+		if b, ok := masked.([]byte); ok {
+			masked = string(b)
+		}
+		out[i] = masked
+	}
+	return out, nil
+}
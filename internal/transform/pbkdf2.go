@@ -0,0 +1,60 @@
+package transform
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// defaultPbkdf2Iterations is the round count when params.iterations is
+// not set.
+const defaultPbkdf2Iterations = 10000
+
+// defaultPbkdf2Cache is the number of digests a Pbkdf2 column keeps when
+// params.cache is not set.
+const defaultPbkdf2Cache = 1 << 16
+
+// Pbkdf2 is the hex PBKDF2-HMAC-SHA256 key derived from the value with the
+// salt, RFC 8018. Every derivation runs the configured number of HMAC
+// rounds, so guessing a low-entropy original costs as much per guess.
+type Pbkdf2 struct {
+	salt       []byte
+	iterations int
+	maxLen     int
+}
+
+func NewPbkdf2(salt string, iterations, maxLen int) *Pbkdf2 {
+	return &Pbkdf2{salt: []byte(salt), iterations: iterations, maxLen: maxLen}
+}
+
+func (t *Pbkdf2) Name() string { return "Pbkdf2" }
+
+func (t *Pbkdf2) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	out := hex.EncodeToString(pbkdf2SHA256([]byte(CanonicalString(value)), t.salt, t.iterations))
+	if t.maxLen > 0 && t.maxLen < len(out) {
+		out = out[:t.maxLen]
+	}
+	return out, nil
+}
+
+// pbkdf2SHA256 derives a single 32-byte block, which is all a SHA-256
+// output needs.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
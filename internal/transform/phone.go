@@ -0,0 +1,81 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// phoneLocale describes mobile numbers of a country: the national
+// significant number is one of prefixes followed by digits random digits.
+// groups splits trunk+number for the national layout.
+type phoneLocale struct {
+	country  string
+	trunk    string
+	prefixes []string
+	digits   int
+	groups   []int
+}
+
+var phoneLocales = map[string]*phoneLocale{
+	"de_DE": {country: "49", trunk: "0", prefixes: []string{"151", "152", "157", "159", "160", "162", "163", "170", "171", "172", "173", "174", "175", "176", "177", "178", "179"}, digits: 7, groups: []int{4, 7}},
+	"en_GB": {country: "44", trunk: "0", prefixes: []string{"71", "72", "73", "74", "75", "77", "78", "79"}, digits: 8, groups: []int{5, 6}},
+	"es_ES": {country: "34", prefixes: []string{"6", "7"}, digits: 8, groups: []int{3, 3, 3}},
+	"fr_FR": {country: "33", trunk: "0", prefixes: []string{"6", "7"}, digits: 8, groups: []int{2, 2, 2, 2, 2}},
+	"it_IT": {country: "39", prefixes: []string{"320", "324", "327", "328", "329", "330", "331", "333", "334", "335", "336", "337", "338", "339", "340", "342", "345", "346", "347", "348", "349"}, digits: 7, groups: []int{3, 7}},
+}
+
+func NewFakerPhone(locale, format string) (*FakerPhone, error) {
+	t := &FakerPhone{}
+	switch strings.ToLower(format) {
+	case "", "national":
+	case "e164":
+		t.e164 = true
+	default:
+		return nil, fmt.Errorf("FakerPhone: unknown format %q (expected national or e164)", format)
+	}
+	key := strings.ReplaceAll(locale, "-", "_")
+	switch strings.ToLower(key) {
+	case "", "en", "en_us":
+		return t, nil
+	}
+	for name, loc := range phoneLocales {
+		if strings.EqualFold(name, key) {
+			t.locale = loc
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("FakerPhone: unsupported locale %q (supported: en_US, %s)", locale, strings.Join(phoneLocaleNames(), ", "))
+}
+
+func (l *phoneLocale) format(n uint64, e164 bool) string {
+	prefix := l.prefixes[n%uint64(len(l.prefixes))]
+	number := fmt.Sprintf("%s%0*d", prefix, l.digits, n/uint64(len(l.prefixes)))
+	if e164 {
+		return "+" + l.country + number
+	}
+	national := l.trunk + number
+	parts := make([]string, 0, len(l.groups))
+	for _, g := range l.groups {
+		parts = append(parts, national[:g])
+		national = national[g:]
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *phoneLocale) space() uint64 {
+	n := uint64(len(l.prefixes))
+	for i := 0; i < l.digits; i++ {
+		n *= 10
+	}
+	return n
+}
+
+func phoneLocaleNames() []string {
+	out := make([]string, 0, len(phoneLocales))
+	for name := range phoneLocales {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -10,3 +10,10 @@ func LoadPlugins(paths []string) error {
 	}
 	return fmt.Errorf("plugins are only supported on linux and darwin")
 }
+
+func LoadPluginsBestEffort(paths []string) []error {
+	if err := LoadPlugins(paths); err != nil {
+		return []error{err}
+	}
+	return nil
+}
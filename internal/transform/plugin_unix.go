@@ -12,30 +12,63 @@ import (
 	"strings"
 )
 
+// LoadPlugins registers the transformers of every plugin in paths, each a
+// .so file or a directory of them, and fails on the first that cannot be
+// loaded.
 func LoadPlugins(paths []string) error {
+	_, err := loadPlugins(paths, false)
+	return err
+}
+
+// LoadPluginsBestEffort is LoadPlugins that skips the plugins that cannot
+// be found, opened, or registered, such as stale builds for another
+// architecture, and returns why each one was skipped.
+func LoadPluginsBestEffort(paths []string) []error {
+	skipped, _ := loadPlugins(paths, true)
+	return skipped
+}
+
+func loadPlugins(paths []string, bestEffort bool) ([]error, error) {
+	var skipped []error
+	fail := func(err error) error {
+		if !bestEffort {
+			return err
+		}
+		skipped = append(skipped, err)
+		return nil
+	}
 	for _, path := range paths {
 		if path == "" {
 			continue
 		}
 		resolved, err := resolvePluginPaths(path)
 		if err != nil {
-			return err
+			if err := fail(err); err != nil {
+				return nil, err
+			}
+			continue
 		}
 		for _, pluginPath := range resolved {
-			p, err := plugin.Open(pluginPath)
-			if err != nil {
-				return fmt.Errorf("open plugin %s: %w", pluginPath, err)
-			}
-			sym, err := p.Lookup("Transformers")
-			if err != nil {
-				return fmt.Errorf("plugin %s: missing Transformers symbol", pluginPath)
-			}
-			if err := registerPluginSymbol(pluginPath, sym); err != nil {
-				return err
+			if err := loadPlugin(pluginPath); err != nil {
+				if err := fail(err); err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
-	return nil
+	return skipped, nil
+}
+
+func loadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("open plugin %s: %w", path, err)
+	}
+	sym, err := p.Lookup("Transformers")
+	if err != nil {
+		return fmt.Errorf("plugin %s: missing Transformers symbol", path)
+	}
+	return registerPluginSymbol(path, sym)
 }
 
 func resolvePluginPaths(path string) ([]string, error) {
@@ -102,12 +135,12 @@ func registerPluginSymbol(path string, sym any) error {
 	switch v := sym.(type) {
 	case map[string]func(any, map[string]any) (any, error):
 		for name, fn := range v {
-			registerPlugin(name, fn)
+			registerPlugin(path, name, fn)
 		}
 		return nil
 	case *map[string]func(any, map[string]any) (any, error):
 		for name, fn := range *v {
-			registerPlugin(name, fn)
+			registerPlugin(path, name, fn)
 		}
 		return nil
 	default:
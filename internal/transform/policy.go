@@ -0,0 +1,58 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PluginsEntry in a Policy list stands for every transformer loaded from a
+// plugin, whatever its name.
+const PluginsEntry = "@plugins"
+
+// Policy limits the transformers Build constructs, by name, compared
+// without case. With Allow set, only the listed transformers are built;
+// Deny lists the ones that never are and wins over Allow.
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+var policies []Policy
+
+// SetPolicy replaces the policies Build enforces. A transformer is built
+// only when every policy allows it, so a policy from the command line
+// cannot be widened by one from a config file.
+func SetPolicy(p ...Policy) {
+	policies = p
+}
+
+// Allowed reports an error when a policy set with SetPolicy forbids the
+// transformer named name.
+func Allowed(name string) error {
+	for _, p := range policies {
+		if err := p.check(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p Policy) check(name string) error {
+	key := strings.ToLower(name)
+	plugin := registryInfo[key].Source == "plugin"
+	listed := func(list []string) bool {
+		for _, entry := range list {
+			if strings.EqualFold(entry, name) || (plugin && strings.EqualFold(entry, PluginsEntry)) {
+				return true
+			}
+		}
+		return false
+	}
+	if listed(p.Deny) {
+		return fmt.Errorf("%s: %w: denied", name, ErrDenied)
+	}
+	if len(p.Allow) > 0 && !listed(p.Allow) {
+		return fmt.Errorf("%s: %w: not in the allowed list", name, ErrDenied)
+	}
+	return nil
+}
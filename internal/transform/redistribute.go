@@ -0,0 +1,176 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxRedistributeDecimals caps the precision inferred from REAL values, whose
+// shortest representation can carry binary rounding noise.
+const maxRedistributeDecimals = 6
+
+// Redistribute replaces the numbers of a column with random amounts that
+// add up to the same total within each group of rows sharing the GroupBy
+// columns, e.g. the line items of an order. A value depends on its whole
+// group, so the new values are computed up front from every row of the
+// table by Load and looked up by primary key as rows stream through.
+type Redistribute struct {
+	groupBy  []string
+	decimals int
+	masked   map[string]any
+}
+
+// RedistributeRow is a row of the column to redistribute: its primary key
+// (or rowid), its GroupBy values, and the value.
+type RedistributeRow struct {
+	PK    []any
+	Group []any
+	Value any
+}
+
+// NewRedistribute keeps sums within the groups of groupBy. decimals is the
+// precision of the new amounts; a negative value infers it from the values,
+// so integers stay integers.
+func NewRedistribute(groupBy []string, decimals int) (*Redistribute, error) {
+	if len(groupBy) == 0 {
+		return nil, fmt.Errorf("Redistribute: params.group_by is required")
+	}
+	if decimals > maxRedistributeDecimals {
+		return nil, fmt.Errorf("Redistribute: params.decimals must be at most %d", maxRedistributeDecimals)
+	}
+	return &Redistribute{groupBy: groupBy, decimals: decimals}, nil
+}
+
+func (t *Redistribute) Name() string { return "Redistribute" }
+
+// GroupBy returns the columns whose values define a group.
+func (t *Redistribute) GroupBy() []string { return t.groupBy }
+
+// Load computes the new value of every row. Each group is shuffled with a
+// random stream derived from row's table, seed, and salt and the group's
+// values, so the result is deterministic. NULL values are left out and
+// stay NULL.
+func (t *Redistribute) Load(rows []RedistributeRow, row RowContext) error {
+	decimals := t.decimals
+	integers := true
+	for _, r := range rows {
+		switch v := r.Value.(type) {
+		case nil, int64:
+		case float64:
+			integers = false
+			if t.decimals < 0 {
+				decimals = max(decimals, floatDecimals(v))
+			}
+		default:
+			return fmt.Errorf("Redistribute: non-numeric value %v at key %v", r.Value, r.PK)
+		}
+	}
+	decimals = max(decimals, 0)
+	scale := math.Pow10(decimals)
+
+	groups := map[string][]RedistributeRow{}
+	for _, r := range rows {
+		if r.Value == nil {
+			continue
+		}
+		key := pkKey(r.Group)
+		groups[key] = append(groups[key], r)
+	}
+	t.masked = make(map[string]any, len(rows))
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return pkKey(group[i].PK) < pkKey(group[j].PK) })
+		units := make([]int64, len(group))
+		var total int64
+		for i, r := range group {
+			switch v := r.Value.(type) {
+			case int64:
+				units[i] = v * int64(scale)
+			case float64:
+				units[i] = int64(math.Round(v * scale))
+			}
+			total += units[i]
+		}
+		rng := DeterministicRand(RowContext{Table: "Redistribute:" + row.Table + "." + row.Column, PK: group[0].Group, Seed: row.Seed, Salt: row.Salt})
+		weights := make([]float64, len(group))
+		for i := range weights {
+			weights[i] = rng.ExpFloat64()
+		}
+		for i, u := range splitUnits(total, weights) {
+			var out any = float64(u) / scale
+			if integers && decimals == 0 {
+				out = u
+			}
+			t.masked[pkKey(group[i].PK)] = out
+		}
+	}
+	return nil
+}
+
+func (t *Redistribute) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if t.masked == nil {
+		return nil, fmt.Errorf("Redistribute: values were not loaded; it needs the whole table and only runs in copy")
+	}
+	out, ok := t.masked[pkKey(row.PK)]
+	if !ok {
+		return nil, fmt.Errorf("Redistribute: no value loaded for key %v", row.PK)
+	}
+	return out, nil
+}
+
+// splitUnits divides total into parts proportional to weights that add up
+// to total exactly, giving the units lost to rounding down to the parts
+// with the largest remainders. Every part has the sign of total.
+func splitUnits(total int64, weights []float64) []int64 {
+	sign := int64(1)
+	if total < 0 {
+		sign, total = -1, -total
+	}
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	parts := make([]int64, len(weights))
+	rems := make([]float64, len(weights))
+	left := total
+	for i, w := range weights {
+		ideal := float64(total) * w / sum
+		parts[i] = int64(math.Floor(ideal))
+		rems[i] = ideal - float64(parts[i])
+		left -= parts[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return rems[order[a]] > rems[order[b]] })
+	for i := 0; left > 0; i = (i + 1) % len(order) {
+		parts[order[i]]++
+		left--
+	}
+	for i := range parts {
+		parts[i] *= sign
+	}
+	return parts
+}
+
+// floatDecimals counts the decimals of v's shortest representation, capped
+// at maxRedistributeDecimals.
+func floatDecimals(v float64) int {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	_, frac, _ := strings.Cut(s, ".")
+	return min(len(frac), maxRedistributeDecimals)
+}
+
+func pkKey(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = CanonicalString(v)
+	}
+	return strings.Join(parts, "|")
+}
@@ -2,6 +2,7 @@ package transform
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/dyne/pinkmask/internal/config"
@@ -11,38 +12,157 @@ type PluginFunc func(value any, row map[string]any) (any, error)
 
 type Factory func(cfg *config.TransformConfig, salt string) (Transformer, error)
 
+type Info struct {
+	Name        string
+	Description string
+	Params      []string
+	Source      string
+	// Path is the file of the plugin that registered the transformer.
+	Path string
+}
+
 var registry = map[string]Factory{}
 
+var registryInfo = map[string]Info{}
+
 func Register(name string, factory Factory) {
-	if name == "" || factory == nil {
+	RegisterWithInfo(Info{Name: name, Source: "registered"}, factory)
+}
+
+func RegisterWithInfo(info Info, factory Factory) {
+	if info.Name == "" || factory == nil {
 		return
 	}
-	registry[strings.ToLower(name)] = factory
+	key := strings.ToLower(info.Name)
+	registry[key] = factory
+	registryInfo[key] = info
+}
+
+func registerPlugin(path, name string, fn PluginFunc) {
+	info := Info{Name: name, Description: "plugin transformer", Source: "plugin", Path: path}
+	RegisterWithInfo(info, func(cfg *config.TransformConfig, salt string) (Transformer, error) {
+		return &PluginTransformer{name: name, path: path, fn: fn, cfg: cfg}, nil
+	})
+}
+
+// PluginPath returns the file of the plugin that registered the
+// transformer name, or "" for a built-in or one registered in code.
+func PluginPath(name string) string {
+	return registryInfo[strings.ToLower(name)].Path
+}
+
+// Overrides lists the registered transformers that take the name of a
+// built-in, which they replace: the registry is consulted first.
+func Overrides() []Info {
+	var out []Info
+	for key, info := range registryInfo {
+		if builtin(key) {
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name)
+	})
+	return out
+}
+
+func builtin(name string) bool {
+	for _, b := range builtins {
+		if strings.EqualFold(b.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Known reports whether name is a built-in or registered transformer.
+func Known(name string) bool {
+	if _, ok := registry[strings.ToLower(name)]; ok {
+		return true
+	}
+	return builtin(name)
 }
 
-func registerPlugin(name string, fn PluginFunc) {
-	Register(name, func(cfg *config.TransformConfig, salt string) (Transformer, error) {
-		return &PluginTransformer{name: name, fn: fn, cfg: cfg}, nil
+// CheckTypes fails with ErrUnknownTransformer, naming every place, when
+// cfg uses a transformer type that is not Known, so a config relying on a
+// plugin that did not load is refused before anything is copied.
+func CheckTypes(cfg *config.Config) error {
+	var unknown []string
+	var check func(where string, tc *config.TransformConfig)
+	check = func(where string, tc *config.TransformConfig) {
+		if tc == nil {
+			return
+		}
+		if tc.Type != "" && !Known(tc.Type) {
+			unknown = append(unknown, fmt.Sprintf("%s (%s)", where, tc.Type))
+		}
+		for name, g := range tc.Groups {
+			check(where+".groups."+name, g)
+		}
+		check(where+".element", tc.Element)
+	}
+	for name, tbl := range cfg.Tables {
+		if tbl == nil {
+			continue
+		}
+		for col, tc := range tbl.Columns {
+			check(name+"."+col, tc)
+		}
+		for _, ac := range tbl.AddColumns {
+			check(name+"."+ac.Name, ac.Transform)
+		}
+	}
+	for affinity, tc := range cfg.ByType {
+		check("by_type."+affinity, tc)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("%w: %s", ErrUnknownTransformer, strings.Join(unknown, ", "))
+}
+
+func Catalog() []Info {
+	out := make([]Info, 0, len(builtins)+len(registryInfo))
+	for _, b := range builtins {
+		if _, ok := registryInfo[strings.ToLower(b.Name)]; ok {
+			continue
+		}
+		b.Source = "builtin"
+		out = append(out, b)
+	}
+	for _, info := range registryInfo {
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name)
 	})
+	return out
 }
 
 type PluginTransformer struct {
 	name string
+	path string
 	fn   PluginFunc
 	cfg  *config.TransformConfig
 }
 
 func (t *PluginTransformer) Name() string { return t.name }
 
+// Path is the file of the plugin the transformer comes from.
+func (t *PluginTransformer) Path() string { return t.path }
+
 func (t *PluginTransformer) Transform(value any, row RowContext) (any, error) {
 	if t.fn == nil {
 		return nil, fmt.Errorf("plugin transformer %s not initialized", t.name)
 	}
 	ctx := map[string]any{
-		"table": row.Table,
-		"pk":    row.PK,
-		"seed":  row.Seed,
-		"salt":  row.Salt,
+		"table":       row.Table,
+		"pk":          row.PK,
+		"seed":        row.Seed,
+		"salt":        row.Salt,
+		"column":      row.Column,
+		"column_type": row.ColumnType,
 		"config": map[string]any{
 			"type":         cfgString(t.cfg, "Type"),
 			"params":       cfgParams(t.cfg),
@@ -51,6 +171,7 @@ func (t *PluginTransformer) Transform(value any, row RowContext) (any, error) {
 			"replace":      cfgString(t.cfg, "Replace"),
 			"locale":       cfgString(t.cfg, "Locale"),
 			"maxlen":       cfgMaxLen(t.cfg),
+			"maxlen_unit":  cfgString(t.cfg, "MaxLenUnit"),
 			"map":          cfgMap(t.cfg),
 			"lookup_table": cfgString(t.cfg, "LookupTable"),
 			"lookup_key":   cfgString(t.cfg, "LookupKey"),
@@ -73,6 +194,8 @@ func cfgString(cfg *config.TransformConfig, field string) string {
 		return cfg.Replace
 	case "Locale":
 		return cfg.Locale
+	case "MaxLenUnit":
+		return cfg.MaxLenUnit
 	case "LookupTable":
 		return cfg.LookupTable
 	case "LookupKey":
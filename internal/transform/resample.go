@@ -0,0 +1,65 @@
+package transform
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// MaxResampleKnots bounds how many quantiles of a column Resample keeps.
+const MaxResampleKnots = 1001
+
+// Resample replaces the numbers of a column with deterministic samples from
+// the column's own distribution, so histograms, ranges, and averages stay
+// realistic while a row's value no longer tells anything about the row. The
+// distribution is given to Load as evenly spaced quantiles of the input
+// column, computed in a pass over the table before it is copied.
+type Resample struct {
+	interpolate bool
+	knots       []float64
+	integers    bool
+}
+
+// NewResample draws from the loaded quantiles. With interpolate, a sample
+// falls anywhere between two neighboring quantiles, rounded for integer
+// columns; otherwise it is one of the quantiles, so only values present in
+// the input come out.
+func NewResample(interpolate bool) *Resample {
+	return &Resample{interpolate: interpolate}
+}
+
+func (t *Resample) Name() string { return "Resample" }
+
+// Load sets the distribution: knots are quantiles of the column in
+// ascending order, and integers reports whether every value was an
+// integer, so the samples are integers too.
+func (t *Resample) Load(knots []float64, integers bool) {
+	t.knots = knots
+	t.integers = integers
+}
+
+func (t *Resample) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if t.knots == nil {
+		return nil, fmt.Errorf("Resample: distribution was not loaded; it needs the whole table and only runs in copy")
+	}
+	// Key the stream by column too, so two resampled columns of a row are
+	// drawn independently.
+	sum := RowHash(RowContext{Table: "Resample:" + row.Table + "." + row.Column, PK: row.PK, Seed: row.Seed, Salt: row.Salt})
+	u := float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+	n := len(t.knots)
+	var v float64
+	if !t.interpolate || n == 1 {
+		v = t.knots[min(int(u*float64(n)), n-1)]
+	} else {
+		pos := u * float64(n-1)
+		i := int(pos)
+		v = t.knots[i] + (pos-float64(i))*(t.knots[i+1]-t.knots[i])
+	}
+	if t.integers {
+		return int64(math.Round(v)), nil
+	}
+	return v, nil
+}
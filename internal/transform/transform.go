@@ -4,19 +4,33 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base32"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"io"
+	"math"
+	"math/rand/v2"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// RowContext identifies the row being masked. Column and ColumnType name
+// the column the transformer is applied to and its declared type; they are
+// informational and, unlike the other fields, do not feed RowHash.
 type RowContext struct {
-	Table string
-	PK    []any
-	Seed  int64
-	Salt  string
+	Table      string
+	PK         []any
+	Seed       int64
+	Salt       string
+	Column     string
+	ColumnType string
+	// Ordinal is the 1-based position of the row in the table's copy
+	// order, or 0 outside a copy.
+	Ordinal int64
 }
 
 type Transformer interface {
@@ -39,7 +53,7 @@ func (t *HashSha256) Transform(value any, row RowContext) (any, error) {
 	if value == nil {
 		return nil, nil
 	}
-	str := fmt.Sprint(value)
+	str := CanonicalString(value)
 	data := []byte(t.salt + str)
 	sum := sha256.Sum256(data)
 	out := hex.EncodeToString(sum[:])
@@ -65,7 +79,7 @@ func (t *HmacSha256) Transform(value any, row RowContext) (any, error) {
 		return nil, nil
 	}
 	mac := hmac.New(sha256.New, t.key)
-	_, _ = mac.Write([]byte(fmt.Sprint(value)))
+	_, _ = mac.Write([]byte(CanonicalString(value)))
 	out := hex.EncodeToString(mac.Sum(nil))
 	if t.maxLen > 0 && t.maxLen < len(out) {
 		out = out[:t.maxLen]
@@ -87,7 +101,7 @@ func (t *StableTokenize) Transform(value any, row RowContext) (any, error) {
 	if value == nil {
 		return nil, nil
 	}
-	sum := sha256.Sum256([]byte(row.Salt + fmt.Sprint(value)))
+	sum := sha256.Sum256([]byte(row.Salt + CanonicalString(value)))
 	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
 	out := strings.ToLower(enc.EncodeToString(sum[:]))
 	if t.maxLen > 0 && t.maxLen < len(out) {
@@ -101,6 +115,9 @@ func (t *StableTokenize) Transform(value any, row RowContext) (any, error) {
 type RegexReplace struct {
 	re   *regexp.Regexp
 	repl string
+	// groups, when set, maps capture group indexes to the transformer that
+	// rewrites the group's text; the rest of each match is kept.
+	groups map[int]Transformer
 }
 
 func NewRegexReplace(pattern, repl string) (*RegexReplace, error) {
@@ -108,16 +125,120 @@ func NewRegexReplace(pattern, repl string) (*RegexReplace, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkReplaceRefs(re, repl); err != nil {
+		return nil, err
+	}
 	return &RegexReplace{re: re, repl: repl}, nil
 }
 
+// NewRegexReplaceGroups rewrites the named capture groups of every match of
+// pattern with their transformer, leaving the text around them untouched.
+func NewRegexReplaceGroups(pattern string, groups map[string]Transformer) (*RegexReplace, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	byIndex := make(map[int]Transformer, len(groups))
+	for name, tr := range groups {
+		idx := re.SubexpIndex(name)
+		if idx < 0 {
+			return nil, fmt.Errorf("groups: pattern has no group named %q", name)
+		}
+		byIndex[idx] = tr
+	}
+	return &RegexReplace{re: re, groups: byIndex}, nil
+}
+
+// checkReplaceRefs rejects $n and ${name} references to groups the pattern
+// does not have, which regexp expands to empty strings. It parses the
+// replacement the way regexp.Expand does, so "$1x" refers to a group named
+// "1x" and "$$" is a literal dollar.
+func checkReplaceRefs(re *regexp.Regexp, repl string) error {
+	names := map[string]bool{}
+	for _, n := range re.SubexpNames() {
+		if n != "" {
+			names[n] = true
+		}
+	}
+	for i := 0; i < len(repl); i++ {
+		if repl[i] != '$' || i+1 == len(repl) {
+			continue
+		}
+		if repl[i+1] == '$' {
+			i++
+			continue
+		}
+		rest := repl[i+1:]
+		braced := rest[0] == '{'
+		if braced {
+			rest = rest[1:]
+		}
+		n := 0
+		for n < len(rest) {
+			r, size := utf8.DecodeRuneInString(rest[n:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			n += size
+		}
+		if n == 0 || (braced && (n == len(rest) || rest[n] != '}')) {
+			continue
+		}
+		name := rest[:n]
+		if idx, err := strconv.Atoi(name); err == nil {
+			if idx > re.NumSubexp() {
+				return fmt.Errorf("replace references group $%s but the pattern has %d", name, re.NumSubexp())
+			}
+		} else if !names[name] {
+			hint := ""
+			if digits := len(name) - len(strings.TrimLeft(name, "0123456789")); !braced && digits > 0 {
+				hint = fmt.Sprintf(" (use ${%s}%s for a numbered group followed by text)", name[:digits], name[digits:])
+			}
+			return fmt.Errorf("replace references unknown group %q%s", name, hint)
+		}
+		i += n
+		if braced {
+			i += 2
+		}
+	}
+	return nil
+}
+
 func (t *RegexReplace) Name() string { return "RegexReplace" }
 
 func (t *RegexReplace) Transform(value any, row RowContext) (any, error) {
 	if value == nil {
 		return nil, nil
 	}
-	return t.re.ReplaceAllString(fmt.Sprint(value), t.repl), nil
+	if t.groups == nil {
+		return t.re.ReplaceAllString(CanonicalString(value), t.repl), nil
+	}
+	s := CanonicalString(value)
+	var b strings.Builder
+	pos := 0
+	for _, m := range t.re.FindAllStringSubmatchIndex(s, -1) {
+		// Groups are visited in pattern order, which is also the order of
+		// their start offsets; a group nested in one already rewritten is
+		// skipped.
+		for idx := 1; idx < len(m)/2; idx++ {
+			tr := t.groups[idx]
+			start, end := m[2*idx], m[2*idx+1]
+			if tr == nil || start < pos {
+				continue
+			}
+			out, err := tr.Transform(s[start:end], row)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %w", t.re.SubexpNames()[idx], err)
+			}
+			b.WriteString(s[pos:start])
+			if out != nil {
+				b.WriteString(CanonicalString(out))
+			}
+			pos = end
+		}
+	}
+	b.WriteString(s[pos:])
+	return b.String(), nil
 }
 
 type SetNull struct{}
@@ -148,20 +269,220 @@ func (t *MapReplace) Transform(value any, row RowContext) (any, error) {
 	if value == nil {
 		return nil, nil
 	}
-	s := fmt.Sprint(value)
+	s := CanonicalString(value)
 	if v, ok := t.m[s]; ok {
 		return v, nil
 	}
 	return s, nil
 }
 
-type FakerName struct{}
+// Choice replaces values with one of a fixed set of choices, picked per
+// row, optionally with weights. It keeps low-cardinality columns such as
+// statuses realistic without copying the real distribution row by row.
+type Choice struct {
+	values []any
+	// cumulative holds the running sum of the weights, or is nil for a
+	// uniform pick.
+	cumulative []float64
+}
+
+// NewChoice picks uniformly from values, or by weights when weights is
+// non-nil; weights must match values, be non-negative, and not all zero.
+func NewChoice(values []any, weights []float64) (*Choice, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("Choice: params.choices must not be empty")
+	}
+	t := &Choice{values: values}
+	if weights == nil {
+		return t, nil
+	}
+	if len(weights) != len(values) {
+		return nil, fmt.Errorf("Choice: %d weights for %d choices", len(weights), len(values))
+	}
+	total := 0.0
+	t.cumulative = make([]float64, len(weights))
+	for i, w := range weights {
+		if w < 0 {
+			return nil, fmt.Errorf("Choice: weight of %v must not be negative", values[i])
+		}
+		total += w
+		t.cumulative[i] = total
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("Choice: weights must not all be zero")
+	}
+	return t, nil
+}
+
+func (t *Choice) Name() string { return "Choice" }
+
+func (t *Choice) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	rng := DeterministicRand(row)
+	if t.cumulative == nil {
+		return t.values[rng.IntN(len(t.values))], nil
+	}
+	r := rng.Float64() * t.cumulative[len(t.cumulative)-1]
+	for i, c := range t.cumulative {
+		if r < c {
+			return t.values[i], nil
+		}
+	}
+	return t.values[len(t.values)-1], nil
+}
+
+// Sequence numbers the rows of a table in copy order, e.g. user_0001,
+// user_0002, for columns whose values only need to be distinct and
+// readable.
+type Sequence struct {
+	format string
+	start  int64
+}
+
+// NewSequence counts from start. format is a fmt template with one integer
+// verb, such as "user_%04d"; an empty format yields the number itself.
+func NewSequence(format string, start int64) (*Sequence, error) {
+	if format != "" {
+		if out := fmt.Sprintf(format, start); strings.Contains(out, "%!") {
+			return nil, fmt.Errorf("Sequence: params.format %q must hold exactly one integer verb such as %%d", format)
+		}
+	}
+	return &Sequence{format: format, start: start}, nil
+}
+
+func (t *Sequence) Name() string { return "Sequence" }
+
+func (t *Sequence) Transform(value any, row RowContext) (any, error) {
+	if row.Ordinal == 0 {
+		return nil, fmt.Errorf("Sequence: the row's position is unknown; it only runs in copy")
+	}
+	n := t.start + row.Ordinal - 1
+	if t.format == "" {
+		return n, nil
+	}
+	return fmt.Sprintf(t.format, n), nil
+}
+
+type Redact struct {
+	char       string
+	keepLength bool
+	length     int
+}
+
+func NewRedact(char string, keepLength bool, length int) *Redact {
+	if char == "" {
+		char = "*"
+	}
+	if length <= 0 {
+		length = 8
+	}
+	return &Redact{char: char, keepLength: keepLength, length: length}
+}
+
+func (t *Redact) Name() string { return "Redact" }
+
+// Transform repeats char once per character of the value (bytes for
+// blobs), or a fixed number of times when keepLength is off. Non-string
+// values are measured by their text form.
+func (t *Redact) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if !t.keepLength {
+		return strings.Repeat(t.char, t.length), nil
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.Repeat(t.char, utf8.RuneCountInString(v)), nil
+	case []byte:
+		return strings.Repeat(t.char, len(v)), nil
+	default:
+		return strings.Repeat(t.char, utf8.RuneCountInString(CanonicalString(v))), nil
+	}
+}
+
+type Truncate struct {
+	inner  Transformer
+	maxLen int
+	runes  bool
+}
+
+func (t *Truncate) Name() string { return t.inner.Name() }
+
+func (t *Truncate) Transform(value any, row RowContext) (any, error) {
+	out, err := t.inner.Transform(value, row)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := out.(string)
+	if !ok {
+		return out, nil
+	}
+	if t.runes {
+		return TruncateRunes(s, t.maxLen), nil
+	}
+	return TruncateBytes(s, t.maxLen), nil
+}
+
+// Match applies inner only to the values whose text form matches re. Any
+// other value, NULL included, is kept as it is.
+type Match struct {
+	inner Transformer
+	re    *regexp.Regexp
+}
+
+func (t *Match) Name() string { return t.inner.Name() }
+
+func (t *Match) Transform(value any, row RowContext) (any, error) {
+	if value == nil || !t.re.MatchString(CanonicalString(value)) {
+		return value, nil
+	}
+	return t.inner.Transform(value, row)
+}
+
+func TruncateRunes(s string, n int) string {
+	if n <= 0 {
+		return s
+	}
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
+
+func TruncateBytes(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+type FakerName struct {
+	first, last []string
+}
 
-type FakerEmail struct{}
+type FakerEmail struct {
+	first, last, domains []string
+}
 
-type FakerAddress struct{}
+type FakerAddress struct {
+	streets, cities, states []string
+}
 
-type FakerPhone struct{}
+// FakerPhone defaults to US numbers in the national layout.
+type FakerPhone struct {
+	locale *phoneLocale
+	e164   bool
+}
 
 func (t *FakerName) Name() string    { return "FakerName" }
 func (t *FakerEmail) Name() string   { return "FakerEmail" }
@@ -170,39 +491,121 @@ func (t *FakerPhone) Name() string   { return "FakerPhone" }
 
 func (t *FakerName) Transform(value any, row RowContext) (any, error) {
 	rng := DeterministicRand(row)
-	first := firstNames[rng.Intn(len(firstNames))]
-	last := lastNames[rng.Intn(len(lastNames))]
+	first := pick(rng, t.first, firstNames)
+	last := pick(rng, t.last, lastNames)
 	return first + " " + last, nil
 }
 
 func (t *FakerEmail) Transform(value any, row RowContext) (any, error) {
 	rng := DeterministicRand(row)
-	first := strings.ToLower(firstNames[rng.Intn(len(firstNames))])
-	last := strings.ToLower(lastNames[rng.Intn(len(lastNames))])
-	domain := emailDomains[rng.Intn(len(emailDomains))]
-	return fmt.Sprintf("%s.%s@%s", first, last, domain), nil
+	first := strings.ToLower(pick(rng, t.first, firstNames))
+	last := strings.ToLower(pick(rng, t.last, lastNames))
+	domain := pick(rng, t.domains, emailDomains)
+	var raw [5]byte
+	token := uniqueIndex(row, emailTokenSpace)
+	for i := len(raw) - 1; i >= 0; i-- {
+		raw[i] = byte(token)
+		token >>= 8
+	}
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	return fmt.Sprintf("%s.%s.%s@%s", first, last, strings.ToLower(enc.EncodeToString(raw[:])), domain), nil
 }
 
 func (t *FakerAddress) Transform(value any, row RowContext) (any, error) {
 	rng := DeterministicRand(row)
-	num := rng.Intn(8999) + 100
-	street := streetNames[rng.Intn(len(streetNames))]
-	city := cityNames[rng.Intn(len(cityNames))]
-	state := stateCodes[rng.Intn(len(stateCodes))]
-	zip := rng.Intn(89999) + 10000
+	num := rng.IntN(8999) + 100
+	street := pick(rng, t.streets, streetNames)
+	city := pick(rng, t.cities, cityNames)
+	state := pick(rng, t.states, stateCodes)
+	zip := rng.IntN(89999) + 10000
 	return fmt.Sprintf("%d %s St, %s, %s %d", num, street, city, state, zip), nil
 }
 
+// pick draws from words, or from the built-in list when no custom list
+// was configured.
+func pick(rng *rand.Rand, words, builtin []string) string {
+	if len(words) == 0 {
+		words = builtin
+	}
+	return words[rng.IntN(len(words))]
+}
+
 func (t *FakerPhone) Transform(value any, row RowContext) (any, error) {
-	rng := DeterministicRand(row)
-	area := rng.Intn(800) + 200
-	prefix := rng.Intn(800) + 200
-	line := rng.Intn(9000) + 1000
-	return fmt.Sprintf("%d-%d-%d", area, prefix, line), nil
+	if t.locale != nil {
+		return t.locale.format(uniqueIndex(row, t.locale.space()), t.e164), nil
+	}
+	n := uniqueIndex(row, phoneSpace)
+	area := n%800 + 200
+	n /= 800
+	prefix := n%800 + 200
+	line := n / 800
+	if t.e164 {
+		return fmt.Sprintf("+1%d%d%04d", area, prefix, line), nil
+	}
+	return fmt.Sprintf("%d-%d-%04d", area, prefix, line), nil
+}
+
+// Pool draws a faker's values from a fixed pool: each row is hashed to one
+// of size slots and gets the value the faker gives that slot, so the column
+// has at most size distinct values. This gives up the per-row uniqueness
+// of FakerEmail and FakerPhone on purpose, for heavier anonymization.
+type Pool struct {
+	inner Transformer
+	size  uint64
+}
+
+func (t *Pool) Name() string { return t.inner.Name() }
+
+func (t *Pool) Transform(value any, row RowContext) (any, error) {
+	sum := RowHash(row)
+	slot := binary.BigEndian.Uint64(sum[:8]) % t.size
+	return t.inner.Transform(value, RowContext{
+		Table:      row.Table,
+		PK:         []any{int64(slot)},
+		Seed:       row.Seed,
+		Salt:       row.Salt,
+		Column:     row.Column,
+		ColumnType: row.ColumnType,
+	})
+}
+
+type IntPermute struct {
+	group string
+	max   uint64
+}
+
+func NewIntPermute(group string, max uint64) *IntPermute {
+	if max == 0 {
+		max = intPermuteSpace
+	}
+	return &IntPermute{group: group, max: max}
+}
+
+func (t *IntPermute) Name() string { return "IntPermute" }
+
+// Transform maps the integer through a permutation keyed by the salt, seed
+// and group only, never by the row, so a key and every foreign key
+// referencing it map to the same new integer.
+func (t *IntPermute) Transform(value any, row RowContext) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	v, ok := asUint(value)
+	if !ok || v >= t.max {
+		return nil, fmt.Errorf("IntPermute: %v is not an integer in [0, %d)", value, t.max)
+	}
+	key := RowHash(RowContext{Table: "IntPermute:" + t.group, Seed: row.Seed, Salt: row.Salt})
+	return int64(permute(key[:], v, t.max)), nil
 }
 
 type DateShift struct {
 	maxDays int
+	// keepTime moves the date by calendar days, keeping the clock time
+	// even where a day is not 24 hours long.
+	keepTime bool
+	// maxSeconds, when set, replaces the day shift with one of up to
+	// that many seconds either way.
+	maxSeconds int
 }
 
 func NewDateShift(maxDays int) *DateShift {
@@ -212,25 +615,50 @@ func NewDateShift(maxDays int) *DateShift {
 	return &DateShift{maxDays: maxDays}
 }
 
+// NewDateShiftKeepTime shifts the date only: the clock time stays the same.
+func NewDateShiftKeepTime(maxDays int) *DateShift {
+	t := NewDateShift(maxDays)
+	t.keepTime = true
+	return t
+}
+
+// NewTimeShift moves values by up to maxSeconds either way, so they keep
+// roughly their time while the exact instant is hidden. Dates without a
+// time are kept.
+func NewTimeShift(maxSeconds int) *DateShift {
+	return &DateShift{maxSeconds: maxSeconds}
+}
+
 func (t *DateShift) Name() string { return "DateShift" }
 
+// shift returns the function moving a time for the row.
+func (t *DateShift) shift(row RowContext) func(time.Time) time.Time {
+	rng := DeterministicRand(row)
+	if t.maxSeconds > 0 {
+		d := time.Duration(rng.IntN(t.maxSeconds*2+1)-t.maxSeconds) * time.Second
+		return func(ts time.Time) time.Time { return ts.Add(d) }
+	}
+	days := rng.IntN(t.maxDays*2+1) - t.maxDays
+	if t.keepTime {
+		return func(ts time.Time) time.Time { return ts.AddDate(0, 0, days) }
+	}
+	d := time.Duration(days) * 24 * time.Hour
+	return func(ts time.Time) time.Time { return ts.Add(d) }
+}
+
 func (t *DateShift) Transform(value any, row RowContext) (any, error) {
 	if value == nil {
 		return nil, nil
 	}
-	shiftDays := DeterministicRand(row).Intn(t.maxDays*2+1) - t.maxDays
-	shift := time.Duration(shiftDays) * 24 * time.Hour
+	shift := t.shift(row)
 	switch v := value.(type) {
 	case time.Time:
-		return v.Add(shift), nil
+		return shift(v), nil
 	case int64:
-		return time.Unix(v, 0).Add(shift).Unix(), nil
+		return shift(time.Unix(v, 0).UTC()).Unix(), nil
 	case string:
-		if ts, err := time.Parse(time.RFC3339, v); err == nil {
-			return ts.Add(shift).Format(time.RFC3339), nil
-		}
-		if ts, err := time.Parse("2006-01-02", v); err == nil {
-			return ts.Add(shift).Format("2006-01-02"), nil
+		if ts, layout, ok := parseDateTime(v); ok && !(t.maxSeconds > 0 && layout == "2006-01-02") {
+			return shift(ts).Format(layout), nil
 		}
 		return v, nil
 	default:
@@ -238,26 +666,207 @@ func (t *DateShift) Transform(value any, row RowContext) (any, error) {
 	}
 }
 
+// parseDateTime parses the date and time text forms SQLite's date
+// functions accept: a date, optionally followed by a space or T, a time
+// with minutes, seconds, or fractional seconds, and a Z or ±HH:MM zone. It
+// returns the layout that formats a time back in the same form, fraction
+// width included.
+func parseDateTime(s string) (time.Time, string, bool) {
+	if ts, err := time.Parse("2006-01-02", s); err == nil {
+		return ts, "2006-01-02", true
+	}
+	if len(s) < len("2006-01-02T15:04") {
+		return time.Time{}, "", false
+	}
+	frac := ""
+	if len(s) > 19 && s[19] == '.' {
+		n := 20
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		frac = "." + strings.Repeat("0", n-20)
+	}
+	for _, sep := range []string{" ", "T"} {
+		for _, clock := range []string{"15:04:05" + frac, "15:04:05", "15:04"} {
+			for _, zone := range []string{"", "Z07:00"} {
+				layout := "2006-01-02" + sep + clock + zone
+				if ts, err := time.Parse(layout, s); err == nil {
+					return ts, layout, true
+				}
+			}
+		}
+	}
+	return time.Time{}, "", false
+}
+
+const (
+	emailTokenSpace = 1 << 40
+	phoneSpace      = 800 * 800 * 10000
+	intPermuteSpace = 1 << 62
+)
+
+// uniqueIndex maps the row to a value in [0, n). Rows with a single
+// non-negative integer key below n go through a keyed permutation, so
+// distinct keys never collide; any other key falls back to the row hash.
+func uniqueIndex(row RowContext, n uint64) uint64 {
+	if len(row.PK) == 1 {
+		if id, ok := asUint(row.PK[0]); ok && id < n {
+			key := RowHash(RowContext{Table: row.Table, Seed: row.Seed, Salt: row.Salt})
+			return permute(key[:], id, n)
+		}
+	}
+	sum := RowHash(row)
+	return binary.BigEndian.Uint64(sum[:8]) % n
+}
+
+func asUint(v any) (uint64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return uint64(t), t >= 0
+	case int:
+		return uint64(t), t >= 0
+	case int32:
+		return uint64(t), t >= 0
+	case uint64:
+		return t, true
+	default:
+		return 0, false
+	}
+}
+
+// permute is a keyed bijection on [0, n): a balanced Feistel network over
+// the smallest even bit width covering n, cycle-walking until the result
+// falls back inside the range.
+func permute(key []byte, x, n uint64) uint64 {
+	bits := uint(2)
+	for uint64(1)<<bits < n {
+		bits += 2
+	}
+	half := bits / 2
+	mask := uint64(1)<<half - 1
+	for {
+		l, r := x>>half, x&mask
+		for round := byte(0); round < 4; round++ {
+			h := sha256.New()
+			_, _ = h.Write(key)
+			var buf [9]byte
+			buf[0] = round
+			binary.BigEndian.PutUint64(buf[1:], r)
+			_, _ = h.Write(buf[:])
+			f := binary.BigEndian.Uint64(h.Sum(nil)[:8]) & mask
+			l, r = r, l^f
+		}
+		x = l<<half | r
+		if x < n {
+			return x
+		}
+	}
+}
+
 func DeterministicRand(row RowContext) *rand.Rand {
-	seed := RowSeed(row)
-	return rand.New(rand.NewSource(seed))
+	return rand.New(rand.NewChaCha8(RowHash(row)))
 }
 
-func RowSeed(row RowContext) int64 {
+func RowHash(row RowContext) [32]byte {
 	h := sha256.New()
-	_, _ = h.Write([]byte(row.Salt))
-	_, _ = h.Write([]byte(row.Table))
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], uint64(row.Seed))
+	_, _ = h.Write(seed[:])
+	writeField(h, row.Salt)
+	writeField(h, row.Table)
 	for _, v := range row.PK {
-		_, _ = h.Write([]byte(fmt.Sprint(v)))
+		writeField(h, CanonicalString(v))
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// DeriveSalt returns the salt of the given version of a base salt: the hex
+// HMAC-SHA256 of the version keyed by the base salt. Every mask depends on
+// the salt, so each version gives a distinct but reproducible dataset, and
+// masks of different versions cannot be linked without the base salt.
+// Version 0 is the base salt itself.
+func DeriveSalt(base string, version int) string {
+	if version == 0 {
+		return base
+	}
+	mac := hmac.New(sha256.New, []byte(base))
+	fmt.Fprintf(mac, "pinkmask salt version %d", version)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MixSeed returns the seed of a table with its own seed: a hash of the
+// global seed and the table's, so changing either rotates the table's
+// masks. A table seed of 0 leaves the global seed as is.
+func MixSeed(seed, tableSeed int64) int64 {
+	if tableSeed == 0 {
+		return seed
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("pinkmask table seed %d %d", seed, tableSeed)))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// CanonicalString is the one text form of a value that keys, row
+// identities, hashes, and map lookups are built from, so they agree however
+// the driver scanned the value. It is fmt.Sprint except for:
+//   - floats holding an integer, written as that integer, so 1e8 stored as
+//     REAL and 100000000 stored as INTEGER, which SQLite considers equal,
+//     mask alike;
+//   - blobs, written as their bytes rather than as a list of numbers;
+//   - booleans, written 1 and 0 as SQLite stores them;
+//   - times, which the driver parses from DATE, DATETIME, and TIMESTAMP
+//     columns, written in SQLite's date and time format, so they match
+//     the same text in a column declared otherwise.
+//
+// NULL is "<nil>".
+func CanonicalString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v, 64)
+	case float32:
+		return formatFloat(float64(v), 32)
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return formatTime(v)
 	}
-	var seed int64
-	for _, b := range h.Sum(nil)[:8] {
-		seed = (seed << 8) | int64(b)
+	return fmt.Sprint(v)
+}
+
+// formatTime writes t as SQLite's date() when it is a UTC midnight and as
+// its datetime(), with fractional seconds and offset when set, otherwise.
+func formatTime(t time.Time) string {
+	if _, offset := t.Zone(); offset == 0 {
+		if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+			return t.Format("2006-01-02")
+		}
+		return t.Format("2006-01-02 15:04:05.999999999")
 	}
-	if seed == 0 {
-		seed = row.Seed
+	return t.Format("2006-01-02 15:04:05.999999999-07:00")
+}
+
+func formatFloat(f float64, bits int) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return strconv.FormatInt(int64(f), 10)
 	}
-	return seed ^ row.Seed
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+func writeField(w io.Writer, s string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(s)))
+	_, _ = w.Write(n[:])
+	_, _ = io.WriteString(w, s)
 }
 
 var firstNames = []string{"Alex", "Jamie", "Taylor", "Jordan", "Morgan", "Casey", "Riley", "Avery", "Parker", "Reese"}
@@ -0,0 +1,86 @@
+package transform
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkTransformers(b *testing.B) {
+	regex, err := NewRegexReplace("[0-9]+", "X")
+	if err != nil {
+		b.Fatal(err)
+	}
+	cases := []struct {
+		tr    Transformer
+		value any
+	}{
+		{NewHashSha256("salt", 0), "user42@example.com"},
+		{NewHmacSha256("salt", 24), "user42@example.com"},
+		{NewStableTokenize(12), "SKU-0042"},
+		{regex, "call 555 0142 after 9"},
+		{&SetNull{}, "secret"},
+		{NewSetValue("redacted"), "hunter2"},
+		{NewMapReplace(map[string]string{"pending": "open"}), "pending"},
+		{&FakerName{}, "Jane Doe"},
+		{&FakerEmail{}, "jane@example.com"},
+		{&FakerAddress{}, "1 Main St"},
+		{&FakerPhone{}, "555-0142"},
+		{NewDateShift(30), "2024-03-01T10:00:00Z"},
+	}
+	for _, tc := range cases {
+		b.Run(tc.tr.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				row := RowContext{Table: "users", PK: []any{int64(i)}, Seed: 1, Salt: "salt"}
+				if _, err := tc.tr.Transform(tc.value, row); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRowHash(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		RowHash(RowContext{Table: "users", PK: []any{int64(i)}, Seed: 1, Salt: "salt"})
+	}
+}
+
+func BenchmarkDeterministicRand(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DeterministicRand(RowContext{Table: "users", PK: []any{int64(i)}, Seed: 1, Salt: "salt"}).IntN(10)
+	}
+}
+
+// BenchmarkPbkdf2 times one uncached derivation at the default rounds, the
+// per-row cost of a column whose values are all distinct.
+func BenchmarkPbkdf2(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pbkdf2SHA256([]byte("123-45-6789"), []byte("salt"), defaultPbkdf2Iterations)
+	}
+}
+
+// BenchmarkCache masks a low-cardinality column of 16 distinct values with
+// Pbkdf2, with and without a cache in front of it.
+func BenchmarkCache(b *testing.B) {
+	values := make([]string, 16)
+	for i := range values {
+		values[i] = fmt.Sprintf("123-45-%04d", i)
+	}
+	for _, size := range []int{0, 16} {
+		var tr Transformer = NewPbkdf2("salt", defaultPbkdf2Iterations, 0)
+		if size > 0 {
+			tr = NewCache(tr, size)
+		}
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := tr.Transform(values[i%len(values)], RowContext{PK: []any{int64(i)}}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,17 @@
 package transform
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/dyne/pinkmask/internal/config"
 )
 
 func TestDeterministicTransforms(t *testing.T) {
@@ -14,6 +24,7 @@ func TestDeterministicTransforms(t *testing.T) {
 		&FakerEmail{},
 		&FakerAddress{},
 		&FakerPhone{},
+		&FakerIBAN{country: "DE", format: ibanFormats["DE"]},
 		NewDateShift(7),
 	}
 	for _, tr := range cases {
@@ -45,3 +56,1028 @@ func TestRegexReplace(t *testing.T) {
 		t.Fatalf("unexpected output: %v", out)
 	}
 }
+
+func TestDeterministicRandSpread(t *testing.T) {
+	const n = 2000
+	const buckets = 10
+	counts := make([]int, buckets)
+	same := 0
+	prev := -1
+	for pk := 0; pk < n; pk++ {
+		row := RowContext{Table: "users", PK: []any{pk}, Seed: 42, Salt: "salt"}
+		v := DeterministicRand(row).IntN(buckets)
+		counts[v]++
+		if v == prev {
+			same++
+		}
+		prev = v
+	}
+	for i, c := range counts {
+		if c < n/buckets*7/10 || c > n/buckets*13/10 {
+			t.Fatalf("bucket %d has %d picks, expected about %d", i, c, n/buckets)
+		}
+	}
+	if same > n/buckets*13/10 {
+		t.Fatalf("adjacent PKs picked the same bucket %d times, expected about %d", same, n/buckets)
+	}
+}
+
+func TestMaxLenUnit(t *testing.T) {
+	row := RowContext{Table: "t", PK: []any{1}, Seed: 1, Salt: "s"}
+	cases := []struct {
+		unit string
+		want string
+	}{
+		{"", "hé"},
+		{"runes", "hé"},
+		{"bytes", "h"},
+	}
+	for _, tc := range cases {
+		tr, err := Build(&config.TransformConfig{Type: "SetValue", Value: "héllo", MaxLen: 2, MaxLenUnit: tc.unit}, "s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tr.Transform("x", row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != tc.want {
+			t.Fatalf("unit %q: got %q, want %q", tc.unit, out, tc.want)
+		}
+	}
+	if _, err := Build(&config.TransformConfig{Type: "SetValue", MaxLenUnit: "words"}, "s"); err == nil {
+		t.Fatal("expected error for invalid maxlen_unit")
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	Register("CatalogTest", func(cfg *config.TransformConfig, salt string) (Transformer, error) {
+		return &SetNull{}, nil
+	})
+	sources := map[string]string{}
+	for _, info := range Catalog() {
+		sources[info.Name] = info.Source
+	}
+	if sources["HashSha256"] != "builtin" {
+		t.Fatalf("HashSha256 missing from catalog: %v", sources)
+	}
+	if sources["CatalogTest"] != "registered" {
+		t.Fatalf("registered transformer missing from catalog: %v", sources)
+	}
+}
+
+func TestFakerUniqueness(t *testing.T) {
+	for _, tr := range []Transformer{&FakerEmail{}, &FakerPhone{}} {
+		seen := map[any]int64{}
+		for pk := int64(1); pk <= 5000; pk++ {
+			out, err := tr.Transform(nil, RowContext{Table: "users", PK: []any{pk}, Seed: 3, Salt: "salt"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prev, ok := seen[out]; ok {
+				t.Fatalf("%s: rows %d and %d both produced %v", tr.Name(), prev, pk, out)
+			}
+			seen[out] = pk
+		}
+	}
+}
+
+func TestFakerPoolSize(t *testing.T) {
+	for _, typ := range []string{"FakerEmail", "FakerPhone"} {
+		tr, err := Build(&config.TransformConfig{Type: typ, Params: map[string]any{"pool_size": 7}}, "salt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[any]bool{}
+		for pk := int64(1); pk <= 500; pk++ {
+			row := RowContext{Table: "users", PK: []any{pk}, Seed: 3, Salt: "salt"}
+			out, err := tr.Transform(nil, row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, _ := tr.Transform(nil, row)
+			if again != out {
+				t.Fatalf("%s: row %d not deterministic: %v then %v", typ, pk, out, again)
+			}
+			seen[out] = true
+		}
+		if len(seen) != 7 {
+			t.Fatalf("%s: %d distinct values, want 7", typ, len(seen))
+		}
+	}
+	for _, cfg := range []*config.TransformConfig{
+		{Type: "FakerName", Params: map[string]any{"pool_size": 0}},
+		{Type: "HashSha256", Params: map[string]any{"pool_size": 5}},
+	} {
+		if _, err := Build(cfg, "salt"); err == nil {
+			t.Fatalf("%s with pool_size %v: expected an error", cfg.Type, cfg.Params["pool_size"])
+		}
+	}
+}
+
+func TestMatchGuard(t *testing.T) {
+	tr, err := Build(&config.TransformConfig{Type: "Redact", Params: map[string]any{"match": `^[^@\s]+@[^@\s]+$`}}, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := RowContext{Table: "notes", PK: []any{int64(1)}}
+	for in, want := range map[any]any{
+		"ann@example.com":      "***************",
+		"call ann@example.com": "call ann@example.com",
+		int64(42):              int64(42),
+	} {
+		out, err := tr.Transform(in, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != want {
+			t.Fatalf("%v: got %v, want %v", in, out, want)
+		}
+	}
+	if out, _ := tr.Transform(nil, row); out != nil {
+		t.Fatalf("NULL changed to %v", out)
+	}
+	for _, cfg := range []*config.TransformConfig{
+		{Type: "HashSha256", Params: map[string]any{"match": "("}},
+		{Type: "HashSha256", Params: map[string]any{"match": 3}},
+		{Type: "Resample", Params: map[string]any{"match": "."}},
+	} {
+		if _, err := Build(cfg, "salt"); err == nil {
+			t.Fatalf("%s with match %v: expected an error", cfg.Type, cfg.Params["match"])
+		}
+	}
+}
+
+func TestFakerWordListFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.txt")
+	if err := os.WriteFile(path, []byte("# locale: it\nGiulia\n\nMarco\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tr, err := Build(&config.TransformConfig{Type: "FakerName", Params: map[string]any{"first_names_file": path}}, "salt")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		out, err := tr.Transform(nil, RowContext{Table: "users", PK: []any{i}})
+		if err != nil {
+			t.Fatalf("transform: %v", err)
+		}
+		first, _, _ := strings.Cut(out.(string), " ")
+		if first != "Giulia" && first != "Marco" {
+			t.Fatalf("unexpected first name %q", first)
+		}
+	}
+	if _, err := Build(&config.TransformConfig{Type: "FakerAddress", Params: map[string]any{"cities_file": filepath.Join(t.TempDir(), "missing.txt")}}, "salt"); err == nil {
+		t.Fatalf("expected error for missing word list")
+	}
+}
+
+func TestIntPermute(t *testing.T) {
+	tr := NewIntPermute("users", 1000)
+	row := RowContext{Table: "users", Seed: 1, Salt: "salt"}
+	seen := map[int64]bool{}
+	for i := int64(0); i < 1000; i++ {
+		out, err := tr.Transform(i, row)
+		if err != nil {
+			t.Fatalf("transform %d: %v", i, err)
+		}
+		v := out.(int64)
+		if v < 0 || v >= 1000 || seen[v] {
+			t.Fatalf("not a permutation: %d -> %d", i, v)
+		}
+		seen[v] = true
+		again, _ := tr.Transform(i, RowContext{Table: "orders", PK: []any{i}, Seed: 1, Salt: "salt"})
+		if again != out {
+			t.Fatalf("mapping depends on the row: %v vs %v", out, again)
+		}
+	}
+	if _, err := tr.Transform(int64(1000), row); err == nil {
+		t.Fatalf("expected error for value outside the domain")
+	}
+	if _, err := tr.Transform("abc", row); err == nil {
+		t.Fatalf("expected error for non-integer value")
+	}
+
+	for _, max := range []any{1000, 1000.0, 1 << 62} {
+		if _, err := Build(&config.TransformConfig{Type: "IntPermute", Params: map[string]any{"max": max}}, "salt"); err != nil {
+			t.Fatalf("params.max %v: %v", max, err)
+		}
+	}
+	// Above 2^62 the Feistel width would overflow, and a fractional max
+	// has no integer range to permute.
+	for _, max := range []any{1<<62 + 1, math.MaxInt64, 1e19, 1000.5, 0, "1000"} {
+		if _, err := Build(&config.TransformConfig{Type: "IntPermute", Params: map[string]any{"max": max}}, "salt"); err == nil || !strings.Contains(err.Error(), "params.max") {
+			t.Fatalf("params.max %v: expected an error, got %v", max, err)
+		}
+	}
+}
+
+func TestFakerIBAN(t *testing.T) {
+	if !ValidIBAN("DE89 3704 0044 0532 0130 00") || ValidIBAN("DE88 3704 0044 0532 0130 00") {
+		t.Fatalf("ValidIBAN disagrees with the reference example")
+	}
+	for _, country := range ibanCountries() {
+		tr, err := NewFakerIBAN(country)
+		if err != nil {
+			t.Fatalf("%s: %v", country, err)
+		}
+		for i := 0; i < 20; i++ {
+			out, _ := tr.Transform(nil, RowContext{Table: "accounts", PK: []any{i}})
+			iban := out.(string)
+			if !strings.HasPrefix(iban, country) || !ValidIBAN(iban) {
+				t.Fatalf("invalid IBAN for %s: %s", country, iban)
+			}
+		}
+	}
+	if _, err := NewFakerIBAN("XX"); err == nil {
+		t.Fatalf("expected error for unsupported country")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tr, err := Build(&config.TransformConfig{Type: "Redact"}, "")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	cases := []struct {
+		in   any
+		want any
+	}{
+		{"héllo", "*****"},
+		{[]byte{1, 2, 3}, "***"},
+		{int64(1234), "****"},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		got, err := tr.Transform(c.in, RowContext{})
+		if err != nil {
+			t.Fatalf("transform %v: %v", c.in, err)
+		}
+		if got != c.want {
+			t.Fatalf("redact %v: got %v, want %v", c.in, got, c.want)
+		}
+	}
+	fixed, err := Build(&config.TransformConfig{Type: "Redact", Params: map[string]any{"char": "#", "keep_length": false, "length": 4}}, "")
+	if err != nil {
+		t.Fatalf("build fixed: %v", err)
+	}
+	if got, _ := fixed.Transform("a much longer secret", RowContext{}); got != "####" {
+		t.Fatalf("fixed-width redact: got %v", got)
+	}
+}
+
+func TestFakerPhoneFormats(t *testing.T) {
+	cases := []struct {
+		locale, format, pattern string
+	}{
+		{"", "", `^[2-9]\d{2}-[2-9]\d{2}-\d{4}$`},
+		{"en_US", "e164", `^\+1[2-9]\d{2}[2-9]\d{6}$`},
+		{"de_DE", "", `^01[5-7]\d \d{7}$`},
+		{"de-DE", "e164", `^\+491[5-7]\d{8}$`},
+		{"en_GB", "", `^07\d{3} \d{6}$`},
+		{"fr_FR", "e164", `^\+33[67]\d{8}$`},
+		{"it_IT", "", `^3\d{2} \d{7}$`},
+		{"es_ES", "", `^[67]\d{2} \d{3} \d{3}$`},
+	}
+	for _, c := range cases {
+		tr, err := Build(&config.TransformConfig{Type: "FakerPhone", Locale: c.locale, Params: map[string]any{"format": c.format}}, "")
+		if err != nil {
+			t.Fatalf("%s/%s: %v", c.locale, c.format, err)
+		}
+		re := regexp.MustCompile(c.pattern)
+		seen := map[any]bool{}
+		for i := 0; i < 200; i++ {
+			out, _ := tr.Transform(nil, RowContext{Table: "users", PK: []any{int64(i)}})
+			if !re.MatchString(out.(string)) {
+				t.Fatalf("%s/%s: %q does not match %s", c.locale, c.format, out, c.pattern)
+			}
+			if seen[out] {
+				t.Fatalf("%s/%s: duplicate number %q", c.locale, c.format, out)
+			}
+			seen[out] = true
+		}
+	}
+	if _, err := NewFakerPhone("xx_XX", ""); err == nil {
+		t.Fatalf("expected error for unsupported locale")
+	}
+}
+
+func TestSetValueCoercesToAffinity(t *testing.T) {
+	cases := []struct {
+		colType string
+		value   any
+		want    any
+	}{
+		{"INTEGER", 0, int64(0)},
+		{"BIGINT", float64(3), int64(3)},
+		{"INTEGER", "42", int64(42)},
+		{"INTEGER", true, int64(1)},
+		{"REAL", 1, float64(1)},
+		{"DOUBLE", "2.5", 2.5},
+		{"NUMERIC", "7", int64(7)},
+		{"DECIMAL(10,2)", 1.25, 1.25},
+		{"TEXT", 5, "5"},
+		{"VARCHAR(10)", 1.5, "1.5"},
+		{"BLOB", 5, 5},
+		{"INTEGER", nil, nil},
+	}
+	for _, c := range cases {
+		tr, err := BuildForColumn(&config.TransformConfig{Type: "SetValue", Value: c.value}, "", c.colType)
+		if err != nil {
+			t.Fatalf("%s %v: %v", c.colType, c.value, err)
+		}
+		got, _ := tr.Transform("orig", RowContext{})
+		if got != c.want {
+			t.Fatalf("%s %v: got %#v, want %#v", c.colType, c.value, got, c.want)
+		}
+	}
+	for _, c := range []struct {
+		colType string
+		value   any
+	}{
+		{"INTEGER", "abc"},
+		{"INTEGER", 1.5},
+		{"REAL", "x"},
+		{"NUMERIC", "n/a"},
+	} {
+		if _, err := BuildForColumn(&config.TransformConfig{Type: "SetValue", Value: c.value}, "", c.colType); err == nil {
+			t.Fatalf("%s %v: expected error", c.colType, c.value)
+		}
+	}
+	if got := OutputTypeForColumn(&config.TransformConfig{Type: "SetValue", Value: "0"}, "INTEGER"); got != "INTEGER" {
+		t.Fatalf("output type: got %q", got)
+	}
+}
+
+func TestPluginContextColumn(t *testing.T) {
+	var got map[string]any
+	tr := &PluginTransformer{name: "Probe", fn: func(value any, ctx map[string]any) (any, error) {
+		got = ctx
+		return value, nil
+	}}
+	if _, err := tr.Transform("x", RowContext{Table: "users", Column: "email", ColumnType: "VARCHAR(64)"}); err != nil {
+		t.Fatal(err)
+	}
+	if got["column"] != "email" || got["column_type"] != "VARCHAR(64)" {
+		t.Fatalf("unexpected plugin context: %v", got)
+	}
+}
+
+func TestRegexReplaceRefs(t *testing.T) {
+	valid := []struct{ pattern, repl string }{
+		{`(\w+)@(\w+)`, "$2 at $1"},
+		{`(?P<user>\w+)@`, "${user}@"},
+		{`(\d+)`, "${1}x"},
+		{`\d+`, "$$0"},
+		{`\d+`, "$0"},
+		{`\d+`, "cost: $"},
+		{`\d+`, "${unclosed"},
+	}
+	for _, c := range valid {
+		if _, err := NewRegexReplace(c.pattern, c.repl); err != nil {
+			t.Fatalf("%s -> %s: %v", c.pattern, c.repl, err)
+		}
+	}
+	dangling := []struct{ pattern, repl, msg string }{
+		{`(\w+)@`, "$2", "has 1"},
+		{`(?P<user>\w+)@`, "${usr}", `"usr"`},
+		{`(\d+)`, "$1x", "${1}x"},
+	}
+	for _, c := range dangling {
+		_, err := Build(&config.TransformConfig{Type: "RegexReplace", Pattern: c.pattern, Replace: c.repl}, "")
+		if err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Fatalf("%s -> %s: expected error mentioning %s, got %v", c.pattern, c.repl, c.msg, err)
+		}
+	}
+}
+
+func TestRegexReplaceGroups(t *testing.T) {
+	cfg := &config.TransformConfig{
+		Type:    "RegexReplace",
+		Pattern: `(?P<user>[a-z]+)-(?P<num>[0-9]+)`,
+		Groups: map[string]*config.TransformConfig{
+			"num":  {Type: "Redact"},
+			"user": {Type: "SetValue", Value: "x"},
+		},
+	}
+	tr, err := Build(cfg, "salt")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	out, err := tr.Transform("ids: bob-1234, al-56.", RowContext{})
+	if err != nil {
+		t.Fatalf("transform: %v", err)
+	}
+	if out != "ids: x-****, x-**." {
+		t.Fatalf("unexpected output: %v", out)
+	}
+
+	bad := []*config.TransformConfig{
+		{Type: "RegexReplace", Pattern: `(?P<num>\d+)`, Groups: map[string]*config.TransformConfig{"digits": {Type: "Redact"}}},
+		{Type: "RegexReplace", Pattern: `(?P<num>\d+)`, Replace: "X", Groups: map[string]*config.TransformConfig{"num": {Type: "Redact"}}},
+		{Type: "RegexReplace", Pattern: `(?P<num>\d+)`, Groups: map[string]*config.TransformConfig{"num": {Type: "Nope"}}},
+	}
+	for _, c := range bad {
+		if _, err := Build(c, ""); err == nil {
+			t.Fatalf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestTransformOneValue(t *testing.T) {
+	row := RowContext{Table: "users", PK: []any{1}, Seed: 7, Salt: "salt", Column: "full_name"}
+	cfg := &config.TransformConfig{Type: "FakerName"}
+	got, err := Transform(cfg, row, "Ann Smith")
+	if err != nil {
+		t.Fatalf("transform: %v", err)
+	}
+	tr, err := Build(cfg, "salt")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	row.PK = []any{int64(1)}
+	want, _ := tr.Transform("Ann Smith", row)
+	if got != want {
+		t.Fatalf("got %v, want %v as in a copy", got, want)
+	}
+	if got, err := Transform(nil, row, "kept"); err != nil || got != "kept" {
+		t.Fatalf("nil config: %v, %v", got, err)
+	}
+	if _, err := Transform(&config.TransformConfig{Type: "Nope"}, row, "x"); !errors.Is(err, ErrUnknownTransformer) {
+		t.Fatalf("expected error for unknown transformer")
+	}
+}
+
+func TestChoice(t *testing.T) {
+	uniform, err := Build(&config.TransformConfig{Type: "Choice", Params: map[string]any{"choices": []any{"active", "suspended", "closed"}}}, "")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	weighted, err := Build(&config.TransformConfig{Type: "Choice", Params: map[string]any{"choices": map[string]any{"active": 0.8, "closed": 0.2, "never": 0}}}, "")
+	if err != nil {
+		t.Fatalf("build weighted: %v", err)
+	}
+	seen := map[any]int{}
+	counts := map[any]int{}
+	for i := 0; i < 2000; i++ {
+		row := RowContext{Table: "users", PK: []any{i}, Seed: 1}
+		v, _ := uniform.Transform("x", row)
+		again, _ := uniform.Transform("y", row)
+		if v != again {
+			t.Fatalf("row %d: %v then %v", i, v, again)
+		}
+		seen[v]++
+		w, _ := weighted.Transform("x", row)
+		counts[w]++
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected all three choices, got %v", seen)
+	}
+	if counts["never"] != 0 || counts["active"] < 1500 || counts["active"] > 1700 {
+		t.Fatalf("unexpected weighted distribution: %v", counts)
+	}
+	if v, _ := uniform.Transform(nil, RowContext{}); v != nil {
+		t.Fatalf("expected NULL to pass through, got %v", v)
+	}
+
+	bad := []any{nil, []any{}, map[string]any{"a": "heavy"}, map[string]any{"a": -1, "b": 2}, map[string]any{"a": 0}, "active"}
+	for _, choices := range bad {
+		if _, err := Build(&config.TransformConfig{Type: "Choice", Params: map[string]any{"choices": choices}}, ""); err == nil {
+			t.Fatalf("expected error for choices %v", choices)
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	plain, err := Build(&config.TransformConfig{Type: "Sequence"}, "")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	formatted, err := Build(&config.TransformConfig{Type: "Sequence", Params: map[string]any{"format": "user_%04d", "start": 0}}, "")
+	if err != nil {
+		t.Fatalf("build formatted: %v", err)
+	}
+	if v, _ := plain.Transform("x", RowContext{Ordinal: 3}); v != int64(3) {
+		t.Fatalf("expected 3, got %v", v)
+	}
+	if v, _ := formatted.Transform(nil, RowContext{Ordinal: 3}); v != "user_0002" {
+		t.Fatalf("expected user_0002, got %v", v)
+	}
+	if _, err := plain.Transform("x", RowContext{}); err == nil {
+		t.Fatalf("expected error without a row position")
+	}
+	for _, params := range []map[string]any{{"format": "user"}, {"format": "%s-%d"}, {"format": 4}, {"start": "one"}} {
+		if _, err := Build(&config.TransformConfig{Type: "Sequence", Params: params}, ""); err == nil {
+			t.Fatalf("expected error for params %v", params)
+		}
+	}
+}
+
+func TestRedistribute(t *testing.T) {
+	tr, err := Build(&config.TransformConfig{Type: "Redistribute", Params: map[string]any{"group_by": "order_id"}}, "")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	r := tr.(*Redistribute)
+	rows := []RedistributeRow{
+		{PK: []any{int64(1)}, Group: []any{int64(10)}, Value: 12.5},
+		{PK: []any{int64(2)}, Group: []any{int64(10)}, Value: 7.25},
+		{PK: []any{int64(3)}, Group: []any{int64(10)}, Value: 0.25},
+		{PK: []any{int64(4)}, Group: []any{int64(11)}, Value: -3.0},
+		{PK: []any{int64(5)}, Group: []any{int64(11)}, Value: nil},
+	}
+	if _, err := r.Transform(1.0, RowContext{PK: []any{int64(1)}}); err == nil {
+		t.Fatalf("expected error before Load")
+	}
+	if err := r.Load(rows, RowContext{Table: "lines", Column: "amount", Salt: "salt"}); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var sum float64
+	changed := false
+	for _, row := range rows[:3] {
+		v, err := r.Transform(row.Value, RowContext{PK: row.PK})
+		if err != nil {
+			t.Fatalf("transform: %v", err)
+		}
+		f := v.(float64)
+		if f < 0 || math.Round(f*100) != f*100 {
+			t.Fatalf("unexpected amount %v", f)
+		}
+		changed = changed || f != row.Value
+		sum += f
+	}
+	if math.Abs(sum-20) > 1e-9 || !changed {
+		t.Fatalf("group total %v, changed %t", sum, changed)
+	}
+	if v, _ := r.Transform(-3.0, RowContext{PK: []any{int64(4)}}); v != -3.0 {
+		t.Fatalf("a one-row group keeps its value, got %v", v)
+	}
+	if v, _ := r.Transform(nil, RowContext{PK: []any{int64(5)}}); v != nil {
+		t.Fatalf("expected NULL, got %v", v)
+	}
+
+	parts := splitUnits(-7, []float64{1, 1, 1})
+	if parts[0]+parts[1]+parts[2] != -7 {
+		t.Fatalf("split %v does not add up to -7", parts)
+	}
+	for _, params := range []map[string]any{{}, {"group_by": 3}, {"group_by": "g", "decimals": -1}} {
+		if _, err := Build(&config.TransformConfig{Type: "Redistribute", Params: params}, ""); err == nil {
+			t.Fatalf("expected error for params %v", params)
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	tr, err := Build(&config.TransformConfig{Type: "Resample"}, "")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	r := tr.(*Resample)
+	if _, err := r.Transform(1.0, RowContext{}); err == nil {
+		t.Fatalf("expected error before Load")
+	}
+	r.Load([]float64{10, 20, 30, 100}, true)
+	same := 0
+	for i := 0; i < 500; i++ {
+		row := RowContext{Table: "t", PK: []any{i}, Column: "a"}
+		v, err := r.Transform(int64(5), row)
+		if err != nil {
+			t.Fatalf("transform: %v", err)
+		}
+		n, ok := v.(int64)
+		if !ok || n < 10 || n > 100 {
+			t.Fatalf("sample %v outside the distribution", v)
+		}
+		if again, _ := r.Transform(int64(6), row); again != v {
+			t.Fatalf("row %d: %v then %v", i, v, again)
+		}
+		row.Column = "b"
+		if other, _ := r.Transform(int64(5), row); other == v {
+			same++
+		}
+	}
+	if same > 50 {
+		t.Fatalf("columns of a row should be drawn independently, %d of 500 matched", same)
+	}
+
+	exact := NewResample(false)
+	exact.Load([]float64{1.5, 2.5}, false)
+	for i := 0; i < 20; i++ {
+		v, _ := exact.Transform(0.0, RowContext{PK: []any{i}})
+		if v != 1.5 && v != 2.5 {
+			t.Fatalf("expected one of the quantiles, got %v", v)
+		}
+	}
+	if _, err := Build(&config.TransformConfig{Type: "Resample", Params: map[string]any{"interpolate": "yes"}}, ""); err == nil {
+		t.Fatalf("expected error for non-boolean interpolate")
+	}
+}
+
+func TestCanonicalString(t *testing.T) {
+	cases := []struct {
+		in   any
+		want string
+	}{
+		{int64(100000000), "100000000"},
+		{float64(100000000), "100000000"},
+		{float64(-0.0), "0"},
+		{1.5, "1.5"},
+		{1e-7, "1e-07"},
+		{1e300, "1e+300"},
+		{float32(2.5), "2.5"},
+		{[]byte("ab"), "ab"},
+		{"ab", "ab"},
+		{nil, "<nil>"},
+		{true, "1"},
+		{false, "0"},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "2024-03-01"},
+		{time.Date(2024, 3, 1, 9, 30, 0, 500000000, time.UTC), "2024-03-01 09:30:00.5"},
+		{time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("", 3600)), "2024-03-01 09:30:00+01:00"},
+	}
+	for _, c := range cases {
+		if got := CanonicalString(c.in); got != c.want {
+			t.Fatalf("CanonicalString(%#v) = %q, want %q", c.in, got, c.want)
+		}
+	}
+	row := RowContext{Table: "t", Seed: 1, Salt: "s"}
+	intRow, realRow := row, row
+	intRow.PK = []any{int64(100000000)}
+	realRow.PK = []any{float64(100000000)}
+	if RowHash(intRow) != RowHash(realRow) {
+		t.Fatalf("REAL and INTEGER keys with the same value hash differently")
+	}
+
+	// Blobs are looked up, rewritten, and measured by their bytes.
+	if got, _ := NewMapReplace(map[string]string{"ab": "x"}).Transform([]byte("ab"), row); got != "x" {
+		t.Fatalf("MapReplace on a blob: %v", got)
+	}
+	if got, _ := NewMapReplace(map[string]string{"100000000": "x"}).Transform(float64(1e8), row); got != "x" {
+		t.Fatalf("MapReplace on a whole REAL: %v", got)
+	}
+	re, err := NewRegexReplace("b", "c")
+	if err != nil {
+		t.Fatalf("regex: %v", err)
+	}
+	if got, _ := re.Transform([]byte("ab"), row); got != "ac" {
+		t.Fatalf("RegexReplace on a blob: %v", got)
+	}
+	if got, _ := NewRedact("*", true, 0).Transform(float64(1e8), row); got != "*********" {
+		t.Fatalf("Redact on a whole REAL: %v", got)
+	}
+}
+
+func TestDeriveSalt(t *testing.T) {
+	if got := DeriveSalt("base", 0); got != "base" {
+		t.Fatalf("version 0: got %q", got)
+	}
+	v1, v2 := DeriveSalt("base", 1), DeriveSalt("base", 2)
+	if v1 != DeriveSalt("base", 1) || len(v1) != 64 {
+		t.Fatalf("version 1 not reproducible: %q", v1)
+	}
+	if v1 == v2 || v1 == DeriveSalt("other", 1) {
+		t.Fatalf("versions or bases collide: %q %q", v1, v2)
+	}
+	row := RowContext{Table: "users", PK: []any{int64(1)}}
+	tr := &FakerName{}
+	a, _ := tr.Transform(nil, RowContext{Table: row.Table, PK: row.PK, Salt: v1})
+	b, _ := tr.Transform(nil, RowContext{Table: row.Table, PK: row.PK, Salt: v2})
+	if a == b {
+		t.Fatalf("versions 1 and 2 both mask to %v", a)
+	}
+}
+
+func TestJSONArrayMask(t *testing.T) {
+	tr, err := Build(&config.TransformConfig{Type: "JSONArrayMask", Element: &config.TransformConfig{Type: "Redact", Params: map[string]any{"keep_length": false, "length": 2}}}, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := RowContext{Table: "users", PK: []any{int64(1)}}
+	for in, want := range map[any]any{
+		`["a<b", 12, 1.5, true, null, {"k": "v"}, ["x", ["y"]]]`: `["**","**","**","**",null,{"k":"v"},["**",["**"]]]`,
+		`[]`:           `[]`,
+		`{"a": [1]}`:   `{"a": [1]}`,
+		`not json`:     `not json`,
+		`[1] trailing`: `[1] trailing`,
+		int64(7):       int64(7),
+	} {
+		out, err := tr.Transform(in, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != want {
+			t.Fatalf("%v: got %v, want %v", in, out, want)
+		}
+	}
+	if out, _ := tr.Transform([]byte(`["a"]`), row); out != `["**"]` {
+		t.Fatalf("blob: got %v", out)
+	}
+
+	emails, err := Build(&config.TransformConfig{Type: "JSONArrayMask", Element: &config.TransformConfig{Type: "FakerEmail"}}, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := emails.Transform(`["a@x.com", "b@x.com"]`, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := json.Unmarshal([]byte(out.(string)), &got); err != nil || len(got) != 2 || got[0] == got[1] {
+		t.Fatalf("elements of one row should get distinct fakes: %v (%v)", out, err)
+	}
+	again, _ := emails.Transform(`["a@x.com", "b@x.com"]`, row)
+	if again != out {
+		t.Fatalf("not deterministic: %v then %v", out, again)
+	}
+
+	for _, cfg := range []*config.TransformConfig{
+		{Type: "JSONArrayMask"},
+		{Type: "JSONArrayMask", Element: &config.TransformConfig{Type: "Resample"}},
+		{Type: "JSONArrayMask", MaxLen: 10, Element: &config.TransformConfig{Type: "HashSha256"}},
+	} {
+		if _, err := Build(cfg, "salt"); err == nil {
+			t.Fatalf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestPbkdf2(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 test vectors for password "password", salt "salt".
+	for iterations, want := range map[int]string{
+		1:    "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2:    "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		4096: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+	} {
+		got, err := NewPbkdf2("salt", iterations, 0).Transform("password", RowContext{})
+		if err != nil || got != want {
+			t.Fatalf("iterations %d: got %v, %v; want %s", iterations, got, err, want)
+		}
+	}
+	tr, err := Build(&config.TransformConfig{Type: "Pbkdf2", MaxLen: 12, Params: map[string]any{"iterations": 100}}, "salt")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	a, _ := tr.Transform("123-45-6789", RowContext{PK: []any{int64(1)}})
+	b, _ := tr.Transform("123-45-6789", RowContext{PK: []any{int64(2)}})
+	if a != b || len(a.(string)) != 12 {
+		t.Fatalf("not deterministic per value: %v %v", a, b)
+	}
+	if v, _ := tr.Transform(nil, RowContext{}); v != nil {
+		t.Fatalf("NULL masked to %v", v)
+	}
+	if _, err := Build(&config.TransformConfig{Type: "Pbkdf2", Params: map[string]any{"iterations": 0}}, "salt"); err == nil {
+		t.Fatalf("expected an error for zero iterations")
+	}
+}
+
+// countingHash counts the values it masks.
+type countingHash struct {
+	HashSha256
+	calls int
+}
+
+func (t *countingHash) Transform(value any, row RowContext) (any, error) {
+	t.calls++
+	return t.HashSha256.Transform(value, row)
+}
+
+func TestCache(t *testing.T) {
+	inner := &countingHash{}
+	tr := NewCache(inner, 2)
+	for i, v := range []any{"a", "b", "a", int64(1), "1", "a", nil, nil} {
+		want, _ := inner.HashSha256.Transform(v, RowContext{})
+		got, err := tr.Transform(v, RowContext{PK: []any{int64(i)}})
+		if err != nil || got != want {
+			t.Fatalf("value %v: got %v, %v; want %v", v, got, err, want)
+		}
+	}
+	// "a" hits once; the INTEGER 1 and the text "1" are cached apart, which
+	// pushes "a" out of a cache of two; NULLs are never cached.
+	if inner.calls != 7 {
+		t.Fatalf("inner called %d times, want 7", inner.calls)
+	}
+
+	tr2, err := Build(&config.TransformConfig{Type: "Pbkdf2", Params: map[string]any{"iterations": 10}}, "salt")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, ok := tr2.(*Cache); !ok {
+		t.Fatalf("Pbkdf2 not cached by default: %T", tr2)
+	}
+	tr2, err = Build(&config.TransformConfig{Type: "Pbkdf2", Params: map[string]any{"iterations": 10, "cache": 0}}, "salt")
+	if _, ok := tr2.(*Cache); ok || err != nil {
+		t.Fatalf("cache: 0 kept the cache: %T, %v", tr2, err)
+	}
+	if _, err := Build(&config.TransformConfig{Type: "HmacSha256", MaxLen: 8, Params: map[string]any{"cache": 100, "match": "@"}}, "salt"); err != nil {
+		t.Fatalf("build cached HmacSha256: %v", err)
+	}
+	for _, typ := range []string{"FakerName", "Choice", "DateShift"} {
+		cfg := &config.TransformConfig{Type: typ, Params: map[string]any{"cache": 100, "choices": []any{"x"}}}
+		if _, err := Build(cfg, "salt"); err == nil || !strings.Contains(err.Error(), "depends on the row") {
+			t.Fatalf("%s: expected a row dependency error, got %v", typ, err)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	t.Cleanup(func() { SetPolicy() })
+	registerPlugin("policy.so", "PolicyPlugin", func(value any, row map[string]any) (any, error) { return value, nil })
+	build := func(typ string) error {
+		_, err := Build(&config.TransformConfig{Type: typ, Value: "x"}, "salt")
+		return err
+	}
+
+	SetPolicy(Policy{Deny: []string{"setvalue", PluginsEntry}})
+	if err := build("SetValue"); !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("SetValue not denied: %v", err)
+	}
+	if err := build("PolicyPlugin"); err == nil {
+		t.Fatalf("plugin not denied")
+	}
+	if err := build("HmacSha256"); err != nil {
+		t.Fatalf("HmacSha256: %v", err)
+	}
+
+	// Every policy must allow a transform, and nested transforms are checked.
+	SetPolicy(Policy{Allow: []string{"HmacSha256", "JSONArrayMask", "SetValue"}}, Policy{Allow: []string{"HmacSha256", "JSONArrayMask"}})
+	if err := build("SetValue"); err == nil || !strings.Contains(err.Error(), "not in the allowed list") {
+		t.Fatalf("SetValue allowed: %v", err)
+	}
+	if _, err := Build(&config.TransformConfig{Type: "JSONArrayMask", Element: &config.TransformConfig{Type: "FakerName"}}, "salt"); err == nil {
+		t.Fatalf("FakerName element allowed")
+	}
+	if _, err := Build(&config.TransformConfig{Type: "JSONArrayMask", Element: &config.TransformConfig{Type: "hmacsha256"}}, "salt"); err != nil {
+		t.Fatalf("HmacSha256 element: %v", err)
+	}
+}
+
+func TestDateShiftLayouts(t *testing.T) {
+	tr := NewDateShift(30)
+	row := RowContext{Table: "events", PK: []any{int64(3)}, Seed: 1, Salt: "salt"}
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	shifted, _ := tr.Transform(base, row)
+	shift := shifted.(time.Time).Sub(base)
+	if shift == 0 {
+		t.Fatalf("pick a row with a non-zero shift")
+	}
+	cases := []struct {
+		in, layout string
+	}{
+		{"2024-01-02", "2006-01-02"},
+		{"2024-01-02 03:04", "2006-01-02 15:04"},
+		{"2024-01-02 03:04:05", "2006-01-02 15:04:05"},
+		{"2024-01-02T03:04:05", "2006-01-02T15:04:05"},
+		{"2024-01-02 03:04:05.123", "2006-01-02 15:04:05.000"},
+		{"2024-01-02T03:04:05.120000", "2006-01-02T15:04:05.000000"},
+		{"2024-01-02T03:04:05Z", "2006-01-02T15:04:05Z07:00"},
+		{"2024-01-02T03:04:05.5+02:00", "2006-01-02T15:04:05.0Z07:00"},
+		{"2024-01-02 03:04:05-05:30", "2006-01-02 15:04:05Z07:00"},
+		{"2024-01-02T03:04Z", "2006-01-02T15:04Z07:00"},
+	}
+	for _, tc := range cases {
+		out, err := tr.Transform(tc.in, row)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		in, _ := time.Parse(tc.layout, tc.in)
+		got, err := time.Parse(tc.layout, out.(string))
+		if err != nil || got.Sub(in) != shift || got.Format(tc.layout) != out {
+			t.Fatalf("%s: got %v, want it shifted by %s in layout %s", tc.in, out, shift, tc.layout)
+		}
+	}
+	for _, v := range []string{"not a date", "2024-13-01", "2024-01-02 25:00", "03:04:05"} {
+		if out, _ := tr.Transform(v, row); out != v {
+			t.Fatalf("%q changed to %v", v, out)
+		}
+	}
+}
+
+func TestDateShiftModes(t *testing.T) {
+	row := RowContext{Table: "appointments", PK: []any{int64(7)}, Seed: 1, Salt: "salt"}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	// The day before DST starts: 24h later the clock reads 10:00.
+	in := time.Date(2024, 3, 9, 9, 0, 0, 0, ny)
+	build := func(params map[string]any) Transformer {
+		tr, err := Build(&config.TransformConfig{Type: "DateShift", Params: params}, "salt")
+		if err != nil {
+			t.Fatalf("build %v: %v", params, err)
+		}
+		return tr
+	}
+
+	keep := build(map[string]any{"keep_time": true, "max_days": 30})
+	for pk := int64(1); pk <= 50; pk++ {
+		r := RowContext{Table: row.Table, PK: []any{pk}, Seed: row.Seed, Salt: row.Salt}
+		out, _ := keep.Transform(in, r)
+		got := out.(time.Time)
+		if got.Hour() != 9 || got.Minute() != 0 {
+			t.Fatalf("pk %d: clock time changed: %v", pk, got)
+		}
+		def, _ := NewDateShift(30).Transform(in, r)
+		if days := got.Sub(in).Round(24 * time.Hour); days != def.(time.Time).Sub(in).Round(24*time.Hour) {
+			t.Fatalf("pk %d: keep_time moved %v days, default %v", pk, days, def)
+		}
+	}
+	if out, _ := keep.Transform("2024-01-02 14:30:00", row); !strings.HasSuffix(out.(string), " 14:30:00") {
+		t.Fatalf("keep_time on text: %v", out)
+	}
+
+	jitter := build(map[string]any{"shift_time": true, "max_seconds": 900})
+	for pk := int64(1); pk <= 50; pk++ {
+		r := RowContext{Table: row.Table, PK: []any{pk}, Seed: row.Seed, Salt: row.Salt}
+		out, _ := jitter.Transform("2024-01-02 14:30:00", r)
+		got, err := time.Parse("2006-01-02 15:04:05", out.(string))
+		if d := got.Sub(time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC)); err != nil || d < -15*time.Minute || d > 15*time.Minute {
+			t.Fatalf("pk %d: jitter out of range: %v", pk, out)
+		}
+		again, _ := jitter.Transform("2024-01-02 14:30:00", r)
+		if again != out {
+			t.Fatalf("pk %d: not deterministic: %v %v", pk, out, again)
+		}
+	}
+	if out, _ := jitter.Transform("2024-01-02", row); out != "2024-01-02" {
+		t.Fatalf("shift_time changed a date: %v", out)
+	}
+	if out, _ := jitter.Transform(int64(1704205800), row); out.(int64) == 1704205800 || absInt64(out.(int64)-1704205800) > 900 {
+		t.Fatalf("shift_time on unix seconds: %v", out)
+	}
+
+	for _, params := range []map[string]any{
+		{"keep_time": true, "shift_time": true, "max_seconds": 10},
+		{"shift_time": true},
+		{"shift_time": true, "max_seconds": 10, "max_days": 3},
+		{"max_seconds": 10},
+		{"keep_time": "yes"},
+	} {
+		if _, err := Build(&config.TransformConfig{Type: "DateShift", Params: params}, "salt"); err == nil {
+			t.Fatalf("expected an error for %v", params)
+		}
+	}
+}
+
+func absInt64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestLoadPluginsBestEffort(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stale.so"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{dir, filepath.Join(dir, "missing.so")}
+	if err := LoadPlugins(paths); err == nil {
+		t.Fatalf("LoadPlugins accepted a broken plugin")
+	}
+	if skipped := LoadPluginsBestEffort(paths); len(skipped) != 2 {
+		t.Fatalf("expected both plugins skipped, got %v", skipped)
+	}
+
+	registerPlugin("loaded.so", "LoadedPlugin", func(value any, row map[string]any) (any, error) { return value, nil })
+	cfg := &config.Config{
+		Tables: map[string]*config.TableConfig{"t": {Columns: map[string]*config.TransformConfig{
+			"a": {Type: "loadedplugin"},
+			"b": {Type: "JSONArrayMask", Element: &config.TransformConfig{Type: "StalePlugin"}},
+		}}},
+		ByType: map[string]*config.TransformConfig{"TEXT": {Type: "HmacSha256"}},
+	}
+	err := CheckTypes(cfg)
+	if !errors.Is(err, ErrUnknownTransformer) || !strings.Contains(err.Error(), "t.b.element (StalePlugin)") || strings.Contains(err.Error(), "t.a") {
+		t.Fatalf("unexpected CheckTypes result: %v", err)
+	}
+	cfg.Tables["t"].Columns["b"].Element.Type = "Redact"
+	if err := CheckTypes(cfg); err != nil {
+		t.Fatalf("CheckTypes: %v", err)
+	}
+}
+
+func TestPluginSource(t *testing.T) {
+	t.Cleanup(func() {
+		delete(registry, "sourceplugin")
+		delete(registryInfo, "sourceplugin")
+		delete(registry, "redact")
+		delete(registryInfo, "redact")
+	})
+	registerPlugin("./source.so", "SourcePlugin", func(value any, row map[string]any) (any, error) { return value, nil })
+	tr, err := Build(&config.TransformConfig{Type: "sourceplugin"}, "salt")
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if p, ok := tr.(*PluginTransformer); !ok || p.Path() != "./source.so" {
+		t.Fatalf("unexpected plugin transformer %#v", tr)
+	}
+	if PluginPath("SOURCEPLUGIN") != "./source.so" || PluginPath("Redact") != "" {
+		t.Fatalf("unexpected plugin paths")
+	}
+	if len(Overrides()) != 0 {
+		t.Fatalf("unexpected overrides: %v", Overrides())
+	}
+	registerPlugin("./redact.so", "Redact", func(value any, row map[string]any) (any, error) { return value, nil })
+	if o := Overrides(); len(o) != 1 || o[0].Name != "Redact" || o[0].Path != "./redact.so" {
+		t.Fatalf("unexpected overrides: %v", o)
+	}
+}
@@ -0,0 +1,105 @@
+package transform
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dyne/pinkmask/internal/config"
+)
+
+var (
+	wordListsMu sync.Mutex
+	wordLists   = map[string][]string{}
+)
+
+// LoadWordList reads a faker word list: one entry per line, blank lines and
+// lines starting with '#' are ignored. Lists are cached by path, so every
+// transformer (and every table) using the same file shares one copy.
+func LoadWordList(path string) ([]string, error) {
+	wordListsMu.Lock()
+	defer wordListsMu.Unlock()
+	if words, ok := wordLists[path]; ok {
+		return words, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open word list: %w", err)
+	}
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read word list %s: %w", path, err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("word list %s is empty", path)
+	}
+	wordLists[path] = words
+	return words, nil
+}
+
+// fakerWords loads the word list named by params[key], returning nil (use
+// the built-in list) when the parameter is not set.
+func fakerWords(cfg *config.TransformConfig, key string) ([]string, error) {
+	v, ok := cfg.Params[key]
+	if !ok {
+		return nil, nil
+	}
+	path, ok := v.(string)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("%s: params.%s must be a file path", cfg.Type, key)
+	}
+	return LoadWordList(path)
+}
+
+func newFakerName(cfg *config.TransformConfig) (*FakerName, error) {
+	var t FakerName
+	var err error
+	if t.first, err = fakerWords(cfg, "first_names_file"); err != nil {
+		return nil, err
+	}
+	if t.last, err = fakerWords(cfg, "last_names_file"); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func newFakerEmail(cfg *config.TransformConfig) (*FakerEmail, error) {
+	var t FakerEmail
+	var err error
+	if t.first, err = fakerWords(cfg, "first_names_file"); err != nil {
+		return nil, err
+	}
+	if t.last, err = fakerWords(cfg, "last_names_file"); err != nil {
+		return nil, err
+	}
+	if t.domains, err = fakerWords(cfg, "domains_file"); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func newFakerAddress(cfg *config.TransformConfig) (*FakerAddress, error) {
+	var t FakerAddress
+	var err error
+	if t.streets, err = fakerWords(cfg, "streets_file"); err != nil {
+		return nil, err
+	}
+	if t.cities, err = fakerWords(cfg, "cities_file"); err != nil {
+		return nil, err
+	}
+	if t.states, err = fakerWords(cfg, "states_file"); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
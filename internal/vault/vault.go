@@ -0,0 +1,267 @@
+// Package vault stores the original values behind masked ones in an
+// encrypted file, so a holder of the key can reverse pseudonymization that
+// the masked database alone does not allow.
+//
+// File format (all integers big-endian):
+//
+//	header: "PMVAULT" | version (1 byte, 1) | file id (16 random bytes)
+//	chunk:  length (uint32) | nonce (12 bytes) | AES-256-GCM ciphertext
+//
+// Each chunk's plaintext is a batch of JSON lines, one Entry per line. The
+// additional data of every chunk is the header followed by the chunk index
+// (uint64) and a final flag (1 byte), so chunks cannot be reordered, moved
+// between files, dropped, or cut off after the last one without failing
+// authentication. Only the last chunk carries the final flag; a file
+// without one is rejected as truncated.
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	magic     = "PMVAULT"
+	version   = 1
+	idSize    = 16
+	nonceSize = 12
+	// chunkEntries is how many entries are sealed together.
+	chunkEntries = 1000
+	// maxChunk bounds the ciphertext length accepted when reading.
+	maxChunk = 64 << 20
+)
+
+// KeySize is the length of a vault key in bytes.
+const KeySize = 32
+
+// Entry maps a masked value back to the original value of a column.
+// []byte values are stored base64-encoded, as encoding/json does.
+type Entry struct {
+	Table    string `json:"table"`
+	Column   string `json:"column"`
+	Masked   any    `json:"masked"`
+	Original any    `json:"original"`
+}
+
+// LoadKey reads a key file holding a hex-encoded 32-byte key, such as the
+// output of `openssl rand -hex 32`.
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read vault key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("vault key %s: expected %d hex-encoded bytes", path, KeySize)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("vault key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("vault cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData(header []byte, index uint64, final bool) []byte {
+	ad := make([]byte, 0, len(header)+9)
+	ad = append(ad, header...)
+	ad = binary.BigEndian.AppendUint64(ad, index)
+	if final {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// Writer appends entries to a new vault file. It is safe for concurrent
+// use. Identical entries are written once; only a SHA-256 digest of each
+// entry is kept to detect them, so memory grows by a fixed amount per
+// distinct entry whatever the size of its values.
+type Writer struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	aead   cipher.AEAD
+	header []byte
+	index  uint64
+	buf    bytes.Buffer
+	n      int
+	seen   map[[sha256.Size]byte]struct{}
+	done   bool
+}
+
+// Create starts a vault file at path, replacing any existing file.
+func Create(path string, key []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(magic)+1+idSize)
+	header = append(header, magic...)
+	header = append(header, version)
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("vault id: %w", err)
+	}
+	header = append(header, id...)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create vault: %w", err)
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("write vault header: %w", err)
+	}
+	return &Writer{path: path, file: file, aead: aead, header: header, seen: map[[sha256.Size]byte]struct{}{}}, nil
+}
+
+// Add records that original was masked as masked in table.column.
+func (w *Writer) Add(table, column string, masked, original any) error {
+	line, err := json.Marshal(Entry{Table: table, Column: column, Masked: masked, Original: original})
+	if err != nil {
+		return fmt.Errorf("encode vault entry: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return errors.New("vault is closed")
+	}
+	sum := sha256.Sum256(line)
+	if _, ok := w.seen[sum]; ok {
+		return nil
+	}
+	w.seen[sum] = struct{}{}
+	w.buf.Write(line)
+	w.buf.WriteByte('\n')
+	w.n++
+	if w.n >= chunkEntries {
+		return w.flush(false)
+	}
+	return nil
+}
+
+func (w *Writer) flush(final bool) error {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("vault nonce: %w", err)
+	}
+	sealed := w.aead.Seal(nil, nonce, w.buf.Bytes(), additionalData(w.header, w.index, final))
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	for _, part := range [][]byte{size[:], nonce, sealed} {
+		if _, err := w.file.Write(part); err != nil {
+			return fmt.Errorf("write vault: %w", err)
+		}
+	}
+	w.index++
+	w.buf.Reset()
+	w.n = 0
+	return nil
+}
+
+// Close seals the remaining entries as the final chunk.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if err := w.flush(true); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("close vault: %w", err)
+	}
+	return nil
+}
+
+// Abort discards an unfinished vault file. It does nothing after Close.
+func (w *Writer) Abort() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.done = true
+	w.file.Close()
+	os.Remove(w.path)
+}
+
+// Read decrypts the vault at path and calls fn for every entry, in the
+// order they were added.
+func Read(path string, key []byte, fn func(Entry) error) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open vault: %w", err)
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	header := make([]byte, len(magic)+1+idSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(magic)]) != magic {
+		return fmt.Errorf("%s is not a pinkmask vault", path)
+	}
+	if header[len(magic)] != version {
+		return fmt.Errorf("vault %s: unsupported version %d", path, header[len(magic)])
+	}
+	for index := uint64(0); ; index++ {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return fmt.Errorf("vault %s is truncated", path)
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > maxChunk {
+			return fmt.Errorf("vault %s: chunk %d too large", path, index)
+		}
+		chunk := make([]byte, nonceSize+int(n))
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return fmt.Errorf("vault %s is truncated", path)
+		}
+		_, err := r.Peek(1)
+		final := errors.Is(err, io.EOF)
+		plain, err := aead.Open(nil, chunk[:nonceSize], chunk[nonceSize:], additionalData(header, index, final))
+		if err != nil {
+			if final {
+				return fmt.Errorf("vault %s: wrong key, corrupted, or truncated", path)
+			}
+			return fmt.Errorf("vault %s: wrong key or corrupted chunk %d", path, index)
+		}
+		dec := json.NewDecoder(bytes.NewReader(plain))
+		dec.UseNumber()
+		for dec.More() {
+			var e Entry
+			if err := dec.Decode(&e); err != nil {
+				return fmt.Errorf("vault %s: decode entry: %w", path, err)
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if final {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.pmv")
+	w, err := Create(path, testKey(1))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 0; i < 2500; i++ {
+		if err := w.Add("users", "email", fmt.Sprintf("tok%d", i), fmt.Sprintf("user%d@example.com", i)); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	if err := w.Add("users", "email", "tok0", "user0@example.com"); err != nil {
+		t.Fatalf("add duplicate: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	var entries []Entry
+	if err := Read(path, testKey(1), func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(entries) != 2500 || entries[42].Masked != "tok42" || entries[42].Original != "user42@example.com" {
+		t.Fatalf("unexpected entries: %d, %+v", len(entries), entries[42])
+	}
+
+	noop := func(Entry) error { return nil }
+	if err := Read(path, testKey(2), noop); err == nil {
+		t.Fatalf("expected error for wrong key")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	// Cut the file after the first chunk: every remaining chunk is intact
+	// but none carries the final flag.
+	first := len(magic) + 1 + idSize
+	size := int(data[first])<<24 | int(data[first+1])<<16 | int(data[first+2])<<8 | int(data[first+3])
+	truncated := filepath.Join(t.TempDir(), "truncated.pmv")
+	if err := os.WriteFile(truncated, data[:first+4+nonceSize+size], 0o600); err != nil {
+		t.Fatalf("write truncated: %v", err)
+	}
+	if err := Read(truncated, testKey(1), noop); err == nil {
+		t.Fatalf("expected error for truncated vault")
+	}
+	data[len(data)-1] ^= 1
+	tampered := filepath.Join(t.TempDir(), "tampered.pmv")
+	if err := os.WriteFile(tampered, data, 0o600); err != nil {
+		t.Fatalf("write tampered: %v", err)
+	}
+	if err := Read(tampered, testKey(1), noop); err == nil {
+		t.Fatalf("expected error for tampered vault")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.key")
+	if err := os.WriteFile(good, []byte(fmt.Sprintf("%x\n", testKey(7))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := LoadKey(good)
+	if err != nil || !bytes.Equal(key, testKey(7)) {
+		t.Fatalf("load key: %v", err)
+	}
+	bad := filepath.Join(dir, "bad.key")
+	if err := os.WriteFile(bad, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(bad); err == nil {
+		t.Fatalf("expected error for malformed key")
+	}
+}